@@ -1,10 +1,43 @@
 package metrics
 
+import "runtime"
+
 type basicProviderConfig struct {
 	// when false, remove per-key mutex entries from `inits` after initialization to
 	// allow GC of mutexes for many ephemeral instrument names. Default: false.
 	doNotCleanupInits bool
 	logger            logger
+	// number of shards for counters; zero means counters are not sharded. Default: 0.
+	counterShards int
+	// which offered exemplars are kept. Default: ExemplarFilterTraceBased.
+	exemplarFilter ExemplarFilter
+	// number of exemplars kept per histogram bucket (and per counter). Default: 1.
+	exemplarReservoirSize int
+	// source of time for snapshots, exemplars and windowed histograms. Default: SystemClock.
+	clock Clock
+	// whether instruments record the time of their last update. Default: false.
+	trackLastUpdate bool
+	// snapshot file restored by NewBasicProvider; empty means none. Default: "".
+	restorePath string
+	// write-ahead log of durable instruments; nil means none. Default: nil.
+	wal *WAL
+}
+
+// defaultExemplarReservoirSize is the number of exemplars kept per bucket unless configured.
+const defaultExemplarReservoirSize = 1
+
+// newExemplarReservoir returns a reservoir for an instrument with the given number of buckets,
+// or nil if exemplars are disabled.
+func (cfg *basicProviderConfig) newExemplarReservoir(buckets int) *exemplarReservoir {
+	size := cfg.exemplarReservoirSize
+	if size == 0 {
+		size = defaultExemplarReservoirSize
+	}
+	r := newExemplarReservoir(cfg.exemplarFilter, size, buckets)
+	if r != nil {
+		r.clock = cfg.clock
+	}
+	return r
 }
 
 // BasicProviderOption configures a BasicProvider constructed by NewBasicProvider.
@@ -21,3 +54,68 @@ func WithInitCleanupDisabled() BasicProviderOption {
 func WithBasicProviderLogger(l logger) BasicProviderOption {
 	return func(cfg *basicProviderConfig) { cfg.logger = l }
 }
+
+// WithShardedCounters makes the provider create ShardedCounter instances instead of
+// BasicCounter for all counters, to eliminate cache-line contention on hot counters
+// updated from many cores. shards is rounded up to a power of two; non-positive values
+// default to GOMAXPROCS.
+func WithShardedCounters(shards int) BasicProviderOption {
+	return func(cfg *basicProviderConfig) {
+		if shards <= 0 {
+			shards = runtime.GOMAXPROCS(0)
+		}
+		cfg.counterShards = shards
+	}
+}
+
+// WithExemplarFilter sets which exemplars offered through AddWithExemplar, RecordWithExemplar
+// or the context-aware recording methods are kept by counters and histograms.
+// The default is ExemplarFilterTraceBased.
+func WithExemplarFilter(f ExemplarFilter) BasicProviderOption {
+	return func(cfg *basicProviderConfig) { cfg.exemplarFilter = f }
+}
+
+// WithClock sets the clock used by the provider for snapshot and exemplar timestamps and
+// rolling-window histograms, and by timers built with TimerFromProvider. The default is
+// SystemClock. A nil clock is ignored.
+func WithClock(c Clock) BasicProviderOption {
+	return func(cfg *basicProviderConfig) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// WithLastUpdateTracking makes instruments record the time of their most recent measurement,
+// reported in InstrumentTimes.LastUpdate, e.g. for staleness detection. It costs a clock read
+// on every Add and Record, which is why it is disabled by default.
+func WithLastUpdateTracking() BasicProviderOption {
+	return func(cfg *basicProviderConfig) { cfg.trackLastUpdate = true }
+}
+
+// WithExemplarReservoirSize sets how many of the most recent exemplars are kept per histogram
+// bucket, and per counter. The default is 1. Non-positive values disable exemplars.
+func WithExemplarReservoirSize(n int) BasicProviderOption {
+	return func(cfg *basicProviderConfig) {
+		if n <= 0 {
+			cfg.exemplarFilter = ExemplarFilterAlwaysOff
+			return
+		}
+		cfg.exemplarReservoirSize = n
+	}
+}
+
+// WithRestoreFrom makes NewBasicProvider restore the instruments persisted in the snapshot
+// file at path (see SaveSnapshot and SnapshotPersister). A missing file is not an error;
+// an unreadable or corrupt one is logged and the provider starts empty. Combined with WithWAL,
+// durable instruments are recovered from the write-ahead log only (see Restore).
+func WithRestoreFrom(path string) BasicProviderOption {
+	return func(cfg *basicProviderConfig) { cfg.restorePath = path }
+}
+
+// WithWAL makes the provider log every update of durable counters and up/down counters
+// (see WithDurable) to w, and seeds durable instruments with the values w recovered when
+// they are created. A WAL can be used by a single provider.
+func WithWAL(w *WAL) BasicProviderOption {
+	return func(cfg *basicProviderConfig) { cfg.wal = w }
+}
@@ -1,14 +1,23 @@
 package metrics
 
-// copyConfig makes a defensive copy of InstrumentConfig (copies Attributes map).
+// copyConfig makes a defensive copy of InstrumentConfig (copies the Attributes map, Buckets
+// and Window, including its Quantiles).
 func copyConfig(in InstrumentConfig) InstrumentConfig {
-	out := InstrumentConfig{Description: in.Description, Unit: in.Unit}
+	out := InstrumentConfig{Description: in.Description, Unit: in.Unit, Durable: in.Durable}
 	if len(in.Attributes) > 0 {
 		out.Attributes = make(map[string]string, len(in.Attributes))
 		for k, v := range in.Attributes {
 			out.Attributes[k] = v
 		}
 	}
+	if len(in.Buckets) > 0 {
+		out.Buckets = append([]float64(nil), in.Buckets...)
+	}
+	if in.Window != nil {
+		w := *in.Window
+		w.Quantiles = append([]float64(nil), w.Quantiles...)
+		out.Window = &w
+	}
 	return out
 }
 
@@ -41,19 +50,12 @@ func (p *BasicProvider) CounterWithMeta(name string) (Counter, InstrumentConfig,
 	km.Lock()
 	defer km.Unlock()
 
-	v, ok := p.counters.Load(name)
+	inst, ok := p.counters.Load(name)
 	if !ok {
 		// not created
 		return nil, InstrumentConfig{}, false
 	}
 
-	inst, ok2 := v.(*BasicCounter)
-	if !ok2 {
-		// invariant violation: wrong type in map
-		p.reportInvariantViolation("counter_type", key)
-		return nil, InstrumentConfig{}, false
-	}
-
 	c, okOverall := p.getInstrumentMeta(key)
 
 	return inst, c, okOverall
@@ -70,19 +72,12 @@ func (p *BasicProvider) UpDownCounterWithMeta(name string) (UpDownCounter, Instr
 	km.Lock()
 	defer km.Unlock()
 
-	v, ok := p.updowns.Load(name)
+	inst, ok := p.updowns.Load(name)
 	if !ok {
 		// not created
 		return nil, InstrumentConfig{}, false
 	}
 
-	inst, ok2 := v.(*BasicUpDownCounter)
-	if !ok2 {
-		// invariant violation: wrong type in map
-		p.reportInvariantViolation("updown_type", key)
-		return nil, InstrumentConfig{}, false
-	}
-
 	c, okOverall := p.getInstrumentMeta(key)
 
 	return inst, c, okOverall
@@ -99,19 +94,12 @@ func (p *BasicProvider) HistogramWithMeta(name string) (Histogram, InstrumentCon
 	km.Lock()
 	defer km.Unlock()
 
-	v, ok := p.histograms.Load(name)
+	inst, ok := p.histograms.Load(name)
 	if !ok {
 		// not created
 		return nil, InstrumentConfig{}, false
 	}
 
-	inst, ok2 := v.(*BasicHistogram)
-	if !ok2 {
-		// invariant violation: wrong type in map
-		p.reportInvariantViolation("histogram_type", key)
-		return nil, InstrumentConfig{}, false
-	}
-
 	c, okOverall := p.getInstrumentMeta(key)
 
 	return inst, c, okOverall
@@ -129,8 +117,27 @@ func (p *BasicProvider) ListMetadata() []InstrumentEntry {
 			return true // skip invalid entries
 		}
 
-		out = append(out, InstrumentEntry{Type: key.Type, Name: key.Name, Config: copyConfig(cfg)})
+		out = append(out, InstrumentEntry{
+			Type: key.Type, Name: key.Name, Config: copyConfig(cfg), Times: p.instrumentTimes(key),
+		})
 		return true
 	})
 	return out
 }
+
+// instrumentTimes returns the lifecycle timestamps of the instrument with the given key.
+func (p *BasicProvider) instrumentTimes(key InstrumentKey) InstrumentTimes {
+	if v, ok := p.times.Load(key); ok {
+		return v.(*instrumentTimes).load()
+	}
+	return InstrumentTimes{}
+}
+
+// CounterRates implements RateInspector.CounterRates for BasicProvider.
+func (p *BasicProvider) CounterRates(name string) ([]Rate, bool) {
+	c, ok := p.counters.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return c.Rates(), true
+}
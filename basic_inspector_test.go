@@ -313,18 +313,6 @@ func TestInvalidMapEntriesAndInvariantBehavior(t *testing.T) {
 			expectOk:    false,
 			expectPanic: true, // meta missing triggers invariant
 		},
-		{
-			name: "wrong_type_in_counters_map",
-			setup: func(p *BasicProvider) {
-				p.counters.Store("bad", "not-a-counter")
-				p.meta.Store(NewInstrumentKey(InstrumentTypeCounter, "bad"), InstrumentConfig{})
-			},
-			callList:    false,
-			keyName:     "bad",
-			expectInst:  false,
-			expectOk:    false,
-			expectPanic: true, // wrong type in counters map triggers invariant
-		},
 		{
 			name: "wrong_type_in_meta_map",
 			setup: func(p *BasicProvider) {
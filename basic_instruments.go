@@ -1,59 +1,243 @@
 package metrics
 
 import (
+	"context"
+	"math"
+	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 )
 
+// counterInstrument is a counter whose current value and exemplars can be read.
+// It is implemented by BasicCounter and ShardedCounter.
+type counterInstrument interface {
+	ExemplarCounter
+	ContextCounter
+	Snapshot() int64
+	Exemplars() []Exemplar
+	AttachRates(trackers ...RateTracker)
+	Rates() []Rate
+	Reset()
+	// restore adds a persisted value without observing it as a measurement.
+	restore(n int64)
+}
+
+// histogramInstrument is a histogram whose state can be read.
+// It is implemented by BasicHistogram and WindowedHistogram.
+type histogramInstrument interface {
+	ExemplarHistogram
+	ContextHistogram
+	Snapshot() HistSnapshot
+	Reset()
+	// restore adds persisted measurements.
+	restore(s HistSnapshot)
+}
+
 // BasicCounter is a thread-safe monotonic counter.
 type BasicCounter struct {
 	val atomic.Int64
+	// exemplars offered by AddWithExemplar and AddContext; nil if exemplars are disabled.
+	ex *exemplarReservoir
+	// rate trackers attached with AttachRates.
+	rates rateTrackers
+	// lifecycle timestamps; nil unless created by BasicProvider.
+	times *instrumentTimes
+	// write-ahead log journal; nil unless durable.
+	journal *walJournal
 }
 
 // Add increments the counter by n (n may be negative but it's not recommended for monotonic counters).
-func (c *BasicCounter) Add(n int64) { c.val.Add(n) }
+func (c *BasicCounter) Add(n int64) {
+	if c.journal.begin(n) {
+		defer c.journal.end()
+	}
+	c.val.Add(n)
+	c.rates.observe(n)
+	c.times.touch()
+}
+
+// AddWithExemplar increments the counter by n and offers ex, with n as its value,
+// to the counter's exemplar reservoir.
+func (c *BasicCounter) AddWithExemplar(n int64, ex Exemplar) {
+	c.Add(n)
+	c.ex.offer(0, float64(n), ex)
+}
+
+// AddContext increments the counter by n and offers an exemplar built from the trace and
+// attributes carried by ctx, if any (see ContextWithTrace).
+func (c *BasicCounter) AddContext(ctx context.Context, n int64) {
+	c.Add(n)
+	if ex, ok := exemplarFromContext(ctx); ok {
+		c.ex.offer(0, float64(n), ex)
+	}
+}
 
 // Snapshot returns the current value.
 func (c *BasicCounter) Snapshot() int64 { return c.val.Load() }
 
+// Exemplars returns copies of the most recent exemplars kept by the counter, oldest first.
+func (c *BasicCounter) Exemplars() []Exemplar { return c.ex.collect(0) }
+
+func (c *BasicCounter) restore(n int64) { c.val.Add(n) }
+
+// Reset sets the counter to zero and drops its exemplars. Adds racing with Reset may or may
+// not be counted.
+func (c *BasicCounter) Reset() {
+	if c.journal.beginReset() {
+		defer c.journal.end()
+	}
+	c.val.Store(0)
+	c.ex.reset()
+	c.times.reset()
+}
+
+// AttachRates makes every subsequent Add also observe the increment in trackers.
+func (c *BasicCounter) AttachRates(trackers ...RateTracker) { c.rates.attach(trackers) }
+
+// Rates returns the current readings of the attached rate trackers, in attachment order.
+func (c *BasicCounter) Rates() []Rate { return c.rates.rates() }
+
 // BasicUpDownCounter is a thread-safe up/down counter.
 type BasicUpDownCounter struct {
 	val atomic.Int64
+	// lifecycle timestamps; nil unless created by BasicProvider.
+	times *instrumentTimes
+	// write-ahead log journal; nil unless durable.
+	journal *walJournal
 }
 
 // Add adds n (positive or negative) to the current value.
-func (u *BasicUpDownCounter) Add(n int64) { u.val.Add(n) }
+func (u *BasicUpDownCounter) Add(n int64) {
+	if u.journal.begin(n) {
+		defer u.journal.end()
+	}
+	u.val.Add(n)
+	u.times.touch()
+}
 
 // Snapshot returns the current value.
 func (u *BasicUpDownCounter) Snapshot() int64 { return u.val.Load() }
 
-// BasicHistogram is a thread-safe histogram that tracks count, sum, min, and max.
-// It does not maintain buckets; it's intended as a lightweight, general-purpose aggregator.
+func (u *BasicUpDownCounter) restore(n int64) { u.val.Add(n) }
+
+// Reset sets the value to zero. Adds racing with Reset may or may not be counted.
+func (u *BasicUpDownCounter) Reset() {
+	if u.journal.beginReset() {
+		defer u.journal.end()
+	}
+	u.val.Store(0)
+	u.times.reset()
+}
+
+// BasicHistogram is a thread-safe histogram that tracks count, sum, min, and max and,
+// if constructed with bucket bounds (see WithBuckets), per-bucket counts. Each bucket can
+// keep a bounded reservoir of exemplars offered by RecordWithExemplar or RecordContext.
+//
+// Record is lock-free: it updates one of two sets of atomic accumulators (the "hot" one)
+// using CAS loops for the float fields. Snapshot swaps the hot and cold sets, waits for
+// recordings still in flight on the now-cold set to complete, reads it and folds it back
+// into the new hot set. Snapshots are therefore internally consistent (Sum, Min and Max
+// always cover exactly Count measurements) while recorders never block.
+// The zero value is ready to use.
 type BasicHistogram struct {
-	mu    sync.Mutex
-	count int64
-	sum   float64
-	min   float64
-	max   float64
+	// countAndHotIdx holds the index of the hot accumulators in its highest bit and the
+	// number of Record calls started so far in the remaining 63 bits.
+	countAndHotIdx atomic.Uint64
+	// mu serializes Snapshot calls; Record never takes it.
+	mu     sync.Mutex
+	counts [2]histCounts
+	// upper bounds of the finite buckets; the +Inf bucket is implicit.
+	bounds []float64
+	// exemplars per bucket (len(bounds)+1 buckets); nil if exemplars are disabled.
+	ex *exemplarReservoir
+	// lifecycle timestamps; nil unless created by BasicProvider.
+	times *instrumentTimes
 }
 
+// newBasicHistogram constructs a BasicHistogram with the given sorted bucket bounds.
+func newBasicHistogram(bounds []float64, ex *exemplarReservoir) *BasicHistogram {
+	h := &BasicHistogram{ex: ex}
+	if len(bounds) > 0 {
+		h.bounds = bounds
+		h.counts[0].buckets = make([]atomic.Uint64, len(bounds))
+		h.counts[1].buckets = make([]atomic.Uint64, len(bounds))
+	}
+	return h
+}
+
+// histCounts is one set of accumulators of a BasicHistogram.
+type histCounts struct {
+	// count of completed Record calls.
+	count atomic.Uint64
+	sum   atomicFloat
+	min   atomicFloatMin
+	max   atomicFloatMax
+	// counts of the finite buckets; the +Inf bucket count is derived from count.
+	buckets []atomic.Uint64
+}
+
+const (
+	hotIdxBit  = uint64(1) << 63
+	countMask  = hotIdxBit - 1
+	posInfBits = 0x7FF0000000000000 // math.Float64bits(math.Inf(1))
+	negInfBits = 0xFFF0000000000000 // math.Float64bits(math.Inf(-1))
+)
+
 // Record adds a measurement to the histogram.
 func (h *BasicHistogram) Record(v float64) {
-	h.mu.Lock()
-	if h.count == 0 {
-		// initialize min/max on first record
-		h.min, h.max = v, v
-	} else {
-		if v < h.min {
-			h.min = v
-		}
-		if v > h.max {
-			h.max = v
-		}
+	n := h.countAndHotIdx.Add(1)
+	hc := &h.counts[n>>63]
+	if i := h.bucketIndex(v); i < len(hc.buckets) {
+		hc.buckets[i].Add(1)
+	}
+	hc.sum.add(v)
+	hc.min.update(v)
+	hc.max.update(v)
+	// must be last: Snapshot relies on count to know the measurement is fully recorded
+	hc.count.Add(1)
+	h.times.touch()
+}
+
+// RecordN implements BatchHistogram: it adds n measurements of v to the histogram at once.
+func (h *BasicHistogram) RecordN(v float64, n int64) {
+	if n <= 0 {
+		return
+	}
+	c := h.countAndHotIdx.Add(uint64(n))
+	hc := &h.counts[c>>63]
+	if i := h.bucketIndex(v); i < len(hc.buckets) {
+		hc.buckets[i].Add(uint64(n))
+	}
+	hc.sum.add(v * float64(n))
+	hc.min.update(v)
+	hc.max.update(v)
+	hc.count.Add(uint64(n))
+	h.times.touch()
+}
+
+// RecordWithExemplar adds a measurement to the histogram and offers ex, with v as its value,
+// to the reservoir of the bucket v falls into.
+func (h *BasicHistogram) RecordWithExemplar(v float64, ex Exemplar) {
+	h.Record(v)
+	h.ex.offer(h.bucketIndex(v), v, ex)
+}
+
+// RecordContext adds a measurement to the histogram and offers an exemplar built from the
+// trace and attributes carried by ctx, if any (see ContextWithTrace).
+func (h *BasicHistogram) RecordContext(ctx context.Context, v float64) {
+	h.Record(v)
+	if ex, ok := exemplarFromContext(ctx); ok {
+		h.ex.offer(h.bucketIndex(v), v, ex)
+	}
+}
+
+// bucketIndex returns the index of the bucket v falls into; len(h.bounds) is the +Inf bucket.
+func (h *BasicHistogram) bucketIndex(v float64) int {
+	if len(h.bounds) == 0 {
+		return 0
 	}
-	h.count++
-	h.sum += v
-	h.mu.Unlock()
+	return sort.SearchFloat64s(h.bounds, v)
 }
 
 // HistSnapshot is an immutable snapshot of a BasicHistogram.
@@ -63,19 +247,192 @@ type HistSnapshot struct {
 	Min   float64
 	Max   float64
 	Mean  float64
+	// Buckets holds one entry per finite bucket bound followed by the +Inf bucket.
+	// Counts are per bucket (not cumulative). Histograms without bounds have only the +Inf bucket.
+	Buckets []HistBucket
+	// Quantiles are the estimated quantiles of a windowed histogram; nil for cumulative ones.
+	Quantiles []Quantile
+	// sketch holds the measurements behind Quantiles, so that snapshots can be merged;
+	// nil for cumulative histograms.
+	sketch *quantileSketch
+}
+
+// HistBucket is a histogram bucket in a HistSnapshot.
+type HistBucket struct {
+	// UpperBound is the inclusive upper bound of the bucket; +Inf for the last one.
+	UpperBound float64
+	// Count is the number of measurements in the bucket (not cumulative).
+	Count int64
+	// Exemplars are the most recent exemplars kept for the bucket, oldest first.
+	Exemplars []Exemplar
 }
 
 // Snapshot returns a copy of the histogram state at the time of call.
+// For an empty histogram Min is +Inf and Max is -Inf.
 func (h *BasicHistogram) Snapshot() HistSnapshot {
 	h.mu.Lock()
-	count := h.count
-	sum := h.sum
-	minV := h.min
-	maxV := h.max
-	h.mu.Unlock()
+	defer h.mu.Unlock()
+
+	// flip the hot index; from now on new recordings go to the other set
+	n := h.countAndHotIdx.Add(hotIdxBit)
+	started := n & countMask
+	hot := &h.counts[n>>63]
+	cold := &h.counts[(n>>63)^1]
+
+	// the cold set holds every measurement started before the flip;
+	// wait for the ones still in flight to complete
+	for cold.count.Load() != started {
+		runtime.Gosched()
+	}
+
+	count := cold.count.Load()
+	sum := cold.sum.load()
+	minV := cold.min.load()
+	maxV := cold.max.load()
+	buckets := make([]HistBucket, len(h.bounds)+1)
+	finite := uint64(0)
+	for i := range cold.buckets {
+		c := cold.buckets[i].Load()
+		finite += c
+		buckets[i] = HistBucket{UpperBound: h.bounds[i], Count: int64(c)}
+	}
+	buckets[len(h.bounds)] = HistBucket{UpperBound: math.Inf(1), Count: int64(count - finite)}
+
+	// fold the cold set into the hot one and reset it, so that the hot set is again
+	// cumulative and the cold one empty for the next flip
+	for i := range cold.buckets {
+		hot.buckets[i].Add(cold.buckets[i].Load())
+		cold.buckets[i].Store(0)
+	}
+	hot.sum.add(sum)
+	hot.min.update(minV)
+	hot.max.update(maxV)
+	hot.count.Add(count)
+	cold.sum.reset()
+	cold.min.reset()
+	cold.max.reset()
+	cold.count.Store(0)
+
+	for i := range buckets {
+		buckets[i].Exemplars = h.ex.collect(i)
+	}
 	mean := 0.0
 	if count > 0 {
 		mean = sum / float64(count)
 	}
-	return HistSnapshot{Count: count, Sum: sum, Min: minV, Max: maxV, Mean: mean}
+	return HistSnapshot{Count: int64(count), Sum: sum, Min: minV, Max: maxV, Mean: mean, Buckets: buckets}
+}
+
+// Reset discards all measurements and exemplars. Recordings racing with Reset may or may
+// not be kept.
+func (h *BasicHistogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// after a Snapshot the hot set holds every measurement: flip and wait as Snapshot does,
+	// then clear the cold set instead of folding it back
+	n := h.countAndHotIdx.Add(hotIdxBit)
+	started := n & countMask
+	cold := &h.counts[(n>>63)^1]
+	for cold.count.Load() != started {
+		runtime.Gosched()
+	}
+	for i := range cold.buckets {
+		cold.buckets[i].Store(0)
+	}
+	cold.sum.reset()
+	cold.min.reset()
+	cold.max.reset()
+	cold.count.Store(0)
+	// the started count must again match what the sets hold: subtract the discarded ones,
+	// leaving the hot index bit untouched
+	h.countAndHotIdx.Add(-started)
+	h.ex.reset()
+	h.times.reset()
+}
+
+// restore adds the measurements of s as if recorded at once. Bucket counts are restored
+// only if s has the same bounds as h; otherwise the measurements count in the +Inf bucket.
+func (h *BasicHistogram) restore(s HistSnapshot) {
+	if s.Count <= 0 {
+		return
+	}
+	sameBounds := len(s.Buckets) == len(h.bounds)+1
+	for i := 0; sameBounds && i < len(h.bounds); i++ {
+		sameBounds = s.Buckets[i].UpperBound == h.bounds[i]
+	}
+	// like Record, but for s.Count measurements at once
+	n := h.countAndHotIdx.Add(uint64(s.Count))
+	hc := &h.counts[n>>63]
+	if sameBounds {
+		for i := range hc.buckets {
+			hc.buckets[i].Add(uint64(s.Buckets[i].Count))
+		}
+	}
+	hc.sum.add(s.Sum)
+	hc.min.update(s.Min)
+	hc.max.update(s.Max)
+	hc.count.Add(uint64(s.Count))
+}
+
+// atomicFloat is a float64 updated atomically with a CAS loop. The zero value is 0.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) load() float64 { return math.Float64frombits(f.bits.Load()) }
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) reset() { f.bits.Store(0) }
+
+// atomicFloatMin tracks a minimum atomically. Bits are stored XOR-ed with +Inf
+// so that the zero value represents +Inf (no measurement yet).
+type atomicFloatMin struct {
+	bits atomic.Uint64
+}
+
+func (m *atomicFloatMin) load() float64 { return math.Float64frombits(m.bits.Load() ^ posInfBits) }
+
+func (m *atomicFloatMin) update(v float64) {
+	for {
+		old := m.bits.Load()
+		if !(v < math.Float64frombits(old^posInfBits)) {
+			return
+		}
+		if m.bits.CompareAndSwap(old, math.Float64bits(v)^posInfBits) {
+			return
+		}
+	}
+}
+
+func (m *atomicFloatMin) reset() { m.bits.Store(0) }
+
+// atomicFloatMax tracks a maximum atomically. Bits are stored XOR-ed with -Inf
+// so that the zero value represents -Inf (no measurement yet).
+type atomicFloatMax struct {
+	bits atomic.Uint64
+}
+
+func (m *atomicFloatMax) load() float64 { return math.Float64frombits(m.bits.Load() ^ negInfBits) }
+
+func (m *atomicFloatMax) update(v float64) {
+	for {
+		old := m.bits.Load()
+		if !(v > math.Float64frombits(old^negInfBits)) {
+			return
+		}
+		if m.bits.CompareAndSwap(old, math.Float64bits(v)^negInfBits) {
+			return
+		}
+	}
 }
+
+func (m *atomicFloatMax) reset() { m.bits.Store(0) }
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mutexHistogram is the previous mutex-based BasicHistogram implementation,
+// kept for benchmark comparisons only.
+type mutexHistogram struct {
+	mu    sync.Mutex
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func (h *mutexHistogram) Record(v float64) {
+	h.mu.Lock()
+	if h.count == 0 {
+		h.min, h.max = v, v
+	} else {
+		if v < h.min {
+			h.min = v
+		}
+		if v > h.max {
+			h.max = v
+		}
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func TestBasicHistogram_ZeroValue(t *testing.T) {
+	var h BasicHistogram
+	s := h.Snapshot()
+	if s.Count != 0 || s.Sum != 0 || s.Mean != 0 || !math.IsInf(s.Min, 1) || !math.IsInf(s.Max, -1) {
+		t.Fatalf("unexpected empty snapshot %+v", s)
+	}
+
+	h.Record(-2)
+	h.Record(4)
+	s = h.Snapshot()
+	if s.Count != 2 || s.Sum != 2 || s.Min != -2 || s.Max != 4 || s.Mean != 1 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+}
+
+func TestBasicHistogram_SnapshotsAreCumulative(t *testing.T) {
+	var h BasicHistogram
+	for i := 1; i <= 5; i++ {
+		h.Record(float64(i))
+		s := h.Snapshot()
+		if s.Count != int64(i) || s.Sum != float64(i*(i+1)/2) || s.Min != 1 || s.Max != float64(i) {
+			t.Fatalf("snapshot %d: unexpected %+v", i, s)
+		}
+	}
+}
+
+func TestRecordN(t *testing.T) {
+	p := NewBasicProvider()
+	for _, h := range []Histogram{
+		p.Histogram("basic", WithBuckets(1, 10)),
+		p.Histogram("windowed", WithBuckets(1, 10), WithRollingWindow(HistogramWindow{Duration: time.Minute})),
+		&mutexHistogram{}, // without RecordN
+	} {
+		RecordN(h, 5, 3)
+		RecordN(h, 0.5, 0)
+		h.Record(20)
+		sh, ok := h.(interface{ Snapshot() HistSnapshot })
+		if !ok {
+			if m := h.(*mutexHistogram); m.count != 4 || m.sum != 35 {
+				t.Fatalf("unexpected fallback recordings %+v", m)
+			}
+			continue
+		}
+		s := sh.Snapshot()
+		if s.Count != 4 || s.Sum != 35 || s.Min != 5 || s.Max != 20 ||
+			s.Buckets[0].Count != 0 || s.Buckets[1].Count != 3 || s.Buckets[2].Count != 1 {
+			t.Fatalf("%T: unexpected snapshot %+v", h, s)
+		}
+	}
+}
+
+func TestBasicHistogram_ConcurrentSnapshotsConsistent(t *testing.T) {
+	var h BasicHistogram
+	workers := runtime.NumCPU() * 2
+	iters := 2000
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+
+	// reader: every snapshot must be internally consistent
+	readerErr := make(chan string, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var last int64
+		for !stop.Load() {
+			s := h.Snapshot()
+			switch {
+			case s.Count < last:
+				readerErr <- "count went backwards"
+				return
+			case s.Sum != float64(s.Count):
+				readerErr <- "sum does not match count"
+				return
+			case s.Count > 0 && (s.Min != 1 || s.Max != 1):
+				readerErr <- "min/max do not match recorded values"
+				return
+			}
+			last = s.Count
+		}
+	}()
+
+	var writers sync.WaitGroup
+	writers.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer writers.Done()
+			for i := 0; i < iters; i++ {
+				h.Record(1)
+			}
+		}()
+	}
+	writers.Wait()
+	stop.Store(true)
+	wg.Wait()
+
+	select {
+	case msg := <-readerErr:
+		t.Fatalf("inconsistent snapshot: %s", msg)
+	default:
+	}
+	if got, want := h.Snapshot().Count, int64(workers*iters); got != want {
+		t.Fatalf("count = %d; want %d", got, want)
+	}
+}
+
+func BenchmarkMutexHistogram_RecordParallel(b *testing.B) {
+	h := &mutexHistogram{}
+	b.RunParallel(func(pb *testing.PB) {
+		v := 0.0
+		for pb.Next() {
+			h.Record(v)
+			v += 0.001
+		}
+	})
+}
+
+func BenchmarkBasicHistogram_RecordParallel(b *testing.B) {
+	h := &BasicHistogram{}
+	b.RunParallel(func(pb *testing.PB) {
+		v := 0.0
+		for pb.Next() {
+			h.Record(v)
+			v += 0.001
+		}
+	})
+}
+
+func BenchmarkBasicHistogram_Snapshot(b *testing.B) {
+	h := &BasicHistogram{}
+	h.Record(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = h.Snapshot()
+	}
+}
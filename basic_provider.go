@@ -1,7 +1,8 @@
 package metrics
 
 import (
-	"math"
+	"errors"
+	"os"
 	"sync"
 	"sync/atomic"
 )
@@ -14,10 +15,11 @@ type BasicProvider struct {
 	cfg    *basicProviderConfig
 	logger logger
 
-	counters   sync.Map // map[string]*BasicCounter
-	updowns    sync.Map // map[string]*BasicUpDownCounter
-	histograms sync.Map // map[string]*BasicHistogram
+	counters   registry[counterInstrument]
+	updowns    registry[*BasicUpDownCounter]
+	histograms registry[histogramInstrument]
 	meta       sync.Map // map[InstrumentKey]InstrumentConfig
+	times      sync.Map // map[InstrumentKey]*instrumentTimes
 	// per-key init mutexes: protect concurrent initialization for the same key
 	inits sync.Map // map[InstrumentKey]*sync.Mutex
 }
@@ -25,7 +27,7 @@ type BasicProvider struct {
 // NewBasicProvider constructs a new BasicProvider.
 // Accepts optional functional options to customize behavior.
 func NewBasicProvider(opts ...BasicProviderOption) *BasicProvider {
-	cfg := &basicProviderConfig{}
+	cfg := &basicProviderConfig{clock: SystemClock()}
 	for _, o := range opts {
 		if o != nil {
 			o(cfg)
@@ -35,9 +37,28 @@ func NewBasicProvider(opts ...BasicProviderOption) *BasicProvider {
 	if l == nil {
 		l = newNoopLogger()
 	}
-	return &BasicProvider{cfg: cfg, logger: l}
+	if cfg.wal != nil && !cfg.wal.attach() {
+		l.Errorf("[metrics] write-ahead log in %s is already used by another provider", cfg.wal.dir)
+		cfg.wal = nil
+	}
+	p := &BasicProvider{cfg: cfg, logger: l}
+	if cfg.restorePath != "" {
+		snap, err := LoadSnapshot(cfg.restorePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			l.Errorf("[metrics] restoring snapshot from %s: %v", cfg.restorePath, err)
+		default:
+			p.Restore(snap)
+		}
+	}
+	return p
 }
 
+// Clock returns the clock of the provider (see WithClock), e.g. to construct rate trackers
+// driven by the same clock.
+func (p *BasicProvider) Clock() Clock { return p.cfg.clock }
+
 // keyMu returns a per-key mutex for the given key, creating one if necessary.
 // The returned mutex is owned by the provider and should be locked/unlocked by callers.
 func (p *BasicProvider) keyMu(key InstrumentKey) *sync.Mutex {
@@ -56,70 +77,84 @@ func applyOptions(opts []InstrumentOption) InstrumentConfig {
 	return cfg
 }
 
-// get retrieves an existing instrument by key.
-func (p *BasicProvider) get(key InstrumentKey) (interface{}, bool) {
-	switch key.Type {
-	case InstrumentTypeCounter:
-		if v, ok := p.counters.Load(key.Name); ok {
-			return v.(*BasicCounter), true
-		}
-	case InstrumentTypeUpDown:
-		if v, ok := p.updowns.Load(key.Name); ok {
-			return v.(*BasicUpDownCounter), true
-		}
-	case InstrumentTypeHistogram:
-		if v, ok := p.histograms.Load(key.Name); ok {
-			return v.(*BasicHistogram), true
-		}
+// newCounter constructs a counter according to the provider configuration.
+func (p *BasicProvider) newCounter(key InstrumentKey, cfg InstrumentConfig, times *instrumentTimes) counterInstrument {
+	if p.cfg.counterShards > 0 {
+		c := NewShardedCounter(p.cfg.counterShards)
+		c.ex = p.cfg.newExemplarReservoir(1)
+		c.times = times
+		c.journal = p.journal(key, cfg, times, c)
+		return c
 	}
-	return nil, false
+	c := &BasicCounter{ex: p.cfg.newExemplarReservoir(1), times: times}
+	c.journal = p.journal(key, cfg, times, c)
+	return c
 }
 
-// create constructs and stores a new instance into the appropriate sync.Map.
-func (p *BasicProvider) create(key InstrumentKey) interface{} {
-	switch key.Type {
-	case InstrumentTypeCounter:
-		c := &BasicCounter{}
-		p.counters.Store(key.Name, c)
-		return c
-	case InstrumentTypeUpDown:
-		u := &BasicUpDownCounter{}
-		p.updowns.Store(key.Name, u)
-		return u
-	case InstrumentTypeHistogram:
-		h := &BasicHistogram{min: math.Inf(1), max: math.Inf(-1)}
-		p.histograms.Store(key.Name, h)
-		return h
-	default:
+func (p *BasicProvider) newUpDownCounter(key InstrumentKey, cfg InstrumentConfig, times *instrumentTimes) *BasicUpDownCounter {
+	u := &BasicUpDownCounter{times: times}
+	u.journal = p.journal(key, cfg, times, u)
+	return u
+}
+
+// journal registers a durable instrument with the write-ahead log of the provider. It returns
+// nil if the instrument is not durable or the provider has no write-ahead log.
+func (p *BasicProvider) journal(key InstrumentKey, cfg InstrumentConfig, times *instrumentTimes, inst durableInstrument) *walJournal {
+	if !cfg.Durable || p.cfg.wal == nil {
 		return nil
 	}
+	return p.cfg.wal.register(key, cfg, times, inst)
+}
+
+// newHistogram constructs a histogram with the buckets from cfg: a *WindowedHistogram if
+// cfg has a window, a *BasicHistogram otherwise.
+func (p *BasicProvider) newHistogram(_ InstrumentKey, cfg InstrumentConfig, times *instrumentTimes) histogramInstrument {
+	ex := p.cfg.newExemplarReservoir(len(cfg.Buckets) + 1)
+	if cfg.Window != nil {
+		return newWindowedHistogram(*cfg.Window, windowedHistogramConfig{
+			clock: p.cfg.clock, bounds: cfg.Buckets, ex: ex, times: times,
+		})
+	}
+	h := newBasicHistogram(cfg.Buckets, ex)
+	h.times = times
+	return h
 }
 
 // Counter returns a monotonic counter instrument for the given name (created once).
+// The instrument is a *BasicCounter, or a *ShardedCounter if the provider was constructed
+// with WithShardedCounters.
 func (p *BasicProvider) Counter(name string, opts ...InstrumentOption) Counter {
 	key := NewInstrumentKey(InstrumentTypeCounter, name)
-	return p.getOrCreate(key, opts).(*BasicCounter)
+	return getOrCreate(p, &p.counters, key, opts, p.newCounter)
 }
 
 // UpDownCounter returns an up/down counter instrument for the given name (created once).
 func (p *BasicProvider) UpDownCounter(name string, opts ...InstrumentOption) UpDownCounter {
 	key := NewInstrumentKey(InstrumentTypeUpDown, name)
-	return p.getOrCreate(key, opts).(*BasicUpDownCounter)
+	return getOrCreate(p, &p.updowns, key, opts, p.newUpDownCounter)
 }
 
 // Histogram returns a histogram instrument for the given name (created once).
+// The instrument is a *BasicHistogram, or a *WindowedHistogram if created with WithRollingWindow.
 func (p *BasicProvider) Histogram(name string, opts ...InstrumentOption) Histogram {
 	key := NewInstrumentKey(InstrumentTypeHistogram, name)
-	return p.getOrCreate(key, opts).(*BasicHistogram)
+	return getOrCreate(p, &p.histograms, key, opts, p.newHistogram)
 }
 
 // getOrCreate is a helper that implements a fast read path, computes options before
 // acquiring locks, and uses a per-key mutex to deduplicate concurrent initializations.
+//   - reg is the typed registry of the instrument type; its lookups neither lock nor allocate,
+//     so repeat lookups of an existing instrument are allocation-free.
 //   - key is a compound "typ:name" key used for both the per-key mutex and meta storage.
 //   - opts are the instrument options (passed to applyOptions).
-func (p *BasicProvider) getOrCreate(key InstrumentKey, opts []InstrumentOption) interface{} {
-	// fast read path using sync.Map loads (safe without a global lock)
-	if v, ok := p.get(key); ok {
+//   - create constructs a new instrument from its key, the config built from opts and the
+//     timestamps recorder of the instrument, which getOrCreate also stores for inspection.
+func getOrCreate[T any](
+	p *BasicProvider, reg *registry[T], key InstrumentKey, opts []InstrumentOption,
+	create func(InstrumentKey, InstrumentConfig, *instrumentTimes) T,
+) T {
+	// fast read path using typed registry loads (safe without a global lock)
+	if v, ok := reg.Load(key.Name); ok {
 		return v
 	}
 
@@ -132,12 +167,15 @@ func (p *BasicProvider) getOrCreate(key InstrumentKey, opts []InstrumentOption)
 	defer km.Unlock()
 
 	// re-check after acquiring per-key mutex
-	if v, ok := p.get(key); ok {
+	if v, ok := reg.Load(key.Name); ok {
 		return v
 	}
 	// store metadata computed earlier using the compound key typ:name
 	p.meta.Store(key, cfg)
-	inst := p.create(key)
+	times := newInstrumentTimes(p.cfg.clock, p.cfg.trackLastUpdate)
+	p.times.Store(key, times)
+	inst := create(key, cfg, times)
+	reg.Store(key.Name, inst)
 	// optional cleanup: remove the per-key mutex from the inits map to allow GC of mutexes
 	// It's safe to delete while holding the mutex; existing goroutines that already
 	// hold the pointer will continue to use it, and new callers will get a new mutex.
@@ -183,3 +221,76 @@ func (p *BasicProvider) reportInvariantViolation(kind string, key InstrumentKey)
 func isDebugBuild() bool {
 	return raceBuild || debugBuild
 }
+
+// TrackCounterRates attaches rate trackers to the counter name, creating the counter with
+// opts if it does not exist yet. Readings are available through CounterRates and Snapshot.
+// Trackers of this package created without WithRateClock are driven by the clock of the
+// provider (see WithClock); they must not be in use yet.
+//
+//	p.TrackCounterRates("requests_total", metrics.NewLoadAverages())
+func (p *BasicProvider) TrackCounterRates(name string, trackers []RateTracker, opts ...InstrumentOption) {
+	for _, t := range trackers {
+		if d, ok := t.(defaultClockSetter); ok {
+			d.setDefaultClock(p.cfg.clock)
+		}
+	}
+	p.Counter(name, opts...).(counterInstrument).AttachRates(trackers...)
+}
+
+// ResetInstrument resets the instrument with the given type and name to its initial state,
+// recording the reset time (see InstrumentTimes). It reports whether the instrument exists.
+// Measurements racing with the reset may or may not be kept.
+func (p *BasicProvider) ResetInstrument(itype InstrumentType, name string) bool {
+	switch itype {
+	case InstrumentTypeCounter:
+		if c, ok := p.counters.Load(name); ok {
+			c.Reset()
+			return true
+		}
+	case InstrumentTypeUpDown:
+		if u, ok := p.updowns.Load(name); ok {
+			u.Reset()
+			return true
+		}
+	case InstrumentTypeHistogram:
+		if h, ok := p.histograms.Load(name); ok {
+			h.Reset()
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveInstrument removes the instrument with the given type and name from the provider,
+// with its metadata and times, so that it is no longer reported. It reports whether the
+// instrument existed. Instruments obtained before the removal keep working, unreported; the
+// next lookup of the name creates a new instrument.
+func (p *BasicProvider) RemoveInstrument(itype InstrumentType, name string) bool {
+	key := NewInstrumentKey(itype, name)
+	km := p.keyMu(key)
+	km.Lock()
+	defer km.Unlock()
+	var ok bool
+	switch itype {
+	case InstrumentTypeCounter:
+		if _, ok = p.counters.Load(name); ok {
+			p.counters.Delete(name)
+		}
+	case InstrumentTypeUpDown:
+		if _, ok = p.updowns.Load(name); ok {
+			p.updowns.Delete(name)
+		}
+	case InstrumentTypeHistogram:
+		if _, ok = p.histograms.Load(name); ok {
+			p.histograms.Delete(name)
+		}
+	}
+	if ok {
+		p.meta.Delete(key)
+		p.times.Delete(key)
+	}
+	if !p.cfg.doNotCleanupInits {
+		p.inits.Delete(key)
+	}
+	return ok
+}
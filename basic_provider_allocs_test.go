@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"strconv"
+	"testing"
+)
+
+// skipIfAllocsUnreliable skips allocation tests under the race detector, which allocates on its own.
+func skipIfAllocsUnreliable(t *testing.T) {
+	t.Helper()
+	if raceBuild {
+		t.Skip("allocation counts are not meaningful under the race detector")
+	}
+}
+
+func TestBasicProvider_RepeatLookupsDoNotAllocate(t *testing.T) {
+	skipIfAllocsUnreliable(t)
+
+	for _, p := range []*BasicProvider{NewBasicProvider(), NewBasicProvider(WithShardedCounters(2))} {
+		// names built at runtime, like in real code
+		cname, uname, hname := "c"+strconv.Itoa(1), "u"+strconv.Itoa(1), "h"+strconv.Itoa(1)
+		p.Counter(cname)
+		p.UpDownCounter(uname)
+		p.Histogram(hname)
+
+		cases := map[string]func(){
+			"counter":   func() { p.Counter(cname) },
+			"updown":    func() { p.UpDownCounter(uname) },
+			"histogram": func() { p.Histogram(hname) },
+		}
+		for name, f := range cases {
+			if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+				t.Fatalf("%s lookup: %v allocs per run; want 0", name, allocs)
+			}
+		}
+	}
+}
+
+func TestBasicInstruments_RecordingDoesNotAllocate(t *testing.T) {
+	skipIfAllocsUnreliable(t)
+
+	p := NewBasicProvider()
+	c := p.Counter("c")
+	u := p.UpDownCounter("u")
+	h := p.Histogram("h")
+	sc := NewShardedCounter(4)
+
+	cases := map[string]func(){
+		"counter_add":      func() { c.Add(1) },
+		"updown_add":       func() { u.Add(-1) },
+		"histogram_record": func() { h.Record(0.5) },
+		"sharded_add":      func() { sc.Add(1) },
+	}
+	for name, f := range cases {
+		if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+			t.Fatalf("%s: %v allocs per run; want 0", name, allocs)
+		}
+	}
+}
+
+func BenchmarkBasicProvider_CounterLookup(b *testing.B) {
+	p := NewBasicProvider()
+	p.Counter("requests_total")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Counter("requests_total").Add(1)
+	}
+}
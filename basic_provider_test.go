@@ -233,3 +233,36 @@ func TestBasicProvider_FetchAndMetaPreserved(t *testing.T) {
 		t.Fatalf("expected histogram description preserved, got %q", cfg.Description)
 	}
 }
+
+func TestBasicProvider_RemoveInstrument(t *testing.T) {
+	p := NewBasicProvider()
+	c := p.Counter("c", WithDescription("removed"))
+	c.Add(5)
+	p.UpDownCounter("u").Add(1)
+	p.Histogram("h").Record(1)
+	for _, k := range []InstrumentKey{
+		NewInstrumentKey(InstrumentTypeCounter, "c"),
+		NewInstrumentKey(InstrumentTypeUpDown, "u"),
+		NewInstrumentKey(InstrumentTypeHistogram, "h"),
+	} {
+		if !p.RemoveInstrument(k.Type, k.Name) {
+			t.Fatalf("expected %v to exist", k)
+		}
+		if p.RemoveInstrument(k.Type, k.Name) {
+			t.Fatalf("expected %v to be removed", k)
+		}
+	}
+	if snap := p.Snapshot(); len(snap.Instruments) != 0 || len(p.ListMetadata()) != 0 {
+		t.Fatalf("expected no instruments, got %+v", snap.Instruments)
+	}
+
+	// the old instrument is detached, the name creates a new one
+	c.Add(1)
+	if _, cfg, _ := p.CounterWithMeta("c"); cfg.Description != "" {
+		t.Fatalf("expected the metadata to be removed, got %+v", cfg)
+	}
+	p.Counter("c").Add(2)
+	if s, _ := p.Snapshot().Get(InstrumentTypeCounter, "c"); s.Value != 2 {
+		t.Fatalf("expected a new counter, got %+v", s)
+	}
+}
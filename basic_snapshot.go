@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// ProviderSnapshot is a point-in-time copy of the state of all instruments of a provider.
+// It is a plain value: exporters, persistence and test helpers work on snapshots rather than
+// on live instruments.
+type ProviderSnapshot struct {
+	// Time is when the snapshot was taken.
+	Time time.Time
+	// Instruments are sorted by type, then name.
+	Instruments []InstrumentSnapshot
+}
+
+// InstrumentSnapshot is the state of a single instrument within a ProviderSnapshot.
+type InstrumentSnapshot struct {
+	Type   InstrumentType
+	Name   string
+	Config InstrumentConfig // defensive copy
+	// Value is the current value of a counter or up/down counter.
+	Value int64
+	// Histogram is the state of a histogram.
+	Histogram HistSnapshot
+	// Exemplars are the most recent exemplars kept by a counter, oldest first.
+	// Histogram exemplars are kept per bucket in Histogram.Buckets.
+	Exemplars []Exemplar
+	// Rates are the readings of the rate trackers attached to a counter.
+	Rates []Rate
+	// Times are the lifecycle timestamps of the instrument.
+	Times InstrumentTimes
+}
+
+// Key returns the InstrumentKey of the instrument.
+func (s InstrumentSnapshot) Key() InstrumentKey {
+	return NewInstrumentKey(s.Type, s.Name)
+}
+
+// Get returns the snapshot of the instrument with the given type and name, if present.
+func (s ProviderSnapshot) Get(itype InstrumentType, name string) (InstrumentSnapshot, bool) {
+	i := sort.Search(len(s.Instruments), func(i int) bool {
+		return !lessKey(s.Instruments[i].Key(), NewInstrumentKey(itype, name))
+	})
+	if i < len(s.Instruments) && s.Instruments[i].Type == itype && s.Instruments[i].Name == name {
+		return s.Instruments[i], true
+	}
+	return InstrumentSnapshot{}, false
+}
+
+// lessKey orders instrument keys by type, then name.
+func lessKey(a, b InstrumentKey) bool {
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return a.Name < b.Name
+}
+
+// sortInstruments sorts instrument snapshots by type, then name.
+func sortInstruments(in []InstrumentSnapshot) {
+	sort.Slice(in, func(i, j int) bool { return lessKey(in[i].Key(), in[j].Key()) })
+}
+
+// Snapshot returns the state of all instruments of the provider. Each instrument is read
+// atomically (histograms are internally consistent), but the snapshot as a whole is
+// best-effort: it may race with concurrent creations and recordings.
+func (p *BasicProvider) Snapshot() ProviderSnapshot {
+	out := ProviderSnapshot{Time: p.cfg.clock.Now()}
+	p.counters.Range(func(name string, c counterInstrument) bool {
+		key := NewInstrumentKey(InstrumentTypeCounter, name)
+		cfg, _ := p.getInstrumentMeta(key)
+		out.Instruments = append(out.Instruments, InstrumentSnapshot{
+			Type: key.Type, Name: name, Config: cfg, Value: c.Snapshot(), Exemplars: c.Exemplars(), Rates: c.Rates(),
+			Times: p.instrumentTimes(key),
+		})
+		return true
+	})
+	p.updowns.Range(func(name string, u *BasicUpDownCounter) bool {
+		key := NewInstrumentKey(InstrumentTypeUpDown, name)
+		cfg, _ := p.getInstrumentMeta(key)
+		out.Instruments = append(out.Instruments, InstrumentSnapshot{
+			Type: key.Type, Name: name, Config: cfg, Value: u.Snapshot(), Times: p.instrumentTimes(key),
+		})
+		return true
+	})
+	p.histograms.Range(func(name string, h histogramInstrument) bool {
+		key := NewInstrumentKey(InstrumentTypeHistogram, name)
+		cfg, _ := p.getInstrumentMeta(key)
+		out.Instruments = append(out.Instruments, InstrumentSnapshot{
+			Type: key.Type, Name: name, Config: cfg, Histogram: h.Snapshot(), Times: p.instrumentTimes(key),
+		})
+		return true
+	})
+	sortInstruments(out.Instruments)
+	return out
+}
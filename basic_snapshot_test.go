@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBasicProvider_Snapshot(t *testing.T) {
+	p := NewBasicProvider()
+	p.Histogram("h", WithBuckets(1), WithUnit("s")).Record(0.5)
+	p.Counter("b", WithDescription("b counter")).Add(2)
+	p.Counter("a").Add(1)
+	p.UpDownCounter("u").Add(-3)
+
+	snap := p.Snapshot()
+	if snap.Time.IsZero() {
+		t.Fatalf("expected snapshot time to be set")
+	}
+	want := []InstrumentKey{
+		NewInstrumentKey(InstrumentTypeCounter, "a"),
+		NewInstrumentKey(InstrumentTypeCounter, "b"),
+		NewInstrumentKey(InstrumentTypeHistogram, "h"),
+		NewInstrumentKey(InstrumentTypeUpDown, "u"),
+	}
+	if len(snap.Instruments) != len(want) {
+		t.Fatalf("expected %d instruments, got %d", len(want), len(snap.Instruments))
+	}
+	for i, k := range want {
+		if snap.Instruments[i].Key() != k {
+			t.Fatalf("instrument %d = %v; want %v", i, snap.Instruments[i].Key(), k)
+		}
+	}
+
+	b, ok := snap.Get(InstrumentTypeCounter, "b")
+	if !ok || b.Value != 2 || b.Config.Description != "b counter" {
+		t.Fatalf("unexpected counter snapshot %+v", b)
+	}
+	u, _ := snap.Get(InstrumentTypeUpDown, "u")
+	if u.Value != -3 {
+		t.Fatalf("updown = %d; want -3", u.Value)
+	}
+	h, _ := snap.Get(InstrumentTypeHistogram, "h")
+	if h.Histogram.Count != 1 || h.Config.Unit != "s" || len(h.Histogram.Buckets) != 2 {
+		t.Fatalf("unexpected histogram snapshot %+v", h)
+	}
+	if _, ok := snap.Get(InstrumentTypeCounter, "missing"); ok {
+		t.Fatalf("expected missing instrument not found")
+	}
+	if _, ok := snap.Get(InstrumentTypeUpDown, "zzz"); ok {
+		t.Fatalf("expected missing instrument not found")
+	}
+
+	// defensive copy of config
+	h.Config.Buckets[0] = 42
+	if _, cfg, _ := p.HistogramWithMeta("h"); cfg.Buckets[0] != 1 {
+		t.Fatalf("snapshot config must be a copy")
+	}
+}
+
+func TestWithBuckets(t *testing.T) {
+	cfg := applyOptions([]InstrumentOption{WithBuckets(5, 1, math.Inf(1), 1, math.NaN(), 2)})
+	want := []float64{1, 2, 5}
+	if len(cfg.Buckets) != len(want) {
+		t.Fatalf("buckets = %v; want %v", cfg.Buckets, want)
+	}
+	for i := range want {
+		if cfg.Buckets[i] != want[i] {
+			t.Fatalf("buckets = %v; want %v", cfg.Buckets, want)
+		}
+	}
+	if cfg := applyOptions([]InstrumentOption{WithBuckets(math.Inf(1))}); cfg.Buckets != nil {
+		t.Fatalf("expected nil buckets, got %v", cfg.Buckets)
+	}
+}
+
+func TestBasicHistogram_BucketCounts(t *testing.T) {
+	p := NewBasicProvider()
+	h := p.Histogram("h", WithBuckets(1, 2)).(*BasicHistogram)
+	for _, v := range []float64{0.5, 1, 1.5, 2, 3, math.NaN()} {
+		h.Record(v)
+	}
+	s := h.Snapshot()
+	want := []HistBucket{{UpperBound: 1, Count: 2}, {UpperBound: 2, Count: 2}, {UpperBound: math.Inf(1), Count: 2}}
+	for i, b := range want {
+		if s.Buckets[i].UpperBound != b.UpperBound || s.Buckets[i].Count != b.Count {
+			t.Fatalf("bucket %d = %+v; want %+v", i, s.Buckets[i], b)
+		}
+	}
+	// snapshots stay cumulative across flips
+	h.Record(0.1)
+	if got := h.Snapshot().Buckets[0].Count; got != 3 {
+		t.Fatalf("first bucket = %d; want 3", got)
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import "time"
+
+// Clock is a source of time. Time-based features such as rate trackers, rolling windows,
+// snapshot and exemplar timestamps, timers and periodic exports take a Clock so that they
+// can be driven deterministically in tests (see metricstest.FakeClock).
+// Implementations must be safe for concurrent use.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer firing once after d, like time.NewTimer.
+	NewTimer(d time.Duration) ClockTimer
+	// NewTicker returns a ticker firing every d, like time.NewTicker. d must be positive.
+	NewTicker(d time.Duration) ClockTicker
+}
+
+// ClockTimer is a timer created by a Clock; see time.Timer.
+type ClockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// ClockTicker is a ticker created by a Clock; see time.Ticker.
+type ClockTicker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock returns the Clock backed by the time package.
+func SystemClock() Clock { return systemClock{} }
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) ClockTimer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) NewTicker(d time.Duration) ClockTicker { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
+
+// clockSource is implemented by providers exposing their Clock, such as BasicProvider.
+type clockSource interface {
+	Clock() Clock
+}
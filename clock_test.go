@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSystemClock(t *testing.T) {
+	c := SystemClock()
+	if d := time.Since(c.Now()); d < 0 || d > time.Minute {
+		t.Fatalf("unexpected system time offset %v", d)
+	}
+	tm := c.NewTimer(time.Millisecond)
+	<-tm.C()
+	if tm.Stop() {
+		t.Fatalf("expired timer must be inactive")
+	}
+	if tm.Reset(time.Hour) {
+		t.Fatalf("Reset of an expired timer must report false")
+	}
+	tm.Stop()
+	tk := c.NewTicker(time.Millisecond)
+	<-tk.C()
+	tk.Stop()
+}
+
+func TestBasicProvider_WithClock(t *testing.T) {
+	clk := newManualClock()
+	p := NewBasicProvider(WithClock(clk), WithClock(nil))
+	if p.Clock() != Clock(clk) {
+		t.Fatalf("expected provider clock")
+	}
+	if NewBasicProvider().Clock() != SystemClock() {
+		t.Fatalf("expected system clock by default")
+	}
+
+	AddWithContext(ContextWithTrace(context.Background(), "t", "s"), p.Counter("c"), 1)
+	p.Histogram("w", WithRollingWindow(HistogramWindow{Duration: time.Minute})).Record(1)
+	clk.Advance(time.Hour)
+
+	snap := p.Snapshot()
+	if !snap.Time.Equal(clk.Now()) {
+		t.Fatalf("snapshot time = %v; want %v", snap.Time, clk.Now())
+	}
+	c, _ := snap.Get(InstrumentTypeCounter, "c")
+	if len(c.Exemplars) != 1 || !c.Exemplars[0].Timestamp.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("unexpected exemplar timestamps %+v", c.Exemplars)
+	}
+	if w, _ := snap.Get(InstrumentTypeHistogram, "w"); w.Histogram.Count != 0 {
+		t.Fatalf("expected the window to follow the provider clock, got count %d", w.Histogram.Count)
+	}
+
+	tm := TimerFromProvider(p, "d")
+	if tm.clock != Clock(clk) {
+		t.Fatalf("expected timer to use the provider clock")
+	}
+	if NewFanoutProvider([]Provider{p}).Clock() != Clock(clk) {
+		t.Fatalf("expected fanout to expose the primary clock")
+	}
+	if NewFanoutProvider([]Provider{NoopProvider{}}).Clock() != SystemClock() {
+		t.Fatalf("expected system clock for primaries without a clock")
+	}
+}
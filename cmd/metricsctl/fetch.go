@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// format is the encoding of the endpoint response.
+type format int
+
+const (
+	formatAuto format = iota
+	formatJSON
+	formatBinary
+	formatText
+)
+
+func parseFormat(s string) (format, error) {
+	switch s {
+	case "auto":
+		return formatAuto, nil
+	case "json":
+		return formatJSON, nil
+	case "binary":
+		return formatBinary, nil
+	case "text", "openmetrics", "prometheus":
+		return formatText, nil
+	}
+	return 0, fmt.Errorf("unknown format %q", s)
+}
+
+// accept is the Accept header of the requests: snapshots are preferred as they carry the full
+// instrument configuration.
+const accept = metrics.SnapshotContentType + ", " + metrics.SnapshotBinaryContentType + ", " +
+	"application/openmetrics-text;q=0.8, text/plain;q=0.5"
+
+// fetcher reads snapshots from a metrics endpoint.
+type fetcher struct {
+	url    string
+	format format
+	client *http.Client
+}
+
+// fetch returns the current snapshot of the endpoint, sorted by type, then name. Snapshots
+// parsed from the text format are timed at their reception.
+func (f *fetcher) fetch(ctx context.Context) (metrics.ProviderSnapshot, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return metrics.ProviderSnapshot{}, err
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return metrics.ProviderSnapshot{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return metrics.ProviderSnapshot{}, fmt.Errorf("GET %s: %s: %s", f.url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	fm := f.format
+	if fm == formatAuto {
+		if fm, err = detectFormat(resp.Header.Get("Content-Type")); err != nil {
+			return metrics.ProviderSnapshot{}, fmt.Errorf("GET %s: %w", f.url, err)
+		}
+	}
+	var snap metrics.ProviderSnapshot
+	switch fm {
+	case formatJSON:
+		snap, err = metrics.DecodeSnapshot(resp.Body)
+	case formatBinary:
+		snap, err = metrics.DecodeSnapshotBinary(resp.Body)
+	default:
+		snap, err = parseText(resp.Body, time.Now())
+	}
+	if err != nil {
+		return metrics.ProviderSnapshot{}, fmt.Errorf("GET %s: %w", f.url, err)
+	}
+	sort.Slice(snap.Instruments, func(i, j int) bool {
+		a, b := snap.Instruments[i], snap.Instruments[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+	return snap, nil
+}
+
+func detectFormat(contentType string) (format, error) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0, fmt.Errorf("unsupported content type %q; use -format", contentType)
+	}
+	switch mt {
+	case metrics.SnapshotContentType:
+		return formatJSON, nil
+	case metrics.SnapshotBinaryContentType:
+		return formatBinary, nil
+	case "application/openmetrics-text", "text/plain":
+		return formatText, nil
+	}
+	return 0, fmt.Errorf("unsupported content type %q; use -format", contentType)
+}
+
+// filter selects instruments by type, name glob and attributes.
+type filter struct {
+	typ   metrics.InstrumentType // empty for any
+	name  string                 // path.Match pattern; empty for any
+	attrs map[string]string
+}
+
+func (f *filter) setType(s string) error {
+	switch t := metrics.InstrumentType(s); t {
+	case metrics.InstrumentTypeCounter, metrics.InstrumentTypeUpDown, metrics.InstrumentTypeHistogram:
+		f.typ = t
+		return nil
+	}
+	return fmt.Errorf("unknown instrument type %q", s)
+}
+
+func (f *filter) setName(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	f.name = pattern
+	return nil
+}
+
+func (f *filter) addAttr(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	if f.attrs == nil {
+		f.attrs = make(map[string]string)
+	}
+	f.attrs[k] = v
+	return nil
+}
+
+// apply returns the instruments of in matching f, in the same order.
+func (f filter) apply(in []metrics.InstrumentSnapshot) []metrics.InstrumentSnapshot {
+	out := in[:0:0]
+	for _, s := range in {
+		if f.match(s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (f filter) match(s metrics.InstrumentSnapshot) bool {
+	if f.typ != "" && s.Type != f.typ {
+		return false
+	}
+	if f.name != "" {
+		if ok, _ := path.Match(f.name, baseName(s.Name)); !ok {
+			return false
+		}
+	}
+	for k, v := range f.attrs {
+		if got, ok := s.Config.Attributes[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// baseName returns the instrument name without the attributes of a series name
+// (see metrics.SeriesName).
+func baseName(name string) string {
+	base, _ := metrics.ParseSeriesName(name)
+	return base
+}
@@ -0,0 +1,201 @@
+// Command metricsctl queries the metrics endpoint of a running service and pretty-prints its
+// instruments.
+//
+// Usage:
+//
+//	metricsctl list  [flags] URL   list instruments with their configuration
+//	metricsctl hist  [flags] URL   show histogram statistics in a table
+//	metricsctl watch [flags] URL   poll the endpoint and print deltas and rates
+//
+// The endpoint may serve snapshots encoded with metrics.EncodeSnapshot (application/json) or
+// metrics.EncodeSnapshotBinary, or the OpenMetrics or Prometheus text format, e.g. written by
+// metrics.WriteOpenMetrics. The format is detected from the response content type unless set
+// with -format.
+//
+// Instruments are filtered with -type, -name (a path.Match glob on the instrument name,
+// without attributes) and -attr key=value, which may be repeated.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: metricsctl <command> [flags] URL
+
+Commands:
+  list    list instruments with their configuration
+  hist    show histogram statistics in a table
+  watch   poll the endpoint and print deltas and rates between polls
+
+Run 'metricsctl <command> -h' for the flags of a command.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line args and returns the exit status: 0 on success, 1 on
+// failure and 2 on invalid usage.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("metricsctl "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		flt     filter
+		format  = fs.String("format", "auto", "format of the endpoint: auto, json, binary or text")
+		timeout = fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	)
+	fs.Func("type", "only instruments of this type: counter, updown or histogram", flt.setType)
+	fs.Func("name", "only instruments whose name matches this glob, e.g. 'http_*'", flt.setName)
+	fs.Func("attr", "only instruments with this attribute, as key=value (repeatable)", flt.addAttr)
+
+	var (
+		long      *bool
+		quantiles *string
+		interval  *time.Duration
+		count     *int
+	)
+	switch cmd {
+	case "list":
+		long = fs.Bool("l", false, "print the full configuration of every instrument")
+	case "hist":
+		quantiles = fs.String("q", "0.5,0.9,0.99", "comma-separated quantiles to show")
+	case "watch":
+		interval = fs.Duration("interval", 5*time.Second, "interval between polls")
+		count = fs.Int("count", 0, "number of reports to print before exiting; 0 to run until interrupted")
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "metricsctl: unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(stderr, "metricsctl %s: expected the endpoint URL\n", cmd)
+		fs.Usage()
+		return 2
+	}
+	f, err := parseFormat(*format)
+	if err != nil {
+		fmt.Fprintf(stderr, "metricsctl %s: %v\n", cmd, err)
+		return 2
+	}
+	fetch := &fetcher{url: fs.Arg(0), format: f, client: &http.Client{Timeout: *timeout}}
+
+	switch cmd {
+	case "list":
+		err = runList(ctx, fetch, flt, *long, stdout)
+	case "hist":
+		qs, perr := parseQuantiles(*quantiles)
+		if perr != nil {
+			fmt.Fprintf(stderr, "metricsctl %s: %v\n", cmd, perr)
+			return 2
+		}
+		err = runHist(ctx, fetch, flt, qs, stdout)
+	case "watch":
+		if *interval <= 0 {
+			fmt.Fprintf(stderr, "metricsctl %s: the interval must be positive\n", cmd)
+			return 2
+		}
+		err = runWatch(ctx, fetch, flt, *interval, *count, stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "metricsctl %s: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+func parseQuantiles(s string) ([]float64, error) {
+	var qs []float64
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(f, 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("invalid quantile %q: must be between 0 and 1", f)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+func runList(ctx context.Context, f *fetcher, flt filter, long bool, w io.Writer) error {
+	snap, err := f.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	snap.Instruments = flt.apply(snap.Instruments)
+	if long {
+		return printConfigs(w, snap)
+	}
+	return printList(w, snap)
+}
+
+func runHist(ctx context.Context, f *fetcher, flt filter, qs []float64, w io.Writer) error {
+	snap, err := f.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	snap.Instruments = flt.apply(snap.Instruments)
+	return printHistograms(w, snap, qs)
+}
+
+// runWatch polls the endpoint every interval and prints the changes since the previous poll,
+// count times or until ctx is done.
+func runWatch(ctx context.Context, f *fetcher, flt filter, interval time.Duration, count int, w io.Writer) error {
+	prev, err := f.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	prev.Instruments = flt.apply(prev.Instruments)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for n := 0; count <= 0 || n < count; n++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		cur, err := f.fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		cur.Instruments = flt.apply(cur.Instruments)
+		if err := printWatch(w, prev, cur); err != nil {
+			return err
+		}
+		prev = cur
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// newService returns a provider with a few instruments and a server exposing it in the
+// format requested by the query parameter "format": json (the default), binary or text.
+func newService(t *testing.T) (*metrics.BasicProvider, *httptest.Server) {
+	t.Helper()
+	p := metrics.NewBasicProvider()
+	p.Counter("requests_total", metrics.WithDescription("served requests"),
+		metrics.WithAttributes(map[string]string{"route": "/users"})).Add(5)
+	p.UpDownCounter("inflight", metrics.WithDurable()).Add(2)
+	h := p.Histogram("latency_seconds", metrics.WithUnit("seconds"), metrics.WithBuckets(0.1, 1))
+	for _, v := range []float64{0.05, 0.2, 0.4, 2} {
+		h.Record(v)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.URL.Query().Get("format") {
+		case "text":
+			w.Header().Set("Content-Type", metrics.OpenMetricsContentType)
+			err = metrics.WriteOpenMetrics(w, p.Snapshot())
+		case "binary":
+			w.Header().Set("Content-Type", metrics.SnapshotBinaryContentType)
+			err = metrics.EncodeSnapshotBinary(w, p.Snapshot())
+		default:
+			w.Header().Set("Content-Type", metrics.SnapshotContentType)
+			err = metrics.EncodeSnapshot(w, p.Snapshot())
+		}
+		if err != nil {
+			t.Errorf("encode: %v", err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return p, srv
+}
+
+func runCmd(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestList(t *testing.T) {
+	_, srv := newService(t)
+	for _, format := range []string{"json", "binary", "text"} {
+		t.Run(format, func(t *testing.T) {
+			out, errOut, code := runCmd(t, "list", srv.URL+"?format="+format)
+			if code != 0 {
+				t.Fatalf("exit %d: %s", code, errOut)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			if len(lines) != 4 || !strings.HasPrefix(lines[0], "TYPE") {
+				t.Fatalf("expected a header and 3 rows, got:\n%s", out)
+			}
+			for i, want := range [][]string{
+				{"counter", "requests_total", "5", "route=/users", "served requests"},
+				{"histogram", "latency_seconds", "4", "seconds"},
+				{"updown", "inflight", "2"},
+			} {
+				if got := strings.Fields(lines[i+1]); len(got) < len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+					t.Fatalf("row %d: expected %v, got %q", i, want, lines[i+1])
+				}
+				for _, w := range want[3:] {
+					if !strings.Contains(lines[i+1], w) {
+						t.Fatalf("row %d: expected %q in %q", i, w, lines[i+1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestList_Long(t *testing.T) {
+	_, srv := newService(t)
+	out, errOut, code := runCmd(t, "list", "-l", "-type", "histogram", srv.URL)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	for _, want := range []string{"histogram latency_seconds\n", "unit:         seconds", "buckets:      0.1, 1", "created:"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "inflight") {
+		t.Fatalf("expected only histograms:\n%s", out)
+	}
+
+	// the durable flag survives the snapshot encodings
+	for _, format := range []string{"json", "binary"} {
+		out, errOut, code = runCmd(t, "list", "-l", "-type", "updown", srv.URL+"?format="+format)
+		if code != 0 {
+			t.Fatalf("exit %d: %s", code, errOut)
+		}
+		if !strings.Contains(out, "durable:      true") {
+			t.Fatalf("%s: expected the up/down counter to be durable:\n%s", format, out)
+		}
+	}
+}
+
+func TestList_Filters(t *testing.T) {
+	_, srv := newService(t)
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-type", "updown"}, []string{"inflight"}},
+		{[]string{"-name", "*_seconds"}, []string{"latency_seconds"}},
+		{[]string{"-name", "re*", "-attr", "route=/users"}, []string{"requests_total"}},
+		{[]string{"-attr", "route=/orders"}, nil},
+	}
+	for _, tt := range tests {
+		args := append(append([]string{"list"}, tt.args...), srv.URL+"?format=text")
+		out, errOut, code := runCmd(t, args...)
+		if code != 0 {
+			t.Fatalf("%v: exit %d: %s", tt.args, code, errOut)
+		}
+		rows := strings.Split(strings.TrimSpace(out), "\n")[1:]
+		if len(rows) != len(tt.want) {
+			t.Fatalf("%v: expected %v, got:\n%s", tt.args, tt.want, out)
+		}
+		for i, name := range tt.want {
+			if strings.Fields(rows[i])[1] != name {
+				t.Fatalf("%v: expected %s, got %q", tt.args, name, rows[i])
+			}
+		}
+	}
+}
+
+func TestHist(t *testing.T) {
+	_, srv := newService(t)
+	for _, format := range []string{"json", "text"} {
+		out, errOut, code := runCmd(t, "hist", "-q", "0.5,0.99", srv.URL+"?format="+format)
+		if code != 0 {
+			t.Fatalf("%s: exit %d: %s", format, code, errOut)
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 2 || strings.Join(strings.Fields(lines[0]), " ") != "NAME ATTRIBUTES COUNT SUM MEAN MIN MAX P50 P99" {
+			t.Fatalf("%s: unexpected table:\n%s", format, out)
+		}
+		// the text format carries no min and max
+		want := "latency_seconds - 4 2.65 0.6625 0.05 2 0.55 2"
+		if format == "text" {
+			want = "latency_seconds - 4 2.65 0.6625 - - 0.55 1"
+		}
+		if got := strings.Join(strings.Fields(lines[1]), " "); got != want {
+			t.Fatalf("%s: expected %q, got %q", format, want, got)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	p, srv := newService(t)
+	c := p.Counter("requests_total")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			c.Add(1)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	out, errOut, code := runCmd(t, "watch", "-interval", "10ms", "-count", "2", "-type", "counter", srv.URL)
+	<-done
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	if n := strings.Count(out, "RATE/S"); n != 2 {
+		t.Fatalf("expected 2 reports, got %d:\n%s", n, out)
+	}
+	if strings.Contains(out, "inflight") || !strings.Contains(out, "requests_total") {
+		t.Fatalf("unexpected instruments:\n%s", out)
+	}
+}
+
+func TestWatch_StopsOnCancel(t *testing.T) {
+	_, srv := newService(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	if code := run(ctx, []string{"watch", "-interval", "10ms", srv.URL}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/html" {
+			w.Header().Set("Content-Type", "text/html")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{nil, 2, "Usage"},
+		{[]string{"top"}, 2, `unknown command "top"`},
+		{[]string{"list"}, 2, "expected the endpoint URL"},
+		{[]string{"list", "-type", "gauge", srv.URL}, 2, `unknown instrument type "gauge"`},
+		{[]string{"list", "-name", "[", srv.URL}, 2, "invalid pattern"},
+		{[]string{"list", "-attr", "route", srv.URL}, 2, "expected key=value"},
+		{[]string{"list", "-format", "xml", srv.URL}, 2, `unknown format "xml"`},
+		{[]string{"hist", "-q", "2", srv.URL}, 2, "invalid quantile"},
+		{[]string{"watch", "-interval", "0s", srv.URL}, 2, "must be positive"},
+		{[]string{"list", srv.URL}, 1, "404 Not Found"},
+		{[]string{"list", srv.URL + "/html"}, 1, `unsupported content type "text/html"`},
+	}
+	for _, tt := range tests {
+		_, errOut, code := runCmd(t, tt.args...)
+		if code != tt.code || !strings.Contains(errOut, tt.want) {
+			t.Fatalf("%v: expected exit %d with %q, got %d: %s", tt.args, tt.code, tt.want, code, errOut)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+// printList prints a row per instrument: its type, name, value (the count of histograms),
+// unit, attributes and description.
+func printList(w io.Writer, snap metrics.ProviderSnapshot) error {
+	tw := newTable(w)
+	fmt.Fprintln(tw, "TYPE\tNAME\tVALUE\tUNIT\tATTRIBUTES\tDESCRIPTION")
+	for _, s := range snap.Instruments {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			s.Type, baseName(s.Name), value(s), s.Config.Unit, formatAttrs(s.Config.Attributes), s.Config.Description)
+	}
+	return tw.Flush()
+}
+
+// printConfigs prints a block per instrument with its full configuration and timestamps.
+func printConfigs(w io.Writer, snap metrics.ProviderSnapshot) error {
+	tw := newTable(w)
+	for i, s := range snap.Instruments {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		cfg := s.Config
+		fmt.Fprintf(tw, "%s %s\n", s.Type, baseName(s.Name))
+		fmt.Fprintf(tw, "  value:\t%d\n", value(s))
+		fmt.Fprintf(tw, "  description:\t%s\n", cfg.Description)
+		fmt.Fprintf(tw, "  unit:\t%s\n", cfg.Unit)
+		fmt.Fprintf(tw, "  attributes:\t%s\n", formatAttrs(cfg.Attributes))
+		if s.Type == metrics.InstrumentTypeHistogram {
+			fmt.Fprintf(tw, "  buckets:\t%s\n", formatFloats(cfg.Buckets))
+			if win := cfg.Window; win != nil {
+				fmt.Fprintf(tw, "  window:\t%s, quantiles %s\n", formatDuration(win.Duration), formatFloats(win.Quantiles))
+			}
+		} else {
+			fmt.Fprintf(tw, "  durable:\t%t\n", cfg.Durable)
+		}
+		fmt.Fprintf(tw, "  created:\t%s\n", formatTime(s.Times.Created))
+		if !s.Times.LastUpdate.IsZero() {
+			fmt.Fprintf(tw, "  last update:\t%s\n", formatTime(s.Times.LastUpdate))
+		}
+		if !s.Times.LastReset.IsZero() {
+			fmt.Fprintf(tw, "  last reset:\t%s\n", formatTime(s.Times.LastReset))
+		}
+	}
+	return tw.Flush()
+}
+
+// printHistograms prints a row of statistics per histogram. Quantiles are those reported by
+// windowed histograms, or estimated from the buckets.
+func printHistograms(w io.Writer, snap metrics.ProviderSnapshot, qs []float64) error {
+	tw := newTable(w)
+	header := "NAME\tATTRIBUTES\tCOUNT\tSUM\tMEAN\tMIN\tMAX"
+	for _, q := range qs {
+		header += "\tP" + strconv.FormatFloat(q*100, 'g', -1, 64)
+	}
+	fmt.Fprintln(tw, header)
+	for _, s := range snap.Instruments {
+		if s.Type != metrics.InstrumentTypeHistogram {
+			continue
+		}
+		h := s.Histogram
+		row := []string{baseName(s.Name), formatAttrs(s.Config.Attributes), strconv.FormatInt(h.Count, 10),
+			formatFloat(h.Sum), "-", "-", "-"}
+		if h.Count > 0 {
+			row[4], row[5], row[6] = formatFloat(h.Mean), formatFloat(h.Min), formatFloat(h.Max)
+		}
+		for _, q := range qs {
+			row = append(row, formatFloat(quantile(h, q)))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printWatch prints the changes between two polls: a row per instrument of cur with its
+// value, delta and per-second rate.
+func printWatch(w io.Writer, prev, cur metrics.ProviderSnapshot) error {
+	d := metrics.DiffSnapshots(prev, cur)
+	fmt.Fprintf(w, "%s (%s)\n", formatTime(d.To), formatDuration(d.Interval()))
+	tw := newTable(w)
+	fmt.Fprintln(tw, "TYPE\tNAME\tATTRIBUTES\tVALUE\tDELTA\tRATE/S")
+	for _, delta := range d.Instruments {
+		s, _ := cur.Get(delta.Type, delta.Name)
+		change := delta.Delta
+		if delta.Type == metrics.InstrumentTypeHistogram {
+			change = delta.Histogram.Count
+		}
+		mark := ""
+		if delta.Reset {
+			mark = " (reset)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%+d%s\t%s\n", delta.Type, baseName(delta.Name),
+			formatAttrs(s.Config.Attributes), value(s), change, mark, formatFloat(delta.Rate))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, k := range d.Removed {
+		fmt.Fprintf(w, "removed: %s\n", k)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// value returns the value of a counter or up/down counter, or the count of a histogram.
+func value(s metrics.InstrumentSnapshot) int64 {
+	if s.Type == metrics.InstrumentTypeHistogram {
+		return s.Histogram.Count
+	}
+	return s.Value
+}
+
+// quantile returns the quantile q of h: the one reported by a windowed histogram if any, or
+// an estimate interpolated linearly within the bucket holding it, or NaN.
+func quantile(h metrics.HistSnapshot, q float64) float64 {
+	for _, hq := range h.Quantiles {
+		if hq.Q == q {
+			return hq.Value
+		}
+	}
+	if h.Count == 0 || len(h.Buckets) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(h.Count)
+	lower := 0.0
+	if !math.IsNaN(h.Min) && !math.IsInf(h.Min, 0) {
+		lower = math.Min(h.Min, h.Buckets[0].UpperBound)
+	}
+	var cumulative int64
+	for _, b := range h.Buckets {
+		if b.Count > 0 && float64(cumulative+b.Count) >= rank {
+			upper := b.UpperBound
+			if math.IsInf(upper, 1) {
+				// nothing is known about the +Inf bucket beyond its lower bound and the max
+				if !math.IsNaN(h.Max) {
+					return h.Max
+				}
+				return lower
+			}
+			v := lower + (upper-lower)*(rank-float64(cumulative))/float64(b.Count)
+			if !math.IsNaN(h.Max) {
+				v = math.Min(v, h.Max)
+			}
+			return v
+		}
+		cumulative += b.Count
+		lower = b.UpperBound
+	}
+	return lower
+}
+
+func formatAttrs(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + attrs[k]
+	}
+	return strings.Join(keys, ",")
+}
+
+func formatFloat(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+func formatFloats(vs []float64) string {
+	if len(vs) == 0 {
+		return "-"
+	}
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(out, ", ")
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.String()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// textFamily is a metric family declared by a # TYPE line.
+type textFamily struct {
+	typ  string
+	help string
+	unit string
+}
+
+// textSeries accumulates the samples of one series of a family.
+type textSeries struct {
+	snap metrics.InstrumentSnapshot
+	// cumulative counts of histogram buckets by upper bound
+	buckets map[float64]int64
+}
+
+var helpUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+// parseText parses the OpenMetrics or Prometheus text exposition format into a snapshot taken
+// at now.
+//
+// Counters become counters named with a "_total" suffix, gauges and untyped metrics become
+// up/down counters (rounded to integers), histograms become histograms without min and max
+// (NaN) and summaries become windowed histograms with their quantiles. Labels become
+// attributes and instruments of labeled series are named by metrics.SeriesName. Timestamps,
+// exemplars and other metric types are ignored.
+func parseText(r io.Reader, now time.Time) (metrics.ProviderSnapshot, error) {
+	var (
+		families = make(map[string]*textFamily)
+		series   = make(map[string]*textSeries)
+		order    []string
+	)
+	family := func(name string) *textFamily {
+		f, ok := families[name]
+		if !ok {
+			f = &textFamily{typ: "unknown"}
+			families[name] = f
+		}
+		return f
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			fields := strings.SplitN(text, " ", 4)
+			if len(fields) < 3 {
+				continue // comment or # EOF
+			}
+			rest := ""
+			if len(fields) == 4 {
+				rest = fields[3]
+			}
+			switch fields[1] {
+			case "TYPE":
+				family(fields[2]).typ = rest
+			case "HELP":
+				family(fields[2]).help = helpUnescaper.Replace(rest)
+			case "UNIT":
+				family(fields[2]).unit = rest
+			}
+			continue
+		}
+
+		name, labels, value, err := parseSample(text)
+		if err != nil {
+			return metrics.ProviderSnapshot{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		fam, suffix := resolveFamily(families, name)
+		f := family(fam)
+		typ, instName, ok := instrumentOf(fam, f.typ)
+		if !ok {
+			continue
+		}
+		attrs := make(map[string]string, len(labels))
+		for k, v := range labels {
+			if (k == "le" && f.typ == "histogram") || (k == "quantile" && f.typ == "summary") {
+				continue
+			}
+			attrs[k] = v
+		}
+		key := string(typ) + "|" + metrics.SeriesName(instName, attrs)
+		s, ok := series[key]
+		if !ok {
+			s = &textSeries{snap: metrics.InstrumentSnapshot{
+				Type: typ,
+				Name: metrics.SeriesName(instName, attrs),
+				Config: metrics.InstrumentConfig{
+					Description: f.help,
+					Unit:        f.unit,
+					Attributes:  attrs,
+				},
+			}}
+			if typ == metrics.InstrumentTypeHistogram {
+				s.snap.Histogram.Min, s.snap.Histogram.Max = math.NaN(), math.NaN()
+			}
+			if f.typ == "summary" {
+				s.snap.Config.Window = &metrics.HistogramWindow{}
+			}
+			series[key] = s
+			order = append(order, key)
+		}
+		if err := s.add(suffix, labels, value); err != nil {
+			return metrics.ProviderSnapshot{}, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return metrics.ProviderSnapshot{}, err
+	}
+
+	snap := metrics.ProviderSnapshot{Time: now}
+	for _, key := range order {
+		snap.Instruments = append(snap.Instruments, series[key].finish())
+	}
+	return snap, nil
+}
+
+// resolveFamily returns the family of a sample name and the suffix of the sample within it,
+// e.g. "_bucket" for "latency_bucket" of the histogram family "latency".
+func resolveFamily(families map[string]*textFamily, name string) (string, string) {
+	if _, ok := families[name]; ok {
+		return name, ""
+	}
+	for _, suffix := range []string{"_total", "_created", "_bucket", "_count", "_sum"} {
+		if fam, ok := strings.CutSuffix(name, suffix); ok {
+			if _, ok := families[fam]; ok {
+				return fam, suffix
+			}
+		}
+	}
+	return name, ""
+}
+
+// instrumentOf returns the instrument type and name of the samples of a family, or false for
+// unsupported metric types.
+func instrumentOf(fam, typ string) (metrics.InstrumentType, string, bool) {
+	switch typ {
+	case "counter":
+		if !strings.HasSuffix(fam, "_total") {
+			fam += "_total"
+		}
+		return metrics.InstrumentTypeCounter, fam, true
+	case "gauge", "unknown", "untyped":
+		return metrics.InstrumentTypeUpDown, fam, true
+	case "histogram", "summary":
+		return metrics.InstrumentTypeHistogram, fam, true
+	}
+	return "", "", false
+}
+
+// add records a sample of the series.
+func (s *textSeries) add(suffix string, labels map[string]string, value float64) error {
+	if suffix == "_created" {
+		s.snap.Times.Created = time.UnixMicro(int64(math.Round(value * 1e6)))
+		return nil
+	}
+	if s.snap.Type != metrics.InstrumentTypeHistogram {
+		s.snap.Value = int64(math.Round(value))
+		return nil
+	}
+	h := &s.snap.Histogram
+	switch suffix {
+	case "_count":
+		h.Count = int64(value)
+	case "_sum":
+		h.Sum = value
+	case "_bucket":
+		le, err := strconv.ParseFloat(labels["le"], 64)
+		if err != nil {
+			return fmt.Errorf("invalid bucket bound %q", labels["le"])
+		}
+		if s.buckets == nil {
+			s.buckets = make(map[float64]int64)
+		}
+		s.buckets[le] = int64(value)
+	case "":
+		// bare samples of histograms are quantiles of summaries only
+		if s.snap.Config.Window == nil {
+			return fmt.Errorf("sample without suffix in histogram %s", s.snap.Name)
+		}
+		q, err := strconv.ParseFloat(labels["quantile"], 64)
+		if err != nil {
+			return fmt.Errorf("invalid quantile %q", labels["quantile"])
+		}
+		h.Quantiles = append(h.Quantiles, metrics.Quantile{Q: q, Value: value})
+		s.snap.Config.Window.Quantiles = append(s.snap.Config.Window.Quantiles, q)
+	}
+	return nil
+}
+
+// finish returns the snapshot of the series, with per bucket histogram counts.
+func (s *textSeries) finish() metrics.InstrumentSnapshot {
+	h := &s.snap.Histogram
+	if h.Count > 0 {
+		h.Mean = h.Sum / float64(h.Count)
+	}
+	if len(s.buckets) == 0 {
+		return s.snap
+	}
+	bounds := make([]float64, 0, len(s.buckets))
+	for le := range s.buckets {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+	var prev int64
+	for _, le := range bounds {
+		h.Buckets = append(h.Buckets, metrics.HistBucket{UpperBound: le, Count: s.buckets[le] - prev})
+		prev = s.buckets[le]
+		if !math.IsInf(le, 1) {
+			s.snap.Config.Buckets = append(s.snap.Config.Buckets, le)
+		}
+	}
+	return s.snap
+}
+
+// parseSample parses a sample line: a series name with optional labels, a value and an
+// optional timestamp and exemplar, which are ignored.
+func parseSample(line string) (string, map[string]string, float64, error) {
+	end := strings.IndexAny(line, " \t")
+	if open := strings.IndexByte(line, '{'); open >= 0 && (end < 0 || open < end) {
+		end = closingBrace(line, open)
+		if end < 0 {
+			return "", nil, 0, fmt.Errorf("unterminated labels in %q", line)
+		}
+		end++
+	}
+	if end < 0 {
+		return "", nil, 0, fmt.Errorf("missing value in %q", line)
+	}
+	fields := strings.Fields(line[end:])
+	if len(fields) == 0 {
+		return "", nil, 0, fmt.Errorf("missing value in %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid value %q", fields[0])
+	}
+	// a malformed label set is returned unchanged
+	name, labels := metrics.ParseSeriesName(line[:end])
+	if strings.ContainsAny(name, "{}") {
+		return "", nil, 0, fmt.Errorf("malformed labels in %q", line)
+	}
+	return name, labels, value, nil
+}
+
+// closingBrace returns the index of the brace closing the label set opened at open, skipping
+// quoted label values, or -1.
+func closingBrace(line string, open int) int {
+	quoted := false
+	for i := open + 1; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '}' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+func TestParseText_OpenMetricsRoundTrip(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	p.Counter("jobs", metrics.WithDescription("processed\njobs"),
+		metrics.WithAttributes(map[string]string{"queue": `a"b`})).Add(3)
+	p.Histogram("size_bytes", metrics.WithUnit("bytes"), metrics.WithBuckets(10, 100)).Record(50)
+	p.Histogram("wait", metrics.WithRollingWindow(metrics.HistogramWindow{Duration: time.Minute, Quantiles: []float64{0.5}})).Record(7)
+
+	var buf bytes.Buffer
+	if err := metrics.WriteOpenMetrics(&buf, p.Snapshot()); err != nil {
+		t.Fatalf("WriteOpenMetrics: %v", err)
+	}
+	now := time.Unix(100, 0)
+	snap, err := parseText(&buf, now)
+	if err != nil {
+		t.Fatalf("parseText: %v", err)
+	}
+	if !snap.Time.Equal(now) || len(snap.Instruments) != 3 {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+
+	byName := make(map[string]metrics.InstrumentSnapshot)
+	for _, s := range snap.Instruments {
+		byName[s.Name] = s
+	}
+	c := byName[`jobs_total{queue="a\"b"}`]
+	if c.Type != metrics.InstrumentTypeCounter || c.Value != 3 || c.Config.Description != "processed\njobs" ||
+		c.Config.Attributes["queue"] != `a"b` || c.Times.Created.IsZero() {
+		t.Fatalf("unexpected counter %+v", c)
+	}
+	h := byName["size_bytes"]
+	if h.Config.Unit != "bytes" || h.Histogram.Count != 1 || h.Histogram.Sum != 50 || h.Histogram.Mean != 50 ||
+		!math.IsNaN(h.Histogram.Min) || len(h.Histogram.Buckets) != 3 || h.Histogram.Buckets[1].Count != 1 ||
+		len(h.Config.Buckets) != 2 {
+		t.Fatalf("unexpected histogram %+v", h)
+	}
+	w := byName["wait"]
+	if w.Config.Window == nil || len(w.Histogram.Quantiles) != 1 || w.Histogram.Quantiles[0].Value != 7 {
+		t.Fatalf("unexpected summary %+v", w)
+	}
+}
+
+func TestParseText_Prometheus(t *testing.T) {
+	in := `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",method="get",} 1027 1395066363000
+http_requests_total{code="400",method="post"} 3
+# TYPE temperature gauge
+temperature{room="a b"} 21.6
+uptime_seconds 1.2e+03
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds{quantile="0.9"} 9001
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+# TYPE rows histogram
+rows_bucket{le="+Inf"} 4 # {trace_id="t"} 7
+rows_bucket{le="1"} 1
+rows_count 4
+rows_sum 9
+# TYPE flags stateset
+flags{flags="a"} 1
+`
+	snap, err := parseText(strings.NewReader(in), time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("parseText: %v", err)
+	}
+	want := map[string]metrics.InstrumentType{
+		`http_requests_total{code="200",method="get"}`:  metrics.InstrumentTypeCounter,
+		`http_requests_total{code="400",method="post"}`: metrics.InstrumentTypeCounter,
+		`temperature{room="a b"}`:                       metrics.InstrumentTypeUpDown,
+		"uptime_seconds":                                metrics.InstrumentTypeUpDown,
+		"rpc_duration_seconds":                          metrics.InstrumentTypeHistogram,
+		"rows":                                          metrics.InstrumentTypeHistogram,
+	}
+	if len(snap.Instruments) != len(want) {
+		t.Fatalf("expected %d instruments, got %+v", len(want), snap.Instruments)
+	}
+	for _, s := range snap.Instruments {
+		if want[s.Name] != s.Type {
+			t.Fatalf("unexpected instrument %s %s", s.Type, s.Name)
+		}
+		switch s.Name {
+		case `http_requests_total{code="200",method="get"}`:
+			if s.Value != 1027 || s.Config.Description != "Requests." {
+				t.Fatalf("unexpected counter %+v", s)
+			}
+		case `temperature{room="a b"}`:
+			if s.Value != 22 {
+				t.Fatalf("expected the rounded gauge, got %d", s.Value)
+			}
+		case "uptime_seconds":
+			if s.Value != 1200 {
+				t.Fatalf("unexpected untyped value %d", s.Value)
+			}
+		case "rpc_duration_seconds":
+			if s.Histogram.Count != 2693 || len(s.Histogram.Quantiles) != 2 || s.Config.Window.Quantiles[1] != 0.9 {
+				t.Fatalf("unexpected summary %+v", s)
+			}
+		case "rows":
+			if b := s.Histogram.Buckets; len(b) != 2 || b[0].Count != 1 || b[1].Count != 3 {
+				t.Fatalf("expected buckets sorted and de-cumulated, got %+v", b)
+			}
+		}
+	}
+}
+
+func TestParseText_Errors(t *testing.T) {
+	for _, in := range []string{
+		"c",
+		`c{a="1" 2`,
+		"c one",
+		`c{a=1} 2`,
+		"# TYPE h histogram\n" + `h_bucket{le="x"} 1`,
+		"# TYPE lat histogram\n" + `lat{quantile="0.5"} 3`,
+	} {
+		if _, err := parseText(strings.NewReader(in), time.Time{}); err == nil || !strings.HasPrefix(err.Error(), "line ") {
+			t.Fatalf("expected an error with the line of %q, got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package collectors provides collectors that periodically read system statistics and record
+// them on the instruments of any metrics.Provider.
+//
+// A collector registers its instruments when constructed. Collect records the current
+// statistics once and Run does so at every collection interval:
+//
+//	c := collectors.NewRuntimeCollector(p, collectors.WithInterval(15*time.Second))
+//	go c.Run(ctx)
+//
+// Statistics read as cumulative totals are recorded on counters and instantaneous ones on
+// up/down counters, both by adding the change since the previous collection. Collectors thus
+// assume that they are the only writers of their instruments.
+package collectors
+
+import (
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/internal/logging"
+)
+
+type (
+	logger     = logging.Logger
+	noopLogger = logging.Noop
+)
+
+// defaultInterval is the interval between collections of Run unless configured.
+const defaultInterval = 10 * time.Second
+
+type config struct {
+	// interval between collections of Run. Default: defaultInterval.
+	interval time.Duration
+	// source of time of the Run ticker. Default: the clock of the provider, or SystemClock.
+	clock metrics.Clock
+	// runtime/metrics names, or path.Match patterns, read by a RuntimeCollector.
+	// Default: DefaultRuntimeMetrics.
+	runtimeMetrics []string
+	// bucket bounds of the histograms in seconds of a RuntimeCollector.
+	// Default: defaultRuntimeBuckets.
+	runtimeBuckets []float64
+	// mount point of the proc filesystem read by a ProcessCollector. Default: "/proc".
+	procFS string
+	logger logger
+}
+
+// Option configures a collector.
+type Option func(*config)
+
+// WithInterval sets the interval between collections of Run. Non-positive values are ignored.
+func WithInterval(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.interval = d
+		}
+	}
+}
+
+// WithClock sets the clock driving the collections of Run. The default is the clock of the
+// provider if it has one (such as metrics.BasicProvider), SystemClock otherwise.
+// A nil clock is ignored.
+func WithClock(c metrics.Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// WithLogger sets the logger used to report statistics that cannot be read.
+func WithLogger(l logger) Option {
+	return func(cfg *config) { cfg.logger = l }
+}
+
+// newConfig returns the configuration built from opts for a collector recording on p.
+func newConfig(p metrics.Provider, opts []Option) config {
+	cfg := config{
+		interval:       defaultInterval,
+		runtimeMetrics: DefaultRuntimeMetrics(),
+		runtimeBuckets: defaultRuntimeBuckets,
+		procFS:         "/proc",
+	}
+	if cs, ok := p.(interface{ Clock() metrics.Clock }); ok {
+		cfg.clock = cs.Clock()
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	if cfg.clock == nil {
+		cfg.clock = metrics.SystemClock()
+	}
+	if cfg.logger == nil {
+		cfg.logger = noopLogger{}
+	}
+	return cfg
+}
+
+// scalar is the counter or up/down counter of a scalar statistic and its last recorded value.
+type scalar struct {
+	counter metrics.Counter
+	gauge   metrics.UpDownCounter
+	last    int64
+}
+
+// record records the change of the statistic whose value is now v.
+func (s *scalar) record(v int64) {
+	delta := v - s.last
+	s.last = v
+	switch {
+	case s.counter != nil:
+		// cumulative statistics do not decrease
+		if delta > 0 {
+			s.counter.Add(delta)
+		}
+	case s.gauge != nil:
+		if delta != 0 {
+			s.gauge.Add(delta)
+		}
+	}
+}
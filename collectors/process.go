@@ -0,0 +1,293 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// userHZ is the number of clock ticks per second in which the kernel reports CPU times and
+// start times in /proc. Fixed to 100 by the Linux userspace ABI.
+const userHZ = 100
+
+// WithProcFS sets the mount point of the proc filesystem read by a ProcessCollector, "/proc"
+// by default. Tests point it at a directory laid out like /proc. Ignored by other collectors.
+func WithProcFS(dir string) Option {
+	return func(cfg *config) {
+		if dir != "" {
+			cfg.procFS = dir
+		}
+	}
+}
+
+// ProcessCollector records statistics of the current process read from the Linux proc
+// filesystem:
+//
+//	process_cpu_seconds_total       user and system CPU time, in whole seconds, from /proc/self/stat
+//	process_cpu_milliseconds_total  user and system CPU time, from /proc/self/stat
+//	process_start_time_seconds      start time since the Unix epoch, from /proc/self/stat and /proc/stat
+//	process_resident_memory_bytes   resident set size, from /proc/self/status
+//	process_virtual_memory_bytes    virtual memory size, from /proc/self/status
+//	process_threads                 number of OS threads, from /proc/self/status
+//	process_open_fds                number of open file descriptors, from /proc/self/fd
+//	process_max_fds                 soft limit of open file descriptors, from /proc/self/limits
+//	process_io_read_bytes_total     bytes read by read-like system calls, from /proc/self/io
+//	process_io_write_bytes_total    bytes written by write-like system calls, from /proc/self/io
+//
+// Counters hold integers: process_cpu_seconds_total keeps its standard name and unit but is
+// truncated to whole seconds, and process_cpu_milliseconds_total records the same time with
+// the precision of the kernel clock ticks.
+//
+// Files that cannot be read, such as /proc/self/io without the required permission, are
+// logged and their statistics left unchanged. On other systems every collection fails this way.
+type ProcessCollector struct {
+	cfg config
+	// directory of the current process in the proc filesystem
+	self string
+
+	mu         sync.Mutex
+	cpu        scalar
+	cpuMillis  scalar
+	start      scalar
+	rss        scalar
+	vms        scalar
+	threads    scalar
+	fds        scalar
+	maxFDs     scalar
+	readBytes  scalar
+	writeBytes scalar
+}
+
+// NewProcessCollector returns a collector of the current process statistics and registers
+// their instruments on p.
+func NewProcessCollector(p metrics.Provider, opts ...Option) *ProcessCollector {
+	cfg := newConfig(p, opts)
+	c := &ProcessCollector{cfg: cfg, self: filepath.Join(cfg.procFS, "self")}
+	counter := func(name, description, unit string) scalar {
+		return scalar{counter: p.Counter(name, metrics.WithDescription(description), metrics.WithUnit(unit))}
+	}
+	gauge := func(name, description, unit string) scalar {
+		return scalar{gauge: p.UpDownCounter(name, metrics.WithDescription(description), metrics.WithUnit(unit))}
+	}
+	c.cpu = counter("process_cpu_seconds_total", "Total user and system CPU time, truncated to seconds.", "seconds")
+	c.cpuMillis = counter("process_cpu_milliseconds_total", "Total user and system CPU time.", "milliseconds")
+	c.start = gauge("process_start_time_seconds", "Start time of the process since the Unix epoch.", "seconds")
+	c.rss = gauge("process_resident_memory_bytes", "Resident memory size.", "bytes")
+	c.vms = gauge("process_virtual_memory_bytes", "Virtual memory size.", "bytes")
+	c.threads = gauge("process_threads", "Number of OS threads.", "threads")
+	c.fds = gauge("process_open_fds", "Number of open file descriptors.", "files")
+	c.maxFDs = gauge("process_max_fds", "Maximum number of open file descriptors.", "files")
+	c.readBytes = counter("process_io_read_bytes_total", "Bytes read by read-like system calls.", "bytes")
+	c.writeBytes = counter("process_io_write_bytes_total", "Bytes written by write-like system calls.", "bytes")
+	return c
+}
+
+// Collect reads the process statistics and records their changes since the previous
+// collection.
+func (c *ProcessCollector) Collect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collectStat()
+	c.collectStatus()
+	c.collectFDs()
+	c.collectLimits()
+	c.collectIO()
+}
+
+// Run collects immediately and then every collection interval until ctx is done.
+func (c *ProcessCollector) Run(ctx context.Context) {
+	c.Collect()
+	t := c.cfg.clock.NewTicker(c.cfg.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			c.Collect()
+		}
+	}
+}
+
+// warn logs that the statistics of the file path cannot be read.
+func (c *ProcessCollector) warn(path string, err error) {
+	c.cfg.logger.Warnf("[collectors] reading %s: %v", path, err)
+}
+
+// collectStat records the CPU time and the start time.
+func (c *ProcessCollector) collectStat() {
+	path := filepath.Join(c.self, "stat")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.warn(path, err)
+		return
+	}
+	// the command name, in parentheses, may contain spaces and parentheses itself: the
+	// fields start after the last ')', with the process state, field 3 in proc(5)
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		c.warn(path, fmt.Errorf("malformed stat %q", data))
+		return
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		c.warn(path, fmt.Errorf("malformed stat %q", data))
+		return
+	}
+	field := func(n int) (int64, error) { return strconv.ParseInt(fields[n-3], 10, 64) }
+	utime, err1 := field(14)
+	stime, err2 := field(15)
+	started, err3 := field(22)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		c.warn(path, err)
+		return
+	}
+	c.cpu.record((utime + stime) / userHZ)
+	c.cpuMillis.record((utime + stime) * 1000 / userHZ)
+
+	// the start time is in clock ticks since boot
+	bootPath := filepath.Join(c.cfg.procFS, "stat")
+	boot, err := readKey(bootPath, "btime")
+	if err != nil {
+		c.warn(bootPath, err)
+		return
+	}
+	c.start.record(boot + started/userHZ)
+}
+
+// collectStatus records the memory sizes and the thread count.
+func (c *ProcessCollector) collectStatus() {
+	path := filepath.Join(c.self, "status")
+	values, err := readKeys(path, ":")
+	if err != nil {
+		c.warn(path, err)
+		return
+	}
+	for _, s := range []struct {
+		key   string
+		inst  *scalar
+		scale int64
+	}{
+		{"VmRSS", &c.rss, 1024},
+		{"VmSize", &c.vms, 1024},
+		{"Threads", &c.threads, 1},
+	} {
+		v, ok := values[s.key]
+		if !ok {
+			// kernel threads have no memory sizes
+			continue
+		}
+		s.inst.record(v * s.scale)
+	}
+}
+
+// collectFDs records the number of open file descriptors.
+func (c *ProcessCollector) collectFDs() {
+	path := filepath.Join(c.self, "fd")
+	f, err := os.Open(path)
+	if err != nil {
+		c.warn(path, err)
+		return
+	}
+	defer f.Close()
+	// the count includes the descriptor opened to read the directory
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		c.warn(path, err)
+		return
+	}
+	c.fds.record(int64(len(names)))
+}
+
+// collectLimits records the limit of open file descriptors.
+func (c *ProcessCollector) collectLimits() {
+	path := filepath.Join(c.self, "limits")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.warn(path, err)
+		return
+	}
+	// Limit                     Soft Limit           Hard Limit           Units
+	// Max open files            1024                 524288               files
+	for _, line := range strings.Split(string(data), "\n") {
+		rest, ok := strings.CutPrefix(line, "Max open files")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 || fields[0] == "unlimited" {
+			return
+		}
+		v, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			c.warn(path, err)
+			return
+		}
+		c.maxFDs.record(v)
+		return
+	}
+}
+
+// collectIO records the bytes read and written.
+func (c *ProcessCollector) collectIO() {
+	path := filepath.Join(c.self, "io")
+	values, err := readKeys(path, ":")
+	if err != nil {
+		c.warn(path, err)
+		return
+	}
+	if v, ok := values["rchar"]; ok {
+		c.readBytes.record(v)
+	}
+	if v, ok := values["wchar"]; ok {
+		c.writeBytes.record(v)
+	}
+}
+
+// readKeys returns the integer values of the "key<sep> value [unit]" lines of the file path.
+// Lines whose value is not an integer are skipped.
+func readKeys(path, sep string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]int64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		key, rest, ok := strings.Cut(s.Text(), sep)
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			values[strings.TrimSpace(key)] = v
+		}
+	}
+	return values, s.Err()
+}
+
+// readKey returns the value of the "key value" line of the file path.
+func readKey(path, key string) (int64, error) {
+	values, err := readKeys(path, " ")
+	if err != nil {
+		return 0, err
+	}
+	v, ok := values[key]
+	if !ok {
+		return 0, fmt.Errorf("no %s", key)
+	}
+	return v, nil
+}
@@ -0,0 +1,157 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// procFS writes the files of a fake proc filesystem, relative to its root, into a temporary
+// directory and returns it.
+func procFS(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// fakeStat returns a /proc/self/stat line with the given CPU times and start time, in ticks.
+func fakeStat(utime, stime, start string) string {
+	fields := make([]string, 50)
+	for i := range fields {
+		fields[i] = "0"
+	}
+	fields[0], fields[1], fields[2] = "42", "(my (odd) cmd)", "S"
+	fields[13], fields[14], fields[21] = utime, stime, start
+	return strings.Join(fields, " ") + "\n"
+}
+
+func TestProcessCollector_FakeProcFS(t *testing.T) {
+	dir := procFS(t, map[string]string{
+		"stat":        "cpu  1 2 3 4\nbtime 1700000000\nprocesses 10\n",
+		"self/stat":   fakeStat("155", "50", "12345"),
+		"self/status": "Name:\tmy cmd\nVmSize:\t  20480 kB\nVmRSS:\t   1024 kB\nThreads:\t7\n",
+		"self/io":     "rchar: 1000\nwchar: 200\nread_bytes: 4096\n",
+		"self/limits": "Limit                     Soft Limit           Hard Limit           Units     \n" +
+			"Max processes             23960                23960                processes \n" +
+			"Max open files            1024                 4096                 files     \n",
+		"self/fd/0": "",
+		"self/fd/1": "",
+		"self/fd/2": "",
+	})
+	p := metrics.NewBasicProvider()
+	l := &warnLogger{}
+	c := NewProcessCollector(p, WithProcFS(dir), WithLogger(l))
+	c.Collect()
+	if len(l.warnings) != 0 {
+		t.Fatalf("unexpected warnings %v", l.warnings)
+	}
+
+	want := map[string]int64{
+		"process_cpu_seconds_total":      2,
+		"process_cpu_milliseconds_total": 2050,
+		"process_io_read_bytes_total":    1000,
+		"process_io_write_bytes_total":   200,
+	}
+	wantGauges := map[string]int64{
+		"process_start_time_seconds":    1700000000 + 123,
+		"process_resident_memory_bytes": 1024 * 1024,
+		"process_virtual_memory_bytes":  20480 * 1024,
+		"process_threads":               7,
+		"process_open_fds":              3,
+		"process_max_fds":               1024,
+	}
+	check := func() {
+		t.Helper()
+		snap := p.Snapshot()
+		for name, v := range want {
+			if s, ok := snap.Get(metrics.InstrumentTypeCounter, name); !ok || s.Value != v {
+				t.Fatalf("expected %s %d, got %+v", name, v, s)
+			}
+		}
+		for name, v := range wantGauges {
+			if s, ok := snap.Get(metrics.InstrumentTypeUpDown, name); !ok || s.Value != v {
+				t.Fatalf("expected %s %d, got %+v", name, v, s)
+			}
+		}
+	}
+	check()
+
+	// later collections follow the files
+	for name, content := range map[string]string{
+		"self/stat":   fakeStat("250", "50", "12345"),
+		"self/status": "VmSize:\t  10240 kB\nVmRSS:\t   2048 kB\nThreads:\t5\n",
+		"self/io":     "rchar: 1500\nwchar: 200\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, "self/fd/2")); err != nil {
+		t.Fatal(err)
+	}
+	c.Collect()
+	want["process_cpu_seconds_total"] = 3
+	want["process_cpu_milliseconds_total"] = 3000
+	want["process_io_read_bytes_total"] = 1500
+	wantGauges["process_resident_memory_bytes"] = 2048 * 1024
+	wantGauges["process_virtual_memory_bytes"] = 10240 * 1024
+	wantGauges["process_threads"] = 5
+	wantGauges["process_open_fds"] = 2
+	check()
+
+	// unreadable files are logged and leave their statistics unchanged
+	if err := os.Remove(filepath.Join(dir, "self/io")); err != nil {
+		t.Fatal(err)
+	}
+	c.Collect()
+	check()
+	if len(l.warnings) != 1 || !strings.Contains(l.warnings[0], filepath.Join("self", "io")) {
+		t.Fatalf("expected a warning for the missing file, got %v", l.warnings)
+	}
+}
+
+func TestProcessCollector_Malformed(t *testing.T) {
+	dir := procFS(t, map[string]string{
+		"self/stat":   "42 (cmd S 1 2\n",
+		"self/limits": "Max open files            unlimited            unlimited            files\n",
+	})
+	p := metrics.NewBasicProvider()
+	l := &warnLogger{}
+	NewProcessCollector(p, WithProcFS(dir), WithLogger(l)).Collect()
+	// stat, status, fd and io: an unlimited limit is no error
+	if len(l.warnings) != 4 || !strings.Contains(l.warnings[0], "malformed stat") {
+		t.Fatalf("unexpected warnings %v", l.warnings)
+	}
+	if s, _ := p.Snapshot().Get(metrics.InstrumentTypeUpDown, "process_max_fds"); s.Value != 0 {
+		t.Fatalf("expected no limit, got %d", s.Value)
+	}
+}
+
+func TestProcessCollector_Self(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires the Linux proc filesystem")
+	}
+	p := metrics.NewBasicProvider()
+	l := &warnLogger{}
+	NewProcessCollector(p, WithLogger(l)).Collect()
+	snap := p.Snapshot()
+	for _, name := range []string{"process_start_time_seconds", "process_resident_memory_bytes",
+		"process_threads", "process_open_fds", "process_max_fds"} {
+		if s, _ := snap.Get(metrics.InstrumentTypeUpDown, name); s.Value <= 0 {
+			t.Fatalf("expected a positive %s, got %d (warnings %v)", name, s.Value, l.warnings)
+		}
+	}
+}
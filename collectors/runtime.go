@@ -0,0 +1,215 @@
+package collectors
+
+import (
+	"context"
+	"math"
+	"path"
+	rtmetrics "runtime/metrics"
+	"strings"
+	"sync"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// DefaultRuntimeMetrics returns the runtime/metrics names read by a RuntimeCollector unless
+// configured: GC cycles, pauses and heap sizes, goroutine count and scheduler latency.
+func DefaultRuntimeMetrics() []string {
+	return []string{
+		"/gc/cycles/total:gc-cycles",
+		"/gc/heap/allocs:bytes",
+		"/gc/heap/goal:bytes",
+		"/gc/heap/live:bytes",
+		"/memory/classes/heap/objects:bytes",
+		"/memory/classes/total:bytes",
+		"/sched/gomaxprocs:threads",
+		"/sched/goroutines:goroutines",
+		"/sched/latencies:seconds",
+		"/sched/pauses/total/gc:seconds",
+	}
+}
+
+// defaultRuntimeBuckets are the bucket bounds of runtime histograms in seconds unless
+// configured: from 1µs to 10s.
+var defaultRuntimeBuckets = []float64{1e-6, 1e-5, 1e-4, 1e-3, 1e-2, 0.1, 1, 10}
+
+// WithRuntimeMetrics sets the runtime/metrics names read by a RuntimeCollector, e.g.
+// "/sched/goroutines:goroutines". Names may be path.Match patterns, such as "/gc/heap/*".
+// Ignored by other collectors.
+func WithRuntimeMetrics(names ...string) Option {
+	return func(cfg *config) { cfg.runtimeMetrics = names }
+}
+
+// WithRuntimeBuckets sets the bucket bounds, in seconds, of the histograms of durations
+// registered by a RuntimeCollector, such as "/sched/latencies:seconds". Histograms in other
+// units keep the bounds of the runtime histogram. Ignored by other collectors.
+func WithRuntimeBuckets(bounds ...float64) Option {
+	return func(cfg *config) { cfg.runtimeBuckets = bounds }
+}
+
+// RuntimeCollector records Go runtime statistics read from runtime/metrics.
+//
+// Every runtime metric is recorded on an instrument named after it, e.g. "go_sched_goroutines_goroutines"
+// for "/sched/goroutines:goroutines", with the runtime description and unit. Cumulative
+// metrics become counters, with a "_total" suffix, other scalar ones up/down counters and
+// distributions histograms. Histograms in seconds get the bucket bounds set by
+// WithRuntimeBuckets, others the finite bounds of the runtime histogram, e.g. the size
+// classes of "/gc/heap/allocs-by-size:bytes". Float scalar values are rounded to integers.
+// Runtime histograms are recorded through metrics.RecordN: the measurements of each runtime
+// bucket count at the middle of the bucket, or at its finite bound for unbounded ones.
+type RuntimeCollector struct {
+	cfg config
+
+	mu      sync.Mutex
+	samples []rtmetrics.Sample
+	insts   []runtimeInstrument
+}
+
+// runtimeInstrument is the instrument of a runtime metric and its last recorded state.
+type runtimeInstrument struct {
+	scalar
+	hist metrics.Histogram
+	// last bucket counts of a histogram
+	counts []uint64
+}
+
+// NewRuntimeCollector returns a collector of the runtime metrics set by WithRuntimeMetrics,
+// DefaultRuntimeMetrics by default, and registers their instruments on p. Names matching no
+// metric supported by the running Go version are logged and ignored.
+func NewRuntimeCollector(p metrics.Provider, opts ...Option) *RuntimeCollector {
+	c := &RuntimeCollector{cfg: newConfig(p, opts)}
+	for _, d := range c.selected() {
+		inst := runtimeInstrument{}
+		name, unit := runtimeName(d.Name)
+		iopts := []metrics.InstrumentOption{metrics.WithDescription(d.Description), metrics.WithUnit(unit)}
+		switch {
+		case d.Kind == rtmetrics.KindFloat64Histogram:
+			inst.hist = p.Histogram(name, append(iopts, metrics.WithBuckets(c.buckets(d.Name, unit)...))...)
+		case d.Cumulative:
+			if !strings.HasSuffix(name, "_total") {
+				name += "_total"
+			}
+			inst.counter = p.Counter(name, iopts...)
+		default:
+			inst.gauge = p.UpDownCounter(name, iopts...)
+		}
+		c.samples = append(c.samples, rtmetrics.Sample{Name: d.Name})
+		c.insts = append(c.insts, inst)
+	}
+	return c
+}
+
+// selected returns the descriptions of the supported runtime metrics matching the configured
+// names, in the order of rtmetrics.All.
+func (c *RuntimeCollector) selected() []rtmetrics.Description {
+	all := rtmetrics.All()
+	matched := make([]bool, len(c.cfg.runtimeMetrics))
+	var out []rtmetrics.Description
+	for _, d := range all {
+		if d.Kind == rtmetrics.KindBad {
+			continue
+		}
+		found := false
+		for i, pattern := range c.cfg.runtimeMetrics {
+			if ok, _ := path.Match(pattern, d.Name); ok {
+				matched[i], found = true, true
+			}
+		}
+		if found {
+			out = append(out, d)
+		}
+	}
+	for i, ok := range matched {
+		if !ok {
+			c.cfg.logger.Warnf("[collectors] no runtime metric matches %q", c.cfg.runtimeMetrics[i])
+		}
+	}
+	return out
+}
+
+// buckets returns the bucket bounds of the instrument of the runtime histogram name in unit:
+// the configured ones for seconds, the finite upper bounds of the runtime buckets otherwise.
+func (c *RuntimeCollector) buckets(name, unit string) []float64 {
+	if unit == "seconds" {
+		return c.cfg.runtimeBuckets
+	}
+	s := []rtmetrics.Sample{{Name: name}}
+	rtmetrics.Read(s)
+	// the bounds of a runtime histogram never change, see rtmetrics.Float64Histogram
+	var bounds []float64
+	for i, b := range s[0].Value.Float64Histogram().Buckets {
+		if i > 0 && !math.IsInf(b, 0) {
+			bounds = append(bounds, b)
+		}
+	}
+	return bounds
+}
+
+// runtimeName returns the instrument name and unit of a runtime metric: "/gc/heap/goal:bytes"
+// is recorded as "go_gc_heap_goal_bytes" in "bytes".
+func runtimeName(name string) (string, string) {
+	_, unit, _ := strings.Cut(name, ":")
+	r := strings.NewReplacer("/", "_", ":", "_", "-", "_")
+	return "go" + r.Replace(name), unit
+}
+
+// Collect reads the runtime metrics and records their changes since the previous collection.
+func (c *RuntimeCollector) Collect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rtmetrics.Read(c.samples)
+	for i, s := range c.samples {
+		inst := &c.insts[i]
+		switch s.Value.Kind() {
+		case rtmetrics.KindUint64:
+			inst.record(int64(s.Value.Uint64()))
+		case rtmetrics.KindFloat64:
+			inst.record(int64(math.Round(s.Value.Float64())))
+		case rtmetrics.KindFloat64Histogram:
+			inst.counts = recordHistogram(inst.hist, inst.counts, s.Value.Float64Histogram())
+		}
+	}
+}
+
+// Run collects immediately and then every collection interval until ctx is done.
+func (c *RuntimeCollector) Run(ctx context.Context) {
+	c.Collect()
+	t := c.cfg.clock.NewTicker(c.cfg.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			c.Collect()
+		}
+	}
+}
+
+// recordHistogram records into h the measurements added to the runtime histogram rh since
+// prev, its bucket counts at the previous collection, and returns its current counts.
+func recordHistogram(h metrics.Histogram, prev []uint64, rh *rtmetrics.Float64Histogram) []uint64 {
+	if len(prev) != len(rh.Counts) {
+		prev = make([]uint64, len(rh.Counts))
+	}
+	for i, n := range rh.Counts {
+		if n > prev[i] {
+			metrics.RecordN(h, bucketValue(rh.Buckets[i], rh.Buckets[i+1]), int64(n-prev[i]))
+		}
+		prev[i] = n
+	}
+	return prev
+}
+
+// bucketValue returns the value standing for the measurements of the runtime bucket
+// [lo, hi).
+func bucketValue(lo, hi float64) float64 {
+	switch {
+	case math.IsInf(lo, -1) && math.IsInf(hi, 1):
+		return 0
+	case math.IsInf(lo, -1):
+		return hi
+	case math.IsInf(hi, 1):
+		return lo
+	}
+	return lo + (hi-lo)/2
+}
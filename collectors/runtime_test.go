@@ -0,0 +1,213 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/metricstest"
+)
+
+func TestRuntimeCollector_Defaults(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	c := NewRuntimeCollector(p)
+
+	// every default metric is registered before the first collection
+	names := make(map[string]metrics.InstrumentType)
+	for _, e := range p.ListMetadata() {
+		names[e.Name] = e.Type
+	}
+	for name, typ := range map[string]metrics.InstrumentType{
+		"go_gc_cycles_total_gc_cycles_total": metrics.InstrumentTypeCounter,
+		"go_gc_heap_allocs_bytes_total":      metrics.InstrumentTypeCounter,
+		"go_gc_heap_live_bytes":              metrics.InstrumentTypeUpDown,
+		"go_sched_goroutines_goroutines":     metrics.InstrumentTypeUpDown,
+		"go_sched_latencies_seconds":         metrics.InstrumentTypeHistogram,
+		"go_sched_pauses_total_gc_seconds":   metrics.InstrumentTypeHistogram,
+	} {
+		if names[name] != typ {
+			t.Fatalf("expected %s %s, got instruments %v", typ, name, names)
+		}
+	}
+	if _, cfg, _ := p.HistogramWithMeta("go_sched_latencies_seconds"); cfg.Unit != "seconds" ||
+		cfg.Description == "" || len(cfg.Buckets) != len(defaultRuntimeBuckets) {
+		t.Fatalf("unexpected histogram config %+v", cfg)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-stop
+		}()
+	}
+	runtime.GC()
+	c.Collect()
+	snap := p.Snapshot()
+	g, _ := snap.Get(metrics.InstrumentTypeUpDown, "go_sched_goroutines_goroutines")
+	if g.Value < 11 {
+		t.Fatalf("expected at least 11 goroutines, got %d", g.Value)
+	}
+	cycles, _ := snap.Get(metrics.InstrumentTypeCounter, "go_gc_cycles_total_gc_cycles_total")
+	if cycles.Value < 1 {
+		t.Fatalf("expected a GC cycle, got %d", cycles.Value)
+	}
+	if h, _ := snap.Get(metrics.InstrumentTypeHistogram, "go_sched_pauses_total_gc_seconds"); h.Histogram.Count < 1 {
+		t.Fatalf("expected GC pauses, got %+v", h.Histogram)
+	}
+
+	// later collections record the changes only
+	close(stop)
+	wg.Wait()
+	runtime.GC()
+	c.Collect()
+	snap = p.Snapshot()
+	if g2, _ := snap.Get(metrics.InstrumentTypeUpDown, "go_sched_goroutines_goroutines"); g2.Value > g.Value-10 {
+		t.Fatalf("expected the goroutine count to follow the runtime, got %d then %d", g.Value, g2.Value)
+	}
+	var total uint64
+	sample := []rtmetrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+	rtmetrics.Read(sample)
+	total = sample[0].Value.Uint64()
+	if c2, _ := snap.Get(metrics.InstrumentTypeCounter, "go_gc_cycles_total_gc_cycles_total"); c2.Value <= cycles.Value ||
+		uint64(c2.Value) > total {
+		t.Fatalf("expected the counter to follow the runtime total %d, got %d", total, c2.Value)
+	}
+}
+
+// warnLogger records warnings.
+type warnLogger struct {
+	noopLogger
+	warnings []string
+}
+
+func (l *warnLogger) Warnf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestRuntimeCollector_Subset(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	l := &warnLogger{}
+	NewRuntimeCollector(p,
+		WithRuntimeMetrics("/gc/heap/*:bytes", "/sched/goroutines:goroutines", "/no/such:metric"),
+		WithRuntimeBuckets(0.5),
+		WithLogger(l))
+
+	entries := p.ListMetadata()
+	for _, e := range entries {
+		if e.Name != "go_sched_goroutines_goroutines" && !strings.HasPrefix(e.Name, "go_gc_heap_") {
+			t.Fatalf("unexpected instrument %s", e.Name)
+		}
+	}
+	if len(entries) < 3 {
+		t.Fatalf("expected the heap metrics and the goroutine count, got %+v", entries)
+	}
+	if len(l.warnings) != 1 || !strings.Contains(l.warnings[0], "/no/such:metric") {
+		t.Fatalf("expected a warning for the unknown metric, got %v", l.warnings)
+	}
+
+	NewRuntimeCollector(p, WithRuntimeMetrics("/sched/latencies:seconds"), WithRuntimeBuckets(0.5))
+	if _, cfg, ok := p.HistogramWithMeta("go_sched_latencies_seconds"); !ok || len(cfg.Buckets) != 1 || cfg.Buckets[0] != 0.5 {
+		t.Fatalf("expected the configured buckets, got %+v", cfg)
+	}
+}
+
+func TestRuntimeCollector_Buckets(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	c := NewRuntimeCollector(p,
+		WithRuntimeMetrics("/gc/heap/allocs-by-size:bytes", "/sched/latencies:seconds"),
+		WithRuntimeBuckets(0.5))
+
+	if _, cfg, _ := p.HistogramWithMeta("go_sched_latencies_seconds"); len(cfg.Buckets) != 1 || cfg.Buckets[0] != 0.5 {
+		t.Fatalf("expected the configured buckets in seconds, got %+v", cfg.Buckets)
+	}
+	// histograms in bytes keep the size classes of the runtime
+	sample := []rtmetrics.Sample{{Name: "/gc/heap/allocs-by-size:bytes"}}
+	rtmetrics.Read(sample)
+	var want []float64
+	for _, b := range sample[0].Value.Float64Histogram().Buckets[1:] {
+		if !math.IsInf(b, 0) {
+			want = append(want, b)
+		}
+	}
+	_, cfg, _ := p.HistogramWithMeta("go_gc_heap_allocs_by_size_bytes")
+	if cfg.Unit != "bytes" || len(want) < 2 || !slices.Equal(cfg.Buckets, want) {
+		t.Fatalf("expected the runtime bounds %v, got %+v", want, cfg)
+	}
+
+	c.Collect()
+	snap := p.Snapshot()
+	h, _ := snap.Get(metrics.InstrumentTypeHistogram, "go_gc_heap_allocs_by_size_bytes")
+	used := 0
+	for _, b := range h.Histogram.Buckets {
+		if b.Count > 0 {
+			used++
+		}
+	}
+	if h.Histogram.Count == 0 || used < 2 {
+		t.Fatalf("expected allocations spread over the size classes, got %+v", h.Histogram)
+	}
+}
+
+func TestRecordHistogram(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	h := p.Histogram("h", metrics.WithBuckets(1, 10))
+	rh := &rtmetrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 0, 1},
+		Buckets: []float64{math.Inf(-1), 0.5, 2, 20, math.Inf(1)},
+	}
+	counts := recordHistogram(h, nil, rh)
+	s := h.(*metrics.BasicHistogram).Snapshot()
+	// 1 at 0.5, 2 at 1.25 and 1 at 20
+	if s.Count != 4 || s.Sum != 0.5+2.5+20 || s.Buckets[0].Count != 1 || s.Buckets[1].Count != 2 || s.Buckets[2].Count != 1 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+
+	rh.Counts = []uint64{1, 2, 3, 1}
+	recordHistogram(h, counts, rh)
+	if s := h.(*metrics.BasicHistogram).Snapshot(); s.Count != 7 || s.Buckets[2].Count != 4 {
+		t.Fatalf("expected only the new measurements, got %+v", s)
+	}
+}
+
+func TestRuntimeCollector_Run(t *testing.T) {
+	clk := metricstest.NewFakeClock(time.Time{})
+	p := metrics.NewBasicProvider(metrics.WithClock(clk))
+	c := NewRuntimeCollector(p, WithRuntimeMetrics("/gc/cycles/total:gc-cycles"), WithInterval(time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	for clk.Waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	value := func() int64 {
+		s, _ := p.Snapshot().Get(metrics.InstrumentTypeCounter, "go_gc_cycles_total_gc_cycles_total")
+		return s.Value
+	}
+	before := value()
+	runtime.GC()
+	clk.Advance(time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for value() <= before {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a collection after the interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
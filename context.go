@@ -0,0 +1,153 @@
+package metrics
+
+import "context"
+
+// ctxKey is an unexported type for context keys defined in this package,
+// preventing collisions with keys defined in other packages.
+type ctxKey int
+
+const (
+	providerCtxKey ctxKey = iota
+	attributesCtxKey
+	traceCtxKey
+)
+
+// ContextCounter is an optional interface for counters that accept a context.Context
+// alongside a measurement. Use AddWithContext to record through it when available.
+// The instruments of this package do not implement it: context attributes are applied when
+// the instrument is created through FromContext, not per measurement.
+type ContextCounter interface {
+	Counter
+	AddContext(ctx context.Context, n int64)
+}
+
+// ContextUpDownCounter is an optional interface for up/down counters that accept a context.Context
+// alongside a measurement. Use AddWithContext to record through it when available.
+type ContextUpDownCounter interface {
+	UpDownCounter
+	AddContext(ctx context.Context, n int64)
+}
+
+// ContextHistogram is an optional interface for histograms that accept a context.Context
+// alongside a measurement. Use RecordWithContext to record through it when available.
+type ContextHistogram interface {
+	Histogram
+	RecordContext(ctx context.Context, v float64)
+}
+
+// ContextWithProvider returns a copy of ctx carrying p. A nil p is ignored.
+func ContextWithProvider(ctx context.Context, p Provider) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, providerCtxKey, p)
+}
+
+// FromContext returns the Provider carried by ctx, or GlobalProvider if there is none.
+//
+// If ctx also carries attributes (see ContextWithAttributes), the returned Provider merges
+// them into the attributes of every instrument it creates. Attributes passed explicitly via
+// WithAttributes take precedence over the context ones. Instruments are named by SeriesName
+// of the name and the merged attributes, so that contexts with different attributes, e.g.
+// different tenants, record separate series.
+func FromContext(ctx context.Context) Provider {
+	p, ok := ctx.Value(providerCtxKey).(Provider)
+	if !ok {
+		p = GlobalProvider()
+	}
+	attrs, _ := ctx.Value(attributesCtxKey).(map[string]string)
+	if len(attrs) == 0 {
+		return p
+	}
+	return &attributesProvider{p: p, attrs: attrs}
+}
+
+// ContextWithAttributes returns a copy of ctx carrying attrs merged over the attributes
+// already carried by ctx; on key conflicts values from attrs win. The map is copied.
+// Attributes are request-scoped (e.g. tenant, route) and should have bounded cardinality.
+func ContextWithAttributes(ctx context.Context, attrs map[string]string) context.Context {
+	if len(attrs) == 0 {
+		return ctx
+	}
+	parent, _ := ctx.Value(attributesCtxKey).(map[string]string)
+	merged := make(map[string]string, len(parent)+len(attrs))
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range attrs {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, attributesCtxKey, merged)
+}
+
+// AttributesFromContext returns a copy of the attributes carried by ctx, or nil if there are none.
+func AttributesFromContext(ctx context.Context) map[string]string {
+	attrs, _ := ctx.Value(attributesCtxKey).(map[string]string)
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+	return out
+}
+
+// AddWithContext adds n to c, passing ctx along if c implements ContextCounter.
+// It works for UpDownCounter values as well.
+func AddWithContext(ctx context.Context, c Counter, n int64) {
+	if cc, ok := c.(ContextCounter); ok {
+		cc.AddContext(ctx, n)
+		return
+	}
+	c.Add(n)
+}
+
+// RecordWithContext records v into h, passing ctx along if h implements ContextHistogram.
+func RecordWithContext(ctx context.Context, h Histogram, v float64) {
+	if ch, ok := h.(ContextHistogram); ok {
+		ch.RecordContext(ctx, v)
+		return
+	}
+	h.Record(v)
+}
+
+// attributesProvider merges context attributes into the options of created instruments.
+type attributesProvider struct {
+	p     Provider
+	attrs map[string]string // owned; never mutated after construction
+}
+
+// series returns the series name of the instrument name and its options carrying the
+// context attributes merged with the explicit ones, which take precedence.
+func (a *attributesProvider) series(name string, opts []InstrumentOption) (string, []InstrumentOption) {
+	var cfg InstrumentConfig
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	merged := make(map[string]string, len(a.attrs)+len(cfg.Attributes))
+	for k, v := range a.attrs {
+		merged[k] = v
+	}
+	for k, v := range cfg.Attributes {
+		merged[k] = v
+	}
+	return SeriesName(name, merged), append(opts[:len(opts):len(opts)], WithAttributes(merged))
+}
+
+func (a *attributesProvider) Counter(name string, opts ...InstrumentOption) Counter {
+	name, opts = a.series(name, opts)
+	return a.p.Counter(name, opts...)
+}
+
+func (a *attributesProvider) UpDownCounter(name string, opts ...InstrumentOption) UpDownCounter {
+	name, opts = a.series(name, opts)
+	return a.p.UpDownCounter(name, opts...)
+}
+
+func (a *attributesProvider) Histogram(name string, opts ...InstrumentOption) Histogram {
+	name, opts = a.series(name, opts)
+	return a.p.Histogram(name, opts...)
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// ctxRecorder is a test instrument implementing the context-aware interfaces;
+// it remembers the attributes seen in the context of each measurement.
+type ctxRecorder struct {
+	mu      sync.Mutex
+	plain   int
+	tenants []string
+}
+
+func (r *ctxRecorder) Add(_ int64)      { r.mu.Lock(); r.plain++; r.mu.Unlock() }
+func (r *ctxRecorder) Record(_ float64) { r.mu.Lock(); r.plain++; r.mu.Unlock() }
+
+func (r *ctxRecorder) AddContext(ctx context.Context, _ int64) {
+	r.mu.Lock()
+	r.tenants = append(r.tenants, AttributesFromContext(ctx)["tenant"])
+	r.mu.Unlock()
+}
+
+func (r *ctxRecorder) RecordContext(ctx context.Context, _ float64) {
+	r.mu.Lock()
+	r.tenants = append(r.tenants, AttributesFromContext(ctx)["tenant"])
+	r.mu.Unlock()
+}
+
+// ctxRecorderProvider returns the same ctxRecorder for every instrument.
+type ctxRecorderProvider struct{ r *ctxRecorder }
+
+func (p ctxRecorderProvider) Counter(_ string, _ ...InstrumentOption) Counter { return p.r }
+func (p ctxRecorderProvider) UpDownCounter(_ string, _ ...InstrumentOption) UpDownCounter {
+	return p.r
+}
+func (p ctxRecorderProvider) Histogram(_ string, _ ...InstrumentOption) Histogram { return p.r }
+
+func TestFromContext_ProviderAndFallback(t *testing.T) {
+	resetGlobal(t)
+
+	bp := NewBasicProvider()
+	ctx := ContextWithProvider(context.Background(), bp)
+	if FromContext(ctx) != Provider(bp) {
+		t.Fatalf("expected provider carried by context")
+	}
+	if ContextWithProvider(ctx, nil) != ctx {
+		t.Fatalf("expected nil provider to be ignored")
+	}
+
+	if _, ok := FromContext(context.Background()).(*delegatingProvider); !ok {
+		t.Fatalf("expected global provider fallback, got %T", FromContext(context.Background()))
+	}
+	SetGlobalProvider(bp)
+	if FromContext(context.Background()) != Provider(bp) {
+		t.Fatalf("expected installed global provider fallback")
+	}
+}
+
+func TestContextWithAttributes_MergesAndCopies(t *testing.T) {
+	ctx := context.Background()
+	if got := AttributesFromContext(ctx); got != nil {
+		t.Fatalf("expected nil attributes, got %v", got)
+	}
+	if ContextWithAttributes(ctx, nil) != ctx {
+		t.Fatalf("expected empty attributes to return the same context")
+	}
+
+	in := map[string]string{"tenant": "a", "route": "/x"}
+	ctx = ContextWithAttributes(ctx, in)
+	ctx2 := ContextWithAttributes(ctx, map[string]string{"route": "/y"})
+	in["tenant"] = mutated
+
+	got := AttributesFromContext(ctx2)
+	if got["tenant"] != "a" || got["route"] != "/y" {
+		t.Fatalf("unexpected merged attributes: %v", got)
+	}
+	got["tenant"] = mutated
+	if AttributesFromContext(ctx2)["tenant"] != "a" {
+		t.Fatalf("returned attributes must be a copy")
+	}
+	if AttributesFromContext(ctx)["route"] != "/x" {
+		t.Fatalf("parent context attributes must be unchanged")
+	}
+}
+
+func TestFromContext_MergesAttributesIntoInstruments(t *testing.T) {
+	bp := NewBasicProvider()
+	ctx := ContextWithProvider(context.Background(), bp)
+	ctx = ContextWithAttributes(ctx, map[string]string{"tenant": "a", "route": "/x"})
+
+	p := FromContext(ctx)
+	p.Counter("c", WithAttributes(map[string]string{"route": "/explicit"})).Add(1)
+	p.UpDownCounter("u").Add(1)
+	p.Histogram("h").Record(1)
+
+	_, cfg, _ := bp.CounterWithMeta(`c{route="/explicit",tenant="a"}`)
+	if cfg.Attributes["tenant"] != "a" || cfg.Attributes["route"] != "/explicit" {
+		t.Fatalf("unexpected counter attributes: %v", cfg.Attributes)
+	}
+	if _, cfg, _ := bp.UpDownCounterWithMeta(`u{route="/x",tenant="a"}`); cfg.Attributes["tenant"] != "a" {
+		t.Fatalf("unexpected updown attributes: %v", cfg.Attributes)
+	}
+	if _, cfg, _ := bp.HistogramWithMeta(`h{route="/x",tenant="a"}`); cfg.Attributes["route"] != "/x" {
+		t.Fatalf("unexpected histogram attributes: %v", cfg.Attributes)
+	}
+	if got := bp.Counter(`c{route="/explicit",tenant="a"}`).(*BasicCounter).Snapshot(); got != 1 {
+		t.Fatalf("counter = %d; want 1", got)
+	}
+}
+
+func TestFromContext_SeparatesSeriesByAttributes(t *testing.T) {
+	bp := NewBasicProvider()
+	ctx := ContextWithProvider(context.Background(), bp)
+	for _, tenant := range []string{"a", "b", "b"} {
+		tctx := ContextWithAttributes(ctx, map[string]string{"tenant": tenant})
+		FromContext(tctx).Counter("requests").Add(1)
+	}
+
+	for tenant, want := range map[string]int64{"a": 1, "b": 2} {
+		name := `requests{tenant="` + tenant + `"}`
+		c, cfg, ok := bp.CounterWithMeta(name)
+		if !ok || c.(*BasicCounter).Snapshot() != want || cfg.Attributes["tenant"] != tenant {
+			t.Fatalf("%s: unexpected series (found %v) with attributes %v", name, ok, cfg.Attributes)
+		}
+	}
+	if _, _, ok := bp.CounterWithMeta("requests"); ok {
+		t.Fatal("expected no series without attributes")
+	}
+}
+
+func TestWithContextHelpers(t *testing.T) {
+	ctx := ContextWithAttributes(context.Background(), map[string]string{"tenant": "t1"})
+
+	r := &ctxRecorder{}
+	AddWithContext(ctx, r, 1)
+	RecordWithContext(ctx, r, 1)
+	if r.plain != 0 || len(r.tenants) != 2 || r.tenants[0] != "t1" || r.tenants[1] != "t1" {
+		t.Fatalf("expected context-aware methods to be used: plain=%d tenants=%v", r.plain, r.tenants)
+	}
+
+	// plain instruments fall back to Add/Record
+	bp := NewBasicProvider()
+	AddWithContext(ctx, bp.Counter("c"), 2)
+	AddWithContext(ctx, bp.UpDownCounter("u"), -2)
+	RecordWithContext(ctx, bp.Histogram("h"), 2)
+	if got := bp.Counter("c").(*BasicCounter).Snapshot(); got != 2 {
+		t.Fatalf("counter = %d; want 2", got)
+	}
+	if got := bp.UpDownCounter("u").(*BasicUpDownCounter).Snapshot(); got != -2 {
+		t.Fatalf("updown = %d; want -2", got)
+	}
+	if got := bp.Histogram("h").(*BasicHistogram).Snapshot().Count; got != 1 {
+		t.Fatalf("histogram count = %d; want 1", got)
+	}
+}
+
+func TestWrappingInstrumentsForwardContext(t *testing.T) {
+	ctx := ContextWithAttributes(context.Background(), map[string]string{"tenant": "t2"})
+
+	t.Run("fanout", func(t *testing.T) {
+		for _, opts := range [][]FanoutProviderOption{nil, {WithFanoutPanicIsolation()}} {
+			r := &ctxRecorder{}
+			f := NewFanoutProvider([]Provider{ctxRecorderProvider{r}}, opts...)
+			AddWithContext(ctx, f.Counter("c"), 1)
+			AddWithContext(ctx, f.UpDownCounter("u"), 1)
+			RecordWithContext(ctx, f.Histogram("h"), 1)
+			if r.plain != 0 || len(r.tenants) != 3 {
+				t.Fatalf("expected context forwarded: plain=%d tenants=%v", r.plain, r.tenants)
+			}
+		}
+	})
+
+	t.Run("delegating", func(t *testing.T) {
+		resetGlobal(t)
+		c := GlobalProvider().Counter("c")
+		u := GlobalProvider().UpDownCounter("u")
+		h := GlobalProvider().Histogram("h")
+		// unbound: discarded without panicking
+		AddWithContext(ctx, c, 1)
+
+		r := &ctxRecorder{}
+		SetGlobalProvider(ctxRecorderProvider{r})
+		AddWithContext(ctx, c, 1)
+		AddWithContext(ctx, u, 1)
+		RecordWithContext(ctx, h, 1)
+		if r.plain != 0 || len(r.tenants) != 3 || r.tenants[2] != "t2" {
+			t.Fatalf("expected context forwarded: plain=%d tenants=%v", r.plain, r.tenants)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+// Sorts the instrument table by the clicked column; clicking again reverses the order.
+(function () {
+  var table = document.getElementById("instruments");
+  var headers = table.querySelectorAll("th[data-sort]");
+
+  function key(row, index, numeric) {
+    var cell = row.cells[index];
+    if (!numeric) {
+      return cell.textContent.trim().toLowerCase();
+    }
+    var v = parseFloat(cell.getAttribute("data-value"));
+    return isNaN(v) ? -Infinity : v;
+  }
+
+  headers.forEach(function (th) {
+    th.addEventListener("click", function () {
+      var index = th.cellIndex;
+      var numeric = th.getAttribute("data-sort") === "number";
+      var asc = !th.classList.contains("asc");
+      headers.forEach(function (h) {
+        h.classList.remove("asc", "desc");
+      });
+      th.classList.add(asc ? "asc" : "desc");
+
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var ka = key(a, index, numeric);
+        var kb = key(b, index, numeric);
+        var c = ka < kb ? -1 : ka > kb ? 1 : 0;
+        return asc ? c : -c;
+      });
+      rows.forEach(function (r) {
+        body.appendChild(r);
+      });
+    });
+  });
+})();
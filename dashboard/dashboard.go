@@ -0,0 +1,263 @@
+// Package dashboard provides an HTML page showing the instruments of a provider, for quick
+// local debugging.
+//
+// The page lists every instrument returned by metrics.Inspector.ListMetadata with its
+// configuration and live value, in a table sortable by clicking the column headers. Counters
+// and up/down counters get a sparkline of their recent values and histograms a bar per bucket.
+// The page is self-contained: its styles and script are embedded in the binary and inlined.
+//
+//	p := metrics.NewBasicProvider()
+//	http.Handle("/debug/metrics", dashboard.New(p))
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+var (
+	//go:embed assets/dashboard.html
+	pageTemplate string
+	//go:embed assets/dashboard.css
+	pageCSS string
+	//go:embed assets/dashboard.js
+	pageJS string
+)
+
+var page = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"formatTime": formatTime,
+}).Parse(pageTemplate))
+
+// Defaults of the dashboard configuration.
+const (
+	defaultHistorySize    = 60
+	defaultSampleInterval = 10 * time.Second
+	defaultTitle          = "Metrics"
+)
+
+type config struct {
+	// number of values kept for the sparkline of a counter. Default: defaultHistorySize.
+	historySize int
+	// minimum interval between two values of the sparklines. Default: defaultSampleInterval.
+	interval time.Duration
+	// source of the sample times. Default: the clock of the inspector, or SystemClock.
+	clock metrics.Clock
+	// title of the page. Default: defaultTitle.
+	title string
+}
+
+// Option configures a Dashboard constructed by New.
+type Option func(*config)
+
+// WithHistorySize sets the number of recent values shown in the sparkline of a counter.
+// Values below 2 are ignored.
+func WithHistorySize(n int) Option {
+	return func(cfg *config) {
+		if n >= 2 {
+			cfg.historySize = n
+		}
+	}
+}
+
+// WithSampleInterval sets the interval at which Run samples the values of counters, and the
+// minimum interval between two values sampled when the page is rendered. Non-positive values
+// are ignored.
+func WithSampleInterval(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.interval = d
+		}
+	}
+}
+
+// WithClock sets the clock timing the samples. The default is the clock of the inspector if it
+// has one (such as metrics.BasicProvider), SystemClock otherwise. A nil clock is ignored.
+func WithClock(c metrics.Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// WithTitle sets the title of the page.
+func WithTitle(title string) Option {
+	return func(cfg *config) { cfg.title = title }
+}
+
+// Dashboard is an http.Handler rendering the instruments of an inspector as an HTML page.
+//
+// Sparklines show the values of counters and up/down counters sampled by Run, or when the page
+// is rendered if the last sample is older than the sample interval. Without Run, every reload
+// of the page thus adds a value.
+type Dashboard struct {
+	insp metrics.Inspector
+	cfg  config
+
+	mu         sync.Mutex
+	history    map[metrics.InstrumentKey][]int64
+	lastSample time.Time
+}
+
+// New returns a Dashboard of the instruments of insp, such as a metrics.BasicProvider.
+func New(insp metrics.Inspector, opts ...Option) *Dashboard {
+	cfg := config{historySize: defaultHistorySize, interval: defaultSampleInterval, title: defaultTitle}
+	if cs, ok := insp.(interface{ Clock() metrics.Clock }); ok {
+		cfg.clock = cs.Clock()
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	if cfg.clock == nil {
+		cfg.clock = metrics.SystemClock()
+	}
+	return &Dashboard{insp: insp, cfg: cfg, history: make(map[metrics.InstrumentKey][]int64)}
+}
+
+// Sample appends the current values of counters and up/down counters to their sparklines and
+// forgets the history of instruments that no longer exist.
+func (d *Dashboard) Sample() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sampleLocked(d.cfg.clock.Now())
+}
+
+// Run samples the values of counters every sample interval until ctx is done.
+func (d *Dashboard) Run(ctx context.Context) {
+	t := d.cfg.clock.NewTicker(d.cfg.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			d.Sample()
+		}
+	}
+}
+
+func (d *Dashboard) sampleLocked(now time.Time) {
+	seen := make(map[metrics.InstrumentKey]bool)
+	for _, e := range d.insp.ListMetadata() {
+		if e.Type == metrics.InstrumentTypeHistogram {
+			continue
+		}
+		v, ok := d.value(e.Type, e.Name)
+		if !ok {
+			continue
+		}
+		key := metrics.NewInstrumentKey(e.Type, e.Name)
+		seen[key] = true
+		h := append(d.history[key], v)
+		if len(h) > d.cfg.historySize {
+			h = append(h[:0], h[len(h)-d.cfg.historySize:]...)
+		}
+		d.history[key] = h
+	}
+	for key := range d.history {
+		if !seen[key] {
+			delete(d.history, key)
+		}
+	}
+	d.lastSample = now
+}
+
+// value returns the current value of a counter or up/down counter, if readable.
+func (d *Dashboard) value(typ metrics.InstrumentType, name string) (int64, bool) {
+	var inst interface{}
+	var ok bool
+	switch typ {
+	case metrics.InstrumentTypeCounter:
+		inst, _, ok = d.insp.CounterWithMeta(name)
+	case metrics.InstrumentTypeUpDown:
+		inst, _, ok = d.insp.UpDownCounterWithMeta(name)
+	}
+	s, readable := inst.(interface{ Snapshot() int64 })
+	if !ok || !readable {
+		return 0, false
+	}
+	return s.Snapshot(), true
+}
+
+// histogram returns the current state of a histogram, if readable.
+func (d *Dashboard) histogram(name string) (metrics.HistSnapshot, bool) {
+	inst, _, ok := d.insp.HistogramWithMeta(name)
+	s, readable := inst.(interface{ Snapshot() metrics.HistSnapshot })
+	if !ok || !readable {
+		return metrics.HistSnapshot{}, false
+	}
+	return s.Snapshot(), true
+}
+
+// ServeHTTP renders the dashboard.
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, d.pageData()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// pageData is the data of the page template.
+type pageData struct {
+	Title string
+	Time  time.Time
+	CSS   template.CSS
+	JS    template.JS
+	Rows  []row
+}
+
+func (d *Dashboard) pageData() pageData {
+	d.mu.Lock()
+	now := d.cfg.clock.Now()
+	if d.lastSample.IsZero() || now.Sub(d.lastSample) >= d.cfg.interval {
+		d.sampleLocked(now)
+	}
+	history := make(map[metrics.InstrumentKey][]int64, len(d.history))
+	for k, h := range d.history {
+		history[k] = append([]int64(nil), h...)
+	}
+	d.mu.Unlock()
+
+	entries := d.insp.ListMetadata()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Type < entries[j].Type
+	})
+	data := pageData{Title: d.cfg.title, Time: now, CSS: template.CSS(pageCSS), JS: template.JS(pageJS)}
+	for _, e := range entries {
+		r := newRow(e)
+		switch e.Type {
+		case metrics.InstrumentTypeHistogram:
+			if h, ok := d.histogram(e.Name); ok {
+				r.setHistogram(h)
+			}
+		default:
+			if v, ok := d.value(e.Type, e.Name); ok {
+				r.setValue(v, history[metrics.NewInstrumentKey(e.Type, e.Name)])
+			}
+		}
+		data.Rows = append(data.Rows, r)
+	}
+	return data
+}
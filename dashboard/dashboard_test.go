@@ -0,0 +1,151 @@
+package dashboard
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/metricstest"
+)
+
+func render(t *testing.T, d *Dashboard) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestDashboard_RendersInstruments(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	p.Counter("requests_total", metrics.WithDescription("served <requests>"),
+		metrics.WithAttributes(map[string]string{"route": "/users"}), metrics.WithDurable()).Add(7)
+	p.UpDownCounter("inflight", metrics.WithUnit("requests")).Add(-2)
+	h := p.Histogram("latency_seconds", metrics.WithBuckets(0.1, 1))
+	h.Record(0.05)
+	h.Record(0.5)
+	h.Record(0.7)
+
+	out := render(t, New(p, WithTitle("api")))
+	for _, want := range []string{
+		"<title>api</title>",
+		"3 instruments at",
+		`<td class="name">requests_total<div class="config">durable</div></td>`,
+		"<td>route=/users</td>",
+		`data-value="7">7</td>`,
+		"served &lt;requests&gt;",
+		`data-value="-2">-2</td>`,
+		"<td>requests</td>",
+		`buckets 0.1, 1`,
+		`<title>≤ 1: 2</title>`,
+		"sum 1.25, mean 0.416667, min 0.05, max 0.7",
+		"th[data-sort]",                 // the embedded styles
+		`getElementById("instruments")`, // and script
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+	// rows are sorted by name
+	if strings.Index(out, "inflight") > strings.Index(out, "latency_seconds") ||
+		strings.Index(out, "latency_seconds") > strings.Index(out, "requests_total") {
+		t.Fatalf("expected rows sorted by name:\n%s", out)
+	}
+	// a single sample draws no sparkline
+	if strings.Contains(out, "<polyline") {
+		t.Fatalf("unexpected sparkline:\n%s", out)
+	}
+}
+
+func TestDashboard_Sparklines(t *testing.T) {
+	clk := metricstest.NewFakeClock(time.Time{})
+	p := metrics.NewBasicProvider(metrics.WithClock(clk))
+	c := p.Counter("c")
+	d := New(p, WithSampleInterval(time.Minute), WithHistorySize(3))
+
+	render(t, d) // samples 0
+	c.Add(5)
+	render(t, d) // within the interval: no sample
+	clk.Advance(time.Minute)
+	out := render(t, d) // samples 5
+	if !strings.Contains(out, `<polyline points="0.0,23.0 120.0,1.0"/>`) {
+		t.Fatalf("expected a rising sparkline:\n%s", out)
+	}
+
+	for _, v := range []int64{5, 10} {
+		c.Add(v)
+		d.Sample()
+	}
+	// the history keeps the last 3 values
+	if h := history(d, "c"); len(h) != 3 || h[0] != 5 || h[1] != 10 || h[2] != 20 {
+		t.Fatalf("unexpected history %v", h)
+	}
+
+	// a constant value is drawn as a flat line
+	if got := sparkline([]int64{4, 4, 4}); got != "0.0,12.0 60.0,12.0 120.0,12.0" {
+		t.Fatalf("unexpected flat sparkline %q", got)
+	}
+}
+
+func TestDashboard_RunSamples(t *testing.T) {
+	clk := metricstest.NewFakeClock(time.Time{})
+	p := metrics.NewBasicProvider(metrics.WithClock(clk))
+	c := p.Counter("c")
+	d := New(p, WithSampleInterval(time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	for clk.Waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i <= 3; i++ {
+		c.Add(1)
+		clk.Advance(time.Second)
+		deadline := time.Now().Add(5 * time.Second)
+		for len(history(d, "c")) != i {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d samples, got %v", i, history(d, "c"))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if h := history(d, "c"); h[0] != 1 || h[2] != 3 {
+		t.Fatalf("unexpected history %v", h)
+	}
+	cancel()
+	<-done
+}
+
+// history returns a copy of the sampled values of the counter name.
+func history(d *Dashboard, name string) []int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]int64(nil), d.history[metrics.NewInstrumentKey(metrics.InstrumentTypeCounter, name)]...)
+}
+
+func TestDashboard_RejectsWrites(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(metrics.NewBasicProvider()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Fatalf("unexpected response %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestDashboard_Empty(t *testing.T) {
+	if out := render(t, New(metrics.NewBasicProvider())); !strings.Contains(out, "No instruments.") {
+		t.Fatalf("expected the empty state:\n%s", out)
+	}
+}
@@ -0,0 +1,181 @@
+package dashboard
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// Size of the sparkline and bucket chart drawings, in SVG user units.
+const (
+	chartWidth  = 120
+	chartHeight = 24
+)
+
+// row is a table row of the page.
+type row struct {
+	Type        metrics.InstrumentType
+	Name        string
+	Attributes  string
+	Description string
+	Unit        string
+	Config      string
+	Created     time.Time
+	// Value is the displayed value and SortValue the number the column sorts by; empty for
+	// instruments whose value cannot be read.
+	Value     string
+	SortValue string
+	// Sparkline holds the points of the polyline of the recent values; empty for fewer than two.
+	Sparkline string
+	// Stats summarizes a histogram.
+	Stats string
+	// Bars are the buckets of a histogram.
+	Bars []bar
+}
+
+// bar is a histogram bucket in the bucket chart.
+type bar struct {
+	X, Y, Width, Height float64
+	Title               string
+}
+
+func newRow(e metrics.InstrumentEntry) row {
+	r := row{
+		Type:        e.Type,
+		Name:        e.Name,
+		Attributes:  formatAttrs(e.Config.Attributes),
+		Description: e.Config.Description,
+		Unit:        e.Config.Unit,
+		Created:     e.Times.Created,
+		Value:       "-",
+	}
+	var cfg []string
+	if len(e.Config.Buckets) > 0 {
+		cfg = append(cfg, "buckets "+formatFloats(e.Config.Buckets))
+	}
+	if w := e.Config.Window; w != nil {
+		cfg = append(cfg, "window "+w.Duration.String())
+	}
+	if e.Config.Durable {
+		cfg = append(cfg, "durable")
+	}
+	r.Config = strings.Join(cfg, "; ")
+	return r
+}
+
+// setValue sets the value of a counter or up/down counter and draws the sparkline of its
+// recent values.
+func (r *row) setValue(v int64, history []int64) {
+	r.Value = strconv.FormatInt(v, 10)
+	r.SortValue = r.Value
+	r.Sparkline = sparkline(history)
+}
+
+// setHistogram sets the count of a histogram as its value, summarizes it and draws its buckets.
+func (r *row) setHistogram(h metrics.HistSnapshot) {
+	r.Value = strconv.FormatInt(h.Count, 10)
+	r.SortValue = r.Value
+	if h.Count > 0 {
+		r.Stats = "sum " + formatFloat(h.Sum) + ", mean " + formatFloat(h.Mean) +
+			", min " + formatFloat(h.Min) + ", max " + formatFloat(h.Max)
+		for _, q := range h.Quantiles {
+			r.Stats += ", p" + strconv.FormatFloat(q.Q*100, 'g', -1, 64) + " " + formatFloat(q.Value)
+		}
+	}
+	r.Bars = bars(h.Buckets)
+}
+
+// sparkline returns the points of a polyline of values scaled to the chart, or "" for fewer
+// than two values.
+func sparkline(values []int64) string {
+	if len(values) < 2 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	span := float64(hi - lo)
+	step := float64(chartWidth) / float64(len(values)-1)
+	var b strings.Builder
+	for i, v := range values {
+		// a flat line sits in the middle
+		y := float64(chartHeight) / 2
+		if span > 0 {
+			y = chartHeight - 1 - float64(v-lo)/span*(chartHeight-2)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(formatCoord(float64(i)*step) + "," + formatCoord(y))
+	}
+	return b.String()
+}
+
+// bars returns a bar per bucket, scaled to the largest bucket count.
+func bars(buckets []metrics.HistBucket) []bar {
+	if len(buckets) == 0 {
+		return nil
+	}
+	var top int64
+	for _, b := range buckets {
+		top = max(top, b.Count)
+	}
+	width := float64(chartWidth) / float64(len(buckets))
+	out := make([]bar, len(buckets))
+	for i, b := range buckets {
+		h := 0.0
+		if top > 0 {
+			h = float64(b.Count) / float64(top) * chartHeight
+		}
+		// empty buckets keep a sliver so that the bounds remain visible
+		h = math.Max(h, 1)
+		out[i] = bar{
+			X: float64(i) * width, Y: chartHeight - h, Width: math.Max(width-1, 1), Height: h,
+			Title: "≤ " + formatFloat(b.UpperBound) + ": " + strconv.FormatInt(b.Count, 10),
+		}
+	}
+	return out
+}
+
+func formatAttrs(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + attrs[k]
+	}
+	return strings.Join(keys, ", ")
+}
+
+func formatFloats(vs []float64) string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = formatFloat(v)
+	}
+	return strings.Join(out, ", ")
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
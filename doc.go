@@ -30,7 +30,7 @@ consistent per-key snapshot.
 # Reference implementation
 
 BasicProvider implements both Provider and Inspector using in-memory data structures.
-It stores instruments in per-type sync.Maps keyed by name and uses a separate sync.Map of
+It stores instruments in per-type typed registries keyed by name and uses a separate sync.Map of
 per-key mutexes to serialize first-time initialization. Metadata is stored alongside and
 Inspector methods acquire the same per-key mutex to return a consistent (instrument, meta)
 snapshot. After initialization, the per-key mutex entry may be removed to reduce memory
@@ -38,7 +38,8 @@ snapshot. After initialization, the per-key mutex entry may be removed to reduce
 
 How it works (high level)
 
- 1. Fast path: look up the instrument in the appropriate sync.Map and return it if present.
+ 1. Fast path: look up the instrument in the appropriate registry and return it if present.
+    Registry lookups are lock-free and allocation-free, and so are Add and Record.
  2. Slow path: build InstrumentConfig off-lock from options; acquire the per-key mutex; re-check;
     store metadata; create and store the instrument; optionally delete the init mutex entry.
  3. Inspector methods take the same per-key mutex, read instrument and metadata, and return a
@@ -84,6 +85,9 @@ Examples
 - InstrumentConfig returned by Inspector methods are defensive copies; mutating them will not
 change the provider's stored metadata.
 
+- Instruments are handles bound to their (type,name) key: hold on to them on hot paths instead of
+looking them up on every measurement. Repeat lookups are allocation-free nevertheless.
+
 - Per-key init mutex entries are removed by default after initialization to allow GC of many
 ephemeral instrument names. Disable this behavior with metrics.WithInitCleanupDisabled().
 */
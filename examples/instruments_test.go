@@ -43,7 +43,7 @@ func ExampleBasicProvider_instruments() {
 		fmt.Printf("%s:%s -> %#v\n", e.Type, e.Name, e.Config)
 	}
 
-	// Output: counter:c1 -> metrics.InstrumentConfig{Description:"counter 1", Unit:"", Attributes:map[string]string{"env":"dev"}}
-	// histogram:h1 -> metrics.InstrumentConfig{Description:"", Unit:"ms", Attributes:map[string]string(nil)}
-	// updown:u1 -> metrics.InstrumentConfig{Description:"updown 1", Unit:"", Attributes:map[string]string(nil)}
+	// Output: counter:c1 -> metrics.InstrumentConfig{Description:"counter 1", Unit:"", Attributes:map[string]string{"env":"dev"}, Buckets:[]float64(nil), Window:(*metrics.HistogramWindow)(nil), Durable:false}
+	// histogram:h1 -> metrics.InstrumentConfig{Description:"", Unit:"ms", Attributes:map[string]string(nil), Buckets:[]float64(nil), Window:(*metrics.HistogramWindow)(nil), Durable:false}
+	// updown:u1 -> metrics.InstrumentConfig{Description:"updown 1", Unit:"", Attributes:map[string]string(nil), Buckets:[]float64(nil), Window:(*metrics.HistogramWindow)(nil), Durable:false}
 }
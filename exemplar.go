@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Exemplar is an example measurement attached to a counter or histogram recording,
+// typically linking it to the trace that produced it.
+type Exemplar struct {
+	// Value is the measurement value; set by the instrument on recording.
+	Value float64
+	// Timestamp is the time of the measurement; set to the current time of the instrument
+	// clock on recording if zero.
+	Timestamp time.Time
+	TraceID   string
+	SpanID    string
+	// Attributes are measurement attributes not part of the instrument attributes,
+	// e.g. request-scoped attributes from the context.
+	Attributes map[string]string
+}
+
+// copyExemplar makes a defensive copy of an Exemplar (copies Attributes map).
+func copyExemplar(in Exemplar) Exemplar {
+	out := in
+	if len(in.Attributes) > 0 {
+		out.Attributes = make(map[string]string, len(in.Attributes))
+		for k, v := range in.Attributes {
+			out.Attributes[k] = v
+		}
+	}
+	return out
+}
+
+// ExemplarFilter decides which exemplars offered by recordings are kept.
+// Plain Add and Record calls never produce exemplars, whatever the filter.
+type ExemplarFilter int
+
+const (
+	// ExemplarFilterTraceBased keeps exemplars that carry a trace ID. This is the default.
+	ExemplarFilterTraceBased ExemplarFilter = iota
+	// ExemplarFilterAlwaysOn keeps every offered exemplar.
+	ExemplarFilterAlwaysOn
+	// ExemplarFilterAlwaysOff drops every exemplar; instruments then keep no reservoir at all.
+	ExemplarFilterAlwaysOff
+)
+
+// String returns a string representation of the ExemplarFilter.
+func (f ExemplarFilter) String() string {
+	switch f {
+	case ExemplarFilterTraceBased:
+		return "trace_based"
+	case ExemplarFilterAlwaysOn:
+		return "always_on"
+	case ExemplarFilterAlwaysOff:
+		return "always_off"
+	default:
+		return "unknown"
+	}
+}
+
+// keeps reports whether ex passes the filter.
+func (f ExemplarFilter) keeps(ex *Exemplar) bool {
+	switch f {
+	case ExemplarFilterAlwaysOn:
+		return true
+	case ExemplarFilterTraceBased:
+		return ex.TraceID != ""
+	default:
+		return false
+	}
+}
+
+// ExemplarCounter is an optional interface for counters able to keep exemplars.
+// Use AddWithExemplar to record through it when available.
+type ExemplarCounter interface {
+	Counter
+	AddWithExemplar(n int64, ex Exemplar)
+}
+
+// ExemplarHistogram is an optional interface for histograms able to keep exemplars.
+// Use RecordWithExemplar to record through it when available.
+type ExemplarHistogram interface {
+	Histogram
+	RecordWithExemplar(v float64, ex Exemplar)
+}
+
+// AddWithExemplar adds n to c, attaching ex if c implements ExemplarCounter.
+func AddWithExemplar(c Counter, n int64, ex Exemplar) {
+	if ec, ok := c.(ExemplarCounter); ok {
+		ec.AddWithExemplar(n, ex)
+		return
+	}
+	c.Add(n)
+}
+
+// RecordWithExemplar records v into h, attaching ex if h implements ExemplarHistogram.
+func RecordWithExemplar(h Histogram, v float64, ex Exemplar) {
+	if eh, ok := h.(ExemplarHistogram); ok {
+		eh.RecordWithExemplar(v, ex)
+		return
+	}
+	h.Record(v)
+}
+
+// traceIDs is the trace context carried by a context.Context for exemplars.
+type traceIDs struct {
+	traceID, spanID string
+}
+
+// ContextWithTrace returns a copy of ctx carrying the trace and span IDs of the current span.
+// Basic instruments recording through AddWithContext or RecordWithContext turn them into
+// exemplars, together with the attributes carried by ctx (see ContextWithAttributes).
+func ContextWithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	return context.WithValue(ctx, traceCtxKey, traceIDs{traceID: traceID, spanID: spanID})
+}
+
+// exemplarFromContext builds an exemplar from the trace and attributes carried by ctx.
+// ok is false if ctx carries neither.
+func exemplarFromContext(ctx context.Context) (Exemplar, bool) {
+	ids, hasTrace := ctx.Value(traceCtxKey).(traceIDs)
+	attrs := AttributesFromContext(ctx)
+	if !hasTrace && attrs == nil {
+		return Exemplar{}, false
+	}
+	return Exemplar{TraceID: ids.traceID, SpanID: ids.spanID, Attributes: attrs}, true
+}
+
+// exemplarReservoir keeps the most recent exemplars per bucket in fixed-size rings.
+// Counters use a single bucket. It is only touched by recordings carrying an exemplar.
+type exemplarReservoir struct {
+	filter ExemplarFilter
+	size   int
+	clock  Clock // timestamps exemplars offered without one
+
+	mu    sync.Mutex
+	rings [][]Exemplar // per bucket, at most size entries
+	next  []int        // per bucket, index of the slot to overwrite once the ring is full
+}
+
+// newExemplarReservoir returns nil if the filter drops everything, so that instruments
+// pay nothing for exemplars in that case.
+func newExemplarReservoir(filter ExemplarFilter, size, buckets int) *exemplarReservoir {
+	if filter == ExemplarFilterAlwaysOff || size <= 0 {
+		return nil
+	}
+	return &exemplarReservoir{
+		filter: filter,
+		size:   size,
+		clock:  SystemClock(),
+		rings:  make([][]Exemplar, buckets),
+		next:   make([]int, buckets),
+	}
+}
+
+// offer stores ex for the bucket if it passes the filter. The map of attributes is copied.
+func (r *exemplarReservoir) offer(bucket int, v float64, ex Exemplar) {
+	if r == nil || !r.filter.keeps(&ex) {
+		return
+	}
+	ex = copyExemplar(ex)
+	ex.Value = v
+	if ex.Timestamp.IsZero() {
+		ex.Timestamp = r.clock.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.rings[bucket]) < r.size {
+		r.rings[bucket] = append(r.rings[bucket], ex)
+		return
+	}
+	r.rings[bucket][r.next[bucket]] = ex
+	r.next[bucket] = (r.next[bucket] + 1) % r.size
+}
+
+// reset drops all kept exemplars.
+func (r *exemplarReservoir) reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.rings {
+		r.rings[i] = nil
+		r.next[i] = 0
+	}
+}
+
+// collect returns copies of the exemplars kept for the bucket, oldest first.
+func (r *exemplarReservoir) collect(bucket int) []Exemplar {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ring := r.rings[bucket]
+	if len(ring) == 0 {
+		return nil
+	}
+	out := make([]Exemplar, 0, len(ring))
+	start := 0
+	if len(ring) == r.size {
+		start = r.next[bucket]
+	}
+	for i := 0; i < len(ring); i++ {
+		out = append(out, copyExemplar(ring[(start+i)%len(ring)]))
+	}
+	return out
+}
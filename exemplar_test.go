@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExemplarFilter(t *testing.T) {
+	traced := Exemplar{TraceID: "t"}
+	untraced := Exemplar{}
+	cases := []struct {
+		f                ExemplarFilter
+		name             string
+		traced, untraced bool
+	}{
+		{ExemplarFilterTraceBased, "trace_based", true, false},
+		{ExemplarFilterAlwaysOn, "always_on", true, true},
+		{ExemplarFilterAlwaysOff, "always_off", false, false},
+		{ExemplarFilter(42), "unknown", false, false},
+	}
+	for _, c := range cases {
+		if c.f.String() != c.name {
+			t.Fatalf("String() = %q; want %q", c.f.String(), c.name)
+		}
+		if c.f.keeps(&traced) != c.traced || c.f.keeps(&untraced) != c.untraced {
+			t.Fatalf("%s: unexpected keeps results", c.name)
+		}
+	}
+}
+
+func TestExemplarReservoir_KeepsMostRecent(t *testing.T) {
+	r := newExemplarReservoir(ExemplarFilterAlwaysOn, 2, 1)
+	ts := time.Unix(100, 0)
+	attrs := map[string]string{"k": "v"}
+	for i := 1; i <= 3; i++ {
+		r.offer(0, float64(i), Exemplar{Timestamp: ts, Attributes: attrs})
+	}
+	attrs["k"] = mutated
+
+	got := r.collect(0)
+	if len(got) != 2 || got[0].Value != 2 || got[1].Value != 3 {
+		t.Fatalf("unexpected exemplars %+v; want values 2, 3", got)
+	}
+	if got[0].Attributes["k"] != "v" || !got[0].Timestamp.Equal(ts) {
+		t.Fatalf("expected copied attributes and kept timestamp, got %+v", got[0])
+	}
+	got[0].Attributes["k"] = mutated
+	if r.collect(0)[0].Attributes["k"] != "v" {
+		t.Fatalf("collect must return copies")
+	}
+
+	// missing timestamp is set on offer
+	r2 := newExemplarReservoir(ExemplarFilterAlwaysOn, 1, 1)
+	r2.offer(0, 1, Exemplar{})
+	if r2.collect(0)[0].Timestamp.IsZero() {
+		t.Fatalf("expected timestamp to be set")
+	}
+
+	if newExemplarReservoir(ExemplarFilterAlwaysOff, 1, 1) != nil || newExemplarReservoir(ExemplarFilterAlwaysOn, 0, 1) != nil {
+		t.Fatalf("expected nil reservoir when exemplars are disabled")
+	}
+	var disabled *exemplarReservoir
+	disabled.offer(0, 1, Exemplar{TraceID: "t"}) // must not panic
+	if disabled.collect(0) != nil {
+		t.Fatalf("expected no exemplars from nil reservoir")
+	}
+}
+
+func TestBasicHistogram_ExemplarsPerBucket(t *testing.T) {
+	p := NewBasicProvider(WithExemplarReservoirSize(2))
+	h := p.Histogram("lat", WithBuckets(0.1, 1))
+
+	RecordWithExemplar(h, 0.05, Exemplar{TraceID: "a", SpanID: "s1"})
+	RecordWithExemplar(h, 0.5, Exemplar{TraceID: "b"})
+	RecordWithExemplar(h, 5, Exemplar{}) // dropped by the trace-based filter
+	h.Record(0.07)
+
+	s := h.(*BasicHistogram).Snapshot()
+	if s.Count != 4 || len(s.Buckets) != 3 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	if b := s.Buckets[0]; b.Count != 2 || len(b.Exemplars) != 1 || b.Exemplars[0].TraceID != "a" || b.Exemplars[0].Value != 0.05 {
+		t.Fatalf("unexpected first bucket %+v", b)
+	}
+	if b := s.Buckets[1]; b.Count != 1 || len(b.Exemplars) != 1 || b.Exemplars[0].TraceID != "b" {
+		t.Fatalf("unexpected second bucket %+v", b)
+	}
+	if b := s.Buckets[2]; b.Count != 1 || len(b.Exemplars) != 0 {
+		t.Fatalf("unexpected +Inf bucket %+v", b)
+	}
+}
+
+func TestBasicCounter_Exemplars(t *testing.T) {
+	for _, p := range []*BasicProvider{
+		NewBasicProvider(WithExemplarFilter(ExemplarFilterAlwaysOn)),
+		NewBasicProvider(WithExemplarFilter(ExemplarFilterAlwaysOn), WithShardedCounters(2)),
+	} {
+		c := p.Counter("c")
+		AddWithExemplar(c, 2, Exemplar{TraceID: "t1"})
+		AddWithExemplar(c, 3, Exemplar{TraceID: "t2"})
+
+		inst := c.(counterInstrument)
+		if inst.Snapshot() != 5 {
+			t.Fatalf("counter = %d; want 5", inst.Snapshot())
+		}
+		ex := inst.Exemplars()
+		if len(ex) != 1 || ex[0].TraceID != "t2" || ex[0].Value != 3 {
+			t.Fatalf("unexpected counter exemplars %+v", ex)
+		}
+	}
+
+	off := NewBasicProvider(WithExemplarFilter(ExemplarFilterAlwaysOff)).Counter("c")
+	AddWithExemplar(off, 1, Exemplar{TraceID: "t"})
+	if ex := off.(*BasicCounter).Exemplars(); ex != nil {
+		t.Fatalf("expected no exemplars when disabled, got %+v", ex)
+	}
+	if NewBasicProvider(WithExemplarReservoirSize(0)).Counter("c").(*BasicCounter).ex != nil {
+		t.Fatalf("expected no reservoir for size 0")
+	}
+}
+
+func TestExemplarsFromContext(t *testing.T) {
+	p := NewBasicProvider()
+	ctx := ContextWithTrace(context.Background(), "trace-1", "span-1")
+	ctx = ContextWithAttributes(ctx, map[string]string{"tenant": "acme"})
+
+	AddWithContext(ctx, p.Counter("c"), 1)
+	RecordWithContext(ctx, p.Histogram("h"), 1)
+	// attributes but no trace: dropped by the default trace-based filter
+	AddWithContext(ContextWithAttributes(context.Background(), map[string]string{"a": "b"}), p.Counter("c"), 1)
+	// nothing in context: no exemplar offered
+	AddWithContext(context.Background(), p.Counter("c"), 1)
+
+	ex := p.Counter("c").(*BasicCounter).Exemplars()
+	if len(ex) != 1 || ex[0].TraceID != "trace-1" || ex[0].SpanID != "span-1" || ex[0].Attributes["tenant"] != "acme" {
+		t.Fatalf("unexpected counter exemplars %+v", ex)
+	}
+	if got := p.Counter("c").(*BasicCounter).Snapshot(); got != 3 {
+		t.Fatalf("counter = %d; want 3", got)
+	}
+	hs := p.Histogram("h").(*BasicHistogram).Snapshot()
+	if len(hs.Buckets) != 1 || len(hs.Buckets[0].Exemplars) != 1 || hs.Buckets[0].Exemplars[0].TraceID != "trace-1" {
+		t.Fatalf("unexpected histogram exemplars %+v", hs.Buckets)
+	}
+
+	// plain instruments fall back to Add/Record
+	bp := NewBasicProvider()
+	AddWithExemplar(bp.UpDownCounter("u"), 1, Exemplar{TraceID: "t"})
+	RecordWithExemplar(noopHistogram{}, 1, Exemplar{TraceID: "t"})
+	if got := bp.UpDownCounter("u").(*BasicUpDownCounter).Snapshot(); got != 1 {
+		t.Fatalf("updown = %d; want 1", got)
+	}
+}
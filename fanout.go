@@ -0,0 +1,311 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+)
+
+// FanoutProvider is a Provider that records every measurement into several underlying
+// providers at once. It is intended for migrations where the same instruments must be
+// reported to both an old and a new backend.
+//
+// Instruments returned by FanoutProvider are composites that forward each call to the
+// matching instrument of every underlying provider, in the order the providers were given.
+// Composites are created once per (type, name) and reused afterwards.
+//
+// FanoutProvider also implements Inspector by delegating to a designated primary provider
+// (the first one by default). Inspection is only available if the primary implements Inspector.
+type FanoutProvider struct {
+	cfg       *fanoutProviderConfig
+	logger    logger
+	providers []Provider
+
+	counters   sync.Map // map[string]*fanoutCounter
+	updowns    sync.Map // map[string]*fanoutUpDownCounter
+	histograms sync.Map // map[string]*fanoutHistogram
+}
+
+type fanoutProviderConfig struct {
+	// when true, a panic raised by one underlying instrument is recovered and logged
+	// so that the remaining providers still receive the measurement. Default: false.
+	isolatePanics bool
+	// index of the provider used for Inspector delegation. Default: 0.
+	primary int
+	logger  logger
+}
+
+// FanoutProviderOption configures a FanoutProvider constructed by NewFanoutProvider.
+type FanoutProviderOption func(*fanoutProviderConfig)
+
+// WithFanoutPanicIsolation makes composite instruments recover panics raised by an
+// underlying instrument, log them and continue forwarding to the remaining providers.
+// Without this option a panic propagates to the caller.
+func WithFanoutPanicIsolation() FanoutProviderOption {
+	return func(cfg *fanoutProviderConfig) { cfg.isolatePanics = true }
+}
+
+// WithFanoutPrimary designates the provider at the given index (in the order passed to
+// NewFanoutProvider) as the primary one used for Inspector delegation.
+// Out of range indexes fall back to the first provider.
+func WithFanoutPrimary(index int) FanoutProviderOption {
+	return func(cfg *fanoutProviderConfig) { cfg.primary = index }
+}
+
+// WithFanoutLogger sets the logger used to report recovered panics.
+func WithFanoutLogger(l logger) FanoutProviderOption {
+	return func(cfg *fanoutProviderConfig) { cfg.logger = l }
+}
+
+// NewFanoutProvider constructs a FanoutProvider forwarding to the given providers.
+// Nil providers are skipped. With no providers the returned instruments discard all measurements.
+func NewFanoutProvider(providers []Provider, opts ...FanoutProviderOption) *FanoutProvider {
+	cfg := &fanoutProviderConfig{}
+	for _, o := range opts {
+		if o != nil {
+			o(cfg)
+		}
+	}
+	l := cfg.logger
+	if l == nil {
+		l = newNoopLogger()
+	}
+	ps := make([]Provider, 0, len(providers))
+	for _, p := range providers {
+		if p != nil {
+			ps = append(ps, p)
+		}
+	}
+	if cfg.primary < 0 || cfg.primary >= len(ps) {
+		cfg.primary = 0
+	}
+	return &FanoutProvider{cfg: cfg, logger: l, providers: ps}
+}
+
+// Counter returns a composite counter forwarding to the counter of the same name
+// in every underlying provider.
+func (f *FanoutProvider) Counter(name string, opts ...InstrumentOption) Counter {
+	if v, ok := f.counters.Load(name); ok {
+		return v.(*fanoutCounter)
+	}
+	c := &fanoutCounter{f: f, insts: make([]Counter, len(f.providers))}
+	for i, p := range f.providers {
+		c.insts[i] = p.Counter(name, opts...)
+	}
+	v, _ := f.counters.LoadOrStore(name, c)
+	return v.(*fanoutCounter)
+}
+
+// UpDownCounter returns a composite up/down counter forwarding to the up/down counter
+// of the same name in every underlying provider.
+func (f *FanoutProvider) UpDownCounter(name string, opts ...InstrumentOption) UpDownCounter {
+	if v, ok := f.updowns.Load(name); ok {
+		return v.(*fanoutUpDownCounter)
+	}
+	u := &fanoutUpDownCounter{f: f, insts: make([]UpDownCounter, len(f.providers))}
+	for i, p := range f.providers {
+		u.insts[i] = p.UpDownCounter(name, opts...)
+	}
+	v, _ := f.updowns.LoadOrStore(name, u)
+	return v.(*fanoutUpDownCounter)
+}
+
+// Histogram returns a composite histogram forwarding to the histogram of the same name
+// in every underlying provider.
+func (f *FanoutProvider) Histogram(name string, opts ...InstrumentOption) Histogram {
+	if v, ok := f.histograms.Load(name); ok {
+		return v.(*fanoutHistogram)
+	}
+	h := &fanoutHistogram{f: f, insts: make([]Histogram, len(f.providers))}
+	for i, p := range f.providers {
+		h.insts[i] = p.Histogram(name, opts...)
+	}
+	v, _ := f.histograms.LoadOrStore(name, h)
+	return v.(*fanoutHistogram)
+}
+
+// Primary returns the provider designated for Inspector delegation, or nil if
+// the FanoutProvider has no underlying providers.
+func (f *FanoutProvider) Primary() Provider {
+	if len(f.providers) == 0 {
+		return nil
+	}
+	return f.providers[f.cfg.primary]
+}
+
+// Clock returns the clock of the primary provider if it exposes one, SystemClock otherwise.
+func (f *FanoutProvider) Clock() Clock {
+	if cs, ok := f.Primary().(clockSource); ok {
+		return cs.Clock()
+	}
+	return SystemClock()
+}
+
+// inspector returns the primary provider as an Inspector, if it implements one.
+func (f *FanoutProvider) inspector() (Inspector, bool) {
+	in, ok := f.Primary().(Inspector)
+	return in, ok
+}
+
+// CounterWithMeta implements Inspector.CounterWithMeta by delegating to the primary provider.
+// The returned instrument is the primary's own counter, not the composite, so callers can
+// type-assert it to the primary's concrete type.
+func (f *FanoutProvider) CounterWithMeta(name string) (Counter, InstrumentConfig, bool) {
+	in, ok := f.inspector()
+	if !ok {
+		return nil, InstrumentConfig{}, false
+	}
+	return in.CounterWithMeta(name)
+}
+
+// UpDownCounterWithMeta implements Inspector.UpDownCounterWithMeta by delegating to the primary provider.
+// The returned instrument is the primary's own up/down counter, not the composite.
+func (f *FanoutProvider) UpDownCounterWithMeta(name string) (UpDownCounter, InstrumentConfig, bool) {
+	in, ok := f.inspector()
+	if !ok {
+		return nil, InstrumentConfig{}, false
+	}
+	return in.UpDownCounterWithMeta(name)
+}
+
+// HistogramWithMeta implements Inspector.HistogramWithMeta by delegating to the primary provider.
+// The returned instrument is the primary's own histogram, not the composite.
+func (f *FanoutProvider) HistogramWithMeta(name string) (Histogram, InstrumentConfig, bool) {
+	in, ok := f.inspector()
+	if !ok {
+		return nil, InstrumentConfig{}, false
+	}
+	return in.HistogramWithMeta(name)
+}
+
+// ListMetadata implements Inspector.ListMetadata by delegating to the primary provider.
+// It returns an empty slice if the primary does not implement Inspector.
+func (f *FanoutProvider) ListMetadata() []InstrumentEntry {
+	in, ok := f.inspector()
+	if !ok {
+		return make([]InstrumentEntry, 0)
+	}
+	return in.ListMetadata()
+}
+
+// guard runs fn for the backend at index i, recovering and logging a panic if it occurs.
+func (f *FanoutProvider) guard(i int, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.logger.Errorf("[metrics] fanout: provider %d panicked: %v", i, r)
+		}
+	}()
+	fn()
+}
+
+type fanoutCounter struct {
+	f     *FanoutProvider
+	insts []Counter
+}
+
+func (c *fanoutCounter) Add(n int64) {
+	for i, inst := range c.insts {
+		if c.f.cfg.isolatePanics {
+			c.f.guard(i, func() { inst.Add(n) })
+			continue
+		}
+		inst.Add(n)
+	}
+}
+
+// AddContext implements ContextCounter, passing ctx to every underlying counter.
+func (c *fanoutCounter) AddContext(ctx context.Context, n int64) {
+	for i, inst := range c.insts {
+		if c.f.cfg.isolatePanics {
+			c.f.guard(i, func() { AddWithContext(ctx, inst, n) })
+			continue
+		}
+		AddWithContext(ctx, inst, n)
+	}
+}
+
+// AddWithExemplar implements ExemplarCounter, attaching ex on every underlying counter
+// supporting exemplars.
+func (c *fanoutCounter) AddWithExemplar(n int64, ex Exemplar) {
+	for i, inst := range c.insts {
+		if c.f.cfg.isolatePanics {
+			c.f.guard(i, func() { AddWithExemplar(inst, n, ex) })
+			continue
+		}
+		AddWithExemplar(inst, n, ex)
+	}
+}
+
+type fanoutUpDownCounter struct {
+	f     *FanoutProvider
+	insts []UpDownCounter
+}
+
+func (u *fanoutUpDownCounter) Add(n int64) {
+	for i, inst := range u.insts {
+		if u.f.cfg.isolatePanics {
+			u.f.guard(i, func() { inst.Add(n) })
+			continue
+		}
+		inst.Add(n)
+	}
+}
+
+// AddContext implements ContextUpDownCounter, passing ctx to every underlying up/down counter.
+func (u *fanoutUpDownCounter) AddContext(ctx context.Context, n int64) {
+	for i, inst := range u.insts {
+		if u.f.cfg.isolatePanics {
+			u.f.guard(i, func() { AddWithContext(ctx, inst, n) })
+			continue
+		}
+		AddWithContext(ctx, inst, n)
+	}
+}
+
+type fanoutHistogram struct {
+	f     *FanoutProvider
+	insts []Histogram
+}
+
+func (h *fanoutHistogram) Record(v float64) {
+	for i, inst := range h.insts {
+		if h.f.cfg.isolatePanics {
+			h.f.guard(i, func() { inst.Record(v) })
+			continue
+		}
+		inst.Record(v)
+	}
+}
+
+// RecordN implements BatchHistogram, recording in bulk into the histograms supporting it.
+func (h *fanoutHistogram) RecordN(v float64, n int64) {
+	for i, inst := range h.insts {
+		if h.f.cfg.isolatePanics {
+			h.f.guard(i, func() { RecordN(inst, v, n) })
+			continue
+		}
+		RecordN(inst, v, n)
+	}
+}
+
+// RecordContext implements ContextHistogram, passing ctx to every underlying histogram.
+func (h *fanoutHistogram) RecordContext(ctx context.Context, v float64) {
+	for i, inst := range h.insts {
+		if h.f.cfg.isolatePanics {
+			h.f.guard(i, func() { RecordWithContext(ctx, inst, v) })
+			continue
+		}
+		RecordWithContext(ctx, inst, v)
+	}
+}
+
+// RecordWithExemplar implements ExemplarHistogram, attaching ex on every underlying histogram
+// supporting exemplars.
+func (h *fanoutHistogram) RecordWithExemplar(v float64, ex Exemplar) {
+	for i, inst := range h.insts {
+		if h.f.cfg.isolatePanics {
+			h.f.guard(i, func() { RecordWithExemplar(inst, v, ex) })
+			continue
+		}
+		RecordWithExemplar(inst, v, ex)
+	}
+}
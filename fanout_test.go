@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// panicProvider returns instruments that always panic; used to verify panic isolation.
+type panicProvider struct{}
+
+func (panicProvider) Counter(_ string, _ ...InstrumentOption) Counter             { return panicInst{} }
+func (panicProvider) UpDownCounter(_ string, _ ...InstrumentOption) UpDownCounter { return panicInst{} }
+func (panicProvider) Histogram(_ string, _ ...InstrumentOption) Histogram         { return panicInst{} }
+
+type panicInst struct{}
+
+func (panicInst) Add(_ int64)      { panic("backend down") }
+func (panicInst) Record(_ float64) { panic("backend down") }
+
+// recordingLogger collects formatted messages; safe for concurrent use.
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordingLogger) record(format string, args ...interface{}) {
+	l.mu.Lock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) { l.record(format, args...) }
+func (l *recordingLogger) Infof(format string, args ...interface{})  { l.record(format, args...) }
+func (l *recordingLogger) Warnf(format string, args ...interface{})  { l.record(format, args...) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.record(format, args...) }
+
+func (l *recordingLogger) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.msgs)
+}
+
+func TestFanoutProvider_ForwardsToAllProviders(t *testing.T) {
+	p1, p2 := NewBasicProvider(), NewBasicProvider()
+	f := NewFanoutProvider([]Provider{p1, nil, p2})
+
+	f.Counter("c", WithDescription("requests")).Add(3)
+	f.UpDownCounter("u").Add(-2)
+	f.Histogram("h").Record(1.5)
+	RecordN(f.Histogram("h"), 2, 2)
+
+	for i, p := range []*BasicProvider{p1, p2} {
+		if got := p.Counter("c").(*BasicCounter).Snapshot(); got != 3 {
+			t.Fatalf("provider %d: counter = %d; want 3", i, got)
+		}
+		if got := p.UpDownCounter("u").(*BasicUpDownCounter).Snapshot(); got != -2 {
+			t.Fatalf("provider %d: updown = %d; want -2", i, got)
+		}
+		if got := p.Histogram("h").(*BasicHistogram).Snapshot(); got.Count != 3 || got.Sum != 5.5 {
+			t.Fatalf("provider %d: unexpected histogram snapshot %+v", i, got)
+		}
+		if cfg, _ := metaLoad(p, InstrumentTypeCounter, "c"); cfg.Description != "requests" {
+			t.Fatalf("provider %d: options not forwarded, got %+v", i, cfg)
+		}
+	}
+}
+
+func TestFanoutProvider_ForwardsExemplars(t *testing.T) {
+	p1, p2 := NewBasicProvider(), NewBasicProvider()
+	for _, opts := range [][]FanoutProviderOption{nil, {WithFanoutPanicIsolation()}} {
+		f := NewFanoutProvider([]Provider{p1, NoopProvider{}, p2}, opts...)
+		AddWithExemplar(f.Counter("c"), 2, Exemplar{TraceID: "t1"})
+		RecordWithExemplar(f.Histogram("h", WithBuckets(1)), 0.5, Exemplar{TraceID: "t2"})
+	}
+
+	for i, p := range []*BasicProvider{p1, p2} {
+		c := p.Counter("c").(*BasicCounter)
+		if ex := c.Exemplars(); c.Snapshot() != 4 || len(ex) != 1 || ex[0].TraceID != "t1" || ex[0].Value != 2 {
+			t.Fatalf("provider %d: unexpected counter %d with exemplars %+v", i, c.Snapshot(), ex)
+		}
+		s := p.Histogram("h").(*BasicHistogram).Snapshot()
+		if ex := s.Buckets[0].Exemplars; s.Count != 2 || len(ex) != 1 || ex[0].TraceID != "t2" || ex[0].Value != 0.5 {
+			t.Fatalf("provider %d: unexpected histogram snapshot %+v", i, s)
+		}
+	}
+}
+
+func TestFanoutProvider_ReusesComposites(t *testing.T) {
+	f := NewFanoutProvider([]Provider{NewBasicProvider()})
+
+	if reflect.ValueOf(f.Counter("c")).Pointer() != reflect.ValueOf(f.Counter("c")).Pointer() {
+		t.Fatalf("expected same composite counter for same name")
+	}
+	if reflect.ValueOf(f.UpDownCounter("u")).Pointer() != reflect.ValueOf(f.UpDownCounter("u")).Pointer() {
+		t.Fatalf("expected same composite updown for same name")
+	}
+	if reflect.ValueOf(f.Histogram("h")).Pointer() != reflect.ValueOf(f.Histogram("h")).Pointer() {
+		t.Fatalf("expected same composite histogram for same name")
+	}
+}
+
+func TestFanoutProvider_PanicIsolation(t *testing.T) {
+	t.Run("isolated", func(t *testing.T) {
+		l := &recordingLogger{}
+		good := NewBasicProvider()
+		f := NewFanoutProvider(
+			[]Provider{panicProvider{}, good},
+			WithFanoutPanicIsolation(),
+			WithFanoutLogger(l),
+		)
+
+		f.Counter("c").Add(1)
+		f.UpDownCounter("u").Add(1)
+		f.Histogram("h").Record(1)
+
+		if got := good.Counter("c").(*BasicCounter).Snapshot(); got != 1 {
+			t.Fatalf("healthy provider counter = %d; want 1", got)
+		}
+		if got := good.UpDownCounter("u").(*BasicUpDownCounter).Snapshot(); got != 1 {
+			t.Fatalf("healthy provider updown = %d; want 1", got)
+		}
+		if got := good.Histogram("h").(*BasicHistogram).Snapshot().Count; got != 1 {
+			t.Fatalf("healthy provider histogram count = %d; want 1", got)
+		}
+		if got := l.count(); got != 3 {
+			t.Fatalf("expected 3 logged panics, got %d", got)
+		}
+	})
+
+	t.Run("not_isolated", func(t *testing.T) {
+		f := NewFanoutProvider([]Provider{panicProvider{}, NewBasicProvider()})
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expected panic to propagate without isolation")
+			}
+		}()
+		f.Counter("c").Add(1)
+	})
+}
+
+func TestFanoutProvider_InspectorDelegatesToPrimary(t *testing.T) {
+	p1, p2 := NewBasicProvider(), NewBasicProvider()
+	p2.Counter("only_in_second", WithUnit("1"))
+	f := NewFanoutProvider([]Provider{p1, p2}, WithFanoutPrimary(1))
+
+	if f.Primary() != Provider(p2) {
+		t.Fatalf("expected second provider to be primary")
+	}
+
+	f.Counter("c").Add(4)
+	f.UpDownCounter("u", WithDescription("ud")).Add(2)
+	f.Histogram("h").Record(2)
+
+	inst, _, ok := f.CounterWithMeta("c")
+	if !ok {
+		t.Fatalf("expected counter found via primary")
+	}
+	if got := inst.(*BasicCounter).Snapshot(); got != 4 {
+		t.Fatalf("primary counter = %d; want 4", got)
+	}
+	if _, cfg, ok := f.UpDownCounterWithMeta("u"); !ok || cfg.Description != "ud" {
+		t.Fatalf("unexpected updown meta: ok=%v cfg=%+v", ok, cfg)
+	}
+	if _, _, ok := f.HistogramWithMeta("h"); !ok {
+		t.Fatalf("expected histogram found via primary")
+	}
+	if got := len(f.ListMetadata()); got != 4 {
+		t.Fatalf("expected 4 metadata entries from primary, got %d", got)
+	}
+
+	// out of range index falls back to the first provider
+	f2 := NewFanoutProvider([]Provider{p1, p2}, WithFanoutPrimary(5))
+	if _, _, ok := f2.CounterWithMeta("only_in_second"); ok {
+		t.Fatalf("expected first provider to be primary for out of range index")
+	}
+}
+
+func TestFanoutProvider_NonInspectorPrimary(t *testing.T) {
+	f := NewFanoutProvider([]Provider{NewNoopProvider(), NewBasicProvider()})
+	f.Counter("c").Add(1)
+
+	if inst, _, ok := f.CounterWithMeta("c"); ok || inst != nil {
+		t.Fatalf("expected not found when primary is not an Inspector")
+	}
+	if _, _, ok := f.UpDownCounterWithMeta("c"); ok {
+		t.Fatalf("expected not found when primary is not an Inspector")
+	}
+	if _, _, ok := f.HistogramWithMeta("c"); ok {
+		t.Fatalf("expected not found when primary is not an Inspector")
+	}
+	if entries := f.ListMetadata(); entries == nil || len(entries) != 0 {
+		t.Fatalf("expected empty non-nil metadata, got %v", entries)
+	}
+
+	empty := NewFanoutProvider(nil)
+	empty.Counter("c").Add(1) // must not panic
+	if empty.Primary() != nil {
+		t.Fatalf("expected nil primary for empty fanout")
+	}
+}
@@ -0,0 +1,411 @@
+// Package gateway provides a push target for metrics of short-lived jobs, such as cron jobs
+// and CI steps, which do not live long enough to be scraped.
+//
+// Jobs push snapshots of their providers to a Gateway over HTTP, with a Pusher or any HTTP
+// client. The gateway keeps the latest snapshot of every group, identified by job and
+// instance, and merges it into its provider, the group labels added as attributes, so that
+// the pushed instruments are exposed with its own ones by any exporter of the provider:
+//
+//	gw := gateway.New(gateway.WithExpiry(time.Hour))
+//	http.ListenAndServe(":9091", gw)
+//
+//	// in the job
+//	p := metrics.NewBasicProvider()
+//	...
+//	err := gateway.NewPusher("http://gateway:9091", "nightly-backup", p).Push(ctx)
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/internal/logging"
+)
+
+// Attributes identifying the group of pushed instruments in the exposed snapshot.
+const (
+	JobAttribute      = "job"
+	InstanceAttribute = "instance"
+)
+
+// defaultMaxBodySize is the maximum size of a pushed snapshot unless configured.
+const defaultMaxBodySize = 16 << 20
+
+type (
+	logger     = logging.Logger
+	noopLogger = logging.Noop
+)
+
+type config struct {
+	// provider of the gateway's own instruments, exposed with the groups. Default: a new one.
+	provider *metrics.BasicProvider
+	// time after the last push at which a group is dropped; zero means never. Default: 0.
+	expiry time.Duration
+	// maximum size of a pushed snapshot in bytes. Default: defaultMaxBodySize.
+	maxBodySize int64
+	logger      logger
+}
+
+// Option configures a Gateway constructed by New.
+type Option func(*config)
+
+// WithProvider sets the provider whose instruments the gateway exposes along with the pushed
+// ones, and which records the gateway's own instruments. Its clock (see metrics.WithClock)
+// timestamps pushes. A nil provider is ignored.
+func WithProvider(p *metrics.BasicProvider) Option {
+	return func(cfg *config) {
+		if p != nil {
+			cfg.provider = p
+		}
+	}
+}
+
+// WithExpiry drops groups that were not pushed for d. Non-positive values keep groups until
+// they are deleted.
+func WithExpiry(d time.Duration) Option {
+	return func(cfg *config) { cfg.expiry = max(d, 0) }
+}
+
+// WithMaxBodySize sets the maximum size in bytes of a pushed snapshot; larger pushes are
+// rejected. Non-positive values are ignored.
+func WithMaxBodySize(n int64) Option {
+	return func(cfg *config) {
+		if n > 0 {
+			cfg.maxBodySize = n
+		}
+	}
+}
+
+// WithLogger sets the logger used to report rejected pushes and metadata conflicts.
+func WithLogger(l logger) Option {
+	return func(cfg *config) { cfg.logger = l }
+}
+
+// GroupKey identifies a group of pushed instruments. Instance may be empty.
+type GroupKey struct {
+	Job      string
+	Instance string
+}
+
+// Group is the latest state pushed for a group.
+type Group struct {
+	GroupKey
+	// LastPush is the time of the latest push, as measured by the gateway's clock.
+	LastPush time.Time
+	Snapshot metrics.ProviderSnapshot
+}
+
+// Gateway is an http.Handler accepting pushed snapshots and exposing them in the OpenMetrics
+// format. It serves:
+//
+//	PUT    /metrics/job/{job}[/instance/{instance}]  replace the snapshot of the group
+//	POST   /metrics/job/{job}[/instance/{instance}]  merge the snapshot into the group
+//	DELETE /metrics/job/{job}[/instance/{instance}]  delete the group
+//	GET    /metrics                                  expose all groups
+//
+// Pushed bodies are snapshots encoded by metrics.EncodeSnapshot (metrics.SnapshotContentType,
+// the default) or metrics.EncodeSnapshotBinary (metrics.SnapshotBinaryContentType). POST
+// sums the pushed values into those of the group (see metrics.MergeSnapshots), so that steps
+// of a job can report their own counts.
+//
+// The instruments of every group are kept in the gateway's provider (see Provider) as the
+// series of the group (see metrics.SeriesName): pushes replace them and deletions and
+// expiry remove them. Rolling-window histograms are kept as plain histograms of the window
+// pushed last. Gateway also implements Snapshot, so its state can be used with any
+// snapshot consumer.
+type Gateway struct {
+	cfg config
+	mux *http.ServeMux
+
+	pushes   metrics.Counter
+	rejected metrics.Counter
+	expired  metrics.Counter
+	groupsN  metrics.UpDownCounter
+
+	mu     sync.Mutex
+	groups map[GroupKey]*Group
+}
+
+// New constructs a Gateway.
+func New(opts ...Option) *Gateway {
+	cfg := config{maxBodySize: defaultMaxBodySize}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	if cfg.provider == nil {
+		cfg.provider = metrics.NewBasicProvider()
+	}
+	if cfg.logger == nil {
+		cfg.logger = noopLogger{}
+	}
+	p := cfg.provider
+	g := &Gateway{
+		cfg:      cfg,
+		mux:      http.NewServeMux(),
+		pushes:   p.Counter("gateway_pushes_total", metrics.WithDescription("Accepted snapshot pushes.")),
+		rejected: p.Counter("gateway_rejected_pushes_total", metrics.WithDescription("Rejected snapshot pushes.")),
+		expired:  p.Counter("gateway_expired_groups_total", metrics.WithDescription("Groups dropped after their expiry.")),
+		groupsN:  p.UpDownCounter("gateway_groups", metrics.WithDescription("Groups currently held.")),
+		groups:   make(map[GroupKey]*Group),
+	}
+	for _, pattern := range []string{"/metrics/job/{job}", "/metrics/job/{job}/instance/{instance}"} {
+		g.mux.HandleFunc("PUT "+pattern, g.handlePush(true))
+		g.mux.HandleFunc("POST "+pattern, g.handlePush(false))
+		g.mux.HandleFunc("DELETE "+pattern, g.handleDelete)
+	}
+	g.mux.HandleFunc("GET /metrics", g.handleExpose)
+	return g
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) { g.mux.ServeHTTP(w, r) }
+
+// Provider returns the provider of the gateway's own instruments and of the pushed ones.
+// Expired groups are removed from it on pushes, on reads through the gateway and, if Run is
+// running, at every expiry interval.
+func (g *Gateway) Provider() *metrics.BasicProvider { return g.cfg.provider }
+
+// Run drops expired groups every expiry (see WithExpiry) until ctx is done, so that readers
+// of Provider do not see them after their expiry. Without expiry it only waits for ctx.
+func (g *Gateway) Run(ctx context.Context) {
+	if g.cfg.expiry == 0 {
+		<-ctx.Done()
+		return
+	}
+	t := g.cfg.provider.Clock().NewTicker(g.cfg.expiry)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			now := g.cfg.provider.Clock().Now()
+			g.mu.Lock()
+			g.expire(now)
+			g.mu.Unlock()
+		}
+	}
+}
+
+func groupKey(r *http.Request) GroupKey {
+	return GroupKey{Job: r.PathValue("job"), Instance: r.PathValue("instance")}
+}
+
+func (g *Gateway) handlePush(replace bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := groupKey(r)
+		snap, status, err := g.decode(w, r)
+		if err != nil {
+			g.rejected.Add(1)
+			g.cfg.logger.Warnf("[gateway] rejected push for %s: %v", key, err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if replace {
+			g.Push(key, snap)
+		} else {
+			g.Add(key, snap)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decode reads the pushed snapshot of r. On error it also returns the HTTP status to reply with.
+func (g *Gateway) decode(w http.ResponseWriter, r *http.Request) (metrics.ProviderSnapshot, int, error) {
+	decode := metrics.DecodeSnapshot
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		switch {
+		case err != nil:
+			return metrics.ProviderSnapshot{}, http.StatusUnsupportedMediaType, fmt.Errorf("content type %q: %w", ct, err)
+		case mt == metrics.SnapshotBinaryContentType:
+			decode = metrics.DecodeSnapshotBinary
+		case mt != metrics.SnapshotContentType:
+			return metrics.ProviderSnapshot{}, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", ct)
+		}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.cfg.maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return metrics.ProviderSnapshot{}, http.StatusRequestEntityTooLarge, err
+		}
+		return metrics.ProviderSnapshot{}, http.StatusBadRequest, err
+	}
+	snap, err := decode(bytes.NewReader(body))
+	if err != nil {
+		return metrics.ProviderSnapshot{}, http.StatusBadRequest, err
+	}
+	return snap, 0, nil
+}
+
+func (g *Gateway) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if !g.Delete(groupKey(r)) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) handleExpose(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", metrics.OpenMetricsContentType)
+	if err := metrics.WriteOpenMetrics(w, g.Snapshot()); err != nil {
+		g.cfg.logger.Errorf("[gateway] writing metrics: %v", err)
+	}
+}
+
+// Push replaces the snapshot of the group key.
+func (g *Gateway) Push(key GroupKey, snap metrics.ProviderSnapshot) {
+	g.store(key, func(*Group) metrics.ProviderSnapshot { return snap })
+}
+
+// Add merges snap into the snapshot of the group key, summing values (see
+// metrics.MergeSnapshots), or stores it if the group does not exist.
+func (g *Gateway) Add(key GroupKey, snap metrics.ProviderSnapshot) {
+	g.store(key, func(old *Group) metrics.ProviderSnapshot {
+		if old == nil {
+			return snap
+		}
+		merged, conflicts := metrics.MergeSnapshots([]metrics.ProviderSnapshot{old.Snapshot, snap})
+		for _, c := range conflicts {
+			g.cfg.logger.Warnf("[gateway] push for %s: %s", key, c)
+		}
+		return merged
+	})
+}
+
+func (g *Gateway) store(key GroupKey, snapshot func(old *Group) metrics.ProviderSnapshot) {
+	now := g.cfg.provider.Clock().Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expire(now)
+	old := g.groups[key]
+	if old == nil {
+		g.groupsN.Add(1)
+	} else {
+		g.unmerge(*old)
+	}
+	grp := &Group{GroupKey: key, LastPush: now, Snapshot: snapshot(old)}
+	g.groups[key] = grp
+	g.cfg.provider.Restore(series(*grp))
+	g.pushes.Add(1)
+}
+
+// unmerge removes the instruments of grp from the provider. g.mu must be held.
+func (g *Gateway) unmerge(grp Group) {
+	for _, s := range labeled(grp).Instruments {
+		g.cfg.provider.RemoveInstrument(s.Type, s.Name)
+	}
+}
+
+// Delete deletes the group key. It reports whether the group existed.
+func (g *Gateway) Delete(key GroupKey) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	grp, ok := g.groups[key]
+	if !ok {
+		return false
+	}
+	g.unmerge(*grp)
+	delete(g.groups, key)
+	g.groupsN.Add(-1)
+	return true
+}
+
+// expire drops the groups not pushed within the expiry. g.mu must be held.
+func (g *Gateway) expire(now time.Time) {
+	if g.cfg.expiry == 0 {
+		return
+	}
+	for key, grp := range g.groups {
+		if now.Sub(grp.LastPush) >= g.cfg.expiry {
+			g.unmerge(*grp)
+			delete(g.groups, key)
+			g.groupsN.Add(-1)
+			g.expired.Add(1)
+		}
+	}
+}
+
+// Groups returns the current groups sorted by job and instance.
+func (g *Gateway) Groups() []Group {
+	now := g.cfg.provider.Clock().Now()
+	g.mu.Lock()
+	g.expire(now)
+	out := make([]Group, 0, len(g.groups))
+	for _, grp := range g.groups {
+		out = append(out, *grp)
+	}
+	g.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Job != out[j].Job {
+			return out[i].Job < out[j].Job
+		}
+		return out[i].Instance < out[j].Instance
+	})
+	return out
+}
+
+// Snapshot returns the instruments of the gateway's provider: its own ones and those of
+// every group, the latter with the JobAttribute and InstanceAttribute attributes of their
+// group and renamed to their series name (see metrics.SeriesName).
+func (g *Gateway) Snapshot() metrics.ProviderSnapshot {
+	now := g.cfg.provider.Clock().Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expire(now)
+	return g.cfg.provider.Snapshot()
+}
+
+// series returns the instruments of grp as merged into the provider: labeled, without
+// windows, which would drop the pushed measurements, and not durable, since the gateway
+// replaces them on every push.
+func series(grp Group) metrics.ProviderSnapshot {
+	out := labeled(grp)
+	for i := range out.Instruments {
+		cfg := &out.Instruments[i].Config
+		cfg.Window, cfg.Durable = nil, false
+	}
+	return out
+}
+
+// labeled returns the snapshot of grp with the group attributes added to its instruments.
+func labeled(grp Group) metrics.ProviderSnapshot {
+	labels := map[string]string{JobAttribute: grp.Job}
+	if grp.Instance != "" {
+		labels[InstanceAttribute] = grp.Instance
+	}
+	out := metrics.ProviderSnapshot{Time: grp.Snapshot.Time, Instruments: make([]metrics.InstrumentSnapshot, len(grp.Snapshot.Instruments))}
+	for i, s := range grp.Snapshot.Instruments {
+		attrs := make(map[string]string, len(s.Config.Attributes)+len(labels))
+		for k, v := range s.Config.Attributes {
+			attrs[k] = v
+		}
+		for k, v := range labels {
+			attrs[k] = v
+		}
+		s.Config.Attributes = attrs
+		s.Name = metrics.SeriesName(s.Name, labels)
+		out.Instruments[i] = s
+	}
+	return out
+}
+
+// String returns the group key in the job/instance form.
+func (k GroupKey) String() string {
+	if k.Instance == "" {
+		return k.Job
+	}
+	return k.Job + "/" + k.Instance
+}
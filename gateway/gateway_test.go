@@ -0,0 +1,267 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/metricstest"
+)
+
+func encodeJSON(t *testing.T, p *metrics.BasicProvider) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := metrics.EncodeSnapshot(&buf, p.Snapshot()); err != nil {
+		t.Fatalf("EncodeSnapshot: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeBinary(t *testing.T, p *metrics.BasicProvider) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := metrics.EncodeSnapshotBinary(&buf, p.Snapshot()); err != nil {
+		t.Fatalf("EncodeSnapshotBinary: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func do(t *testing.T, h http.Handler, method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGateway_PushAndExpose(t *testing.T) {
+	gw := New()
+	job := metrics.NewBasicProvider()
+	job.Counter("rows_total", metrics.WithAttributes(map[string]string{"table": "users"})).Add(10)
+	job.Histogram("step_seconds", metrics.WithBuckets(1, 10)).Record(2)
+
+	if rec := do(t, gw, http.MethodPut, "/metrics/job/backup/instance/db1", metrics.SnapshotContentType, encodeJSON(t, job)); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT: status %d: %s", rec.Code, rec.Body)
+	}
+	// the binary encoding and the job-only group
+	if rec := do(t, gw, http.MethodPut, "/metrics/job/backup", metrics.SnapshotBinaryContentType, encodeBinary(t, job)); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT binary: status %d: %s", rec.Code, rec.Body)
+	}
+
+	metricstest.AssertCounter(t, gw, `rows_total{instance="db1",job="backup"}`, 10)
+	metricstest.AssertCounter(t, gw, `rows_total{job="backup"}`, 10)
+	metricstest.AssertCounter(t, gw, "gateway_pushes_total", 2)
+	metricstest.AssertUpDownCounter(t, gw, "gateway_groups", 2)
+
+	rec := do(t, gw, http.MethodGet, "/metrics", "", nil)
+	if ct := rec.Header().Get("Content-Type"); ct != metrics.OpenMetricsContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE rows counter\n",
+		`rows_total{instance="db1",job="backup",table="users"} 10`,
+		`rows_total{job="backup",table="users"} 10`,
+		`step_seconds_bucket{instance="db1",job="backup",le="10.0"} 1`,
+		"gateway_pushes_total 2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "# TYPE rows counter") != 1 {
+		t.Fatalf("expected a single family for the groups:\n%s", out)
+	}
+
+	groups := gw.Groups()
+	if len(groups) != 2 || groups[0].String() != "backup" || groups[1].String() != "backup/db1" {
+		t.Fatalf("unexpected groups %+v", groups)
+	}
+}
+
+func TestGateway_PutReplacesPostAdds(t *testing.T) {
+	gw := New()
+	step := metrics.NewBasicProvider()
+	step.Counter("tests_total").Add(3)
+	body := encodeJSON(t, step)
+
+	do(t, gw, http.MethodPost, "/metrics/job/ci", metrics.SnapshotContentType, body)
+	do(t, gw, http.MethodPost, "/metrics/job/ci", "", body)
+	metricstest.AssertCounter(t, gw, `tests_total{job="ci"}`, 6)
+
+	do(t, gw, http.MethodPut, "/metrics/job/ci", metrics.SnapshotContentType, body)
+	metricstest.AssertCounter(t, gw, `tests_total{job="ci"}`, 3)
+}
+
+func TestGateway_Delete(t *testing.T) {
+	gw := New()
+	p := metrics.NewBasicProvider()
+	p.Counter("c").Add(1)
+	do(t, gw, http.MethodPut, "/metrics/job/j/instance/i", metrics.SnapshotContentType, encodeJSON(t, p))
+
+	if rec := do(t, gw, http.MethodDelete, "/metrics/job/j/instance/i", "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d", rec.Code)
+	}
+	if rec := do(t, gw, http.MethodDelete, "/metrics/job/j/instance/i", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("second DELETE: status %d", rec.Code)
+	}
+	metricstest.AssertNoInstrument(t, gw, metrics.InstrumentTypeCounter, `c{instance="i",job="j"}`)
+	metricstest.AssertUpDownCounter(t, gw, "gateway_groups", 0)
+}
+
+func TestGateway_RejectsInvalidPushes(t *testing.T) {
+	gw := New(WithMaxBodySize(64))
+	big := metrics.NewBasicProvider()
+	big.Counter(strings.Repeat("x", 100)).Add(1)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        int
+	}{
+		{"unsupported content type", "text/plain", []byte("c 1"), http.StatusUnsupportedMediaType},
+		{"malformed content type", "application/", nil, http.StatusUnsupportedMediaType},
+		{"corrupt body", metrics.SnapshotContentType, []byte("{"), http.StatusBadRequest},
+		{"binary as JSON", metrics.SnapshotContentType, []byte("MSNP\x01\x00\x00\x00\x00"), http.StatusBadRequest},
+		{"JSON as binary", metrics.SnapshotBinaryContentType, []byte("{}"), http.StatusBadRequest},
+		{"too large", metrics.SnapshotContentType, encodeJSON(t, big), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(t, gw, http.MethodPut, "/metrics/job/j", tt.contentType, tt.body); rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+	metricstest.AssertCounter(t, gw, "gateway_rejected_pushes_total", int64(len(tests)))
+	if len(gw.Groups()) != 0 {
+		t.Fatalf("expected no groups, got %+v", gw.Groups())
+	}
+	if rec := do(t, gw, http.MethodGet, "/metrics/job/j", "", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET on a group to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestGateway_Expiry(t *testing.T) {
+	clk := metricstest.NewFakeClock(time.Time{})
+	gw := New(WithProvider(metrics.NewBasicProvider(metrics.WithClock(clk))), WithExpiry(time.Minute))
+	p := metrics.NewBasicProvider()
+	p.Counter("c").Add(1)
+
+	gw.Push(GroupKey{Job: "old"}, p.Snapshot())
+	clk.Advance(30 * time.Second)
+	gw.Push(GroupKey{Job: "new"}, p.Snapshot())
+	clk.Advance(30 * time.Second)
+
+	groups := gw.Groups()
+	if len(groups) != 1 || groups[0].Job != "new" || !groups[0].LastPush.Equal(clk.Now().Add(-30*time.Second)) {
+		t.Fatalf("expected only the recent group, got %+v", groups)
+	}
+	metricstest.AssertCounter(t, gw, "gateway_expired_groups_total", 1)
+	metricstest.AssertUpDownCounter(t, gw, "gateway_groups", 1)
+}
+
+func TestGateway_MergesIntoProvider(t *testing.T) {
+	clk := metricstest.NewFakeClock(time.Time{})
+	gw := New(WithProvider(metrics.NewBasicProvider(metrics.WithClock(clk))), WithExpiry(time.Minute))
+	job := metrics.NewBasicProvider(metrics.WithClock(clk))
+	job.Counter("rows_total").Add(3)
+	job.Counter("dropped_total").Add(1)
+	job.Histogram("lat", metrics.WithBuckets(1),
+		metrics.WithRollingWindow(metrics.HistogramWindow{Duration: time.Minute})).Record(0.5)
+	gw.Push(GroupKey{Job: "j"}, job.Snapshot())
+
+	// readers of the provider, such as the dashboard, see the pushed series
+	p := gw.Provider()
+	names := make(map[string]bool)
+	for _, e := range p.ListMetadata() {
+		names[e.Name] = true
+	}
+	if !names[`rows_total{job="j"}`] || !names[`dropped_total{job="j"}`] {
+		t.Fatalf("expected the pushed series in the provider, got %v", names)
+	}
+	metricstest.AssertCounter(t, p, `rows_total{job="j"}`, 3)
+	metricstest.AssertHistogramCount(t, p, `lat{job="j"}`, 1)
+
+	// a push replaces the series of the group
+	next := metrics.NewBasicProvider()
+	next.Counter("rows_total").Add(5)
+	gw.Push(GroupKey{Job: "j"}, next.Snapshot())
+	metricstest.AssertCounter(t, p, `rows_total{job="j"}`, 5)
+	metricstest.AssertNoInstrument(t, p, metrics.InstrumentTypeCounter, `dropped_total{job="j"}`)
+	metricstest.AssertNoInstrument(t, p, metrics.InstrumentTypeHistogram, `lat{job="j"}`)
+
+	// Run removes expired groups without reads through the gateway
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gw.Run(ctx)
+	}()
+	for clk.Waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := p.Snapshot().Get(metrics.InstrumentTypeCounter, `rows_total{job="j"}`); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the expired group to be removed from the provider")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	metricstest.AssertCounter(t, p, "gateway_expired_groups_total", 1)
+}
+
+func TestGateway_ReportsConflicts(t *testing.T) {
+	l := &recordingLogger{}
+	gw := New(WithLogger(l))
+	a, b := metrics.NewBasicProvider(), metrics.NewBasicProvider()
+	a.Counter("c", metrics.WithUnit("1")).Add(1)
+	b.Counter("c", metrics.WithUnit("By")).Add(1)
+	gw.Add(GroupKey{Job: "j"}, a.Snapshot())
+	gw.Add(GroupKey{Job: "j"}, b.Snapshot())
+
+	metricstest.AssertCounter(t, gw, `c{job="j"}`, 2)
+	if msgs := l.messages(); len(msgs) != 1 || !strings.Contains(msgs[0], "unit") {
+		t.Fatalf("expected the unit conflict to be logged, got %v", msgs)
+	}
+}
+
+// recordingLogger collects formatted warnings and errors; safe for concurrent use.
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordingLogger) Debugf(string, ...interface{})             {}
+func (l *recordingLogger) Infof(string, ...interface{})              {}
+func (l *recordingLogger) Warnf(format string, args ...interface{})  { l.record(format, args...) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.record(format, args...) }
+
+func (l *recordingLogger) record(format string, args ...interface{}) {
+	l.mu.Lock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.msgs...)
+}
@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// Snapshotter is implemented by providers able to snapshot their state, such as
+// metrics.BasicProvider.
+type Snapshotter interface {
+	Snapshot() metrics.ProviderSnapshot
+}
+
+// defaultPushInterval is the interval between pushes of Pusher.Run unless configured.
+const defaultPushInterval = 15 * time.Second
+
+type pusherConfig struct {
+	// instance label of the group; empty for none. Default: "".
+	instance string
+	// client sending the requests. Default: http.DefaultClient.
+	client *http.Client
+	// whether snapshots are encoded with metrics.EncodeSnapshotBinary. Default: false.
+	binary bool
+	// interval between pushes of Run. Default: defaultPushInterval.
+	interval time.Duration
+	// maximum duration of the final push of Run. Default: the push interval.
+	finalTimeout time.Duration
+	// source of time of the Run ticker. Default: the clock of the source, or SystemClock.
+	clock  metrics.Clock
+	logger logger
+}
+
+// PusherOption configures a Pusher constructed by NewPusher.
+type PusherOption func(*pusherConfig)
+
+// WithInstance sets the instance of the pushed group.
+func WithInstance(instance string) PusherOption {
+	return func(cfg *pusherConfig) { cfg.instance = instance }
+}
+
+// WithHTTPClient sets the client sending the requests. A nil client is ignored.
+func WithHTTPClient(c *http.Client) PusherOption {
+	return func(cfg *pusherConfig) {
+		if c != nil {
+			cfg.client = c
+		}
+	}
+}
+
+// WithBinaryEncoding pushes snapshots in the compact binary encoding
+// (metrics.SnapshotBinaryContentType) instead of JSON.
+func WithBinaryEncoding() PusherOption {
+	return func(cfg *pusherConfig) { cfg.binary = true }
+}
+
+// WithPushInterval sets the interval between pushes of Run. Non-positive values are ignored.
+func WithPushInterval(d time.Duration) PusherOption {
+	return func(cfg *pusherConfig) {
+		if d > 0 {
+			cfg.interval = d
+		}
+	}
+}
+
+// WithFinalPushTimeout bounds the duration of the final push of Run, which no longer obeys
+// the context of Run. The default is the push interval. Non-positive values are ignored.
+func WithFinalPushTimeout(d time.Duration) PusherOption {
+	return func(cfg *pusherConfig) {
+		if d > 0 {
+			cfg.finalTimeout = d
+		}
+	}
+}
+
+// WithPusherClock sets the clock driving the pushes of Run. The default is the clock of the
+// source if it has one (such as metrics.BasicProvider), SystemClock otherwise.
+// A nil clock is ignored.
+func WithPusherClock(c metrics.Clock) PusherOption {
+	return func(cfg *pusherConfig) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// WithPusherLogger sets the logger used to report failed periodic pushes of Run.
+func WithPusherLogger(l logger) PusherOption {
+	return func(cfg *pusherConfig) { cfg.logger = l }
+}
+
+// Pusher pushes snapshots of a source to a Gateway.
+type Pusher struct {
+	url string
+	src Snapshotter
+	cfg pusherConfig
+}
+
+// NewPusher returns a Pusher of the snapshots of src to the group job (and the instance set
+// by WithInstance) of the gateway at baseURL, e.g. "http://gateway:9091".
+func NewPusher(baseURL, job string, src Snapshotter, opts ...PusherOption) *Pusher {
+	cfg := pusherConfig{client: http.DefaultClient, interval: defaultPushInterval}
+	if cs, ok := src.(interface{ Clock() metrics.Clock }); ok {
+		cfg.clock = cs.Clock()
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	if cfg.clock == nil {
+		cfg.clock = metrics.SystemClock()
+	}
+	if cfg.logger == nil {
+		cfg.logger = noopLogger{}
+	}
+	if cfg.finalTimeout == 0 {
+		cfg.finalTimeout = cfg.interval
+	}
+	u := strings.TrimSuffix(baseURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	if cfg.instance != "" {
+		u += "/instance/" + url.PathEscape(cfg.instance)
+	}
+	return &Pusher{url: u, src: src, cfg: cfg}
+}
+
+// Push replaces the group with the current snapshot of the source.
+func (p *Pusher) Push(ctx context.Context) error { return p.send(ctx, http.MethodPut, true) }
+
+// Add merges the current snapshot of the source into the group, summing values.
+func (p *Pusher) Add(ctx context.Context) error { return p.send(ctx, http.MethodPost, true) }
+
+// Delete deletes the group.
+func (p *Pusher) Delete(ctx context.Context) error { return p.send(ctx, http.MethodDelete, false) }
+
+// Run pushes the snapshot of the source at every push interval until ctx is done, and then a
+// last time, within the final push timeout (see WithFinalPushTimeout). Failed periodic pushes
+// are logged and retried at the next interval; Run returns the error of the last push.
+func (p *Pusher) Run(ctx context.Context) error {
+	t := p.cfg.clock.NewTicker(p.cfg.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.cfg.finalTimeout)
+			defer cancel()
+			return p.Push(ctx)
+		case <-t.C():
+			if err := p.Push(ctx); err != nil {
+				p.cfg.logger.Errorf("[gateway] %v", err)
+			}
+		}
+	}
+}
+
+func (p *Pusher) send(ctx context.Context, method string, withBody bool) error {
+	var body io.Reader
+	contentType := metrics.SnapshotContentType
+	if withBody {
+		var buf bytes.Buffer
+		encode := metrics.EncodeSnapshot
+		if p.cfg.binary {
+			encode, contentType = metrics.EncodeSnapshotBinary, metrics.SnapshotBinaryContentType
+		}
+		if err := encode(&buf, p.src.Snapshot()); err != nil {
+			return err
+		}
+		body = &buf
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.url, body)
+	if err != nil {
+		return fmt.Errorf("gateway: %s %s: %w", method, p.url, err)
+	}
+	if withBody {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := p.cfg.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gateway: %s %s: %w", method, p.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("gateway: %s %s: %s: %s", method, p.url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
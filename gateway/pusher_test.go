@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/metricstest"
+)
+
+func TestPusher_PushAddDelete(t *testing.T) {
+	gw := New()
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	src := metrics.NewBasicProvider()
+	src.Counter("jobs_total").Add(2)
+	for _, opts := range [][]PusherOption{nil, {WithBinaryEncoding()}} {
+		ps := NewPusher(srv.URL+"/", "batch", src, append(opts, WithInstance("a/b"))...)
+		ctx := context.Background()
+		if err := ps.Push(ctx); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+		if err := ps.Add(ctx); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		metricstest.AssertCounter(t, gw, `jobs_total{instance="a/b",job="batch"}`, 4)
+
+		if err := ps.Delete(ctx); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if len(gw.Groups()) != 0 {
+			t.Fatalf("expected the group to be deleted, got %+v", gw.Groups())
+		}
+	}
+}
+
+func TestPusher_ReportsErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(New())
+	defer srv.Close()
+
+	err := NewPusher(srv.URL, "missing", metrics.NewBasicProvider()).Delete(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "/metrics/job/missing") {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewPusher(srv.URL, "j", metrics.NewBasicProvider()).Push(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation error, got %v", err)
+	}
+}
+
+func TestPusher_Run(t *testing.T) {
+	gw := New()
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	clk := metricstest.NewFakeClock(time.Time{})
+	src := metrics.NewBasicProvider(metrics.WithClock(clk))
+	c := src.Counter("ticks_total")
+	ps := NewPusher(srv.URL, "cron", src, WithPushInterval(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ps.Run(ctx) }()
+	for clk.Waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Add(1)
+	clk.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(gw.Groups()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a push after the interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	metricstest.AssertCounter(t, gw, `ticks_total{job="cron"}`, 1)
+
+	// the final push on shutdown
+	c.Add(1)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	metricstest.AssertCounter(t, gw, `ticks_total{job="cron"}`, 2)
+}
+
+func TestPusher_RunLogsFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	clk := metricstest.NewFakeClock(time.Time{})
+	l := &recordingLogger{}
+	ps := NewPusher(srv.URL, "j", metrics.NewBasicProvider(), WithPusherClock(clk), WithPusherLogger(l))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ps.Run(ctx) }()
+	for clk.Waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(defaultPushInterval)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(l.messages()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed push to be logged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if msg := l.messages()[0]; !strings.Contains(msg, "503") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+
+	cancel()
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Fatalf("expected the error of the final push, got %v", err)
+	}
+}
+
+func TestPusher_RunBoundsFinalPush(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	clk := metricstest.NewFakeClock(time.Time{})
+	ps := NewPusher(srv.URL, "j", metrics.NewBasicProvider(), WithPusherClock(clk),
+		WithFinalPushTimeout(50*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ps.Run(ctx) }()
+	for clk.Waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the final push to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to return once the final push timed out")
+	}
+}
@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// global holds the process-wide provider installed via SetGlobalProvider.
+var global = newGlobalState()
+
+// globalState tracks the installed provider and the delegating instruments handed out
+// before any provider was installed.
+type globalState struct {
+	// installed provider; nil until the first SetGlobalProvider call.
+	provider atomic.Pointer[providerHolder]
+
+	// mu serializes installation with creation of delegating instruments, so that
+	// no instrument is created unbound after a provider has been installed.
+	mu         sync.Mutex
+	delegating *delegatingProvider
+}
+
+// providerHolder boxes a Provider so it can be stored in an atomic.Pointer.
+type providerHolder struct {
+	p Provider
+}
+
+func newGlobalState() *globalState {
+	g := &globalState{}
+	g.delegating = &delegatingProvider{
+		g:          g,
+		counters:   make(map[string]*delegatingCounter),
+		updowns:    make(map[string]*delegatingUpDownCounter),
+		histograms: make(map[string]*delegatingHistogram),
+	}
+	return g
+}
+
+// GlobalProvider returns the process-wide Provider.
+//
+// Until SetGlobalProvider is called it returns a delegating provider whose instruments discard
+// measurements, like NoopProvider, and transparently switch to the installed provider once
+// SetGlobalProvider is called. Libraries can therefore obtain instruments at init time without
+// forcing callers to pass a Provider.
+func GlobalProvider() Provider {
+	if h := global.provider.Load(); h != nil {
+		return h.p
+	}
+	return global.delegating
+}
+
+// SetGlobalProvider installs p as the process-wide Provider. A nil p installs a NoopProvider.
+//
+// Instruments obtained from GlobalProvider before the first call are bound to p: from then on
+// they forward every measurement to the instrument of the same type and name created by p
+// (using the options they were obtained with). Subsequent calls only affect instruments
+// obtained afterwards; already bound instruments keep forwarding to the first provider.
+func SetGlobalProvider(p Provider) {
+	if p == nil {
+		p = NewNoopProvider()
+	}
+	global.install(p)
+}
+
+func (g *globalState) install(p Provider) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	first := g.provider.Load() == nil
+	g.provider.Store(&providerHolder{p: p})
+	if first {
+		g.delegating.bind(p)
+	}
+}
+
+// delegatingProvider hands out delegating instruments until a provider is installed.
+// All fields other than g are protected by g.mu.
+type delegatingProvider struct {
+	g          *globalState
+	counters   map[string]*delegatingCounter
+	updowns    map[string]*delegatingUpDownCounter
+	histograms map[string]*delegatingHistogram
+}
+
+// Counter returns a delegating counter, or the installed provider's counter if one is installed.
+func (d *delegatingProvider) Counter(name string, opts ...InstrumentOption) Counter {
+	d.g.mu.Lock()
+	defer d.g.mu.Unlock()
+	if h := d.g.provider.Load(); h != nil {
+		return h.p.Counter(name, opts...)
+	}
+	if c, ok := d.counters[name]; ok {
+		return c
+	}
+	c := &delegatingCounter{opts: copyOptions(opts)}
+	d.counters[name] = c
+	return c
+}
+
+// UpDownCounter returns a delegating up/down counter, or the installed provider's
+// up/down counter if one is installed.
+func (d *delegatingProvider) UpDownCounter(name string, opts ...InstrumentOption) UpDownCounter {
+	d.g.mu.Lock()
+	defer d.g.mu.Unlock()
+	if h := d.g.provider.Load(); h != nil {
+		return h.p.UpDownCounter(name, opts...)
+	}
+	if u, ok := d.updowns[name]; ok {
+		return u
+	}
+	u := &delegatingUpDownCounter{opts: copyOptions(opts)}
+	d.updowns[name] = u
+	return u
+}
+
+// Histogram returns a delegating histogram, or the installed provider's histogram if one is installed.
+func (d *delegatingProvider) Histogram(name string, opts ...InstrumentOption) Histogram {
+	d.g.mu.Lock()
+	defer d.g.mu.Unlock()
+	if h := d.g.provider.Load(); h != nil {
+		return h.p.Histogram(name, opts...)
+	}
+	if hist, ok := d.histograms[name]; ok {
+		return hist
+	}
+	hist := &delegatingHistogram{opts: copyOptions(opts)}
+	d.histograms[name] = hist
+	return hist
+}
+
+// bind switches every pending delegating instrument to p and drops the pending sets.
+// Must be called with g.mu held.
+func (d *delegatingProvider) bind(p Provider) {
+	for name, c := range d.counters {
+		inst := p.Counter(name, c.opts...)
+		c.delegate.Store(&inst)
+	}
+	for name, u := range d.updowns {
+		inst := p.UpDownCounter(name, u.opts...)
+		u.delegate.Store(&inst)
+	}
+	for name, h := range d.histograms {
+		inst := p.Histogram(name, h.opts...)
+		h.delegate.Store(&inst)
+	}
+	d.counters = nil
+	d.updowns = nil
+	d.histograms = nil
+}
+
+// copyOptions copies an options slice so later mutation by the caller does not affect binding.
+func copyOptions(opts []InstrumentOption) []InstrumentOption {
+	if len(opts) == 0 {
+		return nil
+	}
+	return append([]InstrumentOption(nil), opts...)
+}
+
+// delegatingCounter discards measurements until bound, then forwards them to its delegate.
+// The hot path is a single atomic load.
+type delegatingCounter struct {
+	opts     []InstrumentOption
+	delegate atomic.Pointer[Counter]
+}
+
+func (c *delegatingCounter) Add(n int64) {
+	if d := c.delegate.Load(); d != nil {
+		(*d).Add(n)
+	}
+}
+
+// AddContext implements ContextCounter, passing ctx to the delegate once bound.
+func (c *delegatingCounter) AddContext(ctx context.Context, n int64) {
+	if d := c.delegate.Load(); d != nil {
+		AddWithContext(ctx, *d, n)
+	}
+}
+
+type delegatingUpDownCounter struct {
+	opts     []InstrumentOption
+	delegate atomic.Pointer[UpDownCounter]
+}
+
+func (u *delegatingUpDownCounter) Add(n int64) {
+	if d := u.delegate.Load(); d != nil {
+		(*d).Add(n)
+	}
+}
+
+// AddContext implements ContextUpDownCounter, passing ctx to the delegate once bound.
+func (u *delegatingUpDownCounter) AddContext(ctx context.Context, n int64) {
+	if d := u.delegate.Load(); d != nil {
+		AddWithContext(ctx, *d, n)
+	}
+}
+
+type delegatingHistogram struct {
+	opts     []InstrumentOption
+	delegate atomic.Pointer[Histogram]
+}
+
+func (h *delegatingHistogram) Record(v float64) {
+	if d := h.delegate.Load(); d != nil {
+		(*d).Record(v)
+	}
+}
+
+// RecordContext implements ContextHistogram, passing ctx to the delegate once bound.
+func (h *delegatingHistogram) RecordContext(ctx context.Context, v float64) {
+	if d := h.delegate.Load(); d != nil {
+		RecordWithContext(ctx, *d, v)
+	}
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// resetGlobal restores the pristine global state; tests using it must not run in parallel.
+func resetGlobal(t *testing.T) {
+	t.Helper()
+	global = newGlobalState()
+	t.Cleanup(func() { global = newGlobalState() })
+}
+
+func TestGlobalProvider_DefaultDiscards(t *testing.T) {
+	resetGlobal(t)
+
+	p := GlobalProvider()
+	if _, ok := p.(*delegatingProvider); !ok {
+		t.Fatalf("expected delegating provider before installation, got %T", p)
+	}
+	// must not panic
+	p.Counter("c").Add(1)
+	p.UpDownCounter("u").Add(-1)
+	p.Histogram("h").Record(1)
+}
+
+func TestGlobalProvider_LateBinding(t *testing.T) {
+	resetGlobal(t)
+
+	c := GlobalProvider().Counter("c", WithDescription("early"))
+	u := GlobalProvider().UpDownCounter("u")
+	h := GlobalProvider().Histogram("h")
+	if reflect.ValueOf(c).Pointer() != reflect.ValueOf(GlobalProvider().Counter("c")).Pointer() {
+		t.Fatalf("expected same delegating counter for same name")
+	}
+
+	// recorded before installation: discarded
+	c.Add(100)
+
+	bp := NewBasicProvider()
+	SetGlobalProvider(bp)
+
+	if GlobalProvider() != Provider(bp) {
+		t.Fatalf("expected installed provider to be returned")
+	}
+
+	c.Add(2)
+	u.Add(3)
+	h.Record(0.5)
+
+	if got := bp.Counter("c").(*BasicCounter).Snapshot(); got != 2 {
+		t.Fatalf("counter = %d; want 2", got)
+	}
+	if got := bp.UpDownCounter("u").(*BasicUpDownCounter).Snapshot(); got != 3 {
+		t.Fatalf("updown = %d; want 3", got)
+	}
+	if got := bp.Histogram("h").(*BasicHistogram).Snapshot(); got.Count != 1 || got.Sum != 0.5 {
+		t.Fatalf("unexpected histogram snapshot %+v", got)
+	}
+	if _, cfg, _ := bp.CounterWithMeta("c"); cfg.Description != "early" {
+		t.Fatalf("expected options to be forwarded on binding, got %+v", cfg)
+	}
+}
+
+func TestGlobalProvider_HeldDelegatingProviderSwitches(t *testing.T) {
+	resetGlobal(t)
+
+	held := GlobalProvider()
+	bp := NewBasicProvider()
+	SetGlobalProvider(bp)
+
+	c := held.Counter("after")
+	if _, ok := c.(*BasicCounter); !ok {
+		t.Fatalf("expected installed provider's counter after installation, got %T", c)
+	}
+	if _, ok := held.UpDownCounter("after").(*BasicUpDownCounter); !ok {
+		t.Fatalf("expected installed provider's updown after installation")
+	}
+	if _, ok := held.Histogram("after").(*BasicHistogram); !ok {
+		t.Fatalf("expected installed provider's histogram after installation")
+	}
+}
+
+func TestSetGlobalProvider_SecondCallKeepsBoundInstruments(t *testing.T) {
+	resetGlobal(t)
+
+	c := GlobalProvider().Counter("c")
+	first, second := NewBasicProvider(), NewBasicProvider()
+	SetGlobalProvider(first)
+	SetGlobalProvider(second)
+
+	c.Add(1)
+	GlobalProvider().Counter("c").Add(1)
+
+	if got := first.Counter("c").(*BasicCounter).Snapshot(); got != 1 {
+		t.Fatalf("first provider counter = %d; want 1", got)
+	}
+	if got := second.Counter("c").(*BasicCounter).Snapshot(); got != 1 {
+		t.Fatalf("second provider counter = %d; want 1", got)
+	}
+}
+
+func TestSetGlobalProvider_Nil(t *testing.T) {
+	resetGlobal(t)
+
+	SetGlobalProvider(nil)
+	if _, ok := GlobalProvider().(NoopProvider); !ok {
+		t.Fatalf("expected NoopProvider after installing nil, got %T", GlobalProvider())
+	}
+}
+
+func TestGlobalProvider_ConcurrentInstallation(t *testing.T) {
+	resetGlobal(t)
+
+	bp := NewBasicProvider()
+	const workers = 20
+	var wg sync.WaitGroup
+	wg.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			c := GlobalProvider().Counter("c")
+			for j := 0; j < 100; j++ {
+				c.Add(1)
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		SetGlobalProvider(bp)
+	}()
+	wg.Wait()
+
+	// every counter must be bound now, whichever side won the race
+	before := bp.Counter("c").(*BasicCounter).Snapshot()
+	GlobalProvider().Counter("c").Add(1)
+	if got := bp.Counter("c").(*BasicCounter).Snapshot(); got != before+1 {
+		t.Fatalf("counter = %d; want %d", got, before+1)
+	}
+}
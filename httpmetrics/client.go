@@ -0,0 +1,267 @@
+package httpmetrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// Error types of client requests, recorded as AttrError.
+const (
+	errorDNS      = "dns"
+	errorDial     = "dial"
+	errorTLS      = "tls"
+	errorTimeout  = "timeout"
+	errorCanceled = "canceled"
+	errorOther    = "other"
+)
+
+// Transport is an http.RoundTripper recording the requests sent through the wrapped one:
+//
+//	http_client_requests_total                   counter of requests, with status "error" when they got no response
+//	http_client_errors_total                     counter of requests that got no response, by error type
+//	http_client_request_duration_seconds         histogram of the time until the response headers or the error
+//	http_client_dns_duration_seconds             histogram of the time to look up hosts
+//	http_client_connect_duration_seconds         histogram of the time to establish connections
+//	http_client_tls_handshake_duration_seconds   histogram of the time of TLS handshakes
+//	http_client_first_byte_duration_seconds      histogram of the time until the first response byte
+//
+// Requests are told apart by host only with a HostFunc (see WithHostFunc). The phase
+// histograms, recorded through httptrace hooks added to the request context, are per route
+// and host only. Requests reusing an idle connection record no DNS, connect or TLS phases.
+// Error types are "timeout" for deadlines and timeouts, "dns", "dial" or "tls" for failures
+// of these phases, "canceled" for canceled requests and "other" otherwise.
+//
+// Transport is safe for concurrent use.
+type Transport struct {
+	next http.RoundTripper
+	p    metrics.Provider
+	cfg  config
+
+	// seriesKey -> *clientSeries
+	series sync.Map
+	// seriesKey -> metrics.Counter
+	errors sync.Map
+	// seriesKey of the host -> *phaseSeries
+	phases sync.Map
+}
+
+// clientSeries holds the instruments of a series of sent requests.
+type clientSeries struct {
+	requests metrics.Counter
+	duration metrics.Histogram
+}
+
+// phaseSeries holds the phase histograms of a host.
+type phaseSeries struct {
+	dns, connect, tls, firstByte metrics.Histogram
+}
+
+// NewTransport returns a Transport recording on p the requests sent through next, or
+// http.DefaultTransport if nil.
+func NewTransport(p metrics.Provider, next http.RoundTripper, opts ...Option) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{next: next, p: p, cfg: newConfig(p, opts)}
+}
+
+// RoundTrip sends r through the wrapped RoundTripper and records it.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	key := seriesKey{route: t.cfg.routeOf(r), host: t.cfg.hostOf(r), method: method(r.Method)}
+	phases := t.phasesOf(seriesKey{route: key.route, host: key.host})
+	tr := &requestTrace{clock: t.cfg.clock, phases: phases, start: t.cfg.clock.Now()}
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), tr.clientTrace()))
+
+	resp, err := t.next.RoundTrip(r)
+	elapsed := t.cfg.clock.Now().Sub(tr.start).Seconds()
+	if err != nil {
+		key.status = "error"
+		errKey := key
+		errKey.status, errKey.err = "", tr.errorType(err)
+		t.errorCounter(errKey).Add(1)
+	} else {
+		key.status = statusClass(resp.StatusCode)
+	}
+	s := t.seriesOf(key)
+	s.requests.Add(1)
+	s.duration.Record(elapsed)
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped RoundTripper if it has a
+// CloseIdleConnections method, as http.Client.CloseIdleConnections expects of transports.
+func (t *Transport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// seriesOf returns the instruments of the series k.
+func (t *Transport) seriesOf(k seriesKey) *clientSeries {
+	if s, ok := t.series.Load(k); ok {
+		return s.(*clientSeries)
+	}
+	s := &clientSeries{}
+	name, opts := seriesOptions("http_client_requests_total", k,
+		metrics.WithDescription("HTTP requests sent."), metrics.WithUnit("requests"))
+	s.requests = t.p.Counter(name, opts...)
+	name, opts = seriesOptions("http_client_request_duration_seconds", k,
+		metrics.WithDescription("Time until the response headers of HTTP requests."), metrics.WithUnit("seconds"),
+		metrics.WithBuckets(t.cfg.durationBuckets...))
+	s.duration = t.p.Histogram(name, opts...)
+	actual, _ := t.series.LoadOrStore(k, s)
+	return actual.(*clientSeries)
+}
+
+// errorCounter returns the error counter of the series k.
+func (t *Transport) errorCounter(k seriesKey) metrics.Counter {
+	if c, ok := t.errors.Load(k); ok {
+		return c.(metrics.Counter)
+	}
+	name, opts := seriesOptions("http_client_errors_total", k,
+		metrics.WithDescription("HTTP requests that got no response."), metrics.WithUnit("requests"))
+	c, _ := t.errors.LoadOrStore(k, t.p.Counter(name, opts...))
+	return c.(metrics.Counter)
+}
+
+// phasesOf returns the phase histograms of the route and host series k.
+func (t *Transport) phasesOf(k seriesKey) *phaseSeries {
+	if s, ok := t.phases.Load(k); ok {
+		return s.(*phaseSeries)
+	}
+	histogram := func(name, description string) metrics.Histogram {
+		name, opts := seriesOptions(name, k, metrics.WithDescription(description), metrics.WithUnit("seconds"),
+			metrics.WithBuckets(t.cfg.durationBuckets...))
+		return t.p.Histogram(name, opts...)
+	}
+	s := &phaseSeries{
+		dns:       histogram("http_client_dns_duration_seconds", "Time to look up hosts of HTTP requests."),
+		connect:   histogram("http_client_connect_duration_seconds", "Time to establish connections of HTTP requests."),
+		tls:       histogram("http_client_tls_handshake_duration_seconds", "Time of TLS handshakes of HTTP requests."),
+		firstByte: histogram("http_client_first_byte_duration_seconds", "Time until the first response byte of HTTP requests."),
+	}
+	actual, _ := t.phases.LoadOrStore(k, s)
+	return actual.(*phaseSeries)
+}
+
+// requestTrace times the phases of a request and keeps the phase that failed.
+type requestTrace struct {
+	clock  metrics.Clock
+	phases *phaseSeries
+	start  time.Time
+
+	// hooks run on the goroutines of the transport, concurrent dials included
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+	connected    bool
+	failed       string
+}
+
+func (tr *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.dnsStart = tr.clock.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.done(tr.dnsStart, tr.phases.dns, info.Err, errorDNS)
+		},
+		ConnectStart: func(network, addr string) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			if tr.connectStart == nil {
+				tr.connectStart = make(map[string]time.Time)
+			}
+			tr.connectStart[network+" "+addr] = tr.clock.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			// with concurrent dials, as for both address families, a request fails to dial only
+			// if none of them connects
+			switch {
+			case err != nil && tr.connected:
+				return
+			case err == nil:
+				tr.connected = true
+				if tr.failed == errorDial {
+					tr.failed = ""
+				}
+			}
+			tr.done(tr.connectStart[network+" "+addr], tr.phases.connect, err, errorDial)
+		},
+		TLSHandshakeStart: func() {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.tlsStart = tr.clock.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.done(tr.tlsStart, tr.phases.tls, err, errorTLS)
+		},
+		GotFirstResponseByte: func() {
+			tr.phases.firstByte.Record(tr.clock.Now().Sub(tr.start).Seconds())
+		},
+	}
+}
+
+// done records a phase started at start on h if it succeeded, or keeps its error type.
+// The caller holds tr.mu.
+func (tr *requestTrace) done(start time.Time, h metrics.Histogram, err error, errType string) {
+	if err != nil {
+		tr.failed = errType
+		return
+	}
+	if !start.IsZero() {
+		h.Record(tr.clock.Now().Sub(start).Seconds())
+	}
+}
+
+// errorType returns the AttrError value of the error of a request.
+func (tr *requestTrace) errorType(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return errorTimeout
+	}
+	tr.mu.Lock()
+	failed := tr.failed
+	tr.mu.Unlock()
+	if failed != "" {
+		return failed
+	}
+	// custom dialers run without the phase hooks
+	var (
+		dnsErr  *net.DNSError
+		opErr   *net.OpError
+		certErr *tls.CertificateVerificationError
+		recErr  tls.RecordHeaderError
+		authErr x509.UnknownAuthorityError
+		hostErr x509.HostnameError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return errorDNS
+	case errors.As(err, &certErr), errors.As(err, &recErr), errors.As(err, &authErr), errors.As(err, &hostErr):
+		return errorTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return errorDial
+	case errors.Is(err, context.Canceled):
+		return errorCanceled
+	}
+	return errorOther
+}
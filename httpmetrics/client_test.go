@@ -0,0 +1,222 @@
+package httpmetrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/metricstest"
+)
+
+// get sends a GET request to url through rt and discards the response body.
+func get(ctx context.Context, rt http.RoundTripper, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: rt}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+func TestTransport_RecordsRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	p := metrics.NewBasicProvider()
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	defer base.CloseIdleConnections()
+	rt := NewTransport(p, base, WithHostFunc(RequestHost))
+
+	// hooks already in the context still run
+	var gotFirstByte bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { gotFirstByte = true },
+	})
+	for _, path := range []string{"/", "/", "/missing"} {
+		if err := get(ctx, rt, srv.URL+path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !gotFirstByte {
+		t.Fatal("expected the trace of the context to run")
+	}
+
+	series := `{host="` + host + `",method="GET",status="2xx"}`
+	metricstest.AssertCounter(t, p, "http_client_requests_total"+series, 2)
+	metricstest.AssertHistogramCount(t, p, "http_client_request_duration_seconds"+series, 2)
+	metricstest.AssertCounter(t, p, `http_client_requests_total{host="`+host+`",method="GET",status="4xx"}`, 1)
+	// the connection is reused and the server address needs no lookup
+	metricstest.AssertHistogramCount(t, p, `http_client_connect_duration_seconds{host="`+host+`"}`, 1)
+	metricstest.AssertHistogramCount(t, p, `http_client_first_byte_duration_seconds{host="`+host+`"}`, 3)
+	metricstest.AssertHistogramCount(t, p, `http_client_dns_duration_seconds{host="`+host+`"}`, 0)
+	metricstest.AssertNoInstrument(t, p, metrics.InstrumentTypeCounter, `http_client_errors_total{error="dial",host="`+host+`",method="GET"}`)
+}
+
+func TestTransport_HostFunc(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	defer base.CloseIdleConnections()
+
+	// hosts are not recorded by default
+	p := metrics.NewBasicProvider()
+	if err := get(context.Background(), NewTransport(p, base), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	metricstest.AssertCounter(t, p, `http_client_requests_total{method="GET",status="2xx"}`, 1)
+	metricstest.AssertHistogramCount(t, p, "http_client_first_byte_duration_seconds", 1)
+
+	p = metrics.NewBasicProvider()
+	rt := NewTransport(p, base, WithHostFunc(func(*http.Request) string { return "backend" }))
+	if err := get(context.Background(), rt, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	metricstest.AssertCounter(t, p, `http_client_requests_total{host="backend",method="GET",status="2xx"}`, 1)
+	metricstest.AssertHistogramCount(t, p, `http_client_first_byte_duration_seconds{host="backend"}`, 1)
+}
+
+// idleCloser is a RoundTripper counting calls of CloseIdleConnections.
+type idleCloser struct {
+	http.RoundTripper
+	closed int
+}
+
+func (c *idleCloser) CloseIdleConnections() { c.closed++ }
+
+func TestTransport_CloseIdleConnections(t *testing.T) {
+	next := &idleCloser{RoundTripper: http.DefaultTransport}
+	client := &http.Client{Transport: NewTransport(metrics.NewBasicProvider(), next)}
+	client.CloseIdleConnections()
+	if next.closed != 1 {
+		t.Fatalf("expected the call to be forwarded once, got %d", next.closed)
+	}
+	// RoundTrippers without the method are left alone
+	NewTransport(metrics.NewBasicProvider(), struct{ http.RoundTripper }{next}).CloseIdleConnections()
+	if next.closed != 1 {
+		t.Fatalf("expected no call through a RoundTripper without the method, got %d", next.closed)
+	}
+}
+
+func TestTransport_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+	p := metrics.NewBasicProvider()
+
+	if err := get(context.Background(), NewTransport(p, srv.Client().Transport, WithHostFunc(RequestHost)), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	metricstest.AssertHistogramCount(t, p, `http_client_tls_handshake_duration_seconds{host="`+host+`"}`, 1)
+
+	// the certificate of the test server is not trusted by default
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	defer base.CloseIdleConnections()
+	if err := get(context.Background(), NewTransport(p, base, WithHostFunc(RequestHost)), srv.URL); err == nil {
+		t.Fatal("expected a certificate error")
+	}
+	metricstest.AssertCounter(t, p, `http_client_errors_total{error="tls",host="`+host+`",method="GET"}`, 1)
+	metricstest.AssertCounter(t, p, `http_client_requests_total{host="`+host+`",method="GET",status="error"}`, 1)
+	metricstest.AssertHistogramCount(t, p, `http_client_tls_handshake_duration_seconds{host="`+host+`"}`, 1)
+}
+
+func TestTransport_Errors(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	rt := NewTransport(p, nil, WithHostFunc(RequestHost), WithRouteFunc(func(r *http.Request) string { return r.URL.Path }))
+
+	// a closed listener refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	if err := get(context.Background(), rt, "http://"+closed+"/users"); err == nil {
+		t.Fatal("expected a dial error")
+	}
+	metricstest.AssertCounter(t, p, `http_client_errors_total{error="dial",host="`+closed+`",method="GET",route="/users"}`, 1)
+
+	// a response slower than the deadline
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	host := srv.Listener.Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := get(ctx, rt, srv.URL+"/slow"); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	metricstest.AssertCounter(t, p, `http_client_errors_total{error="timeout",host="`+host+`",method="GET",route="/slow"}`, 1)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := get(ctx, rt, srv.URL+"/canceled"); err == nil {
+		t.Fatal("expected a canceled request")
+	}
+	metricstest.AssertCounter(t, p, `http_client_errors_total{error="canceled",host="`+host+`",method="GET",route="/canceled"}`, 1)
+}
+
+func TestTransport_CustomDialer(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	base := &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: "service.invalid", IsNotFound: true}
+	}}
+	if err := get(context.Background(), NewTransport(p, base, WithHostFunc(RequestHost)), "http://service.invalid/"); err == nil {
+		t.Fatal("expected a lookup error")
+	}
+	metricstest.AssertCounter(t, p, `http_client_errors_total{error="dns",host="service.invalid",method="GET"}`, 1)
+
+	base.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("boom")
+	}
+	if err := get(context.Background(), NewTransport(p, base, WithHostFunc(RequestHost)), "http://service.invalid/"); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the dialer error, got %v", err)
+	}
+	metricstest.AssertCounter(t, p, `http_client_errors_total{error="other",host="service.invalid",method="GET"}`, 1)
+}
+
+func TestRequestTrace_ConcurrentDials(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	rt := NewTransport(p, nil)
+	k := seriesKey{host: "example.com:80", method: http.MethodGet}
+	tr := &requestTrace{clock: metrics.SystemClock(), phases: rt.phasesOf(k), start: time.Now()}
+	ct := tr.clientTrace()
+
+	// the IPv6 dial fails before the IPv4 one connects, and the second one is canceled
+	// after the first one connects
+	ct.ConnectStart("tcp", "[2001:db8::1]:80")
+	ct.ConnectStart("tcp", "192.0.2.1:80")
+	ct.ConnectDone("tcp", "[2001:db8::1]:80", errors.New("network is unreachable"))
+	ct.ConnectDone("tcp", "192.0.2.1:80", nil)
+	ct.ConnectStart("tcp", "192.0.2.2:80")
+	ct.ConnectDone("tcp", "192.0.2.2:80", errors.New("operation was canceled"))
+	if got := tr.errorType(errors.New("connection reset")); got != errorOther {
+		t.Fatalf("expected a request failing after connecting not to be a dial error, got %q", got)
+	}
+	metricstest.AssertHistogramCount(t, p, `http_client_connect_duration_seconds{host="example.com:80",method="GET"}`, 1)
+}
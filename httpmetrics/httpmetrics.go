@@ -0,0 +1,212 @@
+// Package httpmetrics instruments net/http servers and clients with the RED metrics of their
+// requests: rate, errors and duration.
+//
+// Middleware wraps any http.Handler, such as a ServeMux or a single route:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("GET /users/{id}", getUser)
+//	m := httpmetrics.NewMiddleware(p, httpmetrics.WithRouteFunc(httpmetrics.MuxRoute(mux)))
+//	http.ListenAndServe(":8080", m.Handler(mux))
+//
+// Transport wraps the http.RoundTripper of a client:
+//
+//	client := &http.Client{Transport: httpmetrics.NewTransport(p, http.DefaultTransport)}
+//
+// Every series of requests sharing a route, method and status class, and for clients a
+// host, is recorded on its own instruments, named by metrics.SeriesName and carrying the
+// attributes, e.g. `http_server_requests_total{method="GET",route="/users/{id}",status="2xx"}`.
+// Routes are only recorded when named by a RouteFunc, and hosts by a HostFunc: raw paths,
+// or the hosts of a client calling arbitrary URLs, would make the number of series unbounded.
+package httpmetrics
+
+import (
+	"net/http"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// Attributes of the recorded series.
+const (
+	// AttrRoute is the attribute holding the route named by the RouteFunc.
+	AttrRoute = "route"
+	// AttrMethod is the attribute holding the request method. Non-standard methods are
+	// recorded as "OTHER".
+	AttrMethod = "method"
+	// AttrStatus is the attribute holding the class of the response status code, e.g. "2xx",
+	// or "error" for client requests that got no response.
+	AttrStatus = "status"
+	// AttrHost is the attribute holding the host of client requests named by the HostFunc.
+	AttrHost = "host"
+	// AttrError is the attribute holding the type of the error of client requests that got
+	// no response: "dns", "dial", "tls", "timeout", "canceled" or "other".
+	AttrError = "error"
+)
+
+// RouteFunc returns the route of a request: a name of the handler serving it with bounded
+// cardinality, such as the pattern "/users/{id}" rather than the path "/users/42".
+type RouteFunc func(r *http.Request) string
+
+// MuxRoute returns a RouteFunc naming requests by the pattern of mux matching them (see
+// http.ServeMux.Handler). Requests matching no pattern get an empty route.
+func MuxRoute(mux *http.ServeMux) RouteFunc {
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+}
+
+// HostFunc returns the host of a client request: a name of the called service with bounded
+// cardinality, such as "api.example.com:443".
+type HostFunc func(r *http.Request) string
+
+// RequestHost is a HostFunc naming requests by the host, and port if any, of their URL.
+// Use it for clients calling a known set of hosts.
+func RequestHost(r *http.Request) string {
+	if r.URL.Host != "" {
+		return r.URL.Host
+	}
+	return r.Host
+}
+
+// unmatchedRoute is the route of requests for which the RouteFunc returns an empty route.
+const unmatchedRoute = "unmatched"
+
+var (
+	defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	defaultSizeBuckets     = []float64{100, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8}
+)
+
+type config struct {
+	// names the route of requests. Default: nil, routes are not recorded.
+	route RouteFunc
+	// names the host of client requests. Default: nil, hosts are not recorded.
+	host HostFunc
+	// bucket bounds of duration histograms, in seconds. Default: defaultDurationBuckets.
+	durationBuckets []float64
+	// bucket bounds of size histograms, in bytes. Default: defaultSizeBuckets.
+	sizeBuckets []float64
+	// source of time of durations. Default: the clock of the provider, or SystemClock.
+	clock metrics.Clock
+}
+
+// Option configures a Middleware or a Transport.
+type Option func(*config)
+
+// WithRouteFunc sets the function naming the route of requests, recorded as AttrRoute, such
+// as the endpoint called by a client. Empty routes are recorded as "unmatched". Without it,
+// requests are not told apart by route.
+func WithRouteFunc(f RouteFunc) Option {
+	return func(cfg *config) { cfg.route = f }
+}
+
+// WithHostFunc sets the function naming the host of client requests, recorded as AttrHost,
+// e.g. RequestHost. Empty hosts are not recorded. Without it, client requests are not told
+// apart by host. Middleware ignores it.
+func WithHostFunc(f HostFunc) Option {
+	return func(cfg *config) { cfg.host = f }
+}
+
+// WithDurationBuckets sets the bucket bounds, in seconds, of the request duration histograms.
+func WithDurationBuckets(bounds ...float64) Option {
+	return func(cfg *config) { cfg.durationBuckets = bounds }
+}
+
+// WithSizeBuckets sets the bucket bounds, in bytes, of the request and response size
+// histograms.
+func WithSizeBuckets(bounds ...float64) Option {
+	return func(cfg *config) { cfg.sizeBuckets = bounds }
+}
+
+// WithClock sets the clock measuring request durations. The default is the clock of the
+// provider if it has one (such as metrics.BasicProvider), SystemClock otherwise.
+// A nil clock is ignored.
+func WithClock(c metrics.Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// newConfig returns the configuration built from opts for instruments of p.
+func newConfig(p metrics.Provider, opts []Option) config {
+	cfg := config{
+		durationBuckets: defaultDurationBuckets,
+		sizeBuckets:     defaultSizeBuckets,
+	}
+	if cs, ok := p.(interface{ Clock() metrics.Clock }); ok {
+		cfg.clock = cs.Clock()
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	if cfg.clock == nil {
+		cfg.clock = metrics.SystemClock()
+	}
+	return cfg
+}
+
+// routeOf returns the route attribute of r, empty without a RouteFunc.
+func (cfg *config) routeOf(r *http.Request) string {
+	if cfg.route == nil {
+		return ""
+	}
+	if route := cfg.route(r); route != "" {
+		return route
+	}
+	return unmatchedRoute
+}
+
+// hostOf returns the host attribute of r, empty without a HostFunc.
+func (cfg *config) hostOf(r *http.Request) string {
+	if cfg.host == nil {
+		return ""
+	}
+	return cfg.host(r)
+}
+
+// seriesKey identifies a series of requests.
+type seriesKey struct {
+	route, host, method, status, err string
+}
+
+// attributes returns the attributes of the series, without the empty ones.
+func (k seriesKey) attributes() map[string]string {
+	attrs := make(map[string]string, 5)
+	for name, v := range map[string]string{
+		AttrRoute: k.route, AttrHost: k.host, AttrMethod: k.method, AttrStatus: k.status, AttrError: k.err,
+	} {
+		if v != "" {
+			attrs[name] = v
+		}
+	}
+	return attrs
+}
+
+// seriesOptions returns the name and options of the instrument name of the series k.
+func seriesOptions(name string, k seriesKey, opts ...metrics.InstrumentOption) (string, []metrics.InstrumentOption) {
+	attrs := k.attributes()
+	return metrics.SeriesName(name, attrs), append(opts, metrics.WithAttributes(attrs))
+}
+
+// method returns the method attribute of a request method.
+func method(m string) string {
+	switch m {
+	case "":
+		return http.MethodGet
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	}
+	return "OTHER"
+}
+
+// statusClass returns the status attribute of a status code: "2xx" for 204.
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "other"
+	}
+	return string(rune('0'+code/100)) + "xx"
+}
@@ -0,0 +1,213 @@
+package httpmetrics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// Middleware records the requests served by the handlers it wraps:
+//
+//	http_server_requests_total            counter of completed requests
+//	http_server_requests_in_flight        up/down counter of requests being served, without status
+//	http_server_request_duration_seconds  histogram of the time to serve requests
+//	http_server_request_size_bytes        histogram of request body sizes
+//	http_server_response_size_bytes       histogram of response body sizes
+//
+// A request is complete when its handler returns. Its status is the one written by the
+// handler, 200 if it wrote none and 500 if it panicked before writing one; hijacked
+// connections count as 101. The request size is the Content-Length of the request or,
+// when unknown, the bytes the handler read from the body. The response size is the bytes
+// the handler wrote.
+//
+// The response writers passed to handlers implement http.Flusher and http.Hijacker when the
+// wrapped ones do, and unwrap to them for http.ResponseController.
+//
+// Middleware is safe for concurrent use.
+type Middleware struct {
+	p   metrics.Provider
+	cfg config
+
+	// seriesKey without status -> metrics.UpDownCounter
+	inFlight sync.Map
+	// seriesKey -> *serverSeries
+	series sync.Map
+}
+
+// serverSeries holds the instruments of a series of served requests.
+type serverSeries struct {
+	requests     metrics.Counter
+	duration     metrics.Histogram
+	requestSize  metrics.Histogram
+	responseSize metrics.Histogram
+}
+
+// NewMiddleware returns a Middleware recording on p.
+func NewMiddleware(p metrics.Provider, opts ...Option) *Middleware {
+	return &Middleware{p: p, cfg: newConfig(p, opts)}
+}
+
+// Handler returns next instrumented. It fits routers accepting func(http.Handler) http.Handler
+// middleware.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serve(next, w, r)
+	})
+}
+
+func (m *Middleware) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := m.cfg.clock.Now()
+	key := seriesKey{route: m.cfg.routeOf(r), method: method(r.Method)}
+	inFlight := m.inFlightCounter(key)
+	inFlight.Add(1)
+
+	var body *countingBody
+	if r.ContentLength < 0 && r.Body != nil {
+		body = &countingBody{ReadCloser: r.Body}
+		r.Body = body
+	}
+	rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+	panicked := true
+	defer func() {
+		inFlight.Add(-1)
+		if panicked && !rw.wroteHeader {
+			rw.status = http.StatusInternalServerError
+		}
+		key.status = statusClass(rw.status)
+		s := m.seriesOf(key)
+		s.requests.Add(1)
+		s.duration.Record(m.cfg.clock.Now().Sub(start).Seconds())
+		size := r.ContentLength
+		if body != nil {
+			size = body.n
+		}
+		s.requestSize.Record(float64(size))
+		s.responseSize.Record(float64(rw.written))
+	}()
+	next.ServeHTTP(rw.wrap(), r)
+	panicked = false
+}
+
+// inFlightCounter returns the in-flight counter of the series k.
+func (m *Middleware) inFlightCounter(k seriesKey) metrics.UpDownCounter {
+	if c, ok := m.inFlight.Load(k); ok {
+		return c.(metrics.UpDownCounter)
+	}
+	name, opts := seriesOptions("http_server_requests_in_flight", k,
+		metrics.WithDescription("HTTP requests being served."), metrics.WithUnit("requests"))
+	c, _ := m.inFlight.LoadOrStore(k, m.p.UpDownCounter(name, opts...))
+	return c.(metrics.UpDownCounter)
+}
+
+// seriesOf returns the instruments of the series k.
+func (m *Middleware) seriesOf(k seriesKey) *serverSeries {
+	if s, ok := m.series.Load(k); ok {
+		return s.(*serverSeries)
+	}
+	s := &serverSeries{}
+	name, opts := seriesOptions("http_server_requests_total", k,
+		metrics.WithDescription("HTTP requests served."), metrics.WithUnit("requests"))
+	s.requests = m.p.Counter(name, opts...)
+	name, opts = seriesOptions("http_server_request_duration_seconds", k,
+		metrics.WithDescription("Time to serve HTTP requests."), metrics.WithUnit("seconds"),
+		metrics.WithBuckets(m.cfg.durationBuckets...))
+	s.duration = m.p.Histogram(name, opts...)
+	name, opts = seriesOptions("http_server_request_size_bytes", k,
+		metrics.WithDescription("Sizes of HTTP request bodies."), metrics.WithUnit("bytes"),
+		metrics.WithBuckets(m.cfg.sizeBuckets...))
+	s.requestSize = m.p.Histogram(name, opts...)
+	name, opts = seriesOptions("http_server_response_size_bytes", k,
+		metrics.WithDescription("Sizes of HTTP response bodies."), metrics.WithUnit("bytes"),
+		metrics.WithBuckets(m.cfg.sizeBuckets...))
+	s.responseSize = m.p.Histogram(name, opts...)
+	actual, _ := m.series.LoadOrStore(k, s)
+	return actual.(*serverSeries)
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// responseWriter records the status and the size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+}
+
+// wrap returns w as a writer implementing the optional interfaces of the wrapped one.
+func (w *responseWriter) wrap() http.ResponseWriter {
+	_, flusher := w.ResponseWriter.(http.Flusher)
+	_, hijacker := w.ResponseWriter.(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		return flushHijackWriter{w}
+	case flusher:
+		return flushWriter{w}
+	case hijacker:
+		return hijackWriter{w}
+	}
+	return w
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	// informational responses, other than 101 Switching Protocols, precede the final one
+	if !w.wroteHeader && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) flush() {
+	// flushing writes the header
+	w.wroteHeader = true
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && !w.wroteHeader {
+		w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	}
+	return conn, rw, err
+}
+
+type flushWriter struct{ *responseWriter }
+
+func (w flushWriter) Flush() { w.flush() }
+
+type hijackWriter struct{ *responseWriter }
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+type flushHijackWriter struct{ *responseWriter }
+
+func (w flushHijackWriter) Flush() { w.flush() }
+
+func (w flushHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
@@ -0,0 +1,199 @@
+package httpmetrics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+	"github.com/ygrebnov/metrics/metricstest"
+)
+
+func TestMiddleware_RecordsRoutes(t *testing.T) {
+	clk := metricstest.NewFakeClock(time.Time{})
+	p := metrics.NewBasicProvider(metrics.WithClock(clk))
+	mux := http.NewServeMux()
+	m := NewMiddleware(p, WithRouteFunc(MuxRoute(mux)))
+	mux.HandleFunc("POST /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		metricstest.AssertUpDownCounter(t, p, `http_server_requests_in_flight{method="POST",route="POST /users/{id}"}`, 1)
+		clk.Advance(250 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	})
+	h := m.Handler(mux)
+
+	for _, id := range []string{"1", "2"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users/"+id, strings.NewReader("hello")))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	const series = `{method="POST",route="POST /users/{id}",status="2xx"}`
+	metricstest.AssertCounter(t, p, "http_server_requests_total"+series, 2)
+	metricstest.AssertHistogramSum(t, p, "http_server_request_duration_seconds"+series, 0.5, 1e-9)
+	metricstest.AssertHistogramSum(t, p, "http_server_request_size_bytes"+series, 10, 0)
+	metricstest.AssertHistogramSum(t, p, "http_server_response_size_bytes"+series, 14, 0)
+	metricstest.AssertUpDownCounter(t, p, `http_server_requests_in_flight{method="POST",route="POST /users/{id}"}`, 0)
+	metricstest.AssertCounter(t, p, `http_server_requests_total{method="GET",route="unmatched",status="4xx"}`, 1)
+
+	s, ok := p.Snapshot().Get(metrics.InstrumentTypeCounter, "http_server_requests_total"+series)
+	if !ok || s.Config.Attributes[AttrRoute] != "POST /users/{id}" || s.Config.Attributes[AttrStatus] != "2xx" {
+		t.Fatalf("expected the series attributes, got %+v", s.Config)
+	}
+	if _, cfg, _ := p.HistogramWithMeta("http_server_request_duration_seconds" + series); len(cfg.Buckets) != len(defaultDurationBuckets) {
+		t.Fatalf("expected the default duration buckets, got %v", cfg.Buckets)
+	}
+}
+
+func TestMiddleware_Statuses(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	h := NewMiddleware(p).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/written-panic":
+			w.WriteHeader(http.StatusAccepted)
+			panic("boom")
+		case "/early-hints":
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusNotFound)
+		case "/body":
+			// the size of bodies of unknown length is the bytes read
+			n, _ := io.Copy(io.Discard, r.Body)
+			if n != 11 {
+				t.Errorf("read %d bytes", n)
+			}
+		}
+	}))
+	serve := func(method, path string, body io.Reader) {
+		t.Helper()
+		defer func() {
+			if r := recover(); r != nil && r != "boom" {
+				t.Fatalf("unexpected panic %v", r)
+			}
+		}()
+		req := httptest.NewRequest(method, path, body)
+		if body != nil {
+			req.ContentLength = -1
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve(http.MethodGet, "/", nil)
+	serve(http.MethodGet, "/panic", nil)
+	serve(http.MethodGet, "/written-panic", nil)
+	serve(http.MethodGet, "/early-hints", nil)
+	serve("PURGE", "/", nil)
+	serve(http.MethodPut, "/body", strings.NewReader("hello world"))
+
+	// routes are not recorded without a RouteFunc
+	metricstest.AssertCounter(t, p, `http_server_requests_total{method="GET",status="2xx"}`, 2)
+	metricstest.AssertCounter(t, p, `http_server_requests_total{method="GET",status="5xx"}`, 1)
+	metricstest.AssertCounter(t, p, `http_server_requests_total{method="GET",status="4xx"}`, 1)
+	metricstest.AssertCounter(t, p, `http_server_requests_total{method="OTHER",status="2xx"}`, 1)
+	metricstest.AssertHistogramSum(t, p, `http_server_request_size_bytes{method="PUT",status="2xx"}`, 11, 0)
+	metricstest.AssertUpDownCounter(t, p, `http_server_requests_in_flight{method="GET"}`, 0)
+}
+
+// plainWriter implements none of the optional interfaces of response writers.
+type plainWriter struct{ http.ResponseWriter }
+
+// unwrapWriter implements none of the optional interfaces but unwraps to its writer.
+type unwrapWriter struct{ http.ResponseWriter }
+
+func (w unwrapWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func TestMiddleware_OptionalInterfaces(t *testing.T) {
+	var flusher, hijacker bool
+	h := NewMiddleware(metrics.NewBasicProvider()).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !flusher || hijacker {
+		t.Fatalf("expected a Flusher only like the recorder, got flusher %t, hijacker %t", flusher, hijacker)
+	}
+	h.ServeHTTP(plainWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if flusher || hijacker {
+		t.Fatalf("expected no optional interfaces, got flusher %t, hijacker %t", flusher, hijacker)
+	}
+
+	// http.ResponseController reaches the recorder through the Unwrap methods
+	rec := httptest.NewRecorder()
+	NewMiddleware(metrics.NewBasicProvider()).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+	})).ServeHTTP(unwrapWriter{rec}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !rec.Flushed {
+		t.Fatal("expected the recorder to be flushed")
+	}
+}
+
+func TestMiddleware_Server(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			io.WriteString(w, "tick\n")
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+	})
+	srv := httptest.NewServer(NewMiddleware(p, WithRouteFunc(MuxRoute(mux))).Handler(mux))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "tick\ntick\ntick\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	conn, err := (&net.Dialer{}).Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.Contains(status, "101") {
+		t.Fatalf("unexpected upgrade response %q: %v", status, err)
+	}
+
+	// the handlers have returned once the responses are complete
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snap := p.Snapshot()
+		_, streamed := snap.Get(metrics.InstrumentTypeCounter, `http_server_requests_total{method="GET",route="/stream",status="2xx"}`)
+		_, upgraded := snap.Get(metrics.InstrumentTypeCounter, `http_server_requests_total{method="GET",route="/upgrade",status="1xx"}`)
+		if streamed && upgraded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the stream and upgrade series:\n%s", metricstest.Dump(snap))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	metricstest.AssertHistogramSum(t, p, `http_server_response_size_bytes{method="GET",route="/stream",status="2xx"}`, 15, 0)
+}
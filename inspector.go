@@ -15,8 +15,17 @@ type Inspector interface {
 	ListMetadata() []InstrumentEntry
 }
 
+// RateInspector is an optional capability of reading the rate trackers attached to counters.
+type RateInspector interface {
+	// CounterRates returns the current readings of the rate trackers attached to the counter
+	// name, and whether the counter exists.
+	CounterRates(name string) ([]Rate, bool)
+}
+
 type InstrumentEntry struct {
 	Type   InstrumentType
 	Name   string
 	Config InstrumentConfig // defensive copy
+	// Times are the lifecycle timestamps of the instrument, if the implementation records them.
+	Times InstrumentTimes
 }
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// InstrumentTimes are the lifecycle timestamps of an instrument created by BasicProvider.
+type InstrumentTimes struct {
+	// Created is when the instrument was created.
+	Created time.Time
+	// LastUpdate is the time of the most recent measurement; zero if there was none or if
+	// the provider does not track updates (see WithLastUpdateTracking).
+	LastUpdate time.Time
+	// LastReset is the time of the most recent reset; zero if the instrument was never reset.
+	LastReset time.Time
+}
+
+// Start returns the start of the current accumulation period of the instrument: the time of
+// the last reset, or the creation time. It is the start time of cumulative exports.
+func (t InstrumentTimes) Start() time.Time {
+	if !t.LastReset.IsZero() {
+		return t.LastReset
+	}
+	return t.Created
+}
+
+// instrumentTimes records the lifecycle timestamps of an instrument. Instruments hold a
+// pointer to it; a nil pointer records nothing.
+type instrumentTimes struct {
+	clock        Clock
+	trackUpdates bool
+	// Unix nanoseconds XOR-ed with math.MinInt64, so that the zero value means unset.
+	created    atomic.Int64
+	lastUpdate atomic.Int64
+	lastReset  atomic.Int64
+}
+
+func newInstrumentTimes(clock Clock, trackUpdates bool) *instrumentTimes {
+	t := &instrumentTimes{clock: clock, trackUpdates: trackUpdates}
+	storeStamp(&t.created, clock.Now())
+	return t
+}
+
+// touch records an update if update tracking is enabled.
+func (t *instrumentTimes) touch() {
+	if t == nil || !t.trackUpdates {
+		return
+	}
+	storeStamp(&t.lastUpdate, t.clock.Now())
+}
+
+// reset records a reset.
+func (t *instrumentTimes) reset() {
+	if t == nil {
+		return
+	}
+	storeStamp(&t.lastReset, t.clock.Now())
+}
+
+// restore sets the creation and reset times of a restored instrument.
+func (t *instrumentTimes) restore(in InstrumentTimes) {
+	if t == nil {
+		return
+	}
+	if !in.Created.IsZero() {
+		storeStamp(&t.created, in.Created)
+	}
+	if !in.LastReset.IsZero() {
+		storeStamp(&t.lastReset, in.LastReset)
+	}
+}
+
+func (t *instrumentTimes) load() InstrumentTimes {
+	if t == nil {
+		return InstrumentTimes{}
+	}
+	return InstrumentTimes{
+		Created:    loadStamp(&t.created),
+		LastUpdate: loadStamp(&t.lastUpdate),
+		LastReset:  loadStamp(&t.lastReset),
+	}
+}
+
+func storeStamp(v *atomic.Int64, t time.Time) { v.Store(t.UnixNano() ^ math.MinInt64) }
+
+func loadStamp(v *atomic.Int64) time.Time {
+	n := v.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n^math.MinInt64)
+}
@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBasicProvider_InstrumentTimes(t *testing.T) {
+	clk := newManualClock()
+	created := clk.Now()
+	p := NewBasicProvider(WithClock(clk))
+	p.Counter("c")
+	clk.Advance(time.Second)
+	p.Counter("c").Add(1)
+
+	s, _ := p.Snapshot().Get(InstrumentTypeCounter, "c")
+	if !s.Times.Created.Equal(created) || !s.Times.LastUpdate.IsZero() || !s.Times.LastReset.IsZero() {
+		t.Fatalf("unexpected times %+v", s.Times)
+	}
+	if !s.Times.Start().Equal(created) {
+		t.Fatalf("start = %v; want creation time", s.Times.Start())
+	}
+
+	entries := p.ListMetadata()
+	if len(entries) != 1 || !entries[0].Times.Created.Equal(created) {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if (InstrumentTimes{}).Start() != (time.Time{}) || p.instrumentTimes(NewInstrumentKey(InstrumentTypeUpDown, "x")) != (InstrumentTimes{}) {
+		t.Fatalf("expected zero times")
+	}
+}
+
+func TestBasicProvider_LastUpdateTracking(t *testing.T) {
+	clk := newManualClock()
+	p := NewBasicProvider(WithClock(clk), WithLastUpdateTracking())
+	p.Counter("c")
+	p.UpDownCounter("u")
+	p.Histogram("h")
+	p.Histogram("w", WithRollingWindow(HistogramWindow{Duration: time.Minute}))
+	sp := NewBasicProvider(WithClock(clk), WithLastUpdateTracking(), WithShardedCounters(2))
+	sp.Counter("c")
+
+	clk.Advance(time.Minute)
+	updated := clk.Now()
+	p.Counter("c").Add(1)
+	p.UpDownCounter("u").Add(1)
+	p.Histogram("h").Record(1)
+	p.Histogram("w").Record(1)
+	sp.Counter("c").Add(1)
+
+	for _, s := range append(p.Snapshot().Instruments, sp.Snapshot().Instruments...) {
+		if !s.Times.LastUpdate.Equal(updated) {
+			t.Fatalf("%s %s: last update = %v; want %v", s.Type, s.Name, s.Times.LastUpdate, updated)
+		}
+	}
+}
+
+func TestBasicProvider_ResetInstrument(t *testing.T) {
+	clk := newManualClock()
+	p := NewBasicProvider(WithClock(clk), WithExemplarFilter(ExemplarFilterAlwaysOn))
+	sp := NewBasicProvider(WithClock(clk), WithShardedCounters(2))
+	AddWithExemplar(p.Counter("c"), 5, Exemplar{})
+	sp.Counter("c").Add(5)
+	p.UpDownCounter("u").Add(-3)
+	h := p.Histogram("h", WithBuckets(1))
+	RecordWithExemplar(h, 0.5, Exemplar{})
+	h.Record(2)
+	p.Histogram("w", WithRollingWindow(HistogramWindow{Duration: time.Hour})).Record(1)
+	before := p.Snapshot()
+
+	clk.Advance(time.Second)
+	resetAt := clk.Now()
+	for _, k := range []InstrumentKey{
+		NewInstrumentKey(InstrumentTypeCounter, "c"),
+		NewInstrumentKey(InstrumentTypeUpDown, "u"),
+		NewInstrumentKey(InstrumentTypeHistogram, "h"),
+		NewInstrumentKey(InstrumentTypeHistogram, "w"),
+	} {
+		if !p.ResetInstrument(k.Type, k.Name) {
+			t.Fatalf("expected %v to exist", k)
+		}
+	}
+	sp.ResetInstrument(InstrumentTypeCounter, "c")
+	if p.ResetInstrument(InstrumentTypeCounter, "missing") || p.ResetInstrument(InstrumentTypeUpDown, "missing") ||
+		p.ResetInstrument(InstrumentTypeHistogram, "missing") || p.ResetInstrument("other", "c") {
+		t.Fatalf("expected missing instruments not to be reset")
+	}
+
+	after := p.Snapshot()
+	for _, s := range after.Instruments {
+		if s.Value != 0 || s.Histogram.Count != 0 || len(s.Exemplars) != 0 || !s.Times.LastReset.Equal(resetAt) {
+			t.Fatalf("unexpected state after reset %+v", s)
+		}
+	}
+	if got := sp.Counter("c").(*ShardedCounter).Snapshot(); got != 0 {
+		t.Fatalf("sharded counter = %d; want 0", got)
+	}
+	hs, _ := after.Get(InstrumentTypeHistogram, "h")
+	if hs.Histogram.Buckets[0].Count != 0 || hs.Histogram.Buckets[0].Exemplars != nil || hs.Histogram.Sum != 0 {
+		t.Fatalf("unexpected histogram after reset %+v", hs.Histogram)
+	}
+
+	// the histogram keeps working after a reset
+	h.Record(3)
+	h.Record(0.25)
+	if s := h.(*BasicHistogram).Snapshot(); s.Count != 2 || s.Min != 0.25 || s.Max != 3 || s.Buckets[0].Count != 1 {
+		t.Fatalf("unexpected histogram after recording %+v", s)
+	}
+	p.Counter("c").Add(2)
+
+	d := DiffSnapshots(before, p.Snapshot())
+	if c, _ := d.Get(InstrumentTypeCounter, "c"); !c.Reset || c.Delta != 2 {
+		t.Fatalf("expected reset counter delta from zero, got %+v", c)
+	}
+	if u, _ := d.Get(InstrumentTypeUpDown, "u"); !u.Reset || u.Delta != 0 {
+		t.Fatalf("expected reset updown delta from zero, got %+v", u)
+	}
+	if h, _ := d.Get(InstrumentTypeHistogram, "h"); !h.Reset || h.Histogram.Count != 2 {
+		t.Fatalf("expected reset histogram delta from zero, got %+v", h)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, p.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"c_created 1001.000\n", "h_created 1001.000\n", "w_created 1001.000\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestBasicHistogram_ResetConcurrent(t *testing.T) {
+	h := newBasicHistogram(nil, nil)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					h.Record(1)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		h.Reset()
+		s := h.Snapshot()
+		if float64(s.Count) != s.Sum {
+			t.Errorf("inconsistent snapshot %+v", s)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+	h.Reset()
+	if s := h.Snapshot(); s.Count != 0 {
+		t.Fatalf("count = %d; want 0", s.Count)
+	}
+	h.Record(1)
+	if s := h.Snapshot(); s.Count != 1 {
+		t.Fatalf("count = %d; want 1", s.Count)
+	}
+}
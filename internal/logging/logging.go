@@ -0,0 +1,18 @@
+// Package logging holds the logger interface shared by the packages of the module.
+package logging
+
+// Logger is the logger accepted by the logger options of the packages.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Noop is a Logger discarding every message.
+type Noop struct{}
+
+func (Noop) Debugf(string, ...interface{}) {}
+func (Noop) Infof(string, ...interface{})  {}
+func (Noop) Warnf(string, ...interface{})  {}
+func (Noop) Errorf(string, ...interface{}) {}
@@ -0,0 +1,158 @@
+package metricstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// FakeClock is a metrics.Clock whose time only moves when advanced explicitly. Timers and
+// tickers created from it fire, in deadline order, when Advance or Set moves the time past
+// their deadlines. Like those of the time package, their channels have a buffer of one and
+// ticks are dropped if the receiver falls behind. FakeClock is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// defaultFakeTime is the initial time of a FakeClock constructed with a zero time.
+var defaultFakeTime = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// NewFakeClock returns a FakeClock set to start, or to 2024-01-01T00:00:00Z if start is zero.
+func NewFakeClock(start time.Time) *FakeClock {
+	if start.IsZero() {
+		start = defaultFakeTime
+	}
+	return &FakeClock{now: start}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the time forward by d and fires the timers and tickers that became due.
+// Negative durations move the time backwards without firing anything.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(c.now.Add(d))
+}
+
+// Set sets the time to t and fires the timers and tickers that became due.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(t)
+}
+
+// Waiters returns the number of active timers and tickers, e.g. to wait until the code under
+// test has started waiting before advancing the clock.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// NewTimer returns a timer firing once the time reaches Now()+d.
+func (c *FakeClock) NewTimer(d time.Duration) metrics.ClockTimer {
+	w := &fakeWaiter{clock: c, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scheduleLocked(w, d)
+	return fakeTimer{w}
+}
+
+// NewTicker returns a ticker firing every d of fake time. It panics if d is not positive.
+func (c *FakeClock) NewTicker(d time.Duration) metrics.ClockTicker {
+	if d <= 0 {
+		panic("metricstest: non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{clock: c, ch: make(chan time.Time, 1), period: d}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scheduleLocked(w, d)
+	return fakeTicker{w}
+}
+
+func (c *FakeClock) setLocked(t time.Time) {
+	for {
+		// fire the earliest due waiter first so that tickers and timers interleave correctly
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].deadline.Before(c.waiters[j].deadline) })
+		if len(c.waiters) == 0 || c.waiters[0].deadline.After(t) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.deadline
+		select {
+		case w.ch <- w.deadline:
+		default: // receiver is behind: drop the tick
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			c.removeLocked(w)
+		}
+	}
+	c.now = t
+}
+
+func (c *FakeClock) scheduleLocked(w *fakeWaiter, d time.Duration) {
+	w.deadline = c.now.Add(d)
+	c.waiters = append(c.waiters, w)
+	if d <= 0 && w.period == 0 {
+		c.setLocked(c.now) // fire immediately
+	}
+}
+
+// removeLocked removes w and reports whether it was active.
+func (c *FakeClock) removeLocked(w *fakeWaiter) bool {
+	for i, o := range c.waiters {
+		if o == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeWaiter struct {
+	clock    *FakeClock
+	ch       chan time.Time
+	deadline time.Time
+	period   time.Duration // zero for timers
+}
+
+type fakeTimer struct{ w *fakeWaiter }
+
+func (t fakeTimer) C() <-chan time.Time { return t.w.ch }
+
+func (t fakeTimer) Stop() bool {
+	t.w.clock.mu.Lock()
+	defer t.w.clock.mu.Unlock()
+	return t.w.clock.removeLocked(t.w)
+}
+
+func (t fakeTimer) Reset(d time.Duration) bool {
+	c := t.w.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := c.removeLocked(t.w)
+	c.scheduleLocked(t.w, d)
+	return active
+}
+
+type fakeTicker struct{ w *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.ch }
+
+func (t fakeTicker) Stop() {
+	t.w.clock.mu.Lock()
+	defer t.w.clock.mu.Unlock()
+	t.w.clock.removeLocked(t.w)
+}
@@ -0,0 +1,113 @@
+package metricstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+var _ metrics.Clock = (*FakeClock)(nil)
+
+func TestFakeClock_Timers(t *testing.T) {
+	c := NewFakeClock(time.Time{})
+	start := c.Now()
+	if !start.Equal(defaultFakeTime) {
+		t.Fatalf("unexpected start time %v", start)
+	}
+
+	tm := c.NewTimer(10 * time.Second)
+	if c.Waiters() != 1 {
+		t.Fatalf("expected one waiter")
+	}
+	c.Advance(9 * time.Second)
+	select {
+	case <-tm.C():
+		t.Fatalf("timer fired early")
+	default:
+	}
+	c.Advance(time.Second)
+	if got := <-tm.C(); !got.Equal(start.Add(10 * time.Second)) {
+		t.Fatalf("timer fired at %v", got)
+	}
+	if tm.Stop() || c.Waiters() != 0 {
+		t.Fatalf("expired timer must be inactive")
+	}
+
+	if tm.Reset(time.Second) {
+		t.Fatalf("Reset of an expired timer must report false")
+	}
+	if !tm.Reset(5*time.Second) || !tm.Stop() {
+		t.Fatalf("expected an active timer")
+	}
+	c.Advance(time.Minute)
+	select {
+	case <-tm.C():
+		t.Fatalf("stopped timer fired")
+	default:
+	}
+
+	// non-positive durations fire immediately
+	if got := <-c.NewTimer(0).C(); !got.Equal(c.Now()) {
+		t.Fatalf("immediate timer fired at %v", got)
+	}
+}
+
+func TestFakeClock_Ticker(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	tk := c.NewTicker(time.Second)
+	tm := c.NewTimer(1500 * time.Millisecond)
+
+	// timers and tickers fire in deadline order, with Now at their deadline
+	c.Advance(2 * time.Second)
+	if got := <-tk.C(); got.Unix() != 1 {
+		t.Fatalf("first tick at %v", got)
+	}
+	if got := <-tm.C(); got.UnixMilli() != 1500 {
+		t.Fatalf("timer at %v", got)
+	}
+	select {
+	case <-tk.C():
+		t.Fatalf("second tick must have been dropped as the first was not yet received")
+	default:
+	}
+	if !c.Now().Equal(time.Unix(2, 0)) {
+		t.Fatalf("now = %v", c.Now())
+	}
+
+	c.Set(time.Unix(3, 0))
+	if got := <-tk.C(); got.Unix() != 3 {
+		t.Fatalf("tick at %v", got)
+	}
+	tk.Stop()
+	c.Advance(-time.Hour)
+	c.Advance(2 * time.Hour)
+	if c.Waiters() != 0 {
+		t.Fatalf("expected no waiters after Stop")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for a non-positive ticker interval")
+		}
+	}()
+	c.NewTicker(0)
+}
+
+func TestFakeClock_DrivesProvider(t *testing.T) {
+	c := NewFakeClock(time.Time{})
+	p := NewProvider(t, metrics.WithClock(c))
+	h := p.Histogram("w", metrics.WithRollingWindow(metrics.HistogramWindow{Duration: time.Minute}))
+	h.Record(1)
+	tm := metrics.TimerFromProvider(p, "d")
+	sw := tm.Start()
+	c.Advance(2 * time.Minute)
+	if d := sw.Stop(); d != 2*time.Minute {
+		t.Fatalf("stopwatch = %v; want 2m", d)
+	}
+
+	AssertHistogramCount(t, p, "w", 0)
+	if snap := p.Snapshot(); !snap.Time.Equal(c.Now()) {
+		t.Fatalf("snapshot time = %v; want %v", snap.Time, c.Now())
+	}
+}
@@ -0,0 +1,206 @@
+package metricstest
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// UpdateEnv is the environment variable making AssertGolden write golden files when set to
+// "1" or "true".
+const UpdateEnv = "METRICSTEST_UPDATE"
+
+// updateGolden reports whether the tests run with UpdateEnv set or, if the test package
+// defines an -update flag itself, with -update. The flag is looked up on every call: it is
+// defined, if at all, by the tests of the caller, after this package is initialized.
+func updateGolden() bool {
+	if v, err := strconv.ParseBool(os.Getenv(UpdateEnv)); err == nil {
+		return v
+	}
+	f := flag.Lookup("update")
+	return f != nil && f.Value.String() == "true"
+}
+
+// goldenDir is where golden files are stored, relative to the package under test.
+// It is a variable for tests.
+var goldenDir = "testdata"
+
+// GoldenOption configures FormatSnapshot and AssertGolden.
+type GoldenOption func(*goldenConfig)
+
+type goldenConfig struct {
+	ignoreTimestamps bool
+	ignoreExemplars  bool
+	sumTolerance     float64
+}
+
+// IgnoreTimestamps omits exemplar timestamps from the formatted snapshot.
+func IgnoreTimestamps() GoldenOption {
+	return func(c *goldenConfig) { c.ignoreTimestamps = true }
+}
+
+// IgnoreExemplars omits exemplars from the formatted snapshot.
+func IgnoreExemplars() GoldenOption {
+	return func(c *goldenConfig) { c.ignoreExemplars = true }
+}
+
+// WithSumTolerance makes AssertGolden accept histogram sums, minimums and maximums differing
+// from the golden ones by at most tol, e.g. for histograms of measured durations.
+func WithSumTolerance(tol float64) GoldenOption {
+	return func(c *goldenConfig) { c.sumTolerance = math.Abs(tol) }
+}
+
+func applyGoldenOptions(opts []GoldenOption) goldenConfig {
+	var cfg goldenConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+// FormatSnapshot renders snap in a deterministic, line-oriented text form: one line per
+// instrument in the snapshot order (type, then name), followed by indented lines for
+// histogram buckets, exemplars and quantiles. Attributes are sorted by key. The snapshot time is
+// never included.
+func FormatSnapshot(snap metrics.ProviderSnapshot, opts ...GoldenOption) string {
+	cfg := applyGoldenOptions(opts)
+	var b strings.Builder
+	for _, s := range snap.Instruments {
+		fmt.Fprintf(&b, "%s %s%s", s.Type, baseName(s.Name), formatAttrs(s.Config.Attributes))
+		if s.Config.Unit != "" {
+			fmt.Fprintf(&b, " unit=%q", s.Config.Unit)
+		}
+		if s.Config.Description != "" {
+			fmt.Fprintf(&b, " description=%q", s.Config.Description)
+		}
+		if s.Type != metrics.InstrumentTypeHistogram {
+			fmt.Fprintf(&b, " value=%d\n", s.Value)
+			writeExemplars(&b, "  ", s.Exemplars, cfg)
+			continue
+		}
+		h := s.Histogram
+		fmt.Fprintf(&b, " count=%d sum=%s", h.Count, formatFloat(h.Sum))
+		if h.Count > 0 {
+			fmt.Fprintf(&b, " min=%s max=%s", formatFloat(h.Min), formatFloat(h.Max))
+		}
+		b.WriteByte('\n')
+		for _, bk := range h.Buckets {
+			fmt.Fprintf(&b, "  bucket le=%s count=%d\n", formatFloat(bk.UpperBound), bk.Count)
+			writeExemplars(&b, "    ", bk.Exemplars, cfg)
+		}
+		for _, q := range h.Quantiles {
+			fmt.Fprintf(&b, "  quantile q=%s value=%s\n", formatFloat(q.Q), formatFloat(q.Value))
+		}
+	}
+	return b.String()
+}
+
+func writeExemplars(b *strings.Builder, indent string, exemplars []metrics.Exemplar, cfg goldenConfig) {
+	if cfg.ignoreExemplars {
+		return
+	}
+	for _, ex := range exemplars {
+		fmt.Fprintf(b, "%sexemplar value=%s", indent, formatFloat(ex.Value))
+		if ex.TraceID != "" {
+			fmt.Fprintf(b, " trace_id=%q", ex.TraceID)
+		}
+		if ex.SpanID != "" {
+			fmt.Fprintf(b, " span_id=%q", ex.SpanID)
+		}
+		if len(ex.Attributes) > 0 {
+			b.WriteString(" " + formatAttrs(ex.Attributes))
+		}
+		if !cfg.ignoreTimestamps {
+			b.WriteString(" time=" + ex.Timestamp.UTC().Format(time.RFC3339Nano))
+		}
+		b.WriteByte('\n')
+	}
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// AssertGolden compares the formatted snapshot of p (see FormatSnapshot) with the golden file
+// testdata/<name>.golden of the package under test and reports a line diff on mismatch.
+// Running the tests with METRICSTEST_UPDATE=1 (see UpdateEnv), or with -update if the test
+// package defines that flag, writes the golden file instead.
+func AssertGolden(t testing.TB, p Snapshotter, name string, opts ...GoldenOption) bool {
+	t.Helper()
+	got := FormatSnapshot(p.Snapshot(), opts...)
+	path := filepath.Join(goldenDir, name+".golden")
+
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Errorf("creating golden directory: %v", err)
+			return false
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil { //nolint:gosec // golden files are not secret
+			t.Errorf("writing golden file: %v", err)
+			return false
+		}
+		return true
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("reading golden file (run the tests with "+UpdateEnv+"=1 to create it): %v", err)
+		return false
+	}
+	want := string(raw)
+	if goldenEqual(want, got, applyGoldenOptions(opts)) {
+		return true
+	}
+	t.Errorf("provider state differs from %s (run the tests with "+UpdateEnv+"=1 to accept it):\n%s", path, lineDiff(want, got))
+	return false
+}
+
+// goldenEqual compares formatted snapshots token by token, applying the sum tolerance to
+// sum, min and max fields.
+func goldenEqual(want, got string, cfg goldenConfig) bool {
+	if want == got {
+		return true
+	}
+	if cfg.sumTolerance == 0 {
+		return false
+	}
+	wl, gl := strings.Split(want, "\n"), strings.Split(got, "\n")
+	if len(wl) != len(gl) {
+		return false
+	}
+	for i := range wl {
+		wf, gf := strings.Split(wl[i], " "), strings.Split(gl[i], " ")
+		if len(wf) != len(gf) {
+			return false
+		}
+		for j := range wf {
+			if wf[j] != gf[j] && !withinTolerance(wf[j], gf[j], cfg.sumTolerance) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func withinTolerance(want, got string, tol float64) bool {
+	for _, field := range []string{"sum=", "min=", "max="} {
+		if !strings.HasPrefix(want, field) || !strings.HasPrefix(got, field) {
+			continue
+		}
+		w, err1 := strconv.ParseFloat(want[len(field):], 64)
+		g, err2 := strconv.ParseFloat(got[len(field):], 64)
+		return err1 == nil && err2 == nil && math.Abs(w-g) <= tol
+	}
+	return false
+}
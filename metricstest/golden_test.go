@@ -0,0 +1,138 @@
+package metricstest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// goldenProvider returns a provider with one instrument of each type.
+func goldenProvider(t *testing.T, sum float64) *metrics.BasicProvider {
+	p := NewProvider(t)
+	c := p.Counter("requests_total", metrics.WithAttributes(map[string]string{"route": "/"}), metrics.WithDescription("requests"))
+	metrics.AddWithExemplar(c, 2, metrics.Exemplar{TraceID: "t1", Timestamp: time.Unix(1, 0)})
+	p.UpDownCounter("inflight").Add(1)
+	h := p.Histogram("latency", metrics.WithUnit("s"), metrics.WithBuckets(1))
+	metrics.RecordWithExemplar(h, 0.5, metrics.Exemplar{TraceID: "t2", SpanID: "s2", Attributes: map[string]string{"k": "v"}})
+	h.Record(sum - 0.5)
+	return p
+}
+
+func TestFormatSnapshot(t *testing.T) {
+	p := goldenProvider(t, 3)
+	want := `counter requests_total{route="/"} description="requests" value=2
+  exemplar value=2 trace_id="t1" time=1970-01-01T00:00:01Z
+histogram latency unit="s" count=2 sum=3 min=0.5 max=2.5
+  bucket le=1 count=1
+    exemplar value=0.5 trace_id="t2" span_id="s2" {k="v"}
+  bucket le=+Inf count=1
+updown inflight value=1
+`
+	if got := FormatSnapshot(p.Snapshot(), IgnoreTimestamps()); got != strings.Replace(want, " time=1970-01-01T00:00:01Z", "", 1) {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+	got := FormatSnapshot(p.Snapshot())
+	if !strings.Contains(got, " time=1970-01-01T00:00:01Z\n") {
+		t.Fatalf("expected exemplar timestamp in output:\n%s", got)
+	}
+	if got := FormatSnapshot(p.Snapshot(), IgnoreExemplars()); strings.Contains(got, "exemplar") {
+		t.Fatalf("expected no exemplars in output:\n%s", got)
+	}
+	if got := FormatSnapshot(metrics.NewBasicProvider().Snapshot()); got != "" {
+		t.Fatalf("expected empty output, got %q", got)
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	AssertGolden(t, goldenProvider(t, 3), "provider", IgnoreTimestamps())
+
+	// within tolerance
+	AssertGolden(t, goldenProvider(t, 3.01), "provider", IgnoreTimestamps(), WithSumTolerance(0.1))
+
+	ft := &fakeT{TB: t}
+	if AssertGolden(ft, goldenProvider(t, 3.01), "provider", IgnoreTimestamps()) {
+		t.Fatalf("expected mismatch without tolerance")
+	}
+	wantDiff := "- histogram latency unit=\"s\" count=2 sum=3 min=0.5 max=2.5\n" +
+		"+ histogram latency unit=\"s\" count=2 sum=3.01 min=0.5 max=2.51\n"
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], wantDiff) {
+		t.Fatalf("unexpected failure %q", ft.errors)
+	}
+
+	ft = &fakeT{TB: t}
+	if AssertGolden(ft, goldenProvider(t, 5), "provider", IgnoreTimestamps(), WithSumTolerance(0.1)) {
+		t.Fatalf("expected mismatch beyond tolerance")
+	}
+	ft = &fakeT{TB: t}
+	if AssertGolden(ft, goldenProvider(t, 3), "missing", IgnoreTimestamps()) || !strings.Contains(ft.errors[0], "METRICSTEST_UPDATE=1") {
+		t.Fatalf("expected missing golden file failure, got %q", ft.errors)
+	}
+}
+
+// update is defined like in the tests of callers, which metricstest must not conflict with.
+var update = flag.Bool("update", false, "update golden files")
+
+func TestAssertGolden_Update(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testdata")
+	old := goldenDir
+	goldenDir = dir
+	t.Cleanup(func() { goldenDir = old })
+
+	for _, c := range []struct {
+		name string
+		env  string
+		flag bool
+	}{
+		{"env", "1", false},
+		{"flag", "", true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv(UpdateEnv, c.env)
+			*update = c.flag
+			t.Cleanup(func() { *update = false })
+
+			p := goldenProvider(t, 3)
+			if !AssertGolden(t, p, c.name) {
+				t.Fatalf("expected update to succeed")
+			}
+			raw, err := os.ReadFile(filepath.Join(dir, c.name+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != FormatSnapshot(p.Snapshot()) {
+				t.Fatalf("unexpected golden file content:\n%s", raw)
+			}
+		})
+	}
+
+	// METRICSTEST_UPDATE=0 overrides the flag
+	t.Setenv(UpdateEnv, "0")
+	*update = true
+	t.Cleanup(func() { *update = false })
+	if updateGolden() {
+		t.Fatal("expected no update")
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a\nb\nc\n", "a\nx\nc\nd\n")
+	want := "  a\n- b\n+ x\n  c\n+ d\n"
+	if got != want {
+		t.Fatalf("lineDiff = %q; want %q", got, want)
+	}
+}
+
+func TestFormatSnapshot_Quantiles(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	h := p.Histogram("w", metrics.WithRollingWindow(metrics.HistogramWindow{Duration: time.Hour, Quantiles: []float64{0.5}}))
+	h.Record(2)
+	want := "histogram w count=1 sum=2 min=2 max=2\n  bucket le=+Inf count=1\n  quantile q=0.5 value=2\n"
+	if got := FormatSnapshot(p.Snapshot()); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
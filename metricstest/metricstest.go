@@ -0,0 +1,271 @@
+// Package metricstest provides helpers for testing code instrumented with the metrics package.
+//
+// Assertions work on provider snapshots (see metrics.BasicProvider.Snapshot), so they do not
+// depend on concrete instrument types. On failure they report a diff of the expected and
+// actual state of the instrument in question, followed by the full provider state.
+//
+//	p := metricstest.NewProvider(t)
+//	handler := newHandler(p)
+//	handler.ServeHTTP(rec, req)
+//	metricstest.AssertCounter(t, p, "requests_total", 1)
+package metricstest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// Snapshotter is implemented by providers able to snapshot their state, such as
+// metrics.BasicProvider.
+type Snapshotter interface {
+	Snapshot() metrics.ProviderSnapshot
+}
+
+// NewProvider returns a BasicProvider suited for tests: its log output goes to t.Logf and it
+// keeps every exemplar offered (metrics.ExemplarFilterAlwaysOn). opts are applied last and may
+// override these defaults.
+func NewProvider(t testing.TB, opts ...metrics.BasicProviderOption) *metrics.BasicProvider {
+	t.Helper()
+	all := make([]metrics.BasicProviderOption, 0, len(opts)+2)
+	all = append(all,
+		metrics.WithBasicProviderLogger(testLogger{t}),
+		metrics.WithExemplarFilter(metrics.ExemplarFilterAlwaysOn),
+	)
+	return metrics.NewBasicProvider(append(all, opts...)...)
+}
+
+// testLogger forwards provider log messages to the test log.
+type testLogger struct{ t testing.TB }
+
+func (l testLogger) Debugf(format string, args ...interface{}) { l.logf("DEBUG", format, args...) }
+func (l testLogger) Infof(format string, args ...interface{})  { l.logf("INFO", format, args...) }
+func (l testLogger) Warnf(format string, args ...interface{})  { l.logf("WARN", format, args...) }
+func (l testLogger) Errorf(format string, args ...interface{}) { l.logf("ERROR", format, args...) }
+
+func (l testLogger) logf(level, format string, args ...interface{}) {
+	l.t.Helper()
+	l.t.Logf(level+" "+format, args...)
+}
+
+// AssertCounter checks that the counter name exists and has the value want.
+func AssertCounter(t testing.TB, p Snapshotter, name string, want int64) bool {
+	t.Helper()
+	return assertValue(t, p.Snapshot(), metrics.InstrumentTypeCounter, name, want)
+}
+
+// AssertUpDownCounter checks that the up/down counter name exists and has the value want.
+func AssertUpDownCounter(t testing.TB, p Snapshotter, name string, want int64) bool {
+	t.Helper()
+	return assertValue(t, p.Snapshot(), metrics.InstrumentTypeUpDown, name, want)
+}
+
+func assertValue(t testing.TB, snap metrics.ProviderSnapshot, itype metrics.InstrumentType, name string, want int64) bool {
+	t.Helper()
+	s, ok := snap.Get(itype, name)
+	switch {
+	case !ok:
+		t.Errorf("%s %q not found\n%s", itype, name, missingDiff(snap, itype, name))
+		return false
+	case s.Value != want:
+		expected := s
+		expected.Value = want
+		t.Errorf("%s %q = %d; want %d\n%s", itype, name, s.Value, want, instrumentDiff(snap, expected, s))
+		return false
+	}
+	return true
+}
+
+// AssertHistogramCount checks that the histogram name exists and has recorded want values.
+func AssertHistogramCount(t testing.TB, p Snapshotter, name string, want int64) bool {
+	t.Helper()
+	snap := p.Snapshot()
+	s, ok := snap.Get(metrics.InstrumentTypeHistogram, name)
+	switch {
+	case !ok:
+		t.Errorf("histogram %q not found\n%s", name, missingDiff(snap, metrics.InstrumentTypeHistogram, name))
+		return false
+	case s.Histogram.Count != want:
+		expected := s
+		expected.Histogram.Count = want
+		t.Errorf("histogram %q count = %d; want %d\n%s", name, s.Histogram.Count, want, instrumentDiff(snap, expected, s))
+		return false
+	}
+	return true
+}
+
+// AssertHistogramSum checks that the histogram name exists and that the sum of its recorded
+// values is within tolerance of want.
+func AssertHistogramSum(t testing.TB, p Snapshotter, name string, want, tolerance float64) bool {
+	t.Helper()
+	snap := p.Snapshot()
+	s, ok := snap.Get(metrics.InstrumentTypeHistogram, name)
+	switch {
+	case !ok:
+		t.Errorf("histogram %q not found\n%s", name, missingDiff(snap, metrics.InstrumentTypeHistogram, name))
+		return false
+	case s.Histogram.Sum < want-tolerance || s.Histogram.Sum > want+tolerance:
+		expected := s
+		expected.Histogram.Sum = want
+		t.Errorf("histogram %q sum = %g; want %g (±%g)\n%s", name, s.Histogram.Sum, want, tolerance,
+			instrumentDiff(snap, expected, s))
+		return false
+	}
+	return true
+}
+
+// AssertNoInstrument checks that the provider has no instrument of the given type and name.
+func AssertNoInstrument(t testing.TB, p Snapshotter, itype metrics.InstrumentType, name string) bool {
+	t.Helper()
+	snap := p.Snapshot()
+	if s, ok := snap.Get(itype, name); ok {
+		t.Errorf("unexpected %s %q\n%s", itype, name, "+ "+instrumentLine(s)+"\n"+Dump(snap))
+		return false
+	}
+	return true
+}
+
+// instrumentDiff returns the diff of the Dump lines of the expected and actual state of an
+// instrument, followed by the provider state.
+func instrumentDiff(snap metrics.ProviderSnapshot, want, got metrics.InstrumentSnapshot) string {
+	return lineDiff(instrumentLine(want), instrumentLine(got)) + "\n" + Dump(snap)
+}
+
+// missingDiff returns the diff for the missing instrument name: its expected line, with
+// the instruments of the same base name and type, if any, as the actual ones.
+func missingDiff(snap metrics.ProviderSnapshot, itype metrics.InstrumentType, name string) string {
+	var got []string
+	for _, s := range snap.Instruments {
+		if s.Type == itype && baseName(s.Name) == baseName(name) {
+			got = append(got, instrumentLine(s))
+		}
+	}
+	want := fmt.Sprintf("%s %s", itype, name)
+	if len(got) == 0 {
+		return "- " + want + "\n" + Dump(snap)
+	}
+	return lineDiff(want, strings.Join(got, "\n")) + "\n" + Dump(snap)
+}
+
+// pollInterval is how often WaitForCounter re-reads the provider state.
+const pollInterval = 5 * time.Millisecond
+
+// WaitForCounter waits until the counter name reaches at least want, for code updating
+// counters asynchronously. It fails the test if ctx is done first; use a context with a
+// deadline to bound the wait.
+func WaitForCounter(ctx context.Context, t testing.TB, p Snapshotter, name string, want int64) bool {
+	t.Helper()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		snap := p.Snapshot()
+		if s, ok := snap.Get(metrics.InstrumentTypeCounter, name); ok && s.Value >= want {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			snap = p.Snapshot()
+			got := "not found"
+			if s, ok := snap.Get(metrics.InstrumentTypeCounter, name); ok {
+				got = fmt.Sprint(s.Value)
+			}
+			t.Errorf("counter %q did not reach %d: %v (last value: %s)\n%s", name, want, ctx.Err(), got, Dump(snap))
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dump renders the provider state, one instrument per line, in a stable human-readable form.
+func Dump(snap metrics.ProviderSnapshot) string {
+	var b strings.Builder
+	b.WriteString("provider state:\n")
+	if len(snap.Instruments) == 0 {
+		b.WriteString("  (no instruments)\n")
+	}
+	for _, s := range snap.Instruments {
+		b.WriteString("  " + instrumentLine(s) + "\n")
+	}
+	return b.String()
+}
+
+// instrumentLine renders the state of an instrument as a line of Dump.
+func instrumentLine(s metrics.InstrumentSnapshot) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s%s", s.Type, baseName(s.Name), formatAttrs(s.Config.Attributes))
+	if s.Type == metrics.InstrumentTypeHistogram {
+		h := s.Histogram
+		fmt.Fprintf(&b, " count=%d sum=%g", h.Count, h.Sum)
+		if h.Count > 0 {
+			fmt.Fprintf(&b, " min=%g max=%g", h.Min, h.Max)
+		}
+	} else {
+		fmt.Fprintf(&b, " = %d", s.Value)
+	}
+	return b.String()
+}
+
+// baseName returns the instrument name of a series name (see metrics.SeriesName); its
+// attributes are also in the instrument configuration.
+func baseName(name string) string {
+	base, _ := metrics.ParseSeriesName(name)
+	return base
+}
+
+func formatAttrs(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, attrs[k]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// lineDiff returns a minimal line diff of want and got, with "-" marking lines only in want
+// and "+" lines only in got.
+func lineDiff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return strings.TrimSuffix(out.String(), "  \n")
+}
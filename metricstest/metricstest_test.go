@@ -0,0 +1,175 @@
+package metricstest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ygrebnov/metrics"
+)
+
+// fakeT records failures instead of failing the enclosing test.
+type fakeT struct {
+	testing.TB
+	errors []string
+	logs   []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Logf(format string, args ...interface{}) {
+	f.logs = append(f.logs, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions_Pass(t *testing.T) {
+	p := NewProvider(t)
+	p.Counter("c").Add(3)
+	p.UpDownCounter("u").Add(-2)
+	h := p.Histogram("h")
+	h.Record(1.5)
+	h.Record(2.5)
+
+	ft := &fakeT{TB: t}
+	ok := AssertCounter(ft, p, "c", 3) &&
+		AssertUpDownCounter(ft, p, "u", -2) &&
+		AssertHistogramCount(ft, p, "h", 2) &&
+		AssertHistogramSum(ft, p, "h", 4, 1e-9) &&
+		AssertNoInstrument(ft, p, metrics.InstrumentTypeCounter, "missing")
+	if !ok || len(ft.errors) != 0 {
+		t.Fatalf("unexpected failures: %v", ft.errors)
+	}
+}
+
+func TestAssertions_Fail(t *testing.T) {
+	p := NewProvider(t)
+	p.Counter("c", metrics.WithAttributes(map[string]string{"route": "/"})).Add(1)
+	p.Histogram("h").Record(1)
+
+	cases := []struct {
+		name string
+		fn   func(tb testing.TB) bool
+		msg  string
+		diff string
+	}{
+		{"counter value", func(tb testing.TB) bool { return AssertCounter(tb, p, "c", 2) }, `counter "c" = 1; want 2`,
+			"- counter c{route=\"/\"} = 2\n+ counter c{route=\"/\"} = 1\n"},
+		{"counter missing", func(tb testing.TB) bool { return AssertCounter(tb, p, "x", 2) }, `counter "x" not found`,
+			"- counter x\n"},
+		{"series missing", func(tb testing.TB) bool { return AssertCounter(tb, p, `c{route="/x"}`, 2) }, `counter "c{route=\"/x\"}" not found`,
+			"- counter c{route=\"/x\"}\n+ counter c{route=\"/\"} = 1\n"},
+		{"updown missing", func(tb testing.TB) bool { return AssertUpDownCounter(tb, p, "c", 1) }, `updown "c" not found`,
+			"- updown c\n"},
+		{"histogram count", func(tb testing.TB) bool { return AssertHistogramCount(tb, p, "h", 3) }, `histogram "h" count = 1; want 3`,
+			"- histogram h count=3 sum=1 min=1 max=1\n+ histogram h count=1 sum=1 min=1 max=1\n"},
+		{"histogram count missing", func(tb testing.TB) bool { return AssertHistogramCount(tb, p, "x", 3) }, `histogram "x" not found`,
+			"- histogram x\n"},
+		{"histogram sum", func(tb testing.TB) bool { return AssertHistogramSum(tb, p, "h", 2, 0.5) }, `histogram "h" sum = 1; want 2 (±0.5)`,
+			"- histogram h count=1 sum=2 min=1 max=1\n+ histogram h count=1 sum=1 min=1 max=1\n"},
+		{"histogram sum missing", func(tb testing.TB) bool { return AssertHistogramSum(tb, p, "x", 2, 0.5) }, `histogram "x" not found`,
+			"- histogram x\n"},
+		{"unexpected instrument", func(tb testing.TB) bool {
+			return AssertNoInstrument(tb, p, metrics.InstrumentTypeCounter, "c")
+		}, `unexpected counter "c"`, "+ counter c{route=\"/\"} = 1\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ft := &fakeT{TB: t}
+			if c.fn(ft) {
+				t.Fatalf("expected assertion to fail")
+			}
+			if len(ft.errors) != 1 || !strings.HasPrefix(ft.errors[0], c.msg+"\n"+c.diff) {
+				t.Fatalf("unexpected failure message %q; want prefix %q", ft.errors, c.msg+"\n"+c.diff)
+			}
+			if !strings.Contains(ft.errors[0], `  counter c{route="/"} = 1`) {
+				t.Fatalf("expected failure message to include provider state, got %q", ft.errors[0])
+			}
+		})
+	}
+}
+
+func TestWaitForCounter(t *testing.T) {
+	p := NewProvider(t)
+	c := p.Counter("jobs")
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(time.Millisecond)
+			c.Add(1)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !WaitForCounter(ctx, t, p, "jobs", 3) {
+		t.Fatalf("expected counter to reach 3")
+	}
+
+	ft := &fakeT{TB: t}
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	if WaitForCounter(short, ft, p, "jobs", 10) {
+		t.Fatalf("expected wait to time out")
+	}
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "(last value: 3)") {
+		t.Fatalf("unexpected failure message %q", ft.errors)
+	}
+
+	ft = &fakeT{TB: t}
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if WaitForCounter(cancelled, ft, p, "missing", 1) || !strings.Contains(ft.errors[0], "(last value: not found)") {
+		t.Fatalf("unexpected failure message %q", ft.errors)
+	}
+}
+
+func TestDump(t *testing.T) {
+	p := metrics.NewBasicProvider()
+	if got := Dump(p.Snapshot()); got != "provider state:\n  (no instruments)\n" {
+		t.Fatalf("unexpected empty dump %q", got)
+	}
+	p.UpDownCounter("u").Add(2)
+	p.Histogram("empty")
+	h := p.Histogram("h")
+	h.Record(1)
+	h.Record(3)
+	p.Counter("c", metrics.WithAttributes(map[string]string{"b": "2", "a": "1"})).Add(1)
+
+	want := "provider state:\n" +
+		"  counter c{a=\"1\",b=\"2\"} = 1\n" +
+		"  histogram empty count=0 sum=0\n" +
+		"  histogram h count=2 sum=4 min=1 max=3\n" +
+		"  updown u = 2\n"
+	if got := Dump(p.Snapshot()); got != want {
+		t.Fatalf("unexpected dump:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	ft := &fakeT{TB: t}
+	p := NewProvider(ft)
+	metrics.AddWithExemplar(p.Counter("c"), 1, metrics.Exemplar{}) // kept without a trace ID
+	if s, _ := p.Snapshot().Get(metrics.InstrumentTypeCounter, "c"); len(s.Exemplars) != 1 {
+		t.Fatalf("expected exemplars to be always on, got %+v", s.Exemplars)
+	}
+
+	l := testLogger{ft}
+	l.Debugf("d %d", 1)
+	l.Infof("i")
+	l.Warnf("w")
+	l.Errorf("e")
+	want := []string{"DEBUG d 1", "INFO i", "WARN w", "ERROR e"}
+	if fmt.Sprint(ft.logs) != fmt.Sprint(want) {
+		t.Fatalf("logs = %q; want %q", ft.logs, want)
+	}
+
+	// caller options override the defaults
+	p = NewProvider(t, metrics.WithExemplarFilter(metrics.ExemplarFilterAlwaysOff))
+	metrics.AddWithExemplar(p.Counter("c"), 1, metrics.Exemplar{TraceID: "t"})
+	if s, _ := p.Snapshot().Get(metrics.InstrumentTypeCounter, "c"); s.Exemplars != nil {
+		t.Fatalf("expected no exemplars, got %+v", s.Exemplars)
+	}
+}
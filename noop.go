@@ -30,3 +30,5 @@ func (noopUpDownCounter) Add(_ int64) {}
 type noopHistogram struct{}
 
 func (noopHistogram) Record(_ float64) {}
+
+func (noopHistogram) RecordN(_ float64, _ int64) {}
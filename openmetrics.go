@@ -0,0 +1,310 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenMetricsContentType is the HTTP content type of the OpenMetrics text format
+// written by WriteOpenMetrics.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// ErrFamilyCollision is reported by WriteOpenMetrics when instruments are skipped because
+// their family name collides with the family of other instruments.
+var ErrFamilyCollision = errors.New("metrics: OpenMetrics family name collision")
+
+// WriteOpenMetrics writes snap to w in the OpenMetrics text exposition format.
+//
+// Counters are exposed as counter families (with a "_total" sample suffix; a "_total" suffix
+// in the instrument name is not repeated), up/down counters as gauges and histograms as
+// histograms with cumulative buckets; counters, histograms and summaries get a _created sample
+// with the start of their accumulation period when known. Windowed histograms (see WithRollingWindow) are exposed
+// as summaries of their window with quantile samples. Instrument attributes become labels. The most recent
+// exemplar of a counter and of each histogram bucket is attached to the corresponding sample.
+// Names and label names are sanitized to the OpenMetrics character set. Series of merged
+// snapshots (see MergeSnapshots) are grouped into the family of their base name.
+//
+// Instruments whose family name collides with an earlier family, such as a counter and an
+// up/down counter both named "foo", or "a.b" and "a_b" once sanitized, would make the output
+// invalid: they are skipped, the rest is written, and an error wrapping ErrFamilyCollision
+// names them.
+func WriteOpenMetrics(w io.Writer, snap ProviderSnapshot) error {
+	bw := bufio.NewWriter(w)
+	fams, skipped := groupFamilies(snap.Instruments)
+	for _, fam := range fams {
+		writeFamilyHeader(bw, fam)
+		for _, s := range fam.instruments {
+			switch fam.typ {
+			case InstrumentTypeCounter:
+				writeSample(bw, fam.name+"_total", s.Config.Attributes, "", "", strconv.FormatInt(s.Value, 10))
+				writeExemplar(bw, s.Exemplars)
+				bw.WriteByte('\n')
+				writeCreated(bw, fam.name, s)
+			case InstrumentTypeUpDown:
+				writeSample(bw, fam.name, s.Config.Attributes, "", "", strconv.FormatInt(s.Value, 10))
+				bw.WriteByte('\n')
+			case InstrumentTypeHistogram:
+				if fam.omType == omSummary {
+					writeSummary(bw, fam.name, s)
+				} else {
+					writeHistogram(bw, fam.name, s)
+				}
+			}
+		}
+	}
+	bw.WriteString("# EOF\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("%w: skipped %s", ErrFamilyCollision, strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// omFamily is a group of instruments exposed under the same OpenMetrics family name.
+type omFamily struct {
+	name        string
+	base        string // instrument base name, see SeriesName
+	typ         InstrumentType
+	omType      string
+	instruments []InstrumentSnapshot
+}
+
+// groupFamilies groups instruments by sanitized family name, sorted by family name. A family
+// holds the instruments of a single type and base name (see SeriesName); the instruments
+// colliding with it are skipped and described in the returned list.
+func groupFamilies(in []InstrumentSnapshot) ([]*omFamily, []string) {
+	byName := make(map[string]*omFamily)
+	out := make([]*omFamily, 0, len(in))
+	var skipped []string
+	for _, s := range in {
+		omType, known := omTypes[s.Type]
+		if !known {
+			continue
+		}
+		if s.Type == InstrumentTypeHistogram && s.Config.Window != nil {
+			omType = omSummary
+		}
+		name := omFamilyName(s)
+		base, _ := ParseSeriesName(s.Name)
+		fam, ok := byName[name]
+		if !ok {
+			fam = &omFamily{name: name, base: base, typ: s.Type, omType: omType}
+			byName[name] = fam
+			out = append(out, fam)
+		} else if fam.base != base || fam.omType != omType {
+			skipped = append(skipped, fmt.Sprintf("%s %s (family %s of %s %s)",
+				s.Type, s.Name, name, fam.typ, fam.base))
+			continue
+		}
+		fam.instruments = append(fam.instruments, s)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, skipped
+}
+
+// omFamilyName returns the sanitized OpenMetrics family name of an instrument. Instruments
+// named by SeriesName belong to the family of their base name.
+func omFamilyName(s InstrumentSnapshot) string {
+	base, _ := ParseSeriesName(s.Name)
+	name := sanitizeMetricName(base)
+	if s.Type == InstrumentTypeCounter {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	return name
+}
+
+// omTypes maps instrument types to OpenMetrics metric types.
+var omTypes = map[InstrumentType]string{
+	InstrumentTypeCounter:   "counter",
+	InstrumentTypeUpDown:    "gauge",
+	InstrumentTypeHistogram: "histogram",
+}
+
+// omSummary is the OpenMetrics type of windowed histograms.
+const omSummary = "summary"
+
+func writeFamilyHeader(bw *bufio.Writer, fam *omFamily) {
+	bw.WriteString("# TYPE " + fam.name + " " + fam.omType + "\n")
+
+	// metadata of the first instrument describes the family
+	cfg := fam.instruments[0].Config
+	if unit := sanitizeMetricName(cfg.Unit); cfg.Unit != "" && strings.HasSuffix(fam.name, "_"+unit) {
+		bw.WriteString("# UNIT " + fam.name + " " + unit + "\n")
+	}
+	if cfg.Description != "" {
+		bw.WriteString("# HELP " + fam.name + " " + escapeHelp(cfg.Description) + "\n")
+	}
+}
+
+func writeHistogram(bw *bufio.Writer, family string, s InstrumentSnapshot) {
+	attrs := s.Config.Attributes
+	buckets := s.Histogram.Buckets
+	if len(buckets) == 0 {
+		// snapshots not produced by BasicHistogram may lack the +Inf bucket
+		buckets = []HistBucket{{UpperBound: math.Inf(1), Count: s.Histogram.Count}}
+	}
+	var cumulative int64
+	for _, b := range buckets {
+		cumulative += b.Count
+		writeSample(bw, family+"_bucket", attrs, "le", formatOMFloat(b.UpperBound), strconv.FormatInt(cumulative, 10))
+		writeExemplar(bw, b.Exemplars)
+		bw.WriteByte('\n')
+	}
+	writeSample(bw, family+"_count", attrs, "", "", strconv.FormatInt(s.Histogram.Count, 10))
+	bw.WriteByte('\n')
+	writeSample(bw, family+"_sum", attrs, "", "", formatOMFloat(s.Histogram.Sum))
+	bw.WriteByte('\n')
+	writeCreated(bw, family, s)
+}
+
+func writeSummary(bw *bufio.Writer, family string, s InstrumentSnapshot) {
+	attrs := s.Config.Attributes
+	for _, q := range s.Histogram.Quantiles {
+		writeSample(bw, family, attrs, "quantile", formatOMFloat(q.Q), formatOMFloat(q.Value))
+		bw.WriteByte('\n')
+	}
+	writeSample(bw, family+"_count", attrs, "", "", strconv.FormatInt(s.Histogram.Count, 10))
+	bw.WriteByte('\n')
+	writeSample(bw, family+"_sum", attrs, "", "", formatOMFloat(s.Histogram.Sum))
+	bw.WriteByte('\n')
+	writeCreated(bw, family, s)
+}
+
+// writeCreated writes the _created sample of a counter, histogram or summary: the start of
+// its accumulation period, if known (see InstrumentTimes.Start).
+func writeCreated(bw *bufio.Writer, family string, s InstrumentSnapshot) {
+	start := s.Times.Start()
+	if start.IsZero() {
+		return
+	}
+	writeSample(bw, family+"_created", s.Config.Attributes, "", "", formatOMTimestamp(start))
+	bw.WriteByte('\n')
+}
+
+// formatOMTimestamp formats t as Unix seconds with millisecond precision.
+func formatOMTimestamp(t time.Time) string {
+	ms := t.UnixMilli()
+	return strconv.FormatInt(ms/1000, 10) + "." + leftPad3(ms%1000)
+}
+
+// writeSample writes a sample line without the trailing newline. extraName/extraValue
+// is an additional label (e.g. le) written after the sorted attributes if extraName is set.
+func writeSample(bw *bufio.Writer, name string, attrs map[string]string, extraName, extraValue, value string) {
+	bw.WriteString(name)
+	if len(attrs) > 0 || extraName != "" {
+		bw.WriteByte('{')
+		first := true
+		for _, k := range sortedKeys(attrs) {
+			if k == extraName {
+				continue
+			}
+			writeLabel(bw, &first, sanitizeLabelName(k), attrs[k])
+		}
+		if extraName != "" {
+			writeLabel(bw, &first, extraName, extraValue)
+		}
+		bw.WriteByte('}')
+	}
+	bw.WriteString(" " + value)
+}
+
+// writeExemplar appends the most recent exemplar, if any, to the current sample line.
+func writeExemplar(bw *bufio.Writer, exemplars []Exemplar) {
+	if len(exemplars) == 0 {
+		return
+	}
+	ex := exemplars[len(exemplars)-1]
+	bw.WriteString(" # {")
+	first := true
+	if ex.TraceID != "" {
+		writeLabel(bw, &first, "trace_id", ex.TraceID)
+	}
+	if ex.SpanID != "" {
+		writeLabel(bw, &first, "span_id", ex.SpanID)
+	}
+	for _, k := range sortedKeys(ex.Attributes) {
+		writeLabel(bw, &first, sanitizeLabelName(k), ex.Attributes[k])
+	}
+	bw.WriteString("} " + formatOMFloat(ex.Value))
+	if !ex.Timestamp.IsZero() {
+		bw.WriteString(" " + formatOMTimestamp(ex.Timestamp))
+	}
+}
+
+func writeLabel(bw *bufio.Writer, first *bool, name, value string) {
+	if !*first {
+		bw.WriteByte(',')
+	}
+	*first = false
+	bw.WriteString(name + `="` + escapeLabelValue(value) + `"`)
+}
+
+func leftPad3(n int64) string {
+	if n < 0 {
+		n = -n
+	}
+	s := strconv.FormatInt(n, 10)
+	return strings.Repeat("0", 3-len(s)) + s
+}
+
+// formatOMFloat formats a float in the canonical OpenMetrics form (e.g. "1.0", "+Inf").
+func formatOMFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// sanitizeMetricName replaces characters not allowed in OpenMetrics metric names with '_'.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName replaces characters not allowed in OpenMetrics label names with '_'.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, r := range name {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(allowColon && r == ':') || (i > 0 && r >= '0' && r <= '9')
+		switch {
+		case valid:
+			b.WriteRune(r)
+		case i == 0 && r >= '0' && r <= '9':
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(v string) string { return helpEscaper.Replace(v) }
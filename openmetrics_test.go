@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	ts := time.Unix(1700000000, 123000000)
+	snap := ProviderSnapshot{Instruments: []InstrumentSnapshot{
+		{
+			Type: InstrumentTypeCounter, Name: "http.requests_total",
+			Config: InstrumentConfig{Description: "HTTP\nrequests", Attributes: map[string]string{"route": `/a"b`, "1st": "x"}},
+			Value:  7,
+			Exemplars: []Exemplar{
+				{Value: 1, TraceID: "old"},
+				{Value: 2, TraceID: "abc", SpanID: "def", Timestamp: ts, Attributes: map[string]string{"tenant": "t"}},
+			},
+		},
+		{
+			Type: InstrumentTypeHistogram, Name: "latency_seconds",
+			Config: InstrumentConfig{Unit: "seconds", Buckets: []float64{0.1, 1}},
+			Histogram: HistSnapshot{Count: 3, Sum: 1.5, Buckets: []HistBucket{
+				{UpperBound: 0.1, Count: 1, Exemplars: []Exemplar{{Value: 0.05, TraceID: "t1"}}},
+				{UpperBound: 1, Count: 2},
+				{UpperBound: math.Inf(1), Count: 0},
+			}},
+		},
+		{Type: InstrumentTypeHistogram, Name: "plain", Histogram: HistSnapshot{Count: 2, Sum: 4}},
+		{Type: InstrumentTypeUpDown, Name: "inflight", Value: -1},
+		{Type: InstrumentType("custom"), Name: "skipped"},
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, snap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# TYPE http_requests counter
+# HELP http_requests HTTP\nrequests
+http_requests_total{_1st="x",route="/a\"b"} 7 # {trace_id="abc",span_id="def",tenant="t"} 2.0 1700000000.123
+# TYPE inflight gauge
+inflight -1
+# TYPE latency_seconds histogram
+# UNIT latency_seconds seconds
+latency_seconds_bucket{le="0.1"} 1 # {trace_id="t1"} 0.05
+latency_seconds_bucket{le="1.0"} 3
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_count 3
+latency_seconds_sum 1.5
+# TYPE plain histogram
+plain_bucket{le="+Inf"} 2
+plain_count 2
+plain_sum 4.0
+# EOF
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestWriteOpenMetrics_FromProviderAndErrors(t *testing.T) {
+	clk := newManualClock()
+	p := NewBasicProvider(WithClock(clk))
+	p.Counter("c").Add(1)
+	if err := WriteOpenMetrics(failingWriter{}, p.Snapshot()); err == nil {
+		t.Fatalf("expected write error")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, p.Snapshot()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := buf.String(); got != "# TYPE c counter\nc_total 1\nc_created 1000.000\n# EOF\n" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestWriteOpenMetrics_FamilyCollisions(t *testing.T) {
+	p := NewBasicProvider()
+	p.Counter("foo").Add(1)
+	p.UpDownCounter("foo").Add(2)
+	p.UpDownCounter("a.b").Add(3)
+	p.UpDownCounter("a_b").Add(4)
+
+	var buf bytes.Buffer
+	err := WriteOpenMetrics(&buf, p.Snapshot())
+	if !errors.Is(err, ErrFamilyCollision) || !strings.Contains(err.Error(), "updown foo") ||
+		!strings.Contains(err.Error(), "updown a_b") {
+		t.Fatalf("expected the collisions to be reported, got %v", err)
+	}
+	// the earlier families are written once
+	want := "# TYPE a_b gauge\na_b 3\n# TYPE foo counter\nfoo_total 1\n"
+	if got := buf.String(); !strings.HasPrefix(got, want) || strings.Count(got, "# TYPE") != 2 {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestFormatOMFloatAndSanitize(t *testing.T) {
+	cases := map[float64]string{1: "1.0", 0.25: "0.25", 1e21: "1e+21", math.Inf(-1): "-Inf", math.NaN(): "NaN"}
+	for v, want := range cases {
+		if got := formatOMFloat(v); got != want {
+			t.Fatalf("formatOMFloat(%v) = %q; want %q", v, got, want)
+		}
+	}
+	if got := sanitizeMetricName("a:b-c"); got != "a:b_c" {
+		t.Fatalf("sanitizeMetricName = %q", got)
+	}
+	if got := sanitizeLabelName("a:b"); got != "a_b" {
+		t.Fatalf("sanitizeLabelName = %q", got)
+	}
+	if got := sanitizeMetricName(""); got != "_" {
+		t.Fatalf("sanitizeMetricName(\"\") = %q", got)
+	}
+}
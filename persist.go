@@ -0,0 +1,472 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotFormat and snapshotVersion identify files written by SaveSnapshot.
+// The version is bumped on incompatible changes of the persisted layout.
+const (
+	snapshotFormat  = "metrics-snapshot"
+	snapshotVersion = 1
+)
+
+var (
+	// ErrSnapshotCorrupt is returned when a snapshot file is truncated, malformed or fails
+	// its checksum.
+	ErrSnapshotCorrupt = errors.New("metrics: corrupt snapshot")
+	// ErrSnapshotVersion is returned when a snapshot file was written in an unsupported version.
+	ErrSnapshotVersion = errors.New("metrics: unsupported snapshot version")
+)
+
+// SaveSnapshot writes snap to path atomically: it writes a temporary file in the same
+// directory, syncs it, renames it over path and syncs the directory, so that readers and
+// restarts see either the previous or the new state, never a partial one. Counters, up/down
+// counters, histograms, metadata and creation and reset times are persisted; exemplars and
+// rates are not.
+func SaveSnapshot(path string, snap ProviderSnapshot) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("metrics: saving snapshot: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if err = EncodeSnapshot(tmp, snap); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("metrics: saving snapshot: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("metrics: saving snapshot: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("metrics: saving snapshot: %w", err)
+	}
+	if err = syncDir(dir); err != nil {
+		return fmt.Errorf("metrics: saving snapshot: %w", err)
+	}
+	return nil
+}
+
+// syncDir syncs the directory dir, making the renames into it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot. Errors wrap ErrSnapshotCorrupt or
+// ErrSnapshotVersion when the file content is unusable, or the os error when it cannot be
+// read (os.ErrNotExist if it does not exist).
+func LoadSnapshot(path string) (ProviderSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ProviderSnapshot{}, fmt.Errorf("metrics: loading snapshot: %w", err)
+	}
+	defer f.Close()
+	return DecodeSnapshot(f)
+}
+
+// snapshotEnvelope is the top-level layout of a snapshot file. Data is checksummed as is.
+type snapshotEnvelope struct {
+	Format  string          `json:"format"`
+	Version int             `json:"version"`
+	CRC32   uint32          `json:"crc32"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type persistedSnapshot struct {
+	Time        time.Time             `json:"time"`
+	Instruments []persistedInstrument `json:"instruments"`
+}
+
+type persistedInstrument struct {
+	Type        InstrumentType      `json:"type"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Unit        string              `json:"unit,omitempty"`
+	Attributes  map[string]string   `json:"attributes,omitempty"`
+	Buckets     []float64           `json:"buckets,omitempty"`
+	Window      *persistedWindow    `json:"window,omitempty"`
+	Durable     bool                `json:"durable,omitempty"`
+	Value       int64               `json:"value,omitempty"`
+	Histogram   *persistedHistogram `json:"histogram,omitempty"`
+	Created     time.Time           `json:"created"`
+	LastReset   *time.Time          `json:"last_reset,omitempty"`
+}
+
+type persistedWindow struct {
+	Duration   time.Duration `json:"duration"`
+	SubWindows int           `json:"sub_windows"`
+	Quantiles  []float64     `json:"quantiles,omitempty"`
+}
+
+type persistedHistogram struct {
+	Count int64     `json:"count"`
+	Sum   jsonFloat `json:"sum"`
+	// Min and Max are omitted for empty histograms, where they are infinite.
+	Min *jsonFloat `json:"min,omitempty"`
+	Max *jsonFloat `json:"max,omitempty"`
+	// BucketCounts are the per bucket counts, the +Inf bucket last.
+	BucketCounts []int64 `json:"bucket_counts,omitempty"`
+	// Sketch is the quantile sketch of a windowed histogram.
+	Sketch *persistedSketch `json:"sketch,omitempty"`
+}
+
+type persistedSketch struct {
+	Zero int64         `json:"zero,omitempty"`
+	Pos  map[int]int64 `json:"pos,omitempty"`
+	Neg  map[int]int64 `json:"neg,omitempty"`
+}
+
+// jsonFloat is a float64 encoding non-finite values, which JSON numbers cannot represent,
+// as the strings "+Inf", "-Inf" and "NaN".
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case `"+Inf"`:
+		*f = jsonFloat(math.Inf(1))
+	case `"-Inf"`:
+		*f = jsonFloat(math.Inf(-1))
+	case `"NaN"`:
+		*f = jsonFloat(math.NaN())
+	default:
+		var v float64
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*f = jsonFloat(v)
+	}
+	return nil
+}
+
+// EncodeSnapshot writes snap to w in the versioned, checksummed format of SaveSnapshot.
+func EncodeSnapshot(w io.Writer, snap ProviderSnapshot) error {
+	data, err := json.Marshal(toPersisted(snap))
+	if err != nil {
+		return fmt.Errorf("metrics: encoding snapshot: %w", err)
+	}
+	env := snapshotEnvelope{
+		Format: snapshotFormat, Version: snapshotVersion, CRC32: crc32.ChecksumIEEE(data), Data: data,
+	}
+	if err := json.NewEncoder(w).Encode(env); err != nil {
+		return fmt.Errorf("metrics: encoding snapshot: %w", err)
+	}
+	return nil
+}
+
+// DecodeSnapshot reads a snapshot written by EncodeSnapshot. Errors wrap ErrSnapshotCorrupt
+// or ErrSnapshotVersion when the content is unusable.
+func DecodeSnapshot(r io.Reader) (ProviderSnapshot, error) {
+	var env snapshotEnvelope
+	if err := json.NewDecoder(r).Decode(&env); err != nil {
+		return ProviderSnapshot{}, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	if env.Format != snapshotFormat {
+		return ProviderSnapshot{}, fmt.Errorf("%w: unknown format %q", ErrSnapshotCorrupt, env.Format)
+	}
+	if env.Version != snapshotVersion {
+		return ProviderSnapshot{}, fmt.Errorf("%w: %d", ErrSnapshotVersion, env.Version)
+	}
+	if crc32.ChecksumIEEE(env.Data) != env.CRC32 {
+		return ProviderSnapshot{}, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+	var ps persistedSnapshot
+	if err := json.Unmarshal(env.Data, &ps); err != nil {
+		return ProviderSnapshot{}, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	return fromPersisted(ps)
+}
+
+// HTTP content types of encoded snapshots, e.g. pushed to or served by a metrics endpoint.
+const (
+	// SnapshotContentType is the content type of snapshots encoded by EncodeSnapshot.
+	SnapshotContentType = "application/json"
+	// SnapshotBinaryContentType is the content type of snapshots encoded by
+	// EncodeSnapshotBinary.
+	SnapshotBinaryContentType = "application/vnd.metrics.snapshot"
+)
+
+// snapshotBinaryMagic starts snapshots encoded by EncodeSnapshotBinary.
+const snapshotBinaryMagic = "MSNP"
+
+// EncodeSnapshotBinary writes snap to w in a compact binary form of the format of
+// EncodeSnapshot: a magic number, the version, the CRC32 of the data and the gob-encoded data.
+func EncodeSnapshotBinary(w io.Writer, snap ProviderSnapshot) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(toPersisted(snap)); err != nil {
+		return fmt.Errorf("metrics: encoding snapshot: %w", err)
+	}
+	header := make([]byte, 0, len(snapshotBinaryMagic)+5)
+	header = append(header, snapshotBinaryMagic...)
+	header = append(header, snapshotVersion)
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(data.Bytes()))
+	if _, err := w.Write(append(header, data.Bytes()...)); err != nil {
+		return fmt.Errorf("metrics: encoding snapshot: %w", err)
+	}
+	return nil
+}
+
+// DecodeSnapshotBinary reads a snapshot written by EncodeSnapshotBinary. Errors wrap
+// ErrSnapshotCorrupt or ErrSnapshotVersion when the content is unusable.
+func DecodeSnapshotBinary(r io.Reader) (ProviderSnapshot, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return ProviderSnapshot{}, fmt.Errorf("metrics: decoding snapshot: %w", err)
+	}
+	n := len(snapshotBinaryMagic)
+	if len(b) < n+5 || string(b[:n]) != snapshotBinaryMagic {
+		return ProviderSnapshot{}, fmt.Errorf("%w: unknown format", ErrSnapshotCorrupt)
+	}
+	if v := b[n]; v != snapshotVersion {
+		return ProviderSnapshot{}, fmt.Errorf("%w: %d", ErrSnapshotVersion, v)
+	}
+	data := b[n+5:]
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(b[n+1:]) {
+		return ProviderSnapshot{}, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+	var ps persistedSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&ps); err != nil {
+		return ProviderSnapshot{}, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	return fromPersisted(ps)
+}
+
+func toPersisted(snap ProviderSnapshot) persistedSnapshot {
+	out := persistedSnapshot{Time: snap.Time, Instruments: make([]persistedInstrument, 0, len(snap.Instruments))}
+	for _, s := range snap.Instruments {
+		pi := persistedInstrument{
+			Type: s.Type, Name: s.Name,
+			Description: s.Config.Description, Unit: s.Config.Unit,
+			Attributes: s.Config.Attributes, Buckets: s.Config.Buckets,
+			Durable: s.Config.Durable, Created: s.Times.Created,
+		}
+		if w := s.Config.Window; w != nil {
+			pi.Window = &persistedWindow{Duration: w.Duration, SubWindows: w.SubWindows, Quantiles: w.Quantiles}
+		}
+		if !s.Times.LastReset.IsZero() {
+			r := s.Times.LastReset
+			pi.LastReset = &r
+		}
+		if s.Type == InstrumentTypeHistogram {
+			h := &persistedHistogram{Count: s.Histogram.Count, Sum: jsonFloat(s.Histogram.Sum)}
+			if h.Count > 0 {
+				minV, maxV := jsonFloat(s.Histogram.Min), jsonFloat(s.Histogram.Max)
+				h.Min, h.Max = &minV, &maxV
+			}
+			for _, b := range s.Histogram.Buckets {
+				h.BucketCounts = append(h.BucketCounts, b.Count)
+			}
+			if sk := s.Histogram.sketch; sk != nil {
+				h.Sketch = &persistedSketch{Zero: sk.zero, Pos: sk.pos, Neg: sk.neg}
+			}
+			pi.Histogram = h
+		} else {
+			pi.Value = s.Value
+		}
+		out.Instruments = append(out.Instruments, pi)
+	}
+	return out
+}
+
+func fromPersisted(ps persistedSnapshot) (ProviderSnapshot, error) {
+	out := ProviderSnapshot{Time: ps.Time, Instruments: make([]InstrumentSnapshot, 0, len(ps.Instruments))}
+	for _, pi := range ps.Instruments {
+		s := InstrumentSnapshot{
+			Type: pi.Type, Name: pi.Name,
+			Config: InstrumentConfig{
+				Description: pi.Description, Unit: pi.Unit, Attributes: pi.Attributes, Buckets: pi.Buckets,
+				Durable: pi.Durable,
+			},
+			Value: pi.Value,
+			Times: InstrumentTimes{Created: pi.Created},
+		}
+		if pi.Window != nil {
+			s.Config.Window = &HistogramWindow{
+				Duration: pi.Window.Duration, SubWindows: pi.Window.SubWindows, Quantiles: pi.Window.Quantiles,
+			}
+		}
+		if pi.LastReset != nil {
+			s.Times.LastReset = *pi.LastReset
+		}
+		if h := pi.Histogram; h != nil {
+			if len(h.BucketCounts) != 0 && len(h.BucketCounts) != len(pi.Buckets)+1 {
+				return ProviderSnapshot{}, fmt.Errorf("%w: histogram %q has %d bucket counts for %d bounds",
+					ErrSnapshotCorrupt, pi.Name, len(h.BucketCounts), len(pi.Buckets))
+			}
+			s.Histogram = HistSnapshot{Count: h.Count, Sum: float64(h.Sum), Min: math.Inf(1), Max: math.Inf(-1)}
+			if h.Min != nil && h.Max != nil {
+				s.Histogram.Min, s.Histogram.Max = float64(*h.Min), float64(*h.Max)
+			}
+			if h.Count > 0 {
+				s.Histogram.Mean = s.Histogram.Sum / float64(h.Count)
+			}
+			for i, c := range h.BucketCounts {
+				ub := math.Inf(1)
+				if i < len(pi.Buckets) {
+					ub = pi.Buckets[i]
+				}
+				s.Histogram.Buckets = append(s.Histogram.Buckets, HistBucket{UpperBound: ub, Count: c})
+			}
+			if sk := h.Sketch; sk != nil {
+				s.Histogram.sketch = &quantileSketch{pos: sk.Pos, neg: sk.Neg, zero: sk.Zero, count: sk.Zero}
+				for _, c := range sk.Pos {
+					s.Histogram.sketch.count += c
+				}
+				for _, c := range sk.Neg {
+					s.Histogram.sketch.count += c
+				}
+			}
+			if w := s.Config.Window; w != nil && s.Histogram.sketch != nil {
+				s.Histogram.Quantiles = sketchQuantiles(s.Histogram.sketch, w.Quantiles, s.Histogram.Min, s.Histogram.Max)
+			}
+		}
+		out.Instruments = append(out.Instruments, s)
+	}
+	sortInstruments(out.Instruments)
+	return out, nil
+}
+
+// Restore adds the values of the instruments in snap to the provider, creating missing
+// instruments with the persisted configuration, and restores their creation and reset times.
+// Restored values are not observed by rate trackers. Windowed histograms are restored without
+// measurements, which would lie outside of their window. With a write-ahead log (see
+// WithWAL), durable counters and up/down counters are skipped: the log recovers them, and
+// restoring their persisted values as well would count them twice. Restore is meant to be
+// called before the instruments are used, typically through WithRestoreFrom.
+func (p *BasicProvider) Restore(snap ProviderSnapshot) {
+	for _, s := range snap.Instruments {
+		if s.Config.Durable && p.cfg.wal != nil && s.Type != InstrumentTypeHistogram {
+			continue
+		}
+		opts := configOptions(s.Config)
+		switch s.Type {
+		case InstrumentTypeCounter:
+			p.Counter(s.Name, opts...).(counterInstrument).restore(s.Value)
+		case InstrumentTypeUpDown:
+			p.UpDownCounter(s.Name, opts...).(*BasicUpDownCounter).restore(s.Value)
+		case InstrumentTypeHistogram:
+			p.Histogram(s.Name, opts...).(histogramInstrument).restore(s.Histogram)
+		default:
+			continue
+		}
+		if v, ok := p.times.Load(NewInstrumentKey(s.Type, s.Name)); ok {
+			v.(*instrumentTimes).restore(s.Times)
+		}
+	}
+}
+
+// configOptions returns the instrument options reproducing cfg.
+func configOptions(cfg InstrumentConfig) []InstrumentOption {
+	opts := []InstrumentOption{WithDescription(cfg.Description), WithUnit(cfg.Unit), WithAttributes(cfg.Attributes)}
+	if cfg.Buckets != nil {
+		opts = append(opts, WithBuckets(cfg.Buckets...))
+	}
+	if cfg.Window != nil {
+		opts = append(opts, WithRollingWindow(*cfg.Window))
+	}
+	if cfg.Durable {
+		opts = append(opts, WithDurable())
+	}
+	return opts
+}
+
+// defaultPersistInterval is the interval between saves of a SnapshotPersister unless configured.
+const defaultPersistInterval = 30 * time.Second
+
+type persisterConfig struct {
+	// interval between saves. Default: defaultPersistInterval.
+	interval time.Duration
+}
+
+// PersisterOption configures a SnapshotPersister constructed by NewSnapshotPersister.
+type PersisterOption func(*persisterConfig)
+
+// WithPersistInterval sets the interval between saves. Non-positive values are ignored.
+func WithPersistInterval(d time.Duration) PersisterOption {
+	return func(cfg *persisterConfig) {
+		if d > 0 {
+			cfg.interval = d
+		}
+	}
+}
+
+// SnapshotPersister periodically saves the state of a BasicProvider to a snapshot file,
+// which a later process restores with WithRestoreFrom:
+//
+//	p := metrics.NewBasicProvider(metrics.WithRestoreFrom(path))
+//	go metrics.NewSnapshotPersister(p, path).Run(ctx)
+type SnapshotPersister struct {
+	p    *BasicProvider
+	path string
+	cfg  persisterConfig
+}
+
+// NewSnapshotPersister returns a persister saving p to path.
+func NewSnapshotPersister(p *BasicProvider, path string, opts ...PersisterOption) *SnapshotPersister {
+	cfg := persisterConfig{interval: defaultPersistInterval}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	return &SnapshotPersister{p: p, path: path, cfg: cfg}
+}
+
+// Flush saves the current state of the provider.
+func (s *SnapshotPersister) Flush() error {
+	return SaveSnapshot(s.path, s.p.Snapshot())
+}
+
+// Run saves the state of the provider at every interval, as measured by the provider's
+// clock, until ctx is done, and then a last time. Failed periodic saves are logged with the
+// provider's logger; Run returns the error of the last save.
+func (s *SnapshotPersister) Run(ctx context.Context) error {
+	t := s.p.Clock().NewTicker(s.cfg.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return s.Flush()
+		case <-t.C():
+			if err := s.Flush(); err != nil {
+				s.p.logger.Errorf("[metrics] persisting snapshot to %s: %v", s.path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPersistTestProvider(opts ...BasicProviderOption) *BasicProvider {
+	p := NewBasicProvider(append([]BasicProviderOption{WithClock(newManualClock())}, opts...)...)
+	p.Counter("requests_total", WithDescription("requests"), WithAttributes(map[string]string{"route": "/"}), WithDurable()).Add(7)
+	p.UpDownCounter("inflight", WithUnit("1")).Add(-3)
+	h := p.Histogram("latency", WithBuckets(1, 10), WithUnit("ms"))
+	h.Record(0.5)
+	h.Record(5)
+	h.Record(math.Inf(1))
+	p.Histogram("window", WithRollingWindow(HistogramWindow{Duration: time.Minute})).Record(1)
+	return p
+}
+
+func TestEncodeDecodeSnapshot_RoundTrip(t *testing.T) {
+	snap := newPersistTestProvider().Snapshot()
+
+	var buf bytes.Buffer
+	if err := EncodeSnapshot(&buf, snap); err != nil {
+		t.Fatalf("EncodeSnapshot: %v", err)
+	}
+	got, err := DecodeSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("DecodeSnapshot: %v", err)
+	}
+	if !got.Time.Equal(snap.Time) || len(got.Instruments) != len(snap.Instruments) {
+		t.Fatalf("unexpected decoded snapshot %+v", got)
+	}
+
+	c, _ := got.Get(InstrumentTypeCounter, "requests_total")
+	if c.Value != 7 || c.Config.Description != "requests" || c.Config.Attributes["route"] != "/" || !c.Config.Durable {
+		t.Fatalf("unexpected counter %+v", c)
+	}
+	if !c.Times.Created.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("expected creation time to be kept, got %v", c.Times.Created)
+	}
+	u, _ := got.Get(InstrumentTypeUpDown, "inflight")
+	if u.Value != -3 || u.Config.Unit != "1" {
+		t.Fatalf("unexpected up/down counter %+v", u)
+	}
+	h, _ := got.Get(InstrumentTypeHistogram, "latency")
+	if h.Histogram.Count != 3 || !math.IsInf(h.Histogram.Sum, 1) || h.Histogram.Min != 0.5 || !math.IsInf(h.Histogram.Max, 1) {
+		t.Fatalf("unexpected histogram %+v", h.Histogram)
+	}
+	if len(h.Histogram.Buckets) != 3 || h.Histogram.Buckets[1].Count != 1 || !math.IsInf(h.Histogram.Buckets[2].UpperBound, 1) {
+		t.Fatalf("unexpected buckets %+v", h.Histogram.Buckets)
+	}
+	w, _ := got.Get(InstrumentTypeHistogram, "window")
+	if w.Config.Window == nil || w.Config.Window.Duration != time.Minute {
+		t.Fatalf("expected the window to be kept, got %+v", w.Config)
+	}
+}
+
+func TestDecodeSnapshot_EmptyHistogram(t *testing.T) {
+	p := NewBasicProvider()
+	p.Histogram("h")
+
+	var buf bytes.Buffer
+	if err := EncodeSnapshot(&buf, p.Snapshot()); err != nil {
+		t.Fatalf("EncodeSnapshot: %v", err)
+	}
+	got, err := DecodeSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("DecodeSnapshot: %v", err)
+	}
+	h, _ := got.Get(InstrumentTypeHistogram, "h")
+	if h.Histogram.Count != 0 || !math.IsInf(h.Histogram.Min, 1) || !math.IsInf(h.Histogram.Max, -1) {
+		t.Fatalf("unexpected empty histogram %+v", h.Histogram)
+	}
+}
+
+func TestDecodeSnapshot_Errors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeSnapshot(&buf, newPersistTestProvider().Snapshot()); err != nil {
+		t.Fatalf("EncodeSnapshot: %v", err)
+	}
+	valid := buf.String()
+
+	reencode := func(edit func(env map[string]any)) string {
+		var env map[string]any
+		if err := json.Unmarshal([]byte(valid), &env); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		edit(env)
+		b, err := json.Marshal(env)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    error
+	}{
+		{"empty", "", ErrSnapshotCorrupt},
+		{"truncated", valid[:len(valid)/2], ErrSnapshotCorrupt},
+		{"flipped value", strings.Replace(valid, `"value":7`, `"value":8`, 1), ErrSnapshotCorrupt},
+		{"unknown format", reencode(func(env map[string]any) { env["format"] = "other" }), ErrSnapshotCorrupt},
+		{"future version", reencode(func(env map[string]any) { env["version"] = 2 }), ErrSnapshotVersion},
+		{"bucket mismatch", reencode(func(env map[string]any) {
+			ins := env["data"].(map[string]any)["instruments"].([]any)
+			for _, in := range ins {
+				m := in.(map[string]any)
+				if m["name"] == "latency" {
+					m["buckets"] = []any{1.0}
+				}
+			}
+			raw, _ := json.Marshal(env["data"])
+			env["crc32"] = crc32.ChecksumIEEE(raw)
+			env["data"] = json.RawMessage(raw)
+		}), ErrSnapshotCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeSnapshot(strings.NewReader(tt.content))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSaveSnapshot_Atomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.snap")
+	p := newPersistTestProvider()
+
+	if err := SaveSnapshot(path, p.Snapshot()); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	p.Counter("requests_total").Add(1)
+	if err := SaveSnapshot(path, p.Snapshot()); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "metrics.snap" {
+		t.Fatalf("expected only the snapshot file, got %v", entries)
+	}
+	snap, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if c, _ := snap.Get(InstrumentTypeCounter, "requests_total"); c.Value != 8 {
+		t.Fatalf("expected the latest state, got %d", c.Value)
+	}
+
+	if err := SaveSnapshot(filepath.Join(dir, "missing", "metrics.snap"), p.Snapshot()); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+	if _, err := LoadSnapshot(filepath.Join(dir, "none")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestWithRestoreFrom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.snap")
+	prev := newPersistTestProvider()
+	prev.ResetInstrument(InstrumentTypeUpDown, "inflight")
+	prev.UpDownCounter("inflight").Add(2)
+	if err := SaveSnapshot(path, prev.Snapshot()); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	clk := newManualClock()
+	clk.Advance(time.Hour)
+	p := NewBasicProvider(WithClock(clk), WithRestoreFrom(path))
+	p.Counter("requests_total").Add(1)
+	snap := p.Snapshot()
+
+	c, _ := snap.Get(InstrumentTypeCounter, "requests_total")
+	if c.Value != 8 || c.Config.Description != "requests" {
+		t.Fatalf("unexpected restored counter %+v", c)
+	}
+	if !c.Times.Created.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("expected the persisted creation time, got %v", c.Times.Created)
+	}
+	u, _ := snap.Get(InstrumentTypeUpDown, "inflight")
+	if u.Value != 2 || !u.Times.LastReset.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("unexpected restored up/down counter %+v", u)
+	}
+	h, _ := snap.Get(InstrumentTypeHistogram, "latency")
+	if h.Histogram.Count != 3 || h.Histogram.Min != 0.5 || h.Histogram.Buckets[0].Count != 1 || h.Histogram.Buckets[2].Count != 1 {
+		t.Fatalf("unexpected restored histogram %+v", h.Histogram)
+	}
+	p.Histogram("latency").Record(2)
+	if got, _ := p.Snapshot().Get(InstrumentTypeHistogram, "latency"); got.Histogram.Count != 4 {
+		t.Fatalf("expected recording to continue after restore, got %+v", got.Histogram)
+	}
+	w, _ := snap.Get(InstrumentTypeHistogram, "window")
+	if w.Config.Window == nil || w.Histogram.Count != 0 {
+		t.Fatalf("expected the windowed histogram to be restored empty, got %+v", w)
+	}
+}
+
+func TestWithRestoreFrom_WAL(t *testing.T) {
+	dir, path := t.TempDir(), filepath.Join(t.TempDir(), "metrics.snap")
+	w := openTestWAL(t, dir)
+	prev := NewBasicProvider(WithWAL(w))
+	prev.Counter("billed_total", WithDurable()).Add(5)
+	prev.Counter("plain_total").Add(3)
+	if err := SaveSnapshot(path, prev.Snapshot()); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// durable counters are recovered from the log only, other instruments from the snapshot
+	p := NewBasicProvider(WithWAL(openTestWAL(t, dir)), WithRestoreFrom(path))
+	if got := p.Counter("billed_total", WithDurable()).(*BasicCounter).Snapshot(); got != 5 {
+		t.Fatalf("expected billed_total 5, got %d", got)
+	}
+	if got := p.Counter("plain_total").(*BasicCounter).Snapshot(); got != 3 {
+		t.Fatalf("expected plain_total 3, got %d", got)
+	}
+}
+
+func TestWithRestoreFrom_BucketsChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.snap")
+	if err := SaveSnapshot(path, newPersistTestProvider().Snapshot()); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	p := NewBasicProvider()
+	p.Histogram("latency", WithBuckets(1, 2, 5))
+	p.Restore(mustLoad(t, path))
+
+	h, _ := p.Snapshot().Get(InstrumentTypeHistogram, "latency")
+	if h.Histogram.Count != 3 || h.Histogram.Buckets[3].Count != 3 {
+		t.Fatalf("expected measurements in the +Inf bucket, got %+v", h.Histogram.Buckets)
+	}
+}
+
+func TestWithRestoreFrom_MissingOrCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	l := &recordingLogger{}
+	p := NewBasicProvider(WithRestoreFrom(filepath.Join(dir, "none")), WithBasicProviderLogger(l))
+	if len(p.Snapshot().Instruments) != 0 || l.count() != 0 {
+		t.Fatalf("expected an empty provider and no log for a missing file, got %d messages", l.count())
+	}
+
+	corrupt := filepath.Join(dir, "corrupt")
+	if err := os.WriteFile(corrupt, []byte("{"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	p = NewBasicProvider(WithRestoreFrom(corrupt), WithBasicProviderLogger(l))
+	if len(p.Snapshot().Instruments) != 0 || l.count() != 1 {
+		t.Fatalf("expected an empty provider and one log, got %d messages", l.count())
+	}
+}
+
+func TestRestore_NotObservedByRates(t *testing.T) {
+	clk := newManualClock()
+	p := NewBasicProvider(WithClock(clk))
+	p.TrackCounterRates("c", []RateTracker{NewSlidingWindow(time.Minute, WithRateClock(clk))})
+	p.Restore(ProviderSnapshot{Instruments: []InstrumentSnapshot{{Type: InstrumentTypeCounter, Name: "c", Value: 600}}})
+
+	rates, _ := p.CounterRates("c")
+	if rates[0].PerSecond != 0 {
+		t.Fatalf("expected restored value not to count as a rate, got %v", rates[0].PerSecond)
+	}
+	if c, _ := p.Snapshot().Get(InstrumentTypeCounter, "c"); c.Value != 600 {
+		t.Fatalf("expected value 600, got %d", c.Value)
+	}
+}
+
+// tickClock is a manualClock whose tickers fire when Tick is called.
+type tickClock struct {
+	*manualClock
+	ch chan time.Time
+}
+
+type chanTicker struct{ ch chan time.Time }
+
+func (t chanTicker) C() <-chan time.Time { return t.ch }
+func (chanTicker) Stop()                 {}
+
+func (c *tickClock) NewTicker(time.Duration) ClockTicker { return chanTicker{c.ch} }
+
+func TestSnapshotPersister_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.snap")
+	clk := &tickClock{manualClock: newManualClock(), ch: make(chan time.Time)}
+	p := NewBasicProvider(WithClock(clk))
+	c := p.Counter("c")
+	c.Add(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewSnapshotPersister(p, path, WithPersistInterval(time.Second)).Run(ctx) }()
+
+	clk.ch <- clk.Now()
+	// a second tick is only received once the first save is complete
+	clk.ch <- clk.Now()
+	if got := mustLoad(t, path); got.Instruments[0].Value != 1 {
+		t.Fatalf("expected a periodic save, got %+v", got)
+	}
+
+	c.Add(1)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := mustLoad(t, path); got.Instruments[0].Value != 2 {
+		t.Fatalf("expected a final save, got %+v", got)
+	}
+}
+
+func TestSnapshotPersister_LogsFailedSaves(t *testing.T) {
+	clk := &tickClock{manualClock: newManualClock(), ch: make(chan time.Time)}
+	l := &recordingLogger{}
+	p := NewBasicProvider(WithClock(clk), WithBasicProviderLogger(l))
+	path := filepath.Join(t.TempDir(), "missing", "metrics.snap")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewSnapshotPersister(p, path).Run(ctx) }()
+	clk.ch <- clk.Now()
+	clk.ch <- clk.Now()
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("expected the final save to fail")
+	}
+	if l.count() < 1 {
+		t.Fatal("expected failed periodic saves to be logged")
+	}
+}
+
+func mustLoad(t *testing.T, path string) ProviderSnapshot {
+	t.Helper()
+	snap, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	return snap
+}
+
+func TestEncodeDecodeSnapshotBinary(t *testing.T) {
+	snap := newPersistTestProvider().Snapshot()
+
+	var jsonBuf, binBuf bytes.Buffer
+	if err := EncodeSnapshot(&jsonBuf, snap); err != nil {
+		t.Fatalf("EncodeSnapshot: %v", err)
+	}
+	if err := EncodeSnapshotBinary(&binBuf, snap); err != nil {
+		t.Fatalf("EncodeSnapshotBinary: %v", err)
+	}
+	valid := binBuf.Bytes()
+	got, err := DecodeSnapshotBinary(bytes.NewReader(valid))
+	if err != nil {
+		t.Fatalf("DecodeSnapshotBinary: %v", err)
+	}
+	fromJSON, _ := DecodeSnapshot(&jsonBuf)
+	var a, b bytes.Buffer
+	_ = EncodeSnapshot(&a, got)
+	_ = EncodeSnapshot(&b, fromJSON)
+	if a.String() != b.String() {
+		t.Fatalf("binary and JSON encodings decode differently:\n%s\n%s", a.String(), b.String())
+	}
+	if c, _ := got.Get(InstrumentTypeCounter, "requests_total"); !c.Config.Durable {
+		t.Fatalf("expected the counter to stay durable, got %+v", c.Config)
+	}
+	if h, _ := got.Get(InstrumentTypeHistogram, "window"); len(h.Histogram.Quantiles) != 3 || h.Histogram.Quantiles[0].Value != 1 {
+		t.Fatalf("expected quantiles of the windowed histogram, got %+v", h.Histogram.Quantiles)
+	}
+
+	corrupt := append([]byte(nil), valid...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	version := append([]byte(nil), valid...)
+	version[4] = 9
+	for name, tt := range map[string]struct {
+		content []byte
+		want    error
+	}{
+		"truncated":      {valid[:6], ErrSnapshotCorrupt},
+		"unknown format": {[]byte("{}"), ErrSnapshotCorrupt},
+		"checksum":       {corrupt, ErrSnapshotCorrupt},
+		"version":        {version, ErrSnapshotVersion},
+	} {
+		if _, err := DecodeSnapshotBinary(bytes.NewReader(tt.content)); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", name, tt.want, err)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package metrics
 
+import (
+	"math"
+	"slices"
+)
+
 // Provider constructs instruments used to record metrics.
 // Implementations must be safe for concurrent use.
 //
@@ -58,6 +63,26 @@ type Histogram interface {
 	Record(v float64)
 }
 
+// BatchHistogram is an optional interface for histograms able to record a measurement several
+// times in a single update, e.g. to import pre-aggregated distributions.
+// Use RecordN to record through it when available.
+type BatchHistogram interface {
+	Histogram
+	RecordN(v float64, n int64)
+}
+
+// RecordN records v into h n times, in a single update if h implements BatchHistogram.
+// Non-positive n records nothing.
+func RecordN(h Histogram, v float64, n int64) {
+	if bh, ok := h.(BatchHistogram); ok {
+		bh.RecordN(v, n)
+		return
+	}
+	for ; n > 0; n-- {
+		h.Record(v)
+	}
+}
+
 // InstrumentConfig carries optional instrument metadata. It's advisory only.
 type InstrumentConfig struct {
 	Description string
@@ -65,6 +90,15 @@ type InstrumentConfig struct {
 	// Attributes are static key-value pairs associated with the instrument itself.
 	// Cardinality is bounded. Implementations may ignore attributes.
 	Attributes map[string]string
+	// Buckets are the upper bounds of histogram buckets, sorted in increasing order.
+	// The +Inf bucket is implicit. Ignored by instruments other than histograms.
+	Buckets []float64
+	// Window makes a histogram report only the measurements of a rolling time window.
+	// Nil for cumulative histograms. Ignored by instruments other than histograms.
+	Window *HistogramWindow
+	// Durable makes every update of a counter or up/down counter go to the write-ahead log
+	// of the provider, if any (see WithDurable). Ignored by histograms.
+	Durable bool
 }
 
 // InstrumentOption mutates InstrumentConfig.
@@ -95,3 +129,23 @@ func WithAttributes(attrs map[string]string) InstrumentOption {
 		}
 	}
 }
+
+// WithBuckets sets the upper bounds of histogram buckets. Bounds are copied, sorted, and
+// deduplicated; NaN and infinite bounds are dropped as the +Inf bucket is always implicit.
+// Without buckets a histogram only tracks count, sum, min, and max.
+func WithBuckets(bounds ...float64) InstrumentOption {
+	return func(c *InstrumentConfig) {
+		out := make([]float64, 0, len(bounds))
+		for _, b := range bounds {
+			if math.IsNaN(b) || math.IsInf(b, 0) {
+				continue
+			}
+			out = append(out, b)
+		}
+		slices.Sort(out)
+		c.Buckets = slices.Compact(out)
+		if len(c.Buckets) == 0 {
+			c.Buckets = nil
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchRelativeAccuracy is the relative accuracy of quantiles estimated by quantileSketch.
+const sketchRelativeAccuracy = 0.01
+
+var (
+	sketchGamma    = (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// sketchMinValue is the smallest magnitude tracked; smaller values count as zero.
+const sketchMinValue = 1e-9
+
+// quantileSketch estimates quantiles with a bounded relative error by counting values in
+// logarithmically sized buckets (as in DDSketch). Index i covers magnitudes in
+// (gamma^(i-1), gamma^i]. It is not safe for concurrent use.
+type quantileSketch struct {
+	pos, neg map[int]int64 // bucket counts of positive values and of magnitudes of negative ones
+	zero     int64
+	count    int64
+}
+
+func (s *quantileSketch) add(v float64) { s.addN(v, 1) }
+
+// addN adds n occurrences of v.
+func (s *quantileSketch) addN(v float64, n int64) {
+	s.count += n
+	switch {
+	case math.Abs(v) < sketchMinValue:
+		s.zero += n
+	case v > 0:
+		if s.pos == nil {
+			s.pos = make(map[int]int64)
+		}
+		s.pos[sketchIndex(v)] += n
+	default:
+		if s.neg == nil {
+			s.neg = make(map[int]int64)
+		}
+		s.neg[sketchIndex(-v)] += n
+	}
+}
+
+func (s *quantileSketch) merge(o *quantileSketch) {
+	for i, c := range o.pos {
+		if s.pos == nil {
+			s.pos = make(map[int]int64, len(o.pos))
+		}
+		s.pos[i] += c
+	}
+	for i, c := range o.neg {
+		if s.neg == nil {
+			s.neg = make(map[int]int64, len(o.neg))
+		}
+		s.neg[i] += c
+	}
+	s.zero += o.zero
+	s.count += o.count
+}
+
+// quantile returns the estimated q-quantile (0 <= q <= 1); NaN if the sketch is empty.
+func (s *quantileSketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	rank := int64(q * float64(s.count-1))
+
+	// negative values, from the largest magnitude to the smallest
+	negIdx := sortedIndexes(s.neg)
+	for i := len(negIdx) - 1; i >= 0; i-- {
+		if rank < s.neg[negIdx[i]] {
+			return -sketchValue(negIdx[i])
+		}
+		rank -= s.neg[negIdx[i]]
+	}
+	if rank < s.zero {
+		return 0
+	}
+	rank -= s.zero
+	posIdx := sortedIndexes(s.pos)
+	for _, i := range posIdx {
+		if rank < s.pos[i] {
+			return sketchValue(i)
+		}
+		rank -= s.pos[i]
+	}
+	return sketchValue(posIdx[len(posIdx)-1])
+}
+
+// sketchIndex returns the bucket of v > 0. +Inf, whose index would not fit in an int, shares
+// the bucket of math.MaxFloat64.
+func sketchIndex(v float64) int {
+	if math.IsInf(v, 1) {
+		v = math.MaxFloat64
+	}
+	return int(math.Ceil(math.Log(v) / sketchLogGamma))
+}
+
+// sketchValue returns the value representing bucket i, within the relative accuracy of
+// every value in the bucket.
+func sketchValue(i int) float64 { return 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1) }
+
+func sortedIndexes(m map[int]int64) []int {
+	out := make([]int, 0, len(m))
+	for i := range m {
+		out = append(out, i)
+	}
+	sort.Ints(out)
+	return out
+}
@@ -0,0 +1,361 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateKind identifies how a RateTracker computes its rate.
+type RateKind string
+
+const (
+	// RateKindEWMA is an exponentially weighted moving average, like the Unix load average.
+	RateKindEWMA RateKind = "ewma"
+	// RateKindWindow is the average over a sliding window of fixed duration.
+	RateKindWindow RateKind = "window"
+)
+
+// Rate is the reading of a RateTracker.
+type Rate struct {
+	Kind   RateKind
+	Window time.Duration
+	// PerSecond is the rate of the observed increments, per second.
+	PerSecond float64
+}
+
+// RateTracker tracks the rate of increments of a counter over time.
+// Implementations must be safe for concurrent use.
+type RateTracker interface {
+	// Observe records an increment of n at the current time.
+	Observe(n int64)
+	// Rate returns the current rate.
+	Rate() Rate
+}
+
+// RateOption configures the rate trackers returned by NewEWMA and NewSlidingWindow.
+type RateOption func(*rateConfig)
+
+type rateConfig struct {
+	clock        Clock
+	clockSet     bool // by WithRateClock
+	tickInterval time.Duration
+	buckets      int
+}
+
+const (
+	// defaultEWMATickInterval matches the sampling interval of the Unix load average.
+	defaultEWMATickInterval = 5 * time.Second
+	defaultWindowBuckets    = 60
+)
+
+// WithRateClock sets the clock driving the tracker. Defaults to the clock of the provider
+// the tracker is attached to by BasicProvider.TrackCounterRates, or SystemClock.
+func WithRateClock(c Clock) RateOption {
+	return func(cfg *rateConfig) {
+		if c != nil {
+			cfg.clock, cfg.clockSet = c, true
+		}
+	}
+}
+
+// WithEWMATickInterval sets how often an EWMA folds the increments observed since the
+// previous tick into the average. Defaults to 5 seconds. Non-positive values are ignored.
+func WithEWMATickInterval(d time.Duration) RateOption {
+	return func(cfg *rateConfig) {
+		if d > 0 {
+			cfg.tickInterval = d
+		}
+	}
+}
+
+// WithWindowBuckets sets the number of buckets a sliding window is split into, i.e. the
+// resolution at which old increments expire. Defaults to 60. Non-positive values are ignored.
+func WithWindowBuckets(n int) RateOption {
+	return func(cfg *rateConfig) {
+		if n > 0 {
+			cfg.buckets = n
+		}
+	}
+}
+
+// defaultClockSetter is implemented by the trackers of this package, so that providers can
+// drive the ones created without WithRateClock by their own clock.
+type defaultClockSetter interface {
+	// setDefaultClock sets the clock of the tracker to c unless set by WithRateClock.
+	// It must be called before the tracker is used.
+	setDefaultClock(c Clock)
+}
+
+func applyRateOptions(opts []RateOption) rateConfig {
+	cfg := rateConfig{clock: SystemClock(), tickInterval: defaultEWMATickInterval, buckets: defaultWindowBuckets}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+// EWMA is a RateTracker computing an exponentially weighted moving average of the rate,
+// like the 1/5/15 minute Unix load averages. Increments are accumulated atomically and
+// folded into the average once per tick interval; ticking is lazy and happens on Observe
+// and Rate, so no background goroutine is needed.
+type EWMA struct {
+	window   time.Duration
+	interval time.Duration
+	alpha    float64
+	clock    Clock
+	clockSet bool
+
+	uncounted atomic.Int64
+	// nextTick is the time of the next tick in Unix nanoseconds; read without the lock to
+	// keep Observe cheap between ticks.
+	nextTick atomic.Int64
+
+	mu          sync.Mutex
+	rate        float64 // per second
+	initialized bool
+}
+
+// NewEWMA returns an EWMA averaging over the given window. Non-positive windows default
+// to one minute.
+func NewEWMA(window time.Duration, opts ...RateOption) *EWMA {
+	if window <= 0 {
+		window = time.Minute
+	}
+	cfg := applyRateOptions(opts)
+	e := &EWMA{
+		window:   window,
+		interval: cfg.tickInterval,
+		alpha:    1 - math.Exp(-cfg.tickInterval.Seconds()/window.Seconds()),
+		clock:    cfg.clock,
+		clockSet: cfg.clockSet,
+	}
+	e.nextTick.Store(cfg.clock.Now().Add(cfg.tickInterval).UnixNano())
+	return e
+}
+
+func (e *EWMA) setDefaultClock(c Clock) {
+	if e.clockSet {
+		return
+	}
+	e.clock = c
+	e.nextTick.Store(c.Now().Add(e.interval).UnixNano())
+}
+
+// NewLoadAverages returns EWMAs over 1, 5 and 15 minutes, like the Unix load average.
+func NewLoadAverages(opts ...RateOption) []RateTracker {
+	return []RateTracker{
+		NewEWMA(time.Minute, opts...),
+		NewEWMA(5*time.Minute, opts...),
+		NewEWMA(15*time.Minute, opts...),
+	}
+}
+
+// Observe records an increment of n.
+func (e *EWMA) Observe(n int64) {
+	e.tickIfDue(e.clock.Now())
+	e.uncounted.Add(n)
+}
+
+// Rate returns the average rate as of the last completed tick.
+func (e *EWMA) Rate() Rate {
+	e.tickIfDue(e.clock.Now())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Rate{Kind: RateKindEWMA, Window: e.window, PerSecond: e.rate}
+}
+
+func (e *EWMA) tickIfDue(now time.Time) {
+	if now.UnixNano() < e.nextTick.Load() {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	next := e.nextTick.Load()
+	if now.UnixNano() < next {
+		return // ticked concurrently
+	}
+	ticks := (now.UnixNano()-next)/int64(e.interval) + 1
+
+	// increments since the last tick are attributed to the first elapsed tick;
+	// the remaining ones saw no increments and only decay the average
+	instant := float64(e.uncounted.Swap(0)) / e.interval.Seconds()
+	if e.initialized {
+		e.rate += e.alpha * (instant - e.rate)
+	} else {
+		e.rate, e.initialized = instant, true
+	}
+	e.rate *= math.Pow(1-e.alpha, float64(ticks-1))
+	e.nextTick.Store(next + ticks*int64(e.interval))
+}
+
+// SlidingWindow is a RateTracker computing the average rate over a sliding window. The
+// window is split into buckets (see WithWindowBuckets); increments expire one bucket at a
+// time. Increments are always averaged over the full window, so the rate ramps up during
+// the first window after creation rather than reporting spikes.
+type SlidingWindow struct {
+	window   time.Duration
+	bucket   time.Duration
+	clock    Clock
+	clockSet bool
+
+	mu     sync.Mutex
+	counts []int64
+	last   int64 // index of the bucket of the most recent observation or read
+}
+
+// NewSlidingWindow returns a SlidingWindow over the given window. Non-positive windows
+// default to one minute.
+func NewSlidingWindow(window time.Duration, opts ...RateOption) *SlidingWindow {
+	if window <= 0 {
+		window = time.Minute
+	}
+	cfg := applyRateOptions(opts)
+	bucket := window / time.Duration(cfg.buckets)
+	if bucket <= 0 {
+		bucket = 1
+	}
+	now := cfg.clock.Now()
+	return &SlidingWindow{
+		window:   window,
+		bucket:   bucket,
+		clock:    cfg.clock,
+		clockSet: cfg.clockSet,
+		counts:   make([]int64, cfg.buckets),
+		last:     now.UnixNano() / int64(bucket),
+	}
+}
+
+func (w *SlidingWindow) setDefaultClock(c Clock) {
+	if w.clockSet {
+		return
+	}
+	w.clock = c
+	w.last = c.Now().UnixNano() / int64(w.bucket)
+}
+
+// Observe records an increment of n.
+func (w *SlidingWindow) Observe(n int64) {
+	now := w.clock.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	idx := w.advanceLocked(now)
+	w.counts[idx%int64(len(w.counts))] += n
+}
+
+// Rate returns the average rate over the window.
+func (w *SlidingWindow) Rate() Rate {
+	now := w.clock.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advanceLocked(now)
+	var sum int64
+	for _, c := range w.counts {
+		sum += c
+	}
+	return Rate{Kind: RateKindWindow, Window: w.window, PerSecond: float64(sum) / w.window.Seconds()}
+}
+
+// advanceLocked clears the buckets that expired since the last call and returns the index
+// of the current bucket. A clock going backwards keeps the latest bucket current.
+func (w *SlidingWindow) advanceLocked(now time.Time) int64 {
+	idx := now.UnixNano() / int64(w.bucket)
+	if idx <= w.last {
+		return w.last
+	}
+	n := int64(len(w.counts))
+	for i := w.last + 1; i <= idx && i <= w.last+n; i++ {
+		w.counts[i%n] = 0
+	}
+	w.last = idx
+	return idx
+}
+
+// RateCounter is a Counter that forwards increments to another Counter and to rate trackers.
+// It can wrap any Counter; BasicCounter and ShardedCounter also accept trackers directly
+// through AttachRates.
+type RateCounter struct {
+	c        Counter
+	trackers []RateTracker
+}
+
+// NewRateCounter returns a RateCounter forwarding to c and trackers.
+func NewRateCounter(c Counter, trackers ...RateTracker) *RateCounter {
+	return &RateCounter{c: c, trackers: append([]RateTracker(nil), trackers...)}
+}
+
+// Add increments the wrapped counter by n and observes n in every tracker.
+func (r *RateCounter) Add(n int64) {
+	r.c.Add(n)
+	observeRates(r.trackers, n)
+}
+
+// AddContext is like Add, passing ctx to the wrapped counter (see AddWithContext).
+func (r *RateCounter) AddContext(ctx context.Context, n int64) {
+	AddWithContext(ctx, r.c, n)
+	observeRates(r.trackers, n)
+}
+
+// AddWithExemplar is like Add, passing ex to the wrapped counter (see AddWithExemplar).
+func (r *RateCounter) AddWithExemplar(n int64, ex Exemplar) {
+	AddWithExemplar(r.c, n, ex)
+	observeRates(r.trackers, n)
+}
+
+// Rates returns the current readings of the trackers.
+func (r *RateCounter) Rates() []Rate { return readRates(r.trackers) }
+
+// Unwrap returns the wrapped counter.
+func (r *RateCounter) Unwrap() Counter { return r.c }
+
+func observeRates(trackers []RateTracker, n int64) {
+	for _, t := range trackers {
+		t.Observe(n)
+	}
+}
+
+func readRates(trackers []RateTracker) []Rate {
+	if len(trackers) == 0 {
+		return nil
+	}
+	out := make([]Rate, len(trackers))
+	for i, t := range trackers {
+		out[i] = t.Rate()
+	}
+	return out
+}
+
+// rateTrackers is a copy-on-write list of trackers attached to a basic counter. Adds pay a
+// single atomic load when no tracker is attached.
+type rateTrackers struct {
+	p atomic.Pointer[[]RateTracker]
+}
+
+func (r *rateTrackers) attach(trackers []RateTracker) {
+	for {
+		old := r.p.Load()
+		var list []RateTracker
+		if old != nil {
+			list = append(list, *old...)
+		}
+		list = append(list, trackers...)
+		if r.p.CompareAndSwap(old, &list) {
+			return
+		}
+	}
+}
+
+func (r *rateTrackers) observe(n int64) {
+	if list := r.p.Load(); list != nil {
+		observeRates(*list, n)
+	}
+}
+
+func (r *rateTrackers) rates() []Rate {
+	if list := r.p.Load(); list != nil {
+		return readRates(*list)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock is a Clock advanced explicitly by tests.
+// Timers and tickers are those of the system clock.
+type manualClock struct {
+	systemClock
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newManualClock() *manualClock { return &manualClock{now: time.Unix(1000, 0)} }
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestEWMA(t *testing.T) {
+	clk := newManualClock()
+	e := NewEWMA(time.Minute, WithRateClock(clk))
+
+	e.Observe(50)
+	if r := e.Rate(); r.PerSecond != 0 || r.Kind != RateKindEWMA || r.Window != time.Minute {
+		t.Fatalf("unexpected rate before the first tick %+v", r)
+	}
+
+	// first tick initializes the average with the instant rate: 50 / 5s
+	clk.Advance(5 * time.Second)
+	if r := e.Rate(); r.PerSecond != 10 {
+		t.Fatalf("rate = %v; want 10", r.PerSecond)
+	}
+
+	// a constant rate of 10/s keeps the average at 10
+	for i := 0; i < 12; i++ {
+		e.Observe(50)
+		clk.Advance(5 * time.Second)
+	}
+	if r := e.Rate(); math.Abs(r.PerSecond-10) > 1e-9 {
+		t.Fatalf("rate = %v; want 10", r.PerSecond)
+	}
+
+	// one idle minute (12 ticks) decays the 1 minute average by 1/e
+	clk.Advance(time.Minute)
+	if r := e.Rate(); math.Abs(r.PerSecond-10/math.E) > 1e-9 {
+		t.Fatalf("rate = %v; want %v", r.PerSecond, 10/math.E)
+	}
+}
+
+func TestEWMA_TicksOnObserve(t *testing.T) {
+	clk := newManualClock()
+	e := NewEWMA(time.Minute, WithRateClock(clk), WithEWMATickInterval(time.Second))
+	e.Observe(4)
+	clk.Advance(time.Second)
+	e.Observe(100) // closes the first tick before counting towards the second one
+	if r := e.Rate(); r.PerSecond != 4 {
+		t.Fatalf("rate = %v; want 4", r.PerSecond)
+	}
+	if NewEWMA(0).window != time.Minute {
+		t.Fatalf("expected default window")
+	}
+
+	la := NewLoadAverages(WithRateClock(clk))
+	if len(la) != 3 || la[2].Rate().Window != 15*time.Minute {
+		t.Fatalf("unexpected load averages %+v", la)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clk := newManualClock()
+	w := NewSlidingWindow(10*time.Second, WithRateClock(clk), WithWindowBuckets(10))
+
+	w.Observe(5)
+	if r := w.Rate(); r.PerSecond != 0.5 || r.Kind != RateKindWindow || r.Window != 10*time.Second {
+		t.Fatalf("unexpected rate %+v", r)
+	}
+	clk.Advance(4 * time.Second)
+	w.Observe(15)
+	if r := w.Rate(); r.PerSecond != 2 {
+		t.Fatalf("rate = %v; want 2", r.PerSecond)
+	}
+
+	// after a full window the first observation expires
+	clk.Advance(6 * time.Second)
+	if r := w.Rate(); r.PerSecond != 1.5 {
+		t.Fatalf("rate = %v; want 1.5", r.PerSecond)
+	}
+	clk.Advance(time.Hour)
+	if r := w.Rate(); r.PerSecond != 0 {
+		t.Fatalf("rate = %v; want 0", r.PerSecond)
+	}
+
+	// clock going backwards counts into the latest bucket
+	w.Observe(10)
+	clk.Advance(-time.Minute)
+	w.Observe(10)
+	clk.Advance(time.Minute)
+	if r := w.Rate(); r.PerSecond != 2 {
+		t.Fatalf("rate = %v; want 2", r.PerSecond)
+	}
+}
+
+func TestRateCounter(t *testing.T) {
+	clk := newManualClock()
+	p := NewBasicProvider(WithExemplarFilter(ExemplarFilterAlwaysOn))
+	rc := NewRateCounter(p.Counter("c"), NewSlidingWindow(time.Second, WithRateClock(clk)))
+	rc.Add(1)
+	AddWithContext(ContextWithTrace(context.Background(), "t", "s"), rc, 2)
+	AddWithExemplar(rc, 3, Exemplar{TraceID: "t"})
+
+	if got := rc.Unwrap().(*BasicCounter).Snapshot(); got != 6 {
+		t.Fatalf("counter = %d; want 6", got)
+	}
+	if got := len(rc.Unwrap().(*BasicCounter).Exemplars()); got != 1 {
+		t.Fatalf("expected exemplars to be forwarded, got %d", got)
+	}
+	if r := rc.Rates(); len(r) != 1 || r[0].PerSecond != 6 {
+		t.Fatalf("unexpected rates %+v", r)
+	}
+	if NewRateCounter(p.Counter("c")).Rates() != nil {
+		t.Fatalf("expected no rates without trackers")
+	}
+}
+
+func TestBasicProvider_CounterRates(t *testing.T) {
+	for _, p := range []*BasicProvider{NewBasicProvider(), NewBasicProvider(WithShardedCounters(2))} {
+		clk := newManualClock()
+		if _, ok := p.CounterRates("requests"); ok {
+			t.Fatalf("expected missing counter")
+		}
+		p.Counter("plain").Add(1)
+		if r, ok := p.CounterRates("plain"); !ok || r != nil {
+			t.Fatalf("expected no rates for plain counter, got %+v", r)
+		}
+
+		p.TrackCounterRates("requests", []RateTracker{NewSlidingWindow(time.Second, WithRateClock(clk))}, WithUnit("1"))
+		p.TrackCounterRates("requests", []RateTracker{NewEWMA(time.Minute, WithRateClock(clk))})
+		p.Counter("requests").Add(4)
+
+		var ri RateInspector = p
+		r, ok := ri.CounterRates("requests")
+		if !ok || len(r) != 2 || r[0].PerSecond != 4 || r[1].Kind != RateKindEWMA {
+			t.Fatalf("unexpected rates %+v", r)
+		}
+		s, _ := p.Snapshot().Get(InstrumentTypeCounter, "requests")
+		if len(s.Rates) != 2 || s.Config.Unit != "1" {
+			t.Fatalf("unexpected snapshot %+v", s)
+		}
+	}
+}
+
+func TestBasicProvider_TrackCounterRatesClock(t *testing.T) {
+	clk, own := newManualClock(), newManualClock()
+	p := NewBasicProvider(WithClock(clk))
+	ewma, window := NewEWMA(time.Minute, WithEWMATickInterval(time.Second)), NewSlidingWindow(time.Second)
+	explicit := NewSlidingWindow(time.Second, WithRateClock(own))
+	p.TrackCounterRates("requests", []RateTracker{ewma, window, explicit})
+
+	p.Counter("requests").Add(6)
+	// on the system clock, the EWMA would not tick yet and the window would keep the adds
+	clk.Advance(time.Second)
+	if r := ewma.Rate(); r.PerSecond != 6 {
+		t.Fatalf("expected the EWMA to tick on the provider clock, got %+v", r)
+	}
+	if r := window.Rate(); r.PerSecond != 0 {
+		t.Fatalf("expected the window to expire on the provider clock, got %+v", r)
+	}
+	if r := explicit.Rate(); r.PerSecond != 6 {
+		t.Fatalf("expected the explicit clock to be kept, got %+v", r)
+	}
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// registry is a typed, concurrency-safe map from instrument name to instrument.
+// It mirrors the sync.Map API without boxing values into interface{}.
+//
+// Like sync.Map it is optimized for keys written once and read many times: lookups of
+// published entries read an immutable map through an atomic pointer and are lock-free and
+// allocation-free. New entries are first written to a mutex-protected dirty map, which is
+// published (promoted) once enough lookups had to fall back to it, so the cost of copying
+// is amortized over the lookups. The zero value is empty and ready to use.
+type registry[T any] struct {
+	read atomic.Pointer[map[string]T] // immutable once published
+
+	mu sync.Mutex
+	// dirty is nil or a superset of read holding entries not yet published.
+	dirty map[string]T
+	// misses counts lookups that had to take mu since the last promotion.
+	misses int
+}
+
+// Load returns the value stored for name, if any.
+func (r *registry[T]) Load(name string) (T, bool) {
+	if m := r.read.Load(); m != nil {
+		if v, ok := (*m)[name]; ok {
+			return v, true
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// re-check: dirty may have been promoted meanwhile
+	if m := r.read.Load(); m != nil {
+		if v, ok := (*m)[name]; ok {
+			return v, true
+		}
+	}
+	var v T
+	if r.dirty == nil {
+		return v, false
+	}
+	v, ok := r.dirty[name]
+	r.misses++
+	if r.misses >= len(r.dirty) {
+		r.promoteLocked()
+	}
+	return v, ok
+}
+
+// Store sets the value for name.
+// Replacing an already published entry publishes the change immediately.
+func (r *registry[T]) Store(name string, v T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var published bool
+	var m map[string]T
+	if p := r.read.Load(); p != nil {
+		m = *p
+		_, published = m[name]
+	}
+	if r.dirty == nil {
+		r.dirty = make(map[string]T, len(m)+1)
+		for k, val := range m {
+			r.dirty[k] = val
+		}
+	}
+	r.dirty[name] = v
+	if published {
+		r.promoteLocked()
+	}
+}
+
+// Delete removes the value stored for name, if any.
+// Removing a published entry publishes the change immediately.
+func (r *registry[T]) Delete(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var m map[string]T
+	if p := r.read.Load(); p != nil {
+		m = *p
+	}
+	_, published := m[name]
+	if r.dirty == nil {
+		if !published {
+			return
+		}
+		r.dirty = make(map[string]T, len(m))
+		for k, val := range m {
+			r.dirty[k] = val
+		}
+	}
+	delete(r.dirty, name)
+	if published {
+		r.promoteLocked()
+	}
+}
+
+// Range calls f sequentially for each entry present at the time of the call.
+// If f returns false, Range stops the iteration. f may call other registry methods.
+func (r *registry[T]) Range(f func(name string, v T) bool) {
+	r.mu.Lock()
+	if r.dirty != nil {
+		r.promoteLocked()
+	}
+	m := r.read.Load()
+	r.mu.Unlock()
+
+	if m == nil {
+		return
+	}
+	for k, v := range *m {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// promoteLocked publishes dirty as the new read map. Must be called with mu held.
+func (r *registry[T]) promoteLocked() {
+	m := r.dirty
+	r.read.Store(&m)
+	r.dirty = nil
+	r.misses = 0
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRegistry_LoadStorePromote(t *testing.T) {
+	var r registry[int]
+	if _, ok := r.Load("missing"); ok {
+		t.Fatalf("expected empty registry")
+	}
+
+	r.Store("a", 1)
+	r.Store("b", 2)
+	if r.read.Load() != nil {
+		t.Fatalf("expected new entries to stay unpublished until promotion")
+	}
+	if v, ok := r.Load("a"); !ok || v != 1 {
+		t.Fatalf("Load(a) = %v, %v; want 1, true", v, ok)
+	}
+	if v, ok := r.Load("b"); !ok || v != 2 {
+		t.Fatalf("Load(b) = %v, %v; want 2, true", v, ok)
+	}
+	// two misses for two dirty entries: promoted
+	if r.read.Load() == nil || r.dirty != nil {
+		t.Fatalf("expected dirty map to be promoted after enough misses")
+	}
+
+	// add and overwrite after promotion: overwriting a published entry is visible at once
+	r.Store("c", 3)
+	r.Store("a", 10)
+	if v, ok := r.Load("a"); !ok || v != 10 {
+		t.Fatalf("Load(a) after overwrite = %v, %v; want 10, true", v, ok)
+	}
+	got := map[string]int{}
+	r.Range(func(name string, v int) bool {
+		got[name] = v
+		return true
+	})
+	if len(got) != 3 || got["a"] != 10 || got["b"] != 2 || got["c"] != 3 {
+		t.Fatalf("unexpected range result %v", got)
+	}
+	if v, ok := r.Load("c"); !ok || v != 3 {
+		t.Fatalf("Load(c) after Range = %v, %v; want 3, true", v, ok)
+	}
+
+	visited := 0
+	r.Range(func(string, int) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Fatalf("expected Range to stop after first entry, visited %d", visited)
+	}
+
+	// deleting a published entry is visible at once, deleting a missing one is a no-op
+	r.Delete("b")
+	r.Delete("missing")
+	if _, ok := r.Load("b"); ok {
+		t.Fatalf("expected b to be deleted")
+	}
+	r.Store("d", 4)
+	r.Delete("d")
+	if _, ok := r.Load("d"); ok {
+		t.Fatalf("expected unpublished d to be deleted")
+	}
+	if v, ok := r.Load("a"); !ok || v != 10 {
+		t.Fatalf("Load(a) after deletes = %v, %v; want 10, true", v, ok)
+	}
+
+	var empty registry[int]
+	empty.Range(func(string, int) bool {
+		t.Fatalf("unexpected entry in empty registry")
+		return true
+	})
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	var r registry[int]
+	const workers, keys = 8, 200
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				name := strconv.Itoa(i)
+				if w%2 == 0 {
+					r.Store(name, i)
+				}
+				if v, ok := r.Load(name); ok && v != i {
+					t.Errorf("Load(%s) = %d", name, v)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	n := 0
+	r.Range(func(string, int) bool {
+		n++
+		return true
+	})
+	if n != keys {
+		t.Fatalf("expected %d entries, got %d", keys, n)
+	}
+}
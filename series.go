@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+)
+
+// SeriesName returns the name identifying the series of the instrument name with the given
+// attributes, in the OpenMetrics form name{key="value",...} with keys sorted. Attributes
+// already in name, e.g. from a previous call, are kept unless overridden by attrs.
+// It returns name unchanged if there are no attributes.
+func SeriesName(name string, attrs map[string]string) string {
+	base, all := ParseSeriesName(name)
+	if len(attrs) == 0 && len(all) == 0 {
+		return base
+	}
+	if all == nil {
+		all = make(map[string]string, len(attrs))
+	}
+	for k, v := range attrs {
+		all[k] = v
+	}
+	var b strings.Builder
+	b.WriteString(base)
+	b.WriteByte('{')
+	for i, k := range sortedKeys(all) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k + `="` + escapeLabelValue(all[k]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseSeriesName splits a name built by SeriesName into the instrument name and the
+// attributes. Names without a well-formed attribute suffix are returned unchanged with nil
+// attributes.
+func ParseSeriesName(name string) (string, map[string]string) {
+	open := strings.IndexByte(name, '{')
+	if open < 0 || !strings.HasSuffix(name, "}") {
+		return name, nil
+	}
+	attrs := make(map[string]string)
+	rest := name[open+1 : len(name)-1]
+	for rest != "" {
+		eq := strings.Index(rest, `="`)
+		if eq <= 0 {
+			return name, nil
+		}
+		key := rest[:eq]
+		rest = rest[eq+2:]
+		var v strings.Builder
+		closed := false
+		for i := 0; i < len(rest); i++ {
+			c := rest[i]
+			if c == '"' {
+				rest, closed = rest[i+1:], true
+				break
+			}
+			if c == '\\' && i+1 < len(rest) {
+				i++
+				switch rest[i] {
+				case 'n':
+					c = '\n'
+				default:
+					c = rest[i]
+				}
+			}
+			v.WriteByte(c)
+		}
+		if !closed {
+			return name, nil
+		}
+		attrs[key] = v.String()
+		if rest != "" {
+			if rest[0] != ',' {
+				return name, nil
+			}
+			rest = rest[1:]
+		}
+	}
+	return name[:open], attrs
+}
+
+func sortedKeys(m map[string]string) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string { return labelValueEscaper.Replace(v) }
@@ -0,0 +1,23 @@
+package metrics
+
+import "testing"
+
+func TestSeriesName(t *testing.T) {
+	if got := SeriesName("c", nil); got != "c" {
+		t.Fatalf("expected the name unchanged, got %q", got)
+	}
+	name := SeriesName("c", map[string]string{"z": "1", "a": `q"\` + "\n"})
+	if want := `c{a="q\"\\\n",z="1"}`; name != want {
+		t.Fatalf("expected %s, got %s", want, name)
+	}
+	name = SeriesName(name, map[string]string{"z": "2", "m": ""})
+	base, attrs := ParseSeriesName(name)
+	if base != "c" || len(attrs) != 3 || attrs["a"] != `q"\`+"\n" || attrs["z"] != "2" || attrs["m"] != "" {
+		t.Fatalf("unexpected parse of %s: %q %v", name, base, attrs)
+	}
+	for _, malformed := range []string{"c{", "c{a}", `c{a="1"`, `c{a="1"b="2"}`, `c{="1"}`} {
+		if base, attrs := ParseSeriesName(malformed); base != malformed || attrs != nil {
+			t.Fatalf("expected %q to be returned unchanged, got %q %v", malformed, base, attrs)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// cacheLineSize is the assumed size of a CPU cache line. 128 bytes also covers
+// adjacent-line prefetching on modern x86 and the 128-byte lines of some arm64 cores.
+const cacheLineSize = 128
+
+// ShardedCounter is a thread-safe monotonic counter that spreads updates over several
+// cache-line padded cells to avoid contention when many goroutines on many cores update
+// the same counter. Adds running on the same P (see GOMAXPROCS) keep updating the same cell,
+// through a shard hint cached per P, so that its cache line stays with the core; a hint is
+// re-drawn when an update of its cell is contended. Snapshot sums all cells.
+//
+// Compared to BasicCounter, Add scales with the number of cores at the cost of a larger
+// memory footprint (one cache line per shard) and a slower Snapshot.
+type ShardedCounter struct {
+	cells []counterCell
+	mask  uint32
+	// exemplars offered by AddWithExemplar and AddContext; nil if exemplars are disabled.
+	ex *exemplarReservoir
+	// rate trackers attached with AttachRates.
+	rates rateTrackers
+	// lifecycle timestamps; nil unless created by BasicProvider.
+	times *instrumentTimes
+	// write-ahead log journal; nil unless durable.
+	journal *walJournal
+}
+
+// counterCell is an atomic counter padded to occupy a full cache line.
+type counterCell struct {
+	val atomic.Int64
+	_   [cacheLineSize - 8]byte
+}
+
+// shardHint is the cell index preferred by the Adds of a P. The hints live in shardHints,
+// whose per-P caches make Get return the hint last Put on the same P, without locking.
+type shardHint struct{ idx uint32 }
+
+var shardHints = sync.Pool{New: func() any { return &shardHint{idx: rand.Uint32()} }}
+
+// NewShardedCounter constructs a ShardedCounter with the given number of shards rounded up
+// to a power of two. Non-positive values default to GOMAXPROCS.
+func NewShardedCounter(shards int) *ShardedCounter {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	return &ShardedCounter{cells: make([]counterCell, n), mask: uint32(n - 1)}
+}
+
+// Add increments the counter by n (n may be negative but it's not recommended for monotonic counters).
+func (c *ShardedCounter) Add(n int64) {
+	if c.journal.begin(n) {
+		defer c.journal.end()
+	}
+	h := shardHints.Get().(*shardHint)
+	cell := &c.cells[h.idx&c.mask].val
+	if v := cell.Load(); !cell.CompareAndSwap(v, v+n) {
+		// another core updates the cell: add anyway and move to another one
+		cell.Add(n)
+		h.idx = rand.Uint32()
+	}
+	shardHints.Put(h)
+	c.rates.observe(n)
+	c.times.touch()
+}
+
+// AddWithExemplar increments the counter by n and offers ex, with n as its value,
+// to the counter's exemplar reservoir.
+func (c *ShardedCounter) AddWithExemplar(n int64, ex Exemplar) {
+	c.Add(n)
+	c.ex.offer(0, float64(n), ex)
+}
+
+// AddContext increments the counter by n and offers an exemplar built from the trace and
+// attributes carried by ctx, if any (see ContextWithTrace).
+func (c *ShardedCounter) AddContext(ctx context.Context, n int64) {
+	c.Add(n)
+	if ex, ok := exemplarFromContext(ctx); ok {
+		c.ex.offer(0, float64(n), ex)
+	}
+}
+
+// Exemplars returns copies of the most recent exemplars kept by the counter, oldest first.
+func (c *ShardedCounter) Exemplars() []Exemplar { return c.ex.collect(0) }
+
+// AttachRates makes every subsequent Add also observe the increment in trackers.
+func (c *ShardedCounter) AttachRates(trackers ...RateTracker) { c.rates.attach(trackers) }
+
+// Rates returns the current readings of the attached rate trackers, in attachment order.
+func (c *ShardedCounter) Rates() []Rate { return c.rates.rates() }
+
+// Snapshot returns the current value, i.e. the sum of all shards.
+// Adds racing with Snapshot may or may not be included.
+func (c *ShardedCounter) Snapshot() int64 {
+	var sum int64
+	for i := range c.cells {
+		sum += c.cells[i].val.Load()
+	}
+	return sum
+}
+
+func (c *ShardedCounter) restore(n int64) { c.cells[0].val.Add(n) }
+
+// Reset sets the counter to zero and drops its exemplars. Adds racing with Reset may or may
+// not be counted.
+func (c *ShardedCounter) Reset() {
+	if c.journal.beginReset() {
+		defer c.journal.end()
+	}
+	for i := range c.cells {
+		c.cells[i].val.Store(0)
+	}
+	c.ex.reset()
+	c.times.reset()
+}
+
+// Shards returns the number of shards.
+func (c *ShardedCounter) Shards() int { return len(c.cells) }
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestNewShardedCounter_ShardCount(t *testing.T) {
+	cases := []struct {
+		in, want int
+	}{
+		{1, 1},
+		{3, 4},
+		{8, 8},
+		{9, 16},
+	}
+	for _, c := range cases {
+		if got := NewShardedCounter(c.in).Shards(); got != c.want {
+			t.Fatalf("NewShardedCounter(%d).Shards() = %d; want %d", c.in, got, c.want)
+		}
+	}
+	if got := NewShardedCounter(0).Shards(); got < runtime.GOMAXPROCS(0) {
+		t.Fatalf("default shards = %d; want >= GOMAXPROCS", got)
+	}
+	if got := unsafe.Sizeof(counterCell{}); got != cacheLineSize {
+		t.Fatalf("cell size = %d; want %d", got, cacheLineSize)
+	}
+}
+
+func TestShardedCounter_StickyShards(t *testing.T) {
+	if raceBuild {
+		t.Skip("the race detector makes sync.Pool drop cached hints at random")
+	}
+	// a collection could drop the cached hints
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	c := NewShardedCounter(64)
+	for i := 0; i < 1000; i++ {
+		c.Add(1)
+	}
+	// uncontended Adds stay on the cell of their P
+	used := 0
+	for i := range c.cells {
+		if c.cells[i].val.Load() != 0 {
+			used++
+		}
+	}
+	if used > runtime.GOMAXPROCS(0) {
+		t.Fatalf("expected at most a cell per P, got %d cells", used)
+	}
+	if got := c.Snapshot(); got != 1000 {
+		t.Fatalf("Snapshot() = %d; want 1000", got)
+	}
+}
+
+func TestShardedCounter_ConcurrentAdd(t *testing.T) {
+	c := NewShardedCounter(8)
+	workers := runtime.NumCPU() * 2
+	iters := 1000
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iters; i++ {
+				c.Add(2)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := c.Snapshot(), int64(2*workers*iters); got != want {
+		t.Fatalf("counter = %d; want %d", got, want)
+	}
+}
+
+func TestBasicProvider_WithShardedCounters(t *testing.T) {
+	p := NewBasicProvider(WithShardedCounters(4))
+	c := p.Counter("hits", WithDescription("hits"))
+	sc, ok := c.(*ShardedCounter)
+	if !ok {
+		t.Fatalf("expected *ShardedCounter, got %T", c)
+	}
+	if sc.Shards() != 4 {
+		t.Fatalf("shards = %d; want 4", sc.Shards())
+	}
+	c.Add(3)
+	p.Counter("hits").Add(4)
+
+	inst, cfg, ok := p.CounterWithMeta("hits")
+	if !ok || cfg.Description != "hits" {
+		t.Fatalf("expected counter with meta; ok=%v cfg=%+v", ok, cfg)
+	}
+	if got := inst.(*ShardedCounter).Snapshot(); got != 7 {
+		t.Fatalf("counter = %d; want 7", got)
+	}
+
+	// up/down counters are not affected
+	if _, ok := p.UpDownCounter("u").(*BasicUpDownCounter); !ok {
+		t.Fatalf("expected *BasicUpDownCounter")
+	}
+
+	if got := NewBasicProvider(WithShardedCounters(0)).Counter("c").(*ShardedCounter).Shards(); got < 1 {
+		t.Fatalf("expected default shard count, got %d", got)
+	}
+}
+
+func BenchmarkBasicCounter_Parallel(b *testing.B) {
+	c := &BasicCounter{}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(1)
+		}
+	})
+}
+
+func BenchmarkShardedCounter_Parallel(b *testing.B) {
+	c := NewShardedCounter(0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(1)
+		}
+	})
+}
+
+func BenchmarkShardedCounter_Snapshot(b *testing.B) {
+	c := NewShardedCounter(0)
+	c.Add(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = c.Snapshot()
+	}
+}
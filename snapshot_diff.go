@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// SnapshotDiff describes what changed between two snapshots of the same provider.
+type SnapshotDiff struct {
+	// From and To are the times of the older and newer snapshots.
+	From, To time.Time
+	// Instruments holds a delta for every instrument of the newer snapshot, sorted by type,
+	// then name. Instruments created in between are compared against a zero state.
+	Instruments []InstrumentDelta
+	// Created lists instruments present only in the newer snapshot.
+	Created []InstrumentKey
+	// Removed lists instruments present only in the older snapshot.
+	Removed []InstrumentKey
+}
+
+// Interval returns the time elapsed between the two snapshots.
+func (d SnapshotDiff) Interval() time.Duration { return d.To.Sub(d.From) }
+
+// Get returns the delta of the instrument with the given type and name, if present.
+func (d SnapshotDiff) Get(itype InstrumentType, name string) (InstrumentDelta, bool) {
+	key := NewInstrumentKey(itype, name)
+	i := sort.Search(len(d.Instruments), func(i int) bool {
+		return !lessKey(NewInstrumentKey(d.Instruments[i].Type, d.Instruments[i].Name), key)
+	})
+	if i < len(d.Instruments) && d.Instruments[i].Type == itype && d.Instruments[i].Name == name {
+		return d.Instruments[i], true
+	}
+	return InstrumentDelta{}, false
+}
+
+// InstrumentDelta is the change of a single instrument between two snapshots.
+type InstrumentDelta struct {
+	Type InstrumentType
+	Name string
+	// Delta is the change of a counter or up/down counter value. For a counter that was
+	// reset in between, it is the current value.
+	Delta int64
+	// Rate is the per-second change of a counter or up/down counter, or the per-second number
+	// of histogram recordings. It is zero if the snapshots are not ordered in time, and for
+	// rolling-window histograms.
+	Rate float64
+	// Histogram holds the recordings made in between. Bucket deltas are only set if the
+	// bucket bounds did not change. For a rolling-window histogram (see WithRollingWindow),
+	// whose old measurements expire, it holds the current window instead.
+	Histogram HistSnapshotDelta
+	// Reset reports that the instrument was reset in between, or that a counter or histogram
+	// went backwards, e.g. because the process restarted; deltas then start from zero.
+	Reset bool
+}
+
+// HistSnapshotDelta is the change of a histogram between two snapshots.
+type HistSnapshotDelta struct {
+	Count   int64
+	Sum     float64
+	Buckets []HistBucket // per bucket counts, not cumulative; without exemplars
+}
+
+// DiffSnapshots computes per-instrument deltas and rates between prev and cur, two snapshots
+// of the same provider, cur being the newer one.
+func DiffSnapshots(prev, cur ProviderSnapshot) SnapshotDiff {
+	d := SnapshotDiff{From: prev.Time, To: cur.Time}
+	seconds := cur.Time.Sub(prev.Time).Seconds()
+
+	// both snapshots are sorted by key: walk them in step
+	i, j := 0, 0
+	for i < len(prev.Instruments) || j < len(cur.Instruments) {
+		switch {
+		case j == len(cur.Instruments) ||
+			(i < len(prev.Instruments) && lessKey(prev.Instruments[i].Key(), cur.Instruments[j].Key())):
+			d.Removed = append(d.Removed, prev.Instruments[i].Key())
+			i++
+		case i == len(prev.Instruments) || lessKey(cur.Instruments[j].Key(), prev.Instruments[i].Key()):
+			c := cur.Instruments[j]
+			d.Created = append(d.Created, c.Key())
+			d.Instruments = append(d.Instruments, diffInstrument(InstrumentSnapshot{Type: c.Type, Name: c.Name}, c, seconds))
+			j++
+		default:
+			d.Instruments = append(d.Instruments, diffInstrument(prev.Instruments[i], cur.Instruments[j], seconds))
+			i++
+			j++
+		}
+	}
+	return d
+}
+
+func diffInstrument(prev, cur InstrumentSnapshot, seconds float64) InstrumentDelta {
+	out := InstrumentDelta{Type: cur.Type, Name: cur.Name}
+	if prevStart := prev.Times.Start(); !prevStart.IsZero() && cur.Times.Start().After(prevStart) {
+		// explicitly reset in between (see BasicProvider.ResetInstrument): compare to zero
+		prev = InstrumentSnapshot{Type: cur.Type, Name: cur.Name}
+		out.Reset = true
+	}
+	switch cur.Type {
+	case InstrumentTypeCounter:
+		out.Delta = cur.Value - prev.Value
+		if out.Delta < 0 {
+			out.Delta, out.Reset = cur.Value, true
+		}
+		out.Rate = perSecond(float64(out.Delta), seconds)
+	case InstrumentTypeUpDown:
+		out.Delta = cur.Value - prev.Value
+		out.Rate = perSecond(float64(out.Delta), seconds)
+	case InstrumentTypeHistogram:
+		if cur.Config.Window != nil {
+			// the window of prev has partly expired: subtracting it would not be a delta
+			out.Histogram, _ = diffHistogram(HistSnapshot{}, cur.Histogram)
+			break
+		}
+		var reset bool
+		out.Histogram, reset = diffHistogram(prev.Histogram, cur.Histogram)
+		out.Reset = out.Reset || reset
+		out.Rate = perSecond(float64(out.Histogram.Count), seconds)
+	}
+	return out
+}
+
+func diffHistogram(prev, cur HistSnapshot) (HistSnapshotDelta, bool) {
+	reset := cur.Count < prev.Count
+	if reset {
+		prev = HistSnapshot{}
+	}
+	out := HistSnapshotDelta{Count: cur.Count - prev.Count, Sum: cur.Sum - prev.Sum}
+	if prev.Count == 0 || sameBounds(prev.Buckets, cur.Buckets) {
+		out.Buckets = make([]HistBucket, len(cur.Buckets))
+		for k, b := range cur.Buckets {
+			out.Buckets[k] = HistBucket{UpperBound: b.UpperBound, Count: b.Count}
+			if k < len(prev.Buckets) {
+				out.Buckets[k].Count -= prev.Buckets[k].Count
+			}
+		}
+	}
+	return out, reset
+}
+
+func sameBounds(a, b []HistBucket) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k].UpperBound != b[k].UpperBound {
+			return false
+		}
+	}
+	return true
+}
+
+func perSecond(v, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return v / seconds
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	p := NewBasicProvider()
+	p.Counter("requests").Add(10)
+	p.Counter("gone").Add(1)
+	p.UpDownCounter("inflight").Add(5)
+	h := p.Histogram("latency", WithBuckets(1))
+	h.Record(0.5)
+
+	prev := p.Snapshot()
+	p.Counter("requests").Add(20)
+	p.UpDownCounter("inflight").Add(-2)
+	h.Record(0.25)
+	h.Record(2)
+	p.Counter("new").Add(3)
+	cur := p.Snapshot()
+
+	// remove "gone" from cur as if the provider had been recreated without it
+	kept := cur.Instruments[:0:0]
+	for _, s := range cur.Instruments {
+		if s.Name != "gone" {
+			kept = append(kept, s)
+		}
+	}
+	cur.Instruments = kept
+	prev.Time = time.Unix(100, 0)
+	cur.Time = time.Unix(110, 0)
+
+	d := DiffSnapshots(prev, cur)
+	if d.Interval() != 10*time.Second {
+		t.Fatalf("interval = %v; want 10s", d.Interval())
+	}
+	if len(d.Created) != 1 || d.Created[0] != NewInstrumentKey(InstrumentTypeCounter, "new") {
+		t.Fatalf("unexpected created %v", d.Created)
+	}
+	if len(d.Removed) != 1 || d.Removed[0] != NewInstrumentKey(InstrumentTypeCounter, "gone") {
+		t.Fatalf("unexpected removed %v", d.Removed)
+	}
+	if len(d.Instruments) != 4 {
+		t.Fatalf("expected 4 deltas, got %+v", d.Instruments)
+	}
+
+	if r, _ := d.Get(InstrumentTypeCounter, "requests"); r.Delta != 20 || r.Rate != 2 || r.Reset {
+		t.Fatalf("unexpected counter delta %+v", r)
+	}
+	if n, _ := d.Get(InstrumentTypeCounter, "new"); n.Delta != 3 || math.Abs(n.Rate-0.3) > 1e-12 {
+		t.Fatalf("unexpected created counter delta %+v", n)
+	}
+	if u, _ := d.Get(InstrumentTypeUpDown, "inflight"); u.Delta != -2 || u.Rate != -0.2 {
+		t.Fatalf("unexpected updown delta %+v", u)
+	}
+	l, _ := d.Get(InstrumentTypeHistogram, "latency")
+	if l.Histogram.Count != 2 || l.Histogram.Sum != 2.25 || l.Rate != 0.2 || l.Reset {
+		t.Fatalf("unexpected histogram delta %+v", l)
+	}
+	if len(l.Histogram.Buckets) != 2 || l.Histogram.Buckets[0].Count != 1 || l.Histogram.Buckets[1].Count != 1 {
+		t.Fatalf("unexpected bucket deltas %+v", l.Histogram.Buckets)
+	}
+	if _, ok := d.Get(InstrumentTypeCounter, "gone"); ok {
+		t.Fatalf("removed instruments have no delta")
+	}
+}
+
+func TestDiffSnapshots_Resets(t *testing.T) {
+	at := func(sec int64, ins ...InstrumentSnapshot) ProviderSnapshot {
+		return ProviderSnapshot{Time: time.Unix(sec, 0), Instruments: ins}
+	}
+	hist := func(count int64, sum float64, bounds ...float64) InstrumentSnapshot {
+		s := InstrumentSnapshot{Type: InstrumentTypeHistogram, Name: "h", Histogram: HistSnapshot{Count: count, Sum: sum}}
+		for _, b := range bounds {
+			s.Histogram.Buckets = append(s.Histogram.Buckets, HistBucket{UpperBound: b, Count: count})
+		}
+		return s
+	}
+	counter := InstrumentSnapshot{Type: InstrumentTypeCounter, Name: "c"}
+
+	prevC, curC := counter, counter
+	prevC.Value, curC.Value = 100, 7
+	d := DiffSnapshots(at(0, prevC, hist(10, 5)), at(2, curC, hist(3, 1)))
+	if c, _ := d.Get(InstrumentTypeCounter, "c"); c.Delta != 7 || !c.Reset || c.Rate != 3.5 {
+		t.Fatalf("unexpected counter reset delta %+v", c)
+	}
+	if h, _ := d.Get(InstrumentTypeHistogram, "h"); h.Histogram.Count != 3 || h.Histogram.Sum != 1 || !h.Reset {
+		t.Fatalf("unexpected histogram reset delta %+v", h)
+	}
+
+	// changed bounds: no bucket deltas
+	d = DiffSnapshots(at(0, hist(1, 1, 1)), at(0, hist(2, 2, 2)))
+	if h, _ := d.Get(InstrumentTypeHistogram, "h"); h.Histogram.Buckets != nil || h.Histogram.Count != 1 || h.Rate != 0 {
+		t.Fatalf("unexpected delta with changed bounds %+v", h)
+	}
+
+	// snapshots out of order: no rates
+	d = DiffSnapshots(at(5, prevC), at(1, prevC))
+	if c, _ := d.Get(InstrumentTypeCounter, "c"); c.Rate != 0 || c.Delta != 0 {
+		t.Fatalf("unexpected delta %+v", c)
+	}
+}
+
+func TestDiffSnapshots_RollingWindow(t *testing.T) {
+	clk := newManualClock()
+	window := HistogramWindow{Duration: time.Minute, SubWindows: 2}
+	h := NewWindowedHistogram(window, WithWindowedHistogramClock(clk), WithWindowedHistogramBuckets(10))
+	snapshot := func() ProviderSnapshot {
+		return ProviderSnapshot{Time: clk.Now(), Instruments: []InstrumentSnapshot{{
+			Type: InstrumentTypeHistogram, Name: "w", Config: InstrumentConfig{Window: &window}, Histogram: h.Snapshot(),
+		}}}
+	}
+
+	h.Record(1)
+	h.Record(2)
+	h.Record(20)
+	prev := snapshot()
+	// the first measurements expire while a new one is recorded: the window count goes down
+	clk.Advance(time.Minute)
+	h.Record(5)
+	d := DiffSnapshots(prev, snapshot())
+	w, _ := d.Get(InstrumentTypeHistogram, "w")
+	if w.Reset || w.Rate != 0 || w.Histogram.Count != 1 || w.Histogram.Sum != 5 {
+		t.Fatalf("expected the current window, got %+v", w)
+	}
+	if len(w.Histogram.Buckets) != 2 || w.Histogram.Buckets[0].Count != 1 || w.Histogram.Buckets[1].Count != 0 {
+		t.Fatalf("unexpected window buckets %+v", w.Histogram.Buckets)
+	}
+}
@@ -0,0 +1,307 @@
+package metrics
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// MergeConflict reports an instrument whose metadata differs between merged snapshots.
+// The metadata of the first snapshot containing the instrument is kept.
+type MergeConflict struct {
+	Type InstrumentType
+	Name string
+	// Field is the differing field: "description", "unit", "attributes", "buckets" or "window".
+	Field string
+	// Snapshot is the index of the snapshot whose metadata was ignored.
+	Snapshot int
+}
+
+// String returns a human-readable description of the conflict.
+func (c MergeConflict) String() string {
+	return NewInstrumentKey(c.Type, c.Name).String() + ": " + c.Field + " of snapshot " +
+		strconv.Itoa(c.Snapshot) + " differs"
+}
+
+type mergeConfig struct {
+	// attribute identifying the source snapshot; empty to sum instruments. Default: "".
+	instanceKey string
+	// attribute values per snapshot index; the index is used for missing ones.
+	instances []string
+	// most recent exemplars kept per bucket and per counter. Default:
+	// defaultExemplarReservoirSize per merged snapshot.
+	exemplarLimit int
+}
+
+// MergeOption configures MergeSnapshots.
+type MergeOption func(*mergeConfig)
+
+// WithInstanceAttribute keeps the instruments of each snapshot as separate series instead of
+// summing them: every instrument gets the attribute key set to the instance of its snapshot,
+// instances[i] for the i-th snapshot (its index if missing), and is renamed to its
+// SeriesName, so that the merged snapshot has one instrument per series.
+func WithInstanceAttribute(key string, instances ...string) MergeOption {
+	return func(cfg *mergeConfig) {
+		cfg.instanceKey = key
+		cfg.instances = instances
+	}
+}
+
+// WithExemplarLimit sets how many of the most recent exemplars a merged snapshot keeps per
+// histogram bucket, and per counter. The default keeps the exemplars of a reservoir of the
+// default size (see WithExemplarReservoirSize) per merged snapshot. Non-positive values are
+// ignored.
+func WithExemplarLimit(n int) MergeOption {
+	return func(cfg *mergeConfig) {
+		if n > 0 {
+			cfg.exemplarLimit = n
+		}
+	}
+}
+
+// MergeSnapshots aggregates snapshots of several providers, e.g. of worker processes or
+// shards, into a single snapshot. Instruments with the same type and name are merged:
+// counter and up/down counter values and rates are summed; histogram counts, sums and bucket
+// counts are summed and extremes combined. Quantiles of windowed histograms are estimated
+// from their merged sketches, which snapshots taken by WindowedHistogram (directly or through
+// EncodeSnapshot) carry; they are dropped if a snapshot lacks one. Exemplars are combined,
+// oldest first, and only the most recent ones are kept (see WithExemplarLimit), so that
+// merging into the same snapshot again and again does not grow them.
+//
+// The metadata of the first snapshot containing an instrument is kept and every differing
+// metadata of a later snapshot is reported as a conflict. Histogram bucket counts with
+// different bounds are merged into the first bucket whose bound covers theirs.
+// The merged snapshot time is the latest of the snapshots.
+func MergeSnapshots(snaps []ProviderSnapshot, opts ...MergeOption) (ProviderSnapshot, []MergeConflict) {
+	cfg := mergeConfig{exemplarLimit: defaultExemplarReservoirSize * len(snaps)}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	var (
+		out       ProviderSnapshot
+		conflicts []MergeConflict
+		index     = make(map[InstrumentKey]int)
+		// whether every merged snapshot of a windowed histogram had a sketch
+		sketched = make(map[InstrumentKey]bool)
+	)
+	for i, snap := range snaps {
+		if snap.Time.After(out.Time) {
+			out.Time = snap.Time
+		}
+		for _, s := range snap.Instruments {
+			if cfg.instanceKey != "" {
+				s = withInstance(s, cfg.instanceKey, cfg.instance(i))
+			}
+			key := s.Key()
+			j, ok := index[key]
+			if !ok {
+				index[key] = len(out.Instruments)
+				out.Instruments = append(out.Instruments, limitExemplars(copyInstrumentSnapshot(s), cfg.exemplarLimit))
+				sketched[key] = s.Histogram.sketch != nil
+				continue
+			}
+			m := &out.Instruments[j]
+			conflicts = append(conflicts, configConflicts(m, s.Config, i)...)
+			mergeInstrument(m, s, cfg.exemplarLimit)
+			sketched[key] = sketched[key] && s.Histogram.sketch != nil
+		}
+	}
+	for i := range out.Instruments {
+		m := &out.Instruments[i]
+		if m.Type != InstrumentTypeHistogram || m.Histogram.Quantiles == nil {
+			continue
+		}
+		if !sketched[m.Key()] {
+			m.Histogram.Quantiles, m.Histogram.sketch = nil, nil
+			continue
+		}
+		qs := make([]float64, len(m.Histogram.Quantiles))
+		for k, q := range m.Histogram.Quantiles {
+			qs[k] = q.Q
+		}
+		m.Histogram.Quantiles = sketchQuantiles(m.Histogram.sketch, qs, m.Histogram.Min, m.Histogram.Max)
+	}
+	sortInstruments(out.Instruments)
+	return out, conflicts
+}
+
+func (cfg *mergeConfig) instance(i int) string {
+	if i < len(cfg.instances) {
+		return cfg.instances[i]
+	}
+	return strconv.Itoa(i)
+}
+
+// withInstance returns s with the instance attribute set and renamed to its series name.
+func withInstance(s InstrumentSnapshot, key, instance string) InstrumentSnapshot {
+	attrs := make(map[string]string, len(s.Config.Attributes)+1)
+	for k, v := range s.Config.Attributes {
+		attrs[k] = v
+	}
+	attrs[key] = instance
+	s.Config.Attributes = attrs
+	s.Name = SeriesName(s.Name, map[string]string{key: instance})
+	return s
+}
+
+// copyInstrumentSnapshot returns a copy of s sharing no mutable state with it.
+func copyInstrumentSnapshot(s InstrumentSnapshot) InstrumentSnapshot {
+	s.Exemplars = slices.Clone(s.Exemplars)
+	s.Rates = slices.Clone(s.Rates)
+	s.Histogram.Buckets = slices.Clone(s.Histogram.Buckets)
+	for i := range s.Histogram.Buckets {
+		s.Histogram.Buckets[i].Exemplars = slices.Clone(s.Histogram.Buckets[i].Exemplars)
+	}
+	s.Histogram.Quantiles = slices.Clone(s.Histogram.Quantiles)
+	if sk := s.Histogram.sketch; sk != nil {
+		var c quantileSketch
+		c.merge(sk)
+		s.Histogram.sketch = &c
+	}
+	return s
+}
+
+// configConflicts returns the fields of cfg, from the snapshot at index i, differing from
+// the configuration of m.
+func configConflicts(m *InstrumentSnapshot, cfg InstrumentConfig, i int) []MergeConflict {
+	var out []MergeConflict
+	add := func(field string) {
+		out = append(out, MergeConflict{Type: m.Type, Name: m.Name, Field: field, Snapshot: i})
+	}
+	if cfg.Description != m.Config.Description {
+		add("description")
+	}
+	if cfg.Unit != m.Config.Unit {
+		add("unit")
+	}
+	if !mapsEqual(cfg.Attributes, m.Config.Attributes) {
+		add("attributes")
+	}
+	if m.Type == InstrumentTypeHistogram {
+		if !slices.Equal(cfg.Buckets, m.Config.Buckets) {
+			add("buckets")
+		}
+		if !windowsEqual(cfg.Window, m.Config.Window) {
+			add("window")
+		}
+	}
+	return out
+}
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func windowsEqual(a, b *HistogramWindow) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Duration == b.Duration && a.SubWindows == b.SubWindows && slices.Equal(a.Quantiles, b.Quantiles)
+}
+
+// mergeInstrument merges the state of s into m.
+func mergeInstrument(m *InstrumentSnapshot, s InstrumentSnapshot, limit int) {
+	m.Value += s.Value
+	m.Exemplars = mergeExemplars(m.Exemplars, s.Exemplars, limit)
+	m.Rates = mergeRates(m.Rates, s.Rates)
+	m.Times = mergeTimes(m.Times, s.Times)
+	if m.Type == InstrumentTypeHistogram {
+		mergeHistogram(&m.Histogram, s.Histogram, limit)
+	}
+}
+
+func mergeHistogram(m *HistSnapshot, s HistSnapshot, limit int) {
+	m.Buckets = withInfBucket(m.Buckets, m.Count)
+	m.Count += s.Count
+	m.Sum += s.Sum
+	m.Min = math.Min(m.Min, s.Min)
+	m.Max = math.Max(m.Max, s.Max)
+	m.Mean = 0
+	if m.Count > 0 {
+		m.Mean = m.Sum / float64(m.Count)
+	}
+	for _, b := range withInfBucket(s.Buckets, s.Count) {
+		// the first bucket covering b, the +Inf one at worst
+		i := sort.Search(len(m.Buckets), func(i int) bool { return m.Buckets[i].UpperBound >= b.UpperBound })
+		if i == len(m.Buckets) {
+			if i == 0 {
+				m.Buckets = append(m.Buckets, HistBucket{UpperBound: math.Inf(1)})
+			} else {
+				i--
+			}
+		}
+		m.Buckets[i].Count += b.Count
+		m.Buckets[i].Exemplars = mergeExemplars(m.Buckets[i].Exemplars, b.Exemplars, limit)
+	}
+	if m.sketch != nil && s.sketch != nil {
+		m.sketch.merge(s.sketch)
+	}
+}
+
+// withInfBucket returns the buckets of a histogram of count measurements, or a single +Inf
+// bucket holding them if it has none, so that merging keeps them in the buckets.
+func withInfBucket(buckets []HistBucket, count int64) []HistBucket {
+	if len(buckets) > 0 || count == 0 {
+		return buckets
+	}
+	return []HistBucket{{UpperBound: math.Inf(1), Count: count}}
+}
+
+// limitExemplars returns s with at most limit exemplars per bucket and for the instrument.
+func limitExemplars(s InstrumentSnapshot, limit int) InstrumentSnapshot {
+	s.Exemplars = mergeExemplars(s.Exemplars, nil, limit)
+	for i := range s.Histogram.Buckets {
+		s.Histogram.Buckets[i].Exemplars = mergeExemplars(s.Histogram.Buckets[i].Exemplars, nil, limit)
+	}
+	return s
+}
+
+// mergeExemplars returns the at most limit most recent exemplars of a and b ordered by
+// timestamp, oldest first.
+func mergeExemplars(a, b []Exemplar, limit int) []Exemplar {
+	out := append(a, b...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// mergeRates sums the readings of a and b if they come from the same kinds of trackers.
+func mergeRates(a, b []Rate) []Rate {
+	if len(a) != len(b) {
+		return nil
+	}
+	for i := range a {
+		if a[i].Kind != b[i].Kind || a[i].Window != b[i].Window {
+			return nil
+		}
+		a[i].PerSecond += b[i].PerSecond
+	}
+	return a
+}
+
+// mergeTimes keeps the earliest creation and the latest update and reset.
+func mergeTimes(a, b InstrumentTimes) InstrumentTimes {
+	if a.Created.IsZero() || (!b.Created.IsZero() && b.Created.Before(a.Created)) {
+		a.Created = b.Created
+	}
+	if b.LastUpdate.After(a.LastUpdate) {
+		a.LastUpdate = b.LastUpdate
+	}
+	if b.LastReset.After(a.LastReset) {
+		a.LastReset = b.LastReset
+	}
+	return a
+}
@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeSnapshots_Sums(t *testing.T) {
+	clk := newManualClock()
+	p1, p2 := NewBasicProvider(WithClock(clk)), NewBasicProvider(WithClock(clk), WithShardedCounters(2))
+	p1.Counter("c", WithDescription("requests")).Add(3)
+	p2.Counter("c", WithDescription("requests")).Add(4)
+	p1.UpDownCounter("u").Add(-1)
+	p2.UpDownCounter("u").Add(5)
+	p2.Counter("only2").Add(1)
+	h1 := p1.Histogram("h", WithBuckets(1, 10))
+	h2 := p2.Histogram("h", WithBuckets(1, 10))
+	h1.Record(0.5)
+	h1.(ExemplarHistogram).RecordWithExemplar(5, Exemplar{TraceID: "t1", Timestamp: time.Unix(20, 0)})
+	h2.(ExemplarHistogram).RecordWithExemplar(7, Exemplar{TraceID: "t2", Timestamp: time.Unix(10, 0)})
+	h2.Record(100)
+
+	s1 := p1.Snapshot()
+	clk.Advance(time.Second)
+	s2 := p2.Snapshot()
+	merged, conflicts := MergeSnapshots([]ProviderSnapshot{s1, s2})
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+	if !merged.Time.Equal(s2.Time) {
+		t.Fatalf("expected the latest snapshot time, got %v", merged.Time)
+	}
+	if c, _ := merged.Get(InstrumentTypeCounter, "c"); c.Value != 7 || c.Config.Description != "requests" {
+		t.Fatalf("unexpected merged counter %+v", c)
+	}
+	if u, _ := merged.Get(InstrumentTypeUpDown, "u"); u.Value != 4 {
+		t.Fatalf("unexpected merged up/down counter %+v", u)
+	}
+	if c, ok := merged.Get(InstrumentTypeCounter, "only2"); !ok || c.Value != 1 {
+		t.Fatalf("expected instruments of a single snapshot to be kept, got %+v", c)
+	}
+	h, _ := merged.Get(InstrumentTypeHistogram, "h")
+	hs := h.Histogram
+	if hs.Count != 4 || hs.Sum != 112.5 || hs.Min != 0.5 || hs.Max != 100 || hs.Mean != 112.5/4 {
+		t.Fatalf("unexpected merged histogram %+v", hs)
+	}
+	if hs.Buckets[0].Count != 1 || hs.Buckets[1].Count != 2 || hs.Buckets[2].Count != 1 {
+		t.Fatalf("unexpected merged buckets %+v", hs.Buckets)
+	}
+	if ex := hs.Buckets[1].Exemplars; len(ex) != 2 || ex[0].TraceID != "t2" || ex[1].TraceID != "t1" {
+		t.Fatalf("expected exemplars ordered by time, got %+v", ex)
+	}
+
+	// the inputs are not modified
+	if c, _ := s1.Get(InstrumentTypeCounter, "c"); c.Value != 3 {
+		t.Fatalf("expected the input snapshot to be unchanged, got %d", c.Value)
+	}
+	if h, _ := s1.Get(InstrumentTypeHistogram, "h"); len(h.Histogram.Buckets[1].Exemplars) != 1 {
+		t.Fatalf("expected the input exemplars to be unchanged")
+	}
+}
+
+func TestMergeSnapshots_ExemplarLimit(t *testing.T) {
+	snapshot := func(traceID string, ts int64) ProviderSnapshot {
+		p := NewBasicProvider()
+		p.Histogram("h", WithBuckets(10)).(ExemplarHistogram).
+			RecordWithExemplar(1, Exemplar{TraceID: traceID, Timestamp: time.Unix(ts, 0)})
+		return p.Snapshot()
+	}
+	exemplars := func(snap ProviderSnapshot) []string {
+		h, _ := snap.Get(InstrumentTypeHistogram, "h")
+		var ids []string
+		for _, e := range h.Histogram.Buckets[0].Exemplars {
+			ids = append(ids, e.TraceID)
+		}
+		return ids
+	}
+
+	// merging into the same snapshot again and again keeps the most recent exemplars only
+	merged := snapshot("t0", 0)
+	for i := 1; i <= 5; i++ {
+		merged, _ = MergeSnapshots([]ProviderSnapshot{merged, snapshot(fmt.Sprintf("t%d", i), int64(i))})
+	}
+	if got := exemplars(merged); len(got) != 2 || got[0] != "t4" || got[1] != "t5" {
+		t.Fatalf("expected the 2 most recent exemplars, got %v", got)
+	}
+
+	merged, _ = MergeSnapshots([]ProviderSnapshot{snapshot("a", 3), snapshot("b", 1), snapshot("c", 2)},
+		WithExemplarLimit(1))
+	if got := exemplars(merged); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("expected the most recent exemplar, got %v", got)
+	}
+}
+
+func TestMergeSnapshots_Conflicts(t *testing.T) {
+	p1, p2 := NewBasicProvider(), NewBasicProvider()
+	p1.Counter("c", WithUnit("1")).Add(1)
+	p2.Counter("c", WithUnit("requests"), WithAttributes(map[string]string{"a": "b"})).Add(1)
+	p1.Histogram("h", WithBuckets(1, 10)).Record(5)
+	h2 := p2.Histogram("h", WithBuckets(2, 20, 50))
+	h2.Record(1.5)
+	h2.Record(15)
+	h2.Record(30)
+
+	merged, conflicts := MergeSnapshots([]ProviderSnapshot{p1.Snapshot(), p2.Snapshot()})
+	want := []string{
+		"counter:c: unit of snapshot 1 differs",
+		"counter:c: attributes of snapshot 1 differs",
+		"histogram:h: buckets of snapshot 1 differs",
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("expected %d conflicts, got %v", len(want), conflicts)
+	}
+	for i, c := range conflicts {
+		if c.String() != want[i] {
+			t.Fatalf("conflict %d: expected %q, got %q", i, want[i], c)
+		}
+	}
+	c, _ := merged.Get(InstrumentTypeCounter, "c")
+	if c.Config.Unit != "1" || c.Value != 2 {
+		t.Fatalf("expected the first metadata to win, got %+v", c)
+	}
+	// 1.5 (le 2) goes to le 10, 15 (le 20) and 30 (le 50) to +Inf
+	h, _ := merged.Get(InstrumentTypeHistogram, "h")
+	if b := h.Histogram.Buckets; len(b) != 3 || b[0].Count != 0 || b[1].Count != 2 || b[2].Count != 2 {
+		t.Fatalf("unexpected rebucketed counts %+v", b)
+	}
+}
+
+func TestMergeSnapshots_HistogramWithoutBuckets(t *testing.T) {
+	hist := func(count int64, buckets ...HistBucket) ProviderSnapshot {
+		return ProviderSnapshot{Instruments: []InstrumentSnapshot{{
+			Type: InstrumentTypeHistogram, Name: "h",
+			Histogram: HistSnapshot{Count: count, Sum: float64(count), Buckets: buckets},
+		}}}
+	}
+	bare := hist(3)
+	bucketed := hist(2, HistBucket{UpperBound: 1, Count: 1}, HistBucket{UpperBound: math.Inf(1), Count: 1})
+	for _, snaps := range [][]ProviderSnapshot{{bare, bucketed}, {bucketed, bare}} {
+		merged, conflicts := MergeSnapshots(snaps)
+		if len(conflicts) != 0 {
+			t.Fatalf("unexpected conflicts %v", conflicts)
+		}
+		h, _ := merged.Get(InstrumentTypeHistogram, "h")
+		var total int64
+		for _, b := range h.Histogram.Buckets {
+			total += b.Count
+		}
+		if h.Histogram.Count != 5 || total != 5 {
+			t.Fatalf("expected the buckets to cover the 5 measurements, got %+v", h.Histogram)
+		}
+	}
+}
+
+func TestMergeSnapshots_WindowedQuantiles(t *testing.T) {
+	clk := newManualClock()
+	w := WithRollingWindow(HistogramWindow{Duration: time.Minute, Quantiles: []float64{0.5, 0.99}})
+	p1, p2 := NewBasicProvider(WithClock(clk)), NewBasicProvider(WithClock(clk))
+	for i := 1; i <= 50; i++ {
+		p1.Histogram("h", w).Record(float64(i))
+		p2.Histogram("h", w).Record(float64(50 + i))
+	}
+
+	// the sketch survives encoding, e.g. to send the snapshot to an aggregator
+	var buf bytes.Buffer
+	if err := EncodeSnapshot(&buf, p2.Snapshot()); err != nil {
+		t.Fatalf("EncodeSnapshot: %v", err)
+	}
+	s2, err := DecodeSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("DecodeSnapshot: %v", err)
+	}
+	if h, _ := s2.Get(InstrumentTypeHistogram, "h"); len(h.Histogram.Quantiles) != 2 {
+		t.Fatalf("expected decoded quantiles, got %+v", h.Histogram.Quantiles)
+	}
+
+	merged, _ := MergeSnapshots([]ProviderSnapshot{p1.Snapshot(), s2})
+	h, _ := merged.Get(InstrumentTypeHistogram, "h")
+	qs := h.Histogram.Quantiles
+	if len(qs) != 2 || math.Abs(qs[0].Value-50)/50 > 0.01 || math.Abs(qs[1].Value-99)/99 > 0.01 {
+		t.Fatalf("unexpected merged quantiles %+v", qs)
+	}
+
+	// without a sketch the quantiles cannot be merged
+	bare := ProviderSnapshot{Instruments: []InstrumentSnapshot{{
+		Type: InstrumentTypeHistogram, Name: "h", Config: h.Config,
+		Histogram: HistSnapshot{Count: 1, Sum: 1, Min: 1, Max: 1, Quantiles: []Quantile{{Q: 0.5, Value: 1}}},
+	}}}
+	merged, _ = MergeSnapshots([]ProviderSnapshot{p1.Snapshot(), bare})
+	if h, _ := merged.Get(InstrumentTypeHistogram, "h"); h.Histogram.Quantiles != nil || h.Histogram.Count != 51 {
+		t.Fatalf("expected quantiles to be dropped, got %+v", h.Histogram)
+	}
+}
+
+func TestMergeSnapshots_InstanceAttribute(t *testing.T) {
+	p1, p2 := NewBasicProvider(), NewBasicProvider()
+	p1.Counter("requests_total", WithAttributes(map[string]string{"route": "/"})).Add(1)
+	p2.Counter("requests_total", WithAttributes(map[string]string{"route": "/"})).Add(2)
+	p2.UpDownCounter("inflight").Add(3)
+
+	merged, conflicts := MergeSnapshots([]ProviderSnapshot{p1.Snapshot(), p2.Snapshot(), p2.Snapshot()},
+		WithInstanceAttribute("instance", "w1", "w2"))
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+	if len(merged.Instruments) != 5 {
+		t.Fatalf("expected one instrument per series, got %+v", merged.Instruments)
+	}
+	c, ok := merged.Get(InstrumentTypeCounter, `requests_total{instance="w2"}`)
+	if !ok || c.Value != 2 || c.Config.Attributes["instance"] != "w2" || c.Config.Attributes["route"] != "/" {
+		t.Fatalf("unexpected series %+v", c)
+	}
+	if _, ok := merged.Get(InstrumentTypeUpDown, `inflight{instance="2"}`); !ok {
+		t.Fatal("expected the snapshot index as the default instance")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, merged); err != nil {
+		t.Fatalf("WriteOpenMetrics: %v", err)
+	}
+	out := buf.String()
+	if strings.Count(out, "# TYPE requests counter") != 1 ||
+		!strings.Contains(out, `requests_total{instance="w1",route="/"} 1`) ||
+		!strings.Contains(out, `requests_total{instance="w2",route="/"} 2`) {
+		t.Fatalf("expected the series in a single family, got:\n%s", out)
+	}
+}
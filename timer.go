@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+)
+
+// Timer records durations into a Histogram, converting them to the histogram's unit.
+// It replaces the hand-rolled `start := time.Now(); defer h.Record(time.Since(start).Seconds())`
+// pattern:
+//
+//	t := metrics.TimerFromProvider(p, "request_duration_seconds", metrics.WithUnit("seconds"))
+//	defer t.Start().Stop()
+//
+// Timer is safe for concurrent use.
+type Timer struct {
+	h Histogram
+	// nanoseconds per unit of the histogram
+	scale float64
+	clock Clock
+}
+
+type timerConfig struct {
+	unit  string
+	clock Clock
+}
+
+// TimerOption configures a Timer constructed by NewTimer or TimerFromProvider.
+type TimerOption func(*timerConfig)
+
+// WithTimerUnit sets the unit durations are converted to before being recorded.
+// Recognized units are "ns", "us" (or "µs"), "ms", "s", "min" and "h", as well as their
+// long forms ("nanoseconds", ..., "hours"). Unknown or empty units default to seconds.
+func WithTimerUnit(unit string) TimerOption {
+	return func(cfg *timerConfig) { cfg.unit = unit }
+}
+
+// WithTimerClock sets the clock measuring durations. Defaults to SystemClock.
+// A nil clock is ignored.
+func WithTimerClock(c Clock) TimerOption {
+	return func(cfg *timerConfig) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// NewTimer constructs a Timer recording into h. Durations are recorded in seconds
+// unless another unit is set with WithTimerUnit.
+func NewTimer(h Histogram, opts ...TimerOption) *Timer {
+	cfg := &timerConfig{clock: SystemClock()}
+	for _, o := range opts {
+		if o != nil {
+			o(cfg)
+		}
+	}
+	return &Timer{h: h, scale: durationUnitScale(cfg.unit), clock: cfg.clock}
+}
+
+// TimerFromProvider obtains the histogram called name from p and constructs a Timer for it.
+// The unit is taken from a WithUnit option in opts; if there is none and p implements
+// Inspector, the unit stored for an already existing histogram is used instead.
+// If p exposes a Clock (as BasicProvider does), the timer uses it.
+func TimerFromProvider(p Provider, name string, opts ...InstrumentOption) *Timer {
+	h := p.Histogram(name, opts...)
+	unit := applyOptions(opts).Unit
+	if unit == "" {
+		if in, ok := p.(Inspector); ok {
+			if _, cfg, found := in.HistogramWithMeta(name); found {
+				unit = cfg.Unit
+			}
+		}
+	}
+	var clock Clock
+	if cs, ok := p.(clockSource); ok {
+		clock = cs.Clock()
+	}
+	return NewTimer(h, WithTimerUnit(unit), WithTimerClock(clock))
+}
+
+// durationUnitScale returns the number of nanoseconds in one unit.
+func durationUnitScale(unit string) float64 {
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "ns", "nanosecond", "nanoseconds":
+		return float64(time.Nanosecond)
+	case "us", "µs", "microsecond", "microseconds":
+		return float64(time.Microsecond)
+	case "ms", "millisecond", "milliseconds":
+		return float64(time.Millisecond)
+	case "min", "minute", "minutes":
+		return float64(time.Minute)
+	case "h", "hour", "hours":
+		return float64(time.Hour)
+	default:
+		return float64(time.Second)
+	}
+}
+
+// ObserveDuration records d converted to the timer's unit.
+func (t *Timer) ObserveDuration(d time.Duration) {
+	t.h.Record(float64(d) / t.scale)
+}
+
+// Time runs f, records how long it took and returns the duration.
+// The duration is recorded even if f panics.
+func (t *Timer) Time(f func()) (d time.Duration) {
+	s := t.Start()
+	defer func() { d = s.Stop() }()
+	f()
+	return d
+}
+
+// Start starts measuring a duration. Call Stop on the returned Stopwatch to record it.
+func (t *Timer) Start() Stopwatch {
+	return Stopwatch{t: t, start: t.clock.Now()}
+}
+
+// Stopwatch is a running measurement started by Timer.Start.
+// It is a small value type; starting and stopping does not allocate.
+type Stopwatch struct {
+	t     *Timer
+	start time.Time
+}
+
+// Stop records the time elapsed since Start and returns it.
+// Each call records a new measurement.
+func (s Stopwatch) Stop() time.Duration {
+	d := s.t.clock.Now().Sub(s.start)
+	s.t.ObserveDuration(d)
+	return d
+}
+
+// InFlightTracker pairs an UpDownCounter of operations in progress with a Timer
+// recording their durations.
+//
+//	tr := metrics.NewInFlightTracker(p.UpDownCounter("jobs_in_flight"), metrics.TimerFromProvider(p, "job_seconds"))
+//	op := tr.Start()
+//	defer op.Done()
+type InFlightTracker struct {
+	inflight UpDownCounter
+	timer    *Timer
+}
+
+// NewInFlightTracker constructs an InFlightTracker from the given instruments.
+func NewInFlightTracker(inflight UpDownCounter, timer *Timer) *InFlightTracker {
+	return &InFlightTracker{inflight: inflight, timer: timer}
+}
+
+// Start increments the in-flight counter and starts measuring the operation duration.
+func (tr *InFlightTracker) Start() InFlightOperation {
+	tr.inflight.Add(1)
+	return InFlightOperation{tr: tr, sw: tr.timer.Start()}
+}
+
+// InFlightOperation is an operation tracked by InFlightTracker.Start.
+type InFlightOperation struct {
+	tr *InFlightTracker
+	sw Stopwatch
+}
+
+// Done decrements the in-flight counter, records the operation duration and returns it.
+// It must be called exactly once per operation.
+func (op InFlightOperation) Done() time.Duration {
+	op.tr.inflight.Add(-1)
+	return op.sw.Stop()
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// steppingClock is a Clock whose Now advances by step on every call.
+// Timers and tickers are those of the system clock.
+type steppingClock struct {
+	systemClock
+	cur  time.Time
+	step time.Duration
+}
+
+func newSteppingClock(step time.Duration) *steppingClock {
+	return &steppingClock{cur: time.Unix(0, 0), step: step}
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.cur = c.cur.Add(c.step)
+	return c.cur
+}
+
+func almostEqual(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestDurationUnitScale(t *testing.T) {
+	cases := []struct {
+		unit string
+		want time.Duration
+	}{
+		{"", time.Second},
+		{"seconds", time.Second},
+		{"s", time.Second},
+		{"unknown", time.Second},
+		{"ms", time.Millisecond},
+		{" Milliseconds ", time.Millisecond},
+		{"us", time.Microsecond},
+		{"µs", time.Microsecond},
+		{"ns", time.Nanosecond},
+		{"min", time.Minute},
+		{"hours", time.Hour},
+	}
+	for _, c := range cases {
+		if got := durationUnitScale(c.unit); got != float64(c.want) {
+			t.Fatalf("unit %q: scale = %v; want %v", c.unit, got, float64(c.want))
+		}
+	}
+}
+
+func TestTimer_ObserveDurationScales(t *testing.T) {
+	p := NewBasicProvider()
+
+	NewTimer(p.Histogram("s")).ObserveDuration(1500 * time.Millisecond)
+	NewTimer(p.Histogram("ms"), WithTimerUnit("ms")).ObserveDuration(1500 * time.Millisecond)
+
+	if got := p.Histogram("s").(*BasicHistogram).Snapshot().Sum; !almostEqual(got, 1.5) {
+		t.Fatalf("seconds sum = %v; want 1.5", got)
+	}
+	if got := p.Histogram("ms").(*BasicHistogram).Snapshot().Sum; !almostEqual(got, 1500) {
+		t.Fatalf("milliseconds sum = %v; want 1500", got)
+	}
+}
+
+func TestTimerFromProvider_HonorsWithUnit(t *testing.T) {
+	p := NewBasicProvider()
+
+	tm := TimerFromProvider(p, "lat_ms", WithUnit("ms"))
+	tm.ObserveDuration(2 * time.Millisecond)
+	if got := p.Histogram("lat_ms").(*BasicHistogram).Snapshot().Sum; !almostEqual(got, 2) {
+		t.Fatalf("sum = %v; want 2", got)
+	}
+
+	// unit not passed again: taken from the stored metadata
+	TimerFromProvider(p, "lat_ms").ObserveDuration(3 * time.Millisecond)
+	if got := p.Histogram("lat_ms").(*BasicHistogram).Snapshot().Sum; !almostEqual(got, 5) {
+		t.Fatalf("sum = %v; want 5", got)
+	}
+
+	// non-inspector provider: defaults to seconds
+	TimerFromProvider(NewNoopProvider(), "x").ObserveDuration(time.Second)
+}
+
+func TestTimer_StartStopAndTime(t *testing.T) {
+	p := NewBasicProvider()
+	tm := NewTimer(p.Histogram("h"), WithTimerUnit("ms"), WithTimerClock(newSteppingClock(10*time.Millisecond)))
+
+	if d := tm.Start().Stop(); d != 10*time.Millisecond {
+		t.Fatalf("stopwatch duration = %v; want 10ms", d)
+	}
+
+	called := false
+	if d := tm.Time(func() { called = true }); d != 10*time.Millisecond || !called {
+		t.Fatalf("Time duration = %v, called = %v; want 10ms, true", d, called)
+	}
+
+	func() {
+		defer func() { _ = recover() }()
+		tm.Time(func() { panic("boom") })
+	}()
+
+	s := p.Histogram("h").(*BasicHistogram).Snapshot()
+	if s.Count != 3 || !almostEqual(s.Sum, 30) {
+		t.Fatalf("unexpected snapshot %+v; want count 3 sum 30", s)
+	}
+}
+
+func TestInFlightTracker(t *testing.T) {
+	p := NewBasicProvider()
+	tm := NewTimer(p.Histogram("job_seconds"), WithTimerClock(newSteppingClock(time.Second)))
+	tr := NewInFlightTracker(p.UpDownCounter("jobs_in_flight"), tm)
+	inflight := p.UpDownCounter("jobs_in_flight").(*BasicUpDownCounter)
+
+	op1 := tr.Start()
+	op2 := tr.Start()
+	if got := inflight.Snapshot(); got != 2 {
+		t.Fatalf("in flight = %d; want 2", got)
+	}
+	if d := op1.Done(); d != 2*time.Second {
+		t.Fatalf("op1 duration = %v; want 2s", d)
+	}
+	op2.Done()
+	if got := inflight.Snapshot(); got != 0 {
+		t.Fatalf("in flight = %d; want 0", got)
+	}
+	if got := p.Histogram("job_seconds").(*BasicHistogram).Snapshot().Count; got != 2 {
+		t.Fatalf("duration count = %d; want 2", got)
+	}
+}
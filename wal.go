@@ -0,0 +1,649 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithDurable makes a counter or up/down counter durable: with a provider constructed with
+// WithWAL, every update is appended to the write-ahead log, so that it survives a crash once
+// committed. Durable updates are slower than regular ones, as they go through a shared log.
+// Ignored by histograms and by providers without a write-ahead log.
+func WithDurable() InstrumentOption {
+	return func(c *InstrumentConfig) { c.Durable = true }
+}
+
+// WALSyncPolicy controls when the write-ahead log is synced to stable storage.
+type WALSyncPolicy int
+
+const (
+	// WALSyncCommit syncs the log after every group commit that wrote records. It is the default.
+	WALSyncCommit WALSyncPolicy = iota
+	// WALSyncInterval syncs the log at most once per sync interval (see WithWALSyncInterval).
+	// Updates committed since the last sync may be lost if the machine crashes.
+	WALSyncInterval
+	// WALSyncNever leaves syncing the log to the operating system, except on Sync and Close.
+	WALSyncNever
+)
+
+// ErrWALClosed is returned by operations on a closed WAL.
+var ErrWALClosed = errors.New("metrics: write-ahead log closed")
+
+const (
+	defaultWALCommitInterval = 10 * time.Millisecond
+	defaultWALSyncInterval   = time.Second
+	defaultWALMaxLogSize     = 16 << 20
+)
+
+type walConfig struct {
+	// interval between group commits. Default: defaultWALCommitInterval.
+	commitInterval time.Duration
+	// when the log is synced. Default: WALSyncCommit.
+	sync WALSyncPolicy
+	// minimum interval between syncs with WALSyncInterval. Default: defaultWALSyncInterval.
+	syncInterval time.Duration
+	// log size above which the log is compacted after a commit. Default: defaultWALMaxLogSize.
+	maxLogSize int64
+	// source of time of the commit ticker. Default: SystemClock.
+	clock  Clock
+	logger logger
+}
+
+// WALOption configures a WAL opened by OpenWAL.
+type WALOption func(*walConfig)
+
+// WithWALCommitInterval sets the interval between group commits, i.e. the maximum time an
+// update waits before it is written to the log. Non-positive values are ignored.
+func WithWALCommitInterval(d time.Duration) WALOption {
+	return func(cfg *walConfig) {
+		if d > 0 {
+			cfg.commitInterval = d
+		}
+	}
+}
+
+// WithWALSync sets when the log is synced to stable storage.
+func WithWALSync(policy WALSyncPolicy) WALOption {
+	return func(cfg *walConfig) { cfg.sync = policy }
+}
+
+// WithWALSyncInterval syncs the log at most once per d (see WALSyncInterval).
+// Non-positive values are ignored.
+func WithWALSyncInterval(d time.Duration) WALOption {
+	return func(cfg *walConfig) {
+		if d > 0 {
+			cfg.sync = WALSyncInterval
+			cfg.syncInterval = d
+		}
+	}
+}
+
+// WithWALMaxLogSize sets the size in bytes above which the log is compacted into a snapshot
+// file. Non-positive values are ignored.
+func WithWALMaxLogSize(n int64) WALOption {
+	return func(cfg *walConfig) {
+		if n > 0 {
+			cfg.maxLogSize = n
+		}
+	}
+}
+
+// WithWALClock sets the clock driving group commits. The default is SystemClock.
+// A nil clock is ignored.
+func WithWALClock(c Clock) WALOption {
+	return func(cfg *walConfig) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// WithWALLogger sets the logger used to report failed background commits and compactions.
+func WithWALLogger(l logger) WALOption {
+	return func(cfg *walConfig) { cfg.logger = l }
+}
+
+// WAL is a write-ahead log of the updates of durable instruments (see WithDurable and WithWAL).
+//
+// Updates are buffered in memory, coalesced per instrument and appended to the log by a
+// background group commit every commit interval. The log is synced according to the sync
+// policy and compacted into a snapshot file when it grows above the maximum log size. A
+// directory holds the snapshot file and the log files of one WAL:
+//
+//	w, err := metrics.OpenWAL("/var/lib/app/metrics")
+//	if err != nil { ... }
+//	defer w.Close()
+//	p := metrics.NewBasicProvider(metrics.WithWAL(w))
+//	billed := p.Counter("billed_bytes_total", metrics.WithDurable())
+//
+// OpenWAL recovers the state of the previous process from the directory. A durable
+// instrument starts from its recovered value when it is created; recovered instruments
+// that are not created again are kept in later snapshot files.
+type WAL struct {
+	dir    string
+	cfg    walConfig
+	logger logger
+
+	// gate is read-locked by durable updates, which modify the instrument and append to the
+	// pending records together, and write-locked by compactions, so that every update is in
+	// exactly one of the compacted snapshot and the new log.
+	gate sync.RWMutex
+	// compactMu serializes compactions.
+	compactMu sync.Mutex
+
+	// mu protects the fields below; it is never held while acquiring another lock.
+	mu        sync.Mutex
+	pending   map[InstrumentKey]walPending
+	journals  map[InstrumentKey]*walJournal
+	recovered map[InstrumentKey]InstrumentSnapshot
+	attached  bool
+
+	// fileMu protects the fields below. It is held while pending records are taken and
+	// written, so that they are written to the log they were committed to.
+	fileMu   sync.Mutex
+	log      *os.File
+	gen      uint64
+	size     int64
+	dirty    bool
+	lastSync time.Time
+	closed   bool
+	buf      []byte
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// walPending are the coalesced updates of an instrument since the last commit.
+type walPending struct {
+	// reset is set if the instrument was reset before delta was added.
+	reset bool
+	delta int64
+}
+
+// walJournal connects a durable instrument to its WAL.
+type walJournal struct {
+	w     *WAL
+	key   InstrumentKey
+	cfg   InstrumentConfig
+	times *instrumentTimes
+	inst  durableInstrument
+}
+
+// durableInstrument is an instrument whose updates can be logged.
+// It is implemented by BasicCounter, ShardedCounter and BasicUpDownCounter.
+type durableInstrument interface {
+	Snapshot() int64
+	restore(n int64)
+}
+
+// begin appends an update of n to the pending records and holds the WAL gate until end,
+// so that the instrument can apply the update. It returns false for a nil journal.
+func (j *walJournal) begin(n int64) bool {
+	if j == nil {
+		return false
+	}
+	j.w.gate.RLock()
+	j.w.append(j.key, walPending{delta: n})
+	return true
+}
+
+// beginReset is like begin for a reset of the instrument.
+func (j *walJournal) beginReset() bool {
+	if j == nil {
+		return false
+	}
+	j.w.gate.RLock()
+	j.w.append(j.key, walPending{reset: true})
+	return true
+}
+
+// end releases the WAL gate held since begin or beginReset.
+func (j *walJournal) end() { j.w.gate.RUnlock() }
+
+func (w *WAL) append(key InstrumentKey, u walPending) {
+	w.mu.Lock()
+	p := w.pending[key]
+	if u.reset {
+		p = u
+	} else {
+		p.delta += u.delta
+	}
+	w.pending[key] = p
+	w.mu.Unlock()
+}
+
+// OpenWAL opens the write-ahead log in dir, creating the directory if needed, and recovers
+// the state left by a previous process: the latest snapshot file plus the updates logged
+// after it. A torn record at the end of a log, left by a crash during a write, ends the
+// replay of that log. The recovered state is compacted into a new snapshot file right away.
+func OpenWAL(dir string, opts ...WALOption) (*WAL, error) {
+	cfg := walConfig{
+		commitInterval: defaultWALCommitInterval,
+		syncInterval:   defaultWALSyncInterval,
+		maxLogSize:     defaultWALMaxLogSize,
+		clock:          SystemClock(),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(&cfg)
+		}
+	}
+	l := cfg.logger
+	if l == nil {
+		l = newNoopLogger()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("metrics: opening write-ahead log: %w", err)
+	}
+	w := &WAL{
+		dir: dir, cfg: cfg, logger: l,
+		pending:  make(map[InstrumentKey]walPending),
+		journals: make(map[InstrumentKey]*walJournal),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	gen, err := w.recover()
+	if err != nil {
+		return nil, err
+	}
+	w.gen = gen
+	if err := w.Compact(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// recover loads the latest snapshot file of the directory and replays the logs written after
+// it into w.recovered. It returns the highest generation found.
+func (w *WAL) recover() (uint64, error) {
+	snapshots, logs, err := w.files()
+	if err != nil {
+		return 0, err
+	}
+	w.recovered = make(map[InstrumentKey]InstrumentSnapshot)
+	var base, last uint64
+	if n := len(snapshots); n > 0 {
+		base = snapshots[n-1]
+		snap, err := LoadSnapshot(w.snapshotPath(base))
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range snap.Instruments {
+			s.Config.Durable = true
+			w.recovered[s.Key()] = s
+		}
+		last = base
+	}
+	for _, gen := range logs {
+		if gen < base {
+			continue
+		}
+		if err := w.replay(w.logPath(gen)); err != nil {
+			return 0, err
+		}
+		last = max(last, gen)
+	}
+	return last, nil
+}
+
+// files returns the generations of the snapshot and log files of the directory, sorted.
+func (w *WAL) files() (snapshots, logs []uint64, err error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("metrics: opening write-ahead log: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasPrefix(name, "snapshot-"):
+			if gen, err := strconv.ParseUint(strings.TrimPrefix(name, "snapshot-"), 16, 64); err == nil {
+				snapshots = append(snapshots, gen)
+			}
+		case strings.HasPrefix(name, "wal-") && strings.HasSuffix(name, ".log"):
+			if gen, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "wal-"), ".log"), 16, 64); err == nil {
+				logs = append(logs, gen)
+			}
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	return snapshots, logs, nil
+}
+
+func (w *WAL) snapshotPath(gen uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("snapshot-%016x", gen))
+}
+
+func (w *WAL) logPath(gen uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("wal-%016x.log", gen))
+}
+
+// Log records are framed as a little-endian uint32 payload length and the CRC32 (IEEE) of
+// the payload, followed by the payload: the record kind, the uvarint-prefixed instrument type
+// and name and, for walRecordAdd, the varint delta.
+const (
+	walRecordAdd   = 1
+	walRecordReset = 2
+
+	walFrameHeader = 8
+	// walMaxRecord bounds the payload length accepted on replay.
+	walMaxRecord = 1 << 20
+)
+
+// replay applies the records of the log at path to w.recovered.
+func (w *WAL) replay(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("metrics: replaying write-ahead log: %w", err)
+	}
+	for len(data) >= walFrameHeader {
+		n := binary.LittleEndian.Uint32(data)
+		if n > walMaxRecord || int(n) > len(data)-walFrameHeader {
+			break
+		}
+		payload := data[walFrameHeader : walFrameHeader+int(n)]
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:]) {
+			break
+		}
+		key, u, ok := decodeWALRecord(payload)
+		if !ok {
+			break
+		}
+		s, found := w.recovered[key]
+		if !found {
+			s = InstrumentSnapshot{Type: key.Type, Name: key.Name, Config: InstrumentConfig{Durable: true}}
+		}
+		if u.reset {
+			s.Value = 0
+		}
+		s.Value += u.delta
+		w.recovered[key] = s
+		data = data[walFrameHeader+int(n):]
+	}
+	return nil
+}
+
+func appendWALRecord(b []byte, key InstrumentKey, u walPending) []byte {
+	if u.reset {
+		b = appendWALFrame(b, walRecordReset, key, 0)
+		if u.delta == 0 {
+			return b
+		}
+	}
+	return appendWALFrame(b, walRecordAdd, key, u.delta)
+}
+
+func appendWALFrame(b []byte, kind byte, key InstrumentKey, delta int64) []byte {
+	start := len(b)
+	b = append(b, make([]byte, walFrameHeader)...)
+	b = append(b, kind)
+	b = binary.AppendUvarint(b, uint64(len(key.Type)))
+	b = append(b, key.Type...)
+	b = binary.AppendUvarint(b, uint64(len(key.Name)))
+	b = append(b, key.Name...)
+	if kind == walRecordAdd {
+		b = binary.AppendVarint(b, delta)
+	}
+	payload := b[start+walFrameHeader:]
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(b[start+4:], crc32.ChecksumIEEE(payload))
+	return b
+}
+
+func decodeWALRecord(p []byte) (InstrumentKey, walPending, bool) {
+	if len(p) == 0 {
+		return InstrumentKey{}, walPending{}, false
+	}
+	kind, p := p[0], p[1:]
+	typ, p, ok := readWALString(p)
+	if !ok {
+		return InstrumentKey{}, walPending{}, false
+	}
+	name, p, ok := readWALString(p)
+	if !ok {
+		return InstrumentKey{}, walPending{}, false
+	}
+	key := NewInstrumentKey(InstrumentType(typ), name)
+	switch kind {
+	case walRecordReset:
+		return key, walPending{reset: true}, len(p) == 0
+	case walRecordAdd:
+		delta, n := binary.Varint(p)
+		return key, walPending{delta: delta}, n > 0 && n == len(p)
+	}
+	return InstrumentKey{}, walPending{}, false
+}
+
+func readWALString(p []byte) (string, []byte, bool) {
+	n, k := binary.Uvarint(p)
+	if k <= 0 || n > uint64(len(p)-k) {
+		return "", nil, false
+	}
+	return string(p[k : k+int(n)]), p[k+int(n):], true
+}
+
+// attach marks w as used by a provider. It reports false if it already was.
+func (w *WAL) attach() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.attached {
+		return false
+	}
+	w.attached = true
+	return true
+}
+
+// register connects a newly created durable instrument to w, seeding it with its recovered
+// state, if any.
+func (w *WAL) register(key InstrumentKey, cfg InstrumentConfig, times *instrumentTimes, inst durableInstrument) *walJournal {
+	j := &walJournal{w: w, key: key, cfg: cfg, times: times, inst: inst}
+	w.gate.RLock()
+	defer w.gate.RUnlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s, ok := w.recovered[key]; ok {
+		inst.restore(s.Value)
+		times.restore(s.Times)
+		delete(w.recovered, key)
+	}
+	w.journals[key] = j
+	return j
+}
+
+// run commits pending records every commit interval until Close.
+func (w *WAL) run() {
+	defer close(w.done)
+	t := w.cfg.clock.NewTicker(w.cfg.commitInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C():
+			if err := w.commit(false); err != nil {
+				w.logger.Errorf("[metrics] write-ahead log commit: %v", err)
+				continue
+			}
+			if w.logSize() > w.cfg.maxLogSize {
+				if err := w.Compact(); err != nil {
+					w.logger.Errorf("[metrics] write-ahead log compaction: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func (w *WAL) logSize() int64 {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	return w.size
+}
+
+// commit writes the pending records to the log and syncs it according to the sync policy,
+// or unconditionally if forceSync is set.
+func (w *WAL) commit(forceSync bool) error {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	if w.closed {
+		return ErrWALClosed
+	}
+	w.mu.Lock()
+	pending := w.pending
+	if len(pending) > 0 {
+		w.pending = make(map[InstrumentKey]walPending, len(pending))
+	}
+	w.mu.Unlock()
+
+	if len(pending) > 0 {
+		w.buf = w.buf[:0]
+		for key, u := range pending {
+			w.buf = appendWALRecord(w.buf, key, u)
+		}
+		n, err := w.log.Write(w.buf)
+		w.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("metrics: writing write-ahead log: %w", err)
+		}
+		w.dirty = true
+	}
+	if !w.dirty {
+		return nil
+	}
+	now := w.cfg.clock.Now()
+	doSync := forceSync
+	switch w.cfg.sync {
+	case WALSyncCommit:
+		doSync = true
+	case WALSyncInterval:
+		doSync = doSync || now.Sub(w.lastSync) >= w.cfg.syncInterval
+	}
+	if !doSync {
+		return nil
+	}
+	if err := w.log.Sync(); err != nil {
+		return fmt.Errorf("metrics: syncing write-ahead log: %w", err)
+	}
+	w.dirty, w.lastSync = false, now
+	return nil
+}
+
+// Sync writes the pending updates to the log and syncs it, regardless of the sync policy.
+// Updates made before Sync survive a crash once it returns without error.
+func (w *WAL) Sync() error { return w.commit(true) }
+
+// Compact writes the state of the durable instruments, and of the recovered instruments not
+// created again, to a new snapshot file and starts a new log, deleting the older files.
+// Durable updates block while the state is read. Compact runs automatically when the log
+// grows above the maximum log size.
+func (w *WAL) Compact() error {
+	w.compactMu.Lock()
+	defer w.compactMu.Unlock()
+
+	w.gate.Lock()
+	// the current log must hold every update not in the new snapshot
+	if err := w.commit(false); err != nil {
+		w.gate.Unlock()
+		return err
+	}
+	snap := w.snapshot()
+	w.fileMu.Lock()
+	gen := w.gen + 1
+	f, err := os.OpenFile(w.logPath(gen), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		w.fileMu.Unlock()
+		w.gate.Unlock()
+		return fmt.Errorf("metrics: compacting write-ahead log: %w", err)
+	}
+	old := w.log
+	w.log, w.gen, w.size, w.dirty = f, gen, 0, false
+	w.fileMu.Unlock()
+	w.gate.Unlock()
+
+	// until the new snapshot is written, recovery uses the older snapshot and all logs
+	if old != nil {
+		if err := old.Sync(); err != nil {
+			_ = old.Close()
+			return fmt.Errorf("metrics: compacting write-ahead log: %w", err)
+		}
+		_ = old.Close()
+	}
+	if err := SaveSnapshot(w.snapshotPath(gen), snap); err != nil {
+		return fmt.Errorf("metrics: compacting write-ahead log: %w", err)
+	}
+	snapshots, logs, err := w.files()
+	if err != nil {
+		return err
+	}
+	for _, g := range snapshots {
+		if g < gen {
+			_ = os.Remove(w.snapshotPath(g))
+		}
+	}
+	for _, g := range logs {
+		if g < gen {
+			_ = os.Remove(w.logPath(g))
+		}
+	}
+	return nil
+}
+
+// snapshot returns the state of the durable and the remaining recovered instruments.
+func (w *WAL) snapshot() ProviderSnapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	snap := ProviderSnapshot{
+		Time:        w.cfg.clock.Now(),
+		Instruments: make([]InstrumentSnapshot, 0, len(w.journals)+len(w.recovered)),
+	}
+	for key, j := range w.journals {
+		snap.Instruments = append(snap.Instruments, InstrumentSnapshot{
+			Type: key.Type, Name: key.Name, Config: j.cfg, Value: j.inst.Snapshot(), Times: j.times.load(),
+		})
+	}
+	for _, s := range w.recovered {
+		snap.Instruments = append(snap.Instruments, s)
+	}
+	sortInstruments(snap.Instruments)
+	return snap
+}
+
+// Recovered returns the recovered state of the instruments that were not created again yet.
+func (w *WAL) Recovered() []InstrumentSnapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]InstrumentSnapshot, 0, len(w.recovered))
+	for _, s := range w.recovered {
+		out = append(out, s)
+	}
+	sortInstruments(out)
+	return out
+}
+
+// Close stops the background commits, writes and syncs the pending updates and closes the
+// log. Later updates of durable instruments are not logged.
+func (w *WAL) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		w.closeErr = w.commit(true)
+		w.fileMu.Lock()
+		defer w.fileMu.Unlock()
+		w.closed = true
+		if err := w.log.Close(); err != nil && w.closeErr == nil {
+			w.closeErr = fmt.Errorf("metrics: closing write-ahead log: %w", err)
+		}
+	})
+	return w.closeErr
+}
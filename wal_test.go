@@ -0,0 +1,295 @@
+package metrics
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestWAL(t *testing.T, dir string, opts ...WALOption) *WAL {
+	t.Helper()
+	w, err := OpenWAL(dir, opts...)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+// copyDir copies the files of src to a new directory, as a crashed process would leave them.
+func copyDir(t *testing.T, src string) string {
+	t.Helper()
+	dst := t.TempDir()
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), b, 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	return dst
+}
+
+func walFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWAL_RecoversDurableInstruments(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	p := NewBasicProvider(WithWAL(w))
+	p.Counter("billed_total", WithDurable(), WithUnit("By")).Add(5)
+	p.Counter("billed_total").Add(2)
+	p.UpDownCounter("balance", WithDurable()).Add(-4)
+	p.Counter("volatile_total").Add(9)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	w = openTestWAL(t, dir)
+	p = NewBasicProvider(WithWAL(w))
+	c := p.Counter("billed_total", WithDurable())
+	u := p.UpDownCounter("balance", WithDurable())
+	v := p.Counter("volatile_total", WithDurable())
+	if got := c.(*BasicCounter).Snapshot(); got != 7 {
+		t.Fatalf("expected billed_total 7, got %d", got)
+	}
+	if got := u.(*BasicUpDownCounter).Snapshot(); got != -4 {
+		t.Fatalf("expected balance -4, got %d", got)
+	}
+	if got := v.(*BasicCounter).Snapshot(); got != 0 {
+		t.Fatalf("expected the non-durable counter not to be recovered, got %d", got)
+	}
+	if len(w.Recovered()) != 0 {
+		t.Fatalf("expected all recovered instruments to be claimed, got %+v", w.Recovered())
+	}
+}
+
+func TestWAL_SurvivesCrashAfterSync(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, WithWALCommitInterval(time.Hour))
+	p := NewBasicProvider(WithWAL(w), WithShardedCounters(4))
+	c := p.Counter("c", WithDurable())
+	for i := 0; i < 100; i++ {
+		c.Add(1)
+	}
+	p.ResetInstrument(InstrumentTypeCounter, "c")
+	c.Add(3)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	c.Add(1000) // not committed before the crash
+
+	crashed := copyDir(t, dir)
+	w2 := openTestWAL(t, crashed)
+	rec := w2.Recovered()
+	if len(rec) != 1 || rec[0].Value != 3 || !rec[0].Config.Durable {
+		t.Fatalf("expected c=3 to be recovered, got %+v", rec)
+	}
+}
+
+func TestWAL_IgnoresTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, WithWALCommitInterval(time.Hour))
+	p := NewBasicProvider(WithWAL(w))
+	p.Counter("c", WithDurable()).Add(2)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	p.Counter("c").Add(5)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	crashed := copyDir(t, dir)
+	var logPath string
+	for _, name := range walFiles(t, crashed) {
+		if strings.HasSuffix(name, ".log") {
+			logPath = filepath.Join(crashed, name)
+		}
+	}
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	// cut the second record in half, as a crash during its write would
+	if err := os.WriteFile(logPath, b[:len(b)-3], 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	rec := openTestWAL(t, crashed).Recovered()
+	if len(rec) != 1 || rec[0].Value != 2 {
+		t.Fatalf("expected only the complete record to be replayed, got %+v", rec)
+	}
+}
+
+func TestWAL_Compact(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, WithWALCommitInterval(time.Hour))
+	p := NewBasicProvider(WithWAL(w))
+	c := p.Counter("c", WithDurable(), WithDescription("billed"))
+	c.Add(4)
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	c.Add(1)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	files := walFiles(t, dir)
+	if len(files) != 2 || !strings.HasPrefix(files[0], "snapshot-") || !strings.HasPrefix(files[1], "wal-") {
+		t.Fatalf("expected one snapshot and one log, got %v", files)
+	}
+	rec := openTestWAL(t, copyDir(t, dir)).Recovered()
+	if len(rec) != 1 || rec[0].Value != 5 || rec[0].Config.Description != "billed" {
+		t.Fatalf("expected c=5 with its metadata, got %+v", rec)
+	}
+}
+
+func TestWAL_KeepsUnclaimedRecoveredInstruments(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	NewBasicProvider(WithWAL(w)).Counter("old", WithDurable()).Add(3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	w = openTestWAL(t, dir)
+	NewBasicProvider(WithWAL(w)).Counter("new", WithDurable()).Add(1)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	rec := openTestWAL(t, dir).Recovered()
+	if len(rec) != 2 || rec[0].Name != "new" || rec[0].Value != 1 || rec[1].Name != "old" || rec[1].Value != 3 {
+		t.Fatalf("expected both instruments to be recovered, got %+v", rec)
+	}
+}
+
+func TestWAL_BackgroundCommitAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	clk := &tickClock{manualClock: newManualClock(), ch: make(chan time.Time)}
+	w := openTestWAL(t, dir, WithWALClock(clk), WithWALMaxLogSize(1), WithWALSync(WALSyncNever))
+	p := NewBasicProvider(WithWAL(w))
+	p.Counter("c", WithDurable()).Add(6)
+
+	clk.ch <- clk.Now()
+	// a second tick is only received once the first commit and compaction are complete
+	clk.ch <- clk.Now()
+
+	files := walFiles(t, dir)
+	if len(files) != 2 || files[0] != "snapshot-0000000000000002" {
+		t.Fatalf("expected the log to be compacted, got %v", files)
+	}
+	snap, err := LoadSnapshot(filepath.Join(dir, files[0]))
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if c, _ := snap.Get(InstrumentTypeCounter, "c"); c.Value != 6 {
+		t.Fatalf("expected c=6 in the snapshot, got %+v", snap)
+	}
+}
+
+func TestWAL_ConcurrentUpdatesAndCompactions(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, WithWALCommitInterval(time.Millisecond), WithWALSync(WALSyncNever))
+	p := NewBasicProvider(WithWAL(w))
+	c := p.Counter("c", WithDurable())
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				c.Add(1)
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := w.Compact(); err != nil {
+			t.Fatalf("Compact: %v", err)
+		}
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	rec := openTestWAL(t, dir).Recovered()
+	if len(rec) != 1 || rec[0].Value != 2000 {
+		t.Fatalf("expected c=2000, got %+v", rec)
+	}
+}
+
+func TestWAL_Errors(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Sync(); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("expected ErrWALClosed, got %v", err)
+	}
+
+	files := walFiles(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, files[0]), []byte("{"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := OpenWAL(dir); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("expected ErrSnapshotCorrupt, got %v", err)
+	}
+}
+
+func TestWithWAL_SingleProvider(t *testing.T) {
+	w := openTestWAL(t, t.TempDir())
+	NewBasicProvider(WithWAL(w))
+	l := &recordingLogger{}
+	p := NewBasicProvider(WithWAL(w), WithBasicProviderLogger(l))
+	if l.count() != 1 {
+		t.Fatalf("expected the second provider to log an error, got %d messages", l.count())
+	}
+	// the second provider works without a log
+	p.Counter("c", WithDurable()).Add(1)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+}
+
+func TestWithDurable_Meta(t *testing.T) {
+	p := NewBasicProvider()
+	p.Counter("billed_total", WithDurable())
+	p.UpDownCounter("balance", WithDurable())
+	p.Counter("volatile_total")
+	if _, cfg, _ := p.CounterWithMeta("billed_total"); !cfg.Durable {
+		t.Fatalf("expected the counter to be durable, got %+v", cfg)
+	}
+	if _, cfg, _ := p.UpDownCounterWithMeta("balance"); !cfg.Durable {
+		t.Fatalf("expected the up/down counter to be durable, got %+v", cfg)
+	}
+	if _, cfg, _ := p.CounterWithMeta("volatile_total"); cfg.Durable {
+		t.Fatalf("expected the counter not to be durable, got %+v", cfg)
+	}
+}
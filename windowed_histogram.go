@@ -0,0 +1,331 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HistogramWindow configures a rolling-window histogram (see WithRollingWindow).
+type HistogramWindow struct {
+	// Duration is the length of the window.
+	Duration time.Duration
+	// SubWindows is the number of sub-windows the window is split into; measurements expire
+	// one sub-window at a time. Defaults to 6.
+	SubWindows int
+	// Quantiles are the quantiles (between 0 and 1) reported in snapshots.
+	// Defaults to 0.5, 0.9 and 0.99.
+	Quantiles []float64
+}
+
+const defaultSubWindows = 6
+
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// WithRollingWindow makes BasicProvider create the histogram as a WindowedHistogram, which
+// reports only the measurements of the last w.Duration. Ignored if w.Duration is not positive.
+func WithRollingWindow(w HistogramWindow) InstrumentOption {
+	return func(c *InstrumentConfig) {
+		if w.Duration <= 0 {
+			return
+		}
+		w = w.normalized()
+		c.Window = &w
+	}
+}
+
+// normalized returns a copy of w with defaults applied and quantiles copied, sorted and
+// restricted to [0, 1].
+func (w HistogramWindow) normalized() HistogramWindow {
+	if w.SubWindows <= 0 {
+		w.SubWindows = defaultSubWindows
+	}
+	qs := w.Quantiles
+	if len(qs) == 0 {
+		qs = defaultQuantiles
+	}
+	w.Quantiles = make([]float64, 0, len(qs))
+	for _, q := range qs {
+		if q >= 0 && q <= 1 {
+			w.Quantiles = append(w.Quantiles, q)
+		}
+	}
+	sort.Float64s(w.Quantiles)
+	return w
+}
+
+// Quantile is an estimated quantile in a HistSnapshot.
+type Quantile struct {
+	Q     float64
+	Value float64
+}
+
+// WindowedHistogramOption configures a WindowedHistogram constructed with NewWindowedHistogram.
+type WindowedHistogramOption func(*windowedHistogramConfig)
+
+type windowedHistogramConfig struct {
+	clock  Clock
+	bounds []float64
+	ex     *exemplarReservoir
+	times  *instrumentTimes
+}
+
+// WithWindowedHistogramClock sets the clock rotating the sub-windows. Defaults to SystemClock.
+func WithWindowedHistogramClock(c Clock) WindowedHistogramOption {
+	return func(cfg *windowedHistogramConfig) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// WithWindowedHistogramBuckets sets bucket bounds, like WithBuckets for instruments.
+func WithWindowedHistogramBuckets(bounds ...float64) WindowedHistogramOption {
+	return func(cfg *windowedHistogramConfig) {
+		var ic InstrumentConfig
+		WithBuckets(bounds...)(&ic)
+		cfg.bounds = ic.Buckets
+	}
+}
+
+// WindowedHistogram is a thread-safe histogram reporting count, sum, min, max, bucket counts
+// and estimated quantiles of the measurements of a rolling time window only, so that e.g.
+// a startup spike stops dominating its max once it leaves the window.
+//
+// The window is split into sub-windows rotated on a clock; a snapshot covers the current,
+// partially elapsed sub-window and the complete ones before it, that is between
+// (SubWindows-1)/SubWindows of the window and the full window. Quantiles are estimated
+// with a relative error of 1%. NaN measurements are ignored. Unlike BasicHistogram,
+// recordings take a mutex.
+type WindowedHistogram struct {
+	window    time.Duration
+	sub       time.Duration
+	quantiles []float64
+	bounds    []float64
+	clock     Clock
+	// exemplars per bucket; nil if exemplars are disabled. Snapshots drop exemplars older
+	// than the window.
+	ex *exemplarReservoir
+	// lifecycle timestamps; nil unless created by BasicProvider.
+	times *instrumentTimes
+
+	mu    sync.Mutex
+	slots []windowSlot
+}
+
+// windowSlot accumulates the measurements of one sub-window.
+type windowSlot struct {
+	idx      int64 // index of the sub-window since the Unix epoch; slots are reused
+	count    int64
+	sum      float64
+	min, max float64
+	buckets  []int64 // finite buckets
+	sketch   quantileSketch
+}
+
+// NewWindowedHistogram returns a WindowedHistogram over w. A non-positive w.Duration
+// defaults to one minute.
+func NewWindowedHistogram(w HistogramWindow, opts ...WindowedHistogramOption) *WindowedHistogram {
+	cfg := windowedHistogramConfig{clock: SystemClock()}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return newWindowedHistogram(w, cfg)
+}
+
+func newWindowedHistogram(w HistogramWindow, cfg windowedHistogramConfig) *WindowedHistogram {
+	if w.Duration <= 0 {
+		w.Duration = time.Minute
+	}
+	w = w.normalized()
+	sub := w.Duration / time.Duration(w.SubWindows)
+	if sub <= 0 {
+		sub = 1
+	}
+	h := &WindowedHistogram{
+		window:    w.Duration,
+		sub:       sub,
+		quantiles: w.Quantiles,
+		bounds:    cfg.bounds,
+		clock:     cfg.clock,
+		ex:        cfg.ex,
+		times:     cfg.times,
+		slots:     make([]windowSlot, w.SubWindows),
+	}
+	for i := range h.slots {
+		h.slots[i].idx = math.MinInt64
+	}
+	return h
+}
+
+// Window returns the duration of the window.
+func (h *WindowedHistogram) Window() time.Duration { return h.window }
+
+// Record adds a measurement to the current sub-window.
+func (h *WindowedHistogram) Record(v float64) { h.RecordN(v, 1) }
+
+// RecordN implements BatchHistogram: it adds n measurements of v to the current sub-window
+// at once.
+func (h *WindowedHistogram) RecordN(v float64, n int64) {
+	if math.IsNaN(v) || n <= 0 {
+		return
+	}
+	idx := h.clock.Now().UnixNano() / int64(h.sub)
+	h.times.touch()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &h.slots[mod(idx, len(h.slots))]
+	if s.idx != idx {
+		if s.idx > idx {
+			return // clock went back more than a full window; nowhere to record
+		}
+		*s = windowSlot{idx: idx, min: math.Inf(1), max: math.Inf(-1), buckets: s.buckets}
+		clear(s.buckets)
+	}
+	if len(h.bounds) > 0 {
+		if s.buckets == nil {
+			s.buckets = make([]int64, len(h.bounds))
+		}
+		if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+			s.buckets[i] += n
+		}
+	}
+	s.count += n
+	s.sum += v * float64(n)
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+	s.sketch.addN(v, n)
+}
+
+// RecordWithExemplar adds a measurement and offers ex, with v as its value, to the reservoir
+// of the bucket v falls into.
+func (h *WindowedHistogram) RecordWithExemplar(v float64, ex Exemplar) {
+	h.Record(v)
+	if math.IsNaN(v) {
+		return
+	}
+	if ex.Timestamp.IsZero() {
+		// expiry is decided on the histogram clock
+		ex.Timestamp = h.clock.Now()
+	}
+	h.ex.offer(h.bucketIndex(v), v, ex)
+}
+
+// RecordContext adds a measurement and offers an exemplar built from the trace and
+// attributes carried by ctx, if any (see ContextWithTrace).
+func (h *WindowedHistogram) RecordContext(ctx context.Context, v float64) {
+	if ex, ok := exemplarFromContext(ctx); ok {
+		h.RecordWithExemplar(v, ex)
+		return
+	}
+	h.Record(v)
+}
+
+func (h *WindowedHistogram) bucketIndex(v float64) int {
+	if len(h.bounds) == 0 {
+		return 0
+	}
+	return sort.SearchFloat64s(h.bounds, v)
+}
+
+// restore is a no-op: persisted measurements are outside of any current window.
+func (h *WindowedHistogram) restore(HistSnapshot) {}
+
+// Reset discards all measurements and exemplars.
+func (h *WindowedHistogram) Reset() {
+	h.mu.Lock()
+	for i := range h.slots {
+		h.slots[i] = windowSlot{idx: math.MinInt64}
+	}
+	h.mu.Unlock()
+	h.ex.reset()
+	h.times.reset()
+}
+
+// Snapshot returns the state of the measurements within the window, with Quantiles set.
+// For an empty window Min is +Inf, Max is -Inf and quantile values are NaN.
+func (h *WindowedHistogram) Snapshot() HistSnapshot {
+	now := h.clock.Now()
+	cur := now.UnixNano() / int64(h.sub)
+	oldest := cur - int64(len(h.slots)) + 1
+
+	out := HistSnapshot{Min: math.Inf(1), Max: math.Inf(-1)}
+	finite := make([]int64, len(h.bounds))
+	var sketch quantileSketch
+
+	h.mu.Lock()
+	for i := range h.slots {
+		s := &h.slots[i]
+		if s.idx < oldest || s.idx > cur || s.count == 0 {
+			continue
+		}
+		out.Count += s.count
+		out.Sum += s.sum
+		out.Min = math.Min(out.Min, s.min)
+		out.Max = math.Max(out.Max, s.max)
+		for b, c := range s.buckets {
+			finite[b] += c
+		}
+		sketch.merge(&s.sketch)
+	}
+	h.mu.Unlock()
+
+	if out.Count > 0 {
+		out.Mean = out.Sum / float64(out.Count)
+	}
+	out.Buckets = make([]HistBucket, len(h.bounds)+1)
+	var total int64
+	for i, c := range finite {
+		total += c
+		out.Buckets[i] = HistBucket{UpperBound: h.bounds[i], Count: c}
+	}
+	out.Buckets[len(h.bounds)] = HistBucket{UpperBound: math.Inf(1), Count: out.Count - total}
+	since := now.Add(-h.window)
+	for i := range out.Buckets {
+		out.Buckets[i].Exemplars = recentExemplars(h.ex.collect(i), since)
+	}
+
+	out.sketch = &sketch
+	out.Quantiles = sketchQuantiles(&sketch, h.quantiles, out.Min, out.Max)
+	return out
+}
+
+// sketchQuantiles estimates the quantiles qs of the measurements in sketch, whose exact
+// minimum and maximum are minV and maxV.
+func sketchQuantiles(sketch *quantileSketch, qs []float64, minV, maxV float64) []Quantile {
+	out := make([]Quantile, len(qs))
+	for i, q := range qs {
+		v := sketch.quantile(q)
+		if sketch.count > 0 {
+			// the sketch is approximate; exact extremes are known
+			v = math.Max(minV, math.Min(maxV, v))
+		}
+		out[i] = Quantile{Q: q, Value: v}
+	}
+	return out
+}
+
+// recentExemplars filters out exemplars recorded before since, preserving order.
+func recentExemplars(in []Exemplar, since time.Time) []Exemplar {
+	out := in[:0]
+	for _, ex := range in {
+		if !ex.Timestamp.Before(since) {
+			out = append(out, ex)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// mod returns the non-negative remainder of i divided by n.
+func mod(i int64, n int) int {
+	r := int(i % int64(n))
+	if r < 0 {
+		r += n
+	}
+	return r
+}
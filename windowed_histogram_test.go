@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	var s quantileSketch
+	if !math.IsNaN(s.quantile(0.5)) {
+		t.Fatalf("expected NaN for empty sketch")
+	}
+	for i := 1; i <= 1000; i++ {
+		s.add(float64(i))
+	}
+	for _, c := range []struct{ q, want float64 }{{0, 1}, {0.5, 500.5}, {0.9, 900.1}, {0.99, 990.01}, {1, 1000}} {
+		got := s.quantile(c.q)
+		if math.Abs(got-c.want)/c.want > 0.02 {
+			t.Fatalf("quantile(%v) = %v; want %v within 2%%", c.q, got, c.want)
+		}
+	}
+
+	var mixed, other quantileSketch
+	for _, v := range []float64{-10, -1, 0} {
+		mixed.add(v)
+	}
+	for _, v := range []float64{1, 10} {
+		other.add(v)
+	}
+	mixed.merge(&other)
+	want := []float64{-10, -1, 0, 1, 10}
+	for i, w := range want {
+		got := mixed.quantile(float64(i) / 4)
+		if math.Abs(got-w) > math.Abs(w)*0.02 {
+			t.Fatalf("quantile(%v) = %v; want %v", float64(i)/4, got, w)
+		}
+	}
+
+	// infinities share the buckets of the largest finite magnitude
+	var inf quantileSketch
+	for _, v := range []float64{math.Inf(-1), 1, math.Inf(1)} {
+		inf.add(v)
+	}
+	if lo, mid, hi := inf.quantile(0), inf.quantile(0.5), inf.quantile(1); lo > -math.MaxFloat64/2 ||
+		math.Abs(mid-1) > 0.02 || hi < math.MaxFloat64/2 {
+		t.Fatalf("unexpected quantiles %v, %v, %v of infinities", lo, mid, hi)
+	}
+}
+
+func TestWindowedHistogram_Rolls(t *testing.T) {
+	clk := newManualClock()
+	h := NewWindowedHistogram(
+		HistogramWindow{Duration: time.Minute, SubWindows: 6, Quantiles: []float64{0.99, 0.5, 2}},
+		WithWindowedHistogramClock(clk), WithWindowedHistogramBuckets(10),
+	)
+	if h.Window() != time.Minute {
+		t.Fatalf("window = %v", h.Window())
+	}
+
+	empty := h.Snapshot()
+	if empty.Count != 0 || !math.IsInf(empty.Min, 1) || !math.IsInf(empty.Max, -1) || !math.IsNaN(empty.Quantiles[0].Value) {
+		t.Fatalf("unexpected empty snapshot %+v", empty)
+	}
+
+	h.Record(1000) // startup spike
+	h.Record(math.NaN())
+	clk.Advance(30 * time.Second)
+	for i := 1; i <= 100; i++ {
+		h.Record(float64(i % 10))
+	}
+
+	s := h.Snapshot()
+	if s.Count != 101 || s.Max != 1000 || s.Min != 0 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	if len(s.Quantiles) != 2 || s.Quantiles[0].Q != 0.5 || s.Quantiles[1].Q != 0.99 {
+		t.Fatalf("unexpected quantiles %+v", s.Quantiles)
+	}
+	if s.Buckets[0].Count != 100 || s.Buckets[1].Count != 1 {
+		t.Fatalf("unexpected buckets %+v", s.Buckets)
+	}
+
+	// the spike leaves the window
+	clk.Advance(35 * time.Second)
+	s = h.Snapshot()
+	if s.Count != 100 || s.Max != 9 || s.Sum != 450 || s.Mean != 4.5 {
+		t.Fatalf("unexpected snapshot after rotation %+v", s)
+	}
+	if q := s.Quantiles[0].Value; math.Abs(q-4.5) > 0.6 {
+		t.Fatalf("median = %v; want about 4.5", q)
+	}
+	if q := s.Quantiles[1].Value; math.Abs(q-9) > 0.09 {
+		t.Fatalf("p99 = %v; want 9 within 1%%", q)
+	}
+
+	// a slot reused after a full rotation starts empty
+	clk.Advance(time.Minute)
+	h.Record(3)
+	if s := h.Snapshot(); s.Count != 1 || s.Buckets[0].Count != 1 || s.Min != 3 {
+		t.Fatalf("unexpected snapshot after reuse %+v", s)
+	}
+
+	// clock far in the past: measurement dropped
+	clk.Advance(-time.Hour)
+	h.Record(1)
+	clk.Advance(time.Hour)
+	if s := h.Snapshot(); s.Count != 1 {
+		t.Fatalf("count = %d; want 1", s.Count)
+	}
+}
+
+func TestWindowedHistogram_Exemplars(t *testing.T) {
+	clk := newManualClock()
+	h := newWindowedHistogram(HistogramWindow{Duration: 10 * time.Second}, windowedHistogramConfig{
+		clock: clk, bounds: []float64{1}, ex: newExemplarReservoir(ExemplarFilterTraceBased, 2, 2),
+	})
+	RecordWithExemplar(h, 0.5, Exemplar{TraceID: "old"})
+	clk.Advance(20 * time.Second)
+	RecordWithContext(ContextWithTrace(context.Background(), "new", "s"), h, 0.5)
+	RecordWithContext(context.Background(), h, 5)
+	RecordWithExemplar(h, math.NaN(), Exemplar{TraceID: "nan"})
+
+	s := h.Snapshot()
+	if ex := s.Buckets[0].Exemplars; len(ex) != 1 || ex[0].TraceID != "new" {
+		t.Fatalf("expected only the recent exemplar, got %+v", ex)
+	}
+	if s.Buckets[1].Exemplars != nil || s.Buckets[1].Count != 1 {
+		t.Fatalf("unexpected +Inf bucket %+v", s.Buckets[1])
+	}
+}
+
+func TestBasicProvider_RollingWindow(t *testing.T) {
+	p := NewBasicProvider()
+	h := p.Histogram("latency", WithRollingWindow(HistogramWindow{Duration: time.Minute}), WithBuckets(1))
+	wh, ok := h.(*WindowedHistogram)
+	if !ok {
+		t.Fatalf("expected *WindowedHistogram, got %T", h)
+	}
+	if len(wh.slots) != defaultSubWindows || len(wh.quantiles) != len(defaultQuantiles) {
+		t.Fatalf("expected defaults to apply")
+	}
+	h.Record(0.5)
+
+	_, cfg, _ := p.HistogramWithMeta("latency")
+	if cfg.Window == nil || cfg.Window.Duration != time.Minute || cfg.Window.SubWindows != defaultSubWindows {
+		t.Fatalf("unexpected config %+v", cfg.Window)
+	}
+	cfg.Window.Quantiles[0] = 42
+	if _, cfg2, _ := p.HistogramWithMeta("latency"); cfg2.Window.Quantiles[0] != 0.5 {
+		t.Fatalf("config window must be a defensive copy")
+	}
+
+	if _, ok := p.Histogram("plain", WithRollingWindow(HistogramWindow{})).(*BasicHistogram); !ok {
+		t.Fatalf("expected a zero window to be ignored")
+	}
+
+	s, _ := p.Snapshot().Get(InstrumentTypeHistogram, "latency")
+	if s.Histogram.Count != 1 || len(s.Histogram.Quantiles) != 3 {
+		t.Fatalf("unexpected snapshot %+v", s.Histogram)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, p.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	want := "# TYPE latency summary\nlatency{quantile=\"0.5\"} 0.5\nlatency{quantile=\"0.9\"} 0.5\nlatency{quantile=\"0.99\"} 0.5\nlatency_count 1\nlatency_sum 0.5\n"
+	if !strings.HasPrefix(buf.String(), want) {
+		t.Fatalf("unexpected OpenMetrics output:\n%s", buf.String())
+	}
+}